@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"errors"
@@ -21,6 +22,8 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
+	"github.com/apernet/hysteria/app/v2/internal/dns"
+	"github.com/apernet/hysteria/app/v2/internal/fakeip"
 	"github.com/apernet/hysteria/app/v2/internal/forwarding"
 	"github.com/apernet/hysteria/app/v2/internal/http"
 	"github.com/apernet/hysteria/app/v2/internal/proxymux"
@@ -32,11 +35,18 @@ import (
 	"github.com/apernet/hysteria/app/v2/internal/url"
 	"github.com/apernet/hysteria/app/v2/internal/utils"
 	"github.com/apernet/hysteria/core/v2/client"
+	hyErrors "github.com/apernet/hysteria/core/v2/errors"
 	"github.com/apernet/hysteria/extras/v2/correctnet"
 	"github.com/apernet/hysteria/extras/v2/obfs"
+	"github.com/apernet/hysteria/extras/v2/outbounds"
+	"github.com/apernet/hysteria/extras/v2/transport/fallback"
 	"github.com/apernet/hysteria/extras/v2/transport/udphop"
 )
 
+const (
+	reverseRetryInterval = 5 * time.Second
+)
+
 // Client flags
 var (
 	showQR bool
@@ -58,32 +68,47 @@ func initClientFlags() {
 }
 
 type clientConfig struct {
-	Server        string                `mapstructure:"server"`
-	Auth          string                `mapstructure:"auth"`
-	Transport     clientConfigTransport `mapstructure:"transport"`
-	Obfs          clientConfigObfs      `mapstructure:"obfs"`
-	TLS           clientConfigTLS       `mapstructure:"tls"`
-	QUIC          clientConfigQUIC      `mapstructure:"quic"`
-	Bandwidth     clientConfigBandwidth `mapstructure:"bandwidth"`
-	FastOpen      bool                  `mapstructure:"fastOpen"`
-	Lazy          bool                  `mapstructure:"lazy"`
-	SOCKS5        *socks5Config         `mapstructure:"socks5"`
-	HTTP          *httpConfig           `mapstructure:"http"`
-	TCPForwarding []tcpForwardingEntry  `mapstructure:"tcpForwarding"`
-	UDPForwarding []udpForwardingEntry  `mapstructure:"udpForwarding"`
-	TCPTProxy     *tcpTProxyConfig      `mapstructure:"tcpTProxy"`
-	UDPTProxy     *udpTProxyConfig      `mapstructure:"udpTProxy"`
-	TCPRedirect   *tcpRedirectConfig    `mapstructure:"tcpRedirect"`
-	TUN           *tunConfig            `mapstructure:"tun"`
+	Server        string                  `mapstructure:"server"`
+	Auth          string                  `mapstructure:"auth"`
+	Transport     clientConfigTransport   `mapstructure:"transport"`
+	Obfs          clientConfigObfs        `mapstructure:"obfs"`
+	TLS           clientConfigTLS         `mapstructure:"tls"`
+	QUIC          clientConfigQUIC        `mapstructure:"quic"`
+	Bandwidth     clientConfigBandwidth   `mapstructure:"bandwidth"`
+	Congestion    clientConfigCongestion  `mapstructure:"congestion"`
+	FastOpen      bool                    `mapstructure:"fastOpen"`
+	UDPStream     string                  `mapstructure:"udpStream"`
+	Lazy          bool                    `mapstructure:"lazy"`
+	Servers       []clientConfigServer    `mapstructure:"servers"`
+	LoadBalance   clientConfigLoadBalance `mapstructure:"loadBalance"`
+	ACL           clientConfigACL         `mapstructure:"acl"`
+	SOCKS5        *socks5Config           `mapstructure:"socks5"`
+	HTTP          *httpConfig             `mapstructure:"http"`
+	TCPForwarding []tcpForwardingEntry    `mapstructure:"tcpForwarding"`
+	UDPForwarding []udpForwardingEntry    `mapstructure:"udpForwarding"`
+	Reverse       []reverseEntry          `mapstructure:"reverse"`
+	TCPTProxy     *tcpTProxyConfig        `mapstructure:"tcpTProxy"`
+	UDPTProxy     *udpTProxyConfig        `mapstructure:"udpTProxy"`
+	TCPRedirect   *tcpRedirectConfig      `mapstructure:"tcpRedirect"`
+	TUN           *tunConfig              `mapstructure:"tun"`
+	DNS           *dnsConfig              `mapstructure:"dns"`
 }
 
 type clientConfigTransportUDP struct {
-	HopInterval time.Duration `mapstructure:"hopInterval"`
+	HopInterval    time.Duration `mapstructure:"hopInterval"`
+	HopIntervalMax time.Duration `mapstructure:"hopIntervalMax"`
+}
+
+type clientConfigTransportFallback struct {
+	Type string `mapstructure:"type"`
+	Addr string `mapstructure:"addr"`
+	Path string `mapstructure:"path"`
 }
 
 type clientConfigTransport struct {
-	Type string                   `mapstructure:"type"`
-	UDP  clientConfigTransportUDP `mapstructure:"udp"`
+	Type     string                        `mapstructure:"type"`
+	UDP      clientConfigTransportUDP      `mapstructure:"udp"`
+	Fallback clientConfigTransportFallback `mapstructure:"fallback"`
 }
 
 type clientConfigObfsSalamander struct {
@@ -119,11 +144,51 @@ type clientConfigQUICSockopts struct {
 	FdControlUnixSocket *string `mapstructure:"fdControlUnixSocket"`
 }
 
+// clientConfigACL routes the requests of the SOCKS5, HTTP, TProxy, redirect
+// and TUN modes to one of the built-in outbounds: "proxy" (the Hysteria
+// server, also the default), "direct" or "reject".
+// Forwarding modes are not affected, as their remote is explicitly set.
+type clientConfigACL struct {
+	File              string                     `mapstructure:"file"`
+	Inline            []string                   `mapstructure:"inline"`
+	GeoIP             string                     `mapstructure:"geoip"`
+	GeoSite           string                     `mapstructure:"geosite"`
+	GeoUpdateInterval time.Duration              `mapstructure:"geoUpdateInterval"`
+	RuleSets          []serverConfigACLRuleSet   `mapstructure:"ruleSets"`
+	Direct            serverConfigOutboundDirect `mapstructure:"direct"`
+}
+
 type clientConfigBandwidth struct {
 	Up   string `mapstructure:"up"`
 	Down string `mapstructure:"down"`
 }
 
+type clientConfigCongestion struct {
+	Type string `mapstructure:"type"`
+}
+
+// clientConfigServer contains the fields needed to connect to a single server.
+// It's used for entries in the server list for connecting to multiple servers,
+// where each entry is independent and does not inherit anything from the top-level fields,
+// and also for the server-side hysteria outbound.
+type clientConfigServer struct {
+	Server     string                 `mapstructure:"server"`
+	Auth       string                 `mapstructure:"auth"`
+	Transport  clientConfigTransport  `mapstructure:"transport"`
+	Obfs       clientConfigObfs       `mapstructure:"obfs"`
+	TLS        clientConfigTLS        `mapstructure:"tls"`
+	QUIC       clientConfigQUIC       `mapstructure:"quic"`
+	Bandwidth  clientConfigBandwidth  `mapstructure:"bandwidth"`
+	Congestion clientConfigCongestion `mapstructure:"congestion"`
+	FastOpen   bool                   `mapstructure:"fastOpen"`
+	UDPStream  string                 `mapstructure:"udpStream"`
+}
+
+type clientConfigLoadBalance struct {
+	Strategy            string        `mapstructure:"strategy"`
+	HealthCheckInterval time.Duration `mapstructure:"healthCheckInterval"`
+}
+
 type socks5Config struct {
 	Listen     string `mapstructure:"listen"`
 	Username   string `mapstructure:"username"`
@@ -149,6 +214,15 @@ type udpForwardingEntry struct {
 	Timeout time.Duration `mapstructure:"timeout"`
 }
 
+// reverseEntry is a reverse forwarding: connections to Listen on the server side
+// are forwarded to Remote, which is dialed directly from the client.
+type reverseEntry struct {
+	Network string        `mapstructure:"network"`
+	Listen  string        `mapstructure:"listen"`
+	Remote  string        `mapstructure:"remote"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
 type tcpTProxyConfig struct {
 	Listen string `mapstructure:"listen"`
 }
@@ -179,6 +253,50 @@ type tunConfig struct {
 	} `mapstructure:"route"`
 }
 
+type dnsConfig struct {
+	Listen   string           `mapstructure:"listen"`
+	Upstream string           `mapstructure:"upstream"`
+	Direct   bool             `mapstructure:"direct"`
+	Timeout  time.Duration    `mapstructure:"timeout"`
+	FakeIP   *dnsFakeIPConfig `mapstructure:"fakeIP"`
+}
+
+type dnsFakeIPConfig struct {
+	IPv4Range string `mapstructure:"ipv4Range"`
+	IPv6Range string `mapstructure:"ipv6Range"`
+}
+
+// FakeIPPool returns the fake IP pool shared by the DNS server and TUN,
+// or nil if fake IP is not enabled.
+func (c *dnsConfig) FakeIPPool() (*fakeip.Pool, error) {
+	if c.FakeIP == nil {
+		return nil, nil
+	}
+	ipv4Range := c.FakeIP.IPv4Range
+	if ipv4Range == "" && c.FakeIP.IPv6Range == "" {
+		ipv4Range = "198.18.0.0/15"
+	}
+	var prefix4, prefix6 netip.Prefix
+	var err error
+	if ipv4Range != "" {
+		prefix4, err = netip.ParsePrefix(ipv4Range)
+		if err != nil {
+			return nil, configError{Field: "dns.fakeIP.ipv4Range", Err: err}
+		}
+	}
+	if c.FakeIP.IPv6Range != "" {
+		prefix6, err = netip.ParsePrefix(c.FakeIP.IPv6Range)
+		if err != nil {
+			return nil, configError{Field: "dns.fakeIP.ipv6Range", Err: err}
+		}
+	}
+	pool, err := fakeip.NewPool(prefix4, prefix6)
+	if err != nil {
+		return nil, configError{Field: "dns.fakeIP", Err: err}
+	}
+	return pool, nil
+}
+
 func (c *clientConfig) fillServerAddr(hyConfig *client.Config) error {
 	if c.Server == "" {
 		return configError{Field: "server", Err: errors.New("server address is empty")}
@@ -186,10 +304,12 @@ func (c *clientConfig) fillServerAddr(hyConfig *client.Config) error {
 	var addr net.Addr
 	var err error
 	host, port, hostPort := parseServerAddrString(c.Server)
-	if !isPortHoppingPort(port) {
+	if !isUDPHopAddr(host, port) {
 		addr, err = net.ResolveUDPAddr("udp", hostPort)
 	} else {
 		addr, err = udphop.ResolveUDPHopAddr(hostPort)
+		// Use the first one as SNI
+		host, _, _ = strings.Cut(host, ",")
 	}
 	if err != nil {
 		return configError{Field: "server", Err: err}
@@ -228,7 +348,7 @@ func (c *clientConfig) fillConnFactory(hyConfig *client.Config) error {
 		if hyConfig.ServerAddr.Network() == "udphop" {
 			hopAddr := hyConfig.ServerAddr.(*udphop.UDPHopAddr)
 			newFunc = func(addr net.Addr) (net.PacketConn, error) {
-				return udphop.NewUDPHopPacketConn(hopAddr, c.Transport.UDP.HopInterval, so.ListenUDP)
+				return udphop.NewUDPHopPacketConn(hopAddr, c.Transport.UDP.HopInterval, c.Transport.UDP.HopIntervalMax, so.ListenUDP)
 			}
 		} else {
 			newFunc = func(addr net.Addr) (net.PacketConn, error) {
@@ -259,6 +379,49 @@ func (c *clientConfig) fillConnFactory(hyConfig *client.Config) error {
 	return nil
 }
 
+// fillFallbackConnFactory must be called after fillTLSConfig,
+// as the fallback transport uses the same TLS settings as QUIC.
+func (c *clientConfig) fillFallbackConnFactory(hyConfig *client.Config) error {
+	switch strings.ToLower(c.Transport.Fallback.Type) {
+	case "", "none":
+		return nil
+	case "http2", "h2":
+		// Supported
+	default:
+		return configError{Field: "transport.fallback.type", Err: errors.New("unsupported fallback transport type")}
+	}
+	if !strings.HasPrefix(c.Transport.Fallback.Path, "/") {
+		return configError{Field: "transport.fallback.path", Err: errors.New("path must start with /")}
+	}
+	addr := c.Transport.Fallback.Addr
+	if addr == "" {
+		// Same host & port as the server, but TCP
+		host, port, hostPort := parseServerAddrString(c.Server)
+		if isUDPHopAddr(host, port) {
+			return configError{Field: "transport.fallback.addr", Err: errors.New("must be set when using port hopping or multiple server hosts")}
+		}
+		addr = hostPort
+	}
+	tlsConfig := &tls.Config{
+		ServerName:            hyConfig.TLSConfig.ServerName,
+		InsecureSkipVerify:    hyConfig.TLSConfig.InsecureSkipVerify,
+		VerifyPeerCertificate: hyConfig.TLSConfig.VerifyPeerCertificate,
+		RootCAs:               hyConfig.TLSConfig.RootCAs,
+	}
+	fbConfig := fallback.ClientConfig{
+		Addr:      addr,
+		Path:      c.Transport.Fallback.Path,
+		TLSConfig: tlsConfig,
+	}
+	hyConfig.FallbackConnFactory = &adaptiveConnFactory{
+		NewFunc: func(addr net.Addr) (net.PacketConn, error) {
+			return fallback.NewClientConn(fbConfig)
+		},
+		// No obfuscation, as it's already TLS
+	}
+	return nil
+}
+
 func (c *clientConfig) fillAuth(hyConfig *client.Config) error {
 	hyConfig.Auth = c.Auth
 	return nil
@@ -328,11 +491,36 @@ func (c *clientConfig) fillBandwidthConfig(hyConfig *client.Config) error {
 	return nil
 }
 
+func (c *clientConfig) fillCongestionControl(hyConfig *client.Config) error {
+	switch t := strings.ToLower(c.Congestion.Type); t {
+	case "", client.CongestionControlBBR, client.CongestionControlBBR3, client.CongestionControlBrutal, client.CongestionControlAdaptiveBrutal,
+		client.CongestionControlCubic, client.CongestionControlReno:
+		hyConfig.CongestionControl = t
+		return nil
+	default:
+		return configError{Field: "congestion.type", Err: errors.New("unsupported congestion control type")}
+	}
+}
+
 func (c *clientConfig) fillFastOpen(hyConfig *client.Config) error {
 	hyConfig.FastOpen = c.FastOpen
 	return nil
 }
 
+func (c *clientConfig) fillUDPStream(hyConfig *client.Config) error {
+	switch strings.ToLower(c.UDPStream) {
+	case "", "never":
+		hyConfig.UDPStreamMode = client.UDPStreamModeNever
+	case "auto":
+		hyConfig.UDPStreamMode = client.UDPStreamModeAuto
+	case "always":
+		hyConfig.UDPStreamMode = client.UDPStreamModeAlways
+	default:
+		return configError{Field: "udpStream", Err: errors.New("unsupported mode")}
+	}
+	return nil
+}
+
 // URI generates a URI for sharing the config with others.
 // Note that only the bare minimum of information required to
 // connect to the server is included in the URI, specifically:
@@ -429,9 +617,12 @@ func (c *clientConfig) Config() (*client.Config, error) {
 		c.fillConnFactory,
 		c.fillAuth,
 		c.fillTLSConfig,
+		c.fillFallbackConnFactory,
 		c.fillQUICConfig,
 		c.fillBandwidthConfig,
+		c.fillCongestionControl,
 		c.fillFastOpen,
+		c.fillUDPStream,
 	}
 	for _, f := range fillers {
 		if err := f(hyConfig); err != nil {
@@ -441,6 +632,112 @@ func (c *clientConfig) Config() (*client.Config, error) {
 	return hyConfig, nil
 }
 
+// RoutedClient returns a client that routes requests according to the ACL,
+// with hyClient as the "proxy" outbound. If there is no ACL, hyClient is returned as is.
+func (c *clientConfig) RoutedClient(hyClient client.Client) (client.Client, error) {
+	if c.ACL.File == "" && len(c.ACL.Inline) == 0 {
+		return hyClient, nil
+	}
+	direct, err := serverConfigOutboundDirectToOutbound(c.ACL.Direct)
+	if err != nil {
+		if ce, ok := err.(configError); ok {
+			ce.Field = "acl.direct" + strings.TrimPrefix(ce.Field, "outbounds.direct")
+			return nil, ce
+		}
+		return nil, err
+	}
+	rsLoader, err := serverConfigACLRuleSetsToLoader(c.ACL.RuleSets)
+	if err != nil {
+		return nil, err
+	}
+	gLoader := &aclLoader{
+		GeoLoader: &utils.GeoLoader{
+			GeoIPFilename:   c.ACL.GeoIP,
+			GeoSiteFilename: c.ACL.GeoSite,
+			UpdateInterval:  c.ACL.GeoUpdateInterval,
+			DownloadFunc:    geoDownloadFunc,
+			DownloadErrFunc: geoDownloadErrFunc,
+		},
+		RuleSetLoader: rsLoader,
+	}
+	// "proxy" comes first, so it's also the default
+	obs := []outbounds.OutboundEntry{
+		{Name: "proxy", Outbound: outbounds.NewHysteriaOutbound(hyClient)},
+		{Name: "direct", Outbound: direct},
+	}
+	ob, _, err := newACLOrFirstOutbound(c.ACL.File, c.ACL.Inline, obs, gLoader)
+	if err != nil {
+		return nil, err
+	}
+	// Like on the server side, a resolver is needed for IP rules to work on
+	// domain requests. It only resolves locally when the ACL gets to such a rule,
+	// and proxied requests still send the domain to the server.
+	ob = outbounds.NewOnDemandSystemResolver(ob)
+	return &outbounds.PluggableOutboundClientAdapter{PluggableOutbound: ob}, nil
+}
+
+// serverConfigs returns a clientConfig for each entry in the server list.
+func (c *clientConfig) serverConfigs() []*clientConfig {
+	configs := make([]*clientConfig, len(c.Servers))
+	for i, entry := range c.Servers {
+		configs[i] = entry.clientConfig()
+	}
+	return configs
+}
+
+func (e clientConfigServer) clientConfig() *clientConfig {
+	return &clientConfig{
+		Server:     e.Server,
+		Auth:       e.Auth,
+		Transport:  e.Transport,
+		Obfs:       e.Obfs,
+		TLS:        e.TLS,
+		QUIC:       e.QUIC,
+		Bandwidth:  e.Bandwidth,
+		Congestion: e.Congestion,
+		FastOpen:   e.FastOpen,
+		UDPStream:  e.UDPStream,
+	}
+}
+
+// MultiConfig validates the load balancing fields and returns a Hysteria multi-server
+// client config. The configs of the individual servers are only evaluated when connecting.
+func (c *clientConfig) MultiConfig() (*client.MultiConfig, error) {
+	if c.Server != "" {
+		return nil, configError{Field: "servers", Err: errors.New("cannot set both server and servers")}
+	}
+	hyConfig := &client.MultiConfig{
+		HealthCheckInterval: c.LoadBalance.HealthCheckInterval,
+	}
+	switch strings.ToLower(c.LoadBalance.Strategy) {
+	case "", "failover":
+		hyConfig.Strategy = client.MultiStrategyFailover
+	case "roundrobin":
+		hyConfig.Strategy = client.MultiStrategyRoundRobin
+	case "lowestrtt":
+		hyConfig.Strategy = client.MultiStrategyLowestRTT
+	case "sticky":
+		hyConfig.Strategy = client.MultiStrategySticky
+	default:
+		return nil, configError{Field: "loadBalance.strategy", Err: errors.New("unsupported strategy")}
+	}
+	if c.LoadBalance.HealthCheckInterval < 0 {
+		return nil, configError{Field: "loadBalance.healthCheckInterval", Err: errors.New("must be positive")}
+	}
+	for i, sc := range c.serverConfigs() {
+		i, sc := i, sc
+		hyConfig.ConfigFuncs = append(hyConfig.ConfigFuncs, func() (*client.Config, error) {
+			config, err := sc.Config()
+			if ce, ok := err.(configError); ok {
+				ce.Field = fmt.Sprintf("servers[%d].%s", i, ce.Field)
+				return nil, ce
+			}
+			return config, err
+		})
+	}
+	return hyConfig, nil
+}
+
 func runClient(cmd *cobra.Command, args []string) {
 	logger.Info("client mode")
 
@@ -452,39 +749,77 @@ func runClient(cmd *cobra.Command, args []string) {
 		logger.Fatal("failed to parse client config", zap.Error(err))
 	}
 
-	c, err := client.NewReconnectableClient(
-		config.Config,
-		func(c client.Client, info *client.HandshakeInfo, count int) {
-			connectLog(info, count)
-			// On the client side, we start checking for updates after we successfully connect
-			// to the server, which, depending on whether lazy mode is enabled, may or may not
-			// be immediately after the client starts. We don't want the update check request
-			// to interfere with the lazy mode option.
-			if count == 1 && !disableUpdateCheck {
-				go runCheckUpdateClient(c)
-			}
-		}, config.Lazy)
-	if err != nil {
-		logger.Fatal("failed to initialize client", zap.Error(err))
+	connectedFunc := func(c client.Client, info *client.HandshakeInfo, count int) {
+		connectLog(info, count)
+		// On the client side, we start checking for updates after we successfully connect
+		// to the server, which, depending on whether lazy mode is enabled, may or may not
+		// be immediately after the client starts. We don't want the update check request
+		// to interfere with the lazy mode option.
+		if count == 1 && !disableUpdateCheck {
+			go runCheckUpdateClient(c)
+		}
+	}
+	var c client.Client
+	var uris []string
+	if len(config.Servers) > 0 {
+		multiConfig, err := config.MultiConfig()
+		if err != nil {
+			logger.Fatal("failed to load client config", zap.Error(err))
+		}
+		multiConfig.ConnectedFunc = func(c client.Client, index int, info *client.HandshakeInfo, count int) {
+			logger.Info("using server", zap.Int("index", index), zap.String("server", config.Servers[index].Server))
+			connectedFunc(c, info, count)
+		}
+		c, err = client.NewMultiClient(multiConfig, config.Lazy)
+		if err != nil {
+			logger.Fatal("failed to initialize client", zap.Error(err))
+		}
+		for _, sc := range config.serverConfigs() {
+			sc.parseURI()
+			uris = append(uris, sc.URI())
+		}
+	} else {
+		var err error
+		c, err = client.NewReconnectableClient(config.Config, connectedFunc, config.Lazy)
+		if err != nil {
+			logger.Fatal("failed to initialize client", zap.Error(err))
+		}
+		uris = []string{config.URI()}
 	}
 	defer c.Close()
 
-	uri := config.URI()
-	logger.Info("use this URI to share your server", zap.String("uri", uri))
-	if showQR {
-		utils.PrintQR(uri)
+	// Routed client for the proxy modes
+	rc, err := config.RoutedClient(c)
+	if err != nil {
+		logger.Fatal("failed to load client ACL", zap.Error(err))
+	}
+
+	// Fake IP pool shared by the DNS server and TUN
+	var fakeIPPool *fakeip.Pool
+	if config.DNS != nil {
+		fakeIPPool, err = config.DNS.FakeIPPool()
+		if err != nil {
+			logger.Fatal("failed to initialize fake IP pool", zap.Error(err))
+		}
+	}
+
+	for _, uri := range uris {
+		logger.Info("use this URI to share your server", zap.String("uri", uri))
+		if showQR {
+			utils.PrintQR(uri)
+		}
 	}
 
 	// Register modes
 	var runner clientModeRunner
 	if config.SOCKS5 != nil {
 		runner.Add("SOCKS5 server", func() error {
-			return clientSOCKS5(*config.SOCKS5, c)
+			return clientSOCKS5(*config.SOCKS5, rc)
 		})
 	}
 	if config.HTTP != nil {
 		runner.Add("HTTP proxy server", func() error {
-			return clientHTTP(*config.HTTP, c)
+			return clientHTTP(*config.HTTP, rc)
 		})
 	}
 	if len(config.TCPForwarding) > 0 {
@@ -497,24 +832,34 @@ func runClient(cmd *cobra.Command, args []string) {
 			return clientUDPForwarding(config.UDPForwarding, c)
 		})
 	}
+	if len(config.Reverse) > 0 {
+		runner.Add("reverse forwarding", func() error {
+			return clientReverse(config.Reverse, c)
+		})
+	}
 	if config.TCPTProxy != nil {
 		runner.Add("TCP transparent proxy", func() error {
-			return clientTCPTProxy(*config.TCPTProxy, c)
+			return clientTCPTProxy(*config.TCPTProxy, rc)
 		})
 	}
 	if config.UDPTProxy != nil {
 		runner.Add("UDP transparent proxy", func() error {
-			return clientUDPTProxy(*config.UDPTProxy, c)
+			return clientUDPTProxy(*config.UDPTProxy, rc)
 		})
 	}
 	if config.TCPRedirect != nil {
 		runner.Add("TCP redirect", func() error {
-			return clientTCPRedirect(*config.TCPRedirect, c)
+			return clientTCPRedirect(*config.TCPRedirect, rc)
 		})
 	}
 	if config.TUN != nil {
 		runner.Add("TUN", func() error {
-			return clientTUN(*config.TUN, c)
+			return clientTUN(*config.TUN, rc, fakeIPPool)
+		})
+	}
+	if config.DNS != nil {
+		runner.Add("DNS server", func() error {
+			return clientDNS(*config.DNS, c, fakeIPPool)
 		})
 	}
 
@@ -698,6 +1043,86 @@ func clientUDPForwarding(entries []udpForwardingEntry, c client.Client) error {
 	return <-errChan
 }
 
+// clientReverse runs the reverse forwardings. The listeners on the server are
+// tied to the connection, so they are requested again whenever they're closed.
+func clientReverse(entries []reverseEntry, c client.Client) error {
+	rc, ok := c.(client.ReverseClient)
+	if !ok {
+		return errors.New("reverse forwarding is not supported by the client")
+	}
+	direct := &outbounds.PluggableOutboundClientAdapter{
+		PluggableOutbound: outbounds.NewDirectOutboundSimple(outbounds.DirectOutboundModeAuto),
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.Network = strings.ToLower(e.Network)
+		if e.Network == "" {
+			e.Network = "tcp"
+		}
+		if e.Network != "tcp" && e.Network != "udp" {
+			return configError{Field: "reverse.network", Err: errors.New("unsupported network")}
+		}
+		if e.Listen == "" {
+			return configError{Field: "reverse.listen", Err: errors.New("listen address is empty")}
+		}
+		if e.Remote == "" {
+			return configError{Field: "reverse.remote", Err: errors.New("remote address is empty")}
+		}
+	}
+	errChan := make(chan error, len(entries))
+	for _, e := range entries {
+		go func(e reverseEntry) {
+			errChan <- runReverse(e, rc, direct)
+		}(e)
+	}
+	// Return if any one of the forwarding fails
+	return <-errChan
+}
+
+func runReverse(e reverseEntry, rc client.ReverseClient, direct client.Client) error {
+	listened := false
+	for {
+		var err error
+		if e.Network == "tcp" {
+			var l net.Listener
+			l, err = rc.ListenTCP(e.Listen)
+			if err == nil {
+				listened = true
+				logger.Info("reverse TCP forwarding listening on server", zap.String("addr", e.Listen), zap.String("remote", e.Remote))
+				t := &forwarding.TCPTunnel{
+					HyClient:    direct,
+					Remote:      e.Remote,
+					EventLogger: &tcpLogger{},
+				}
+				err = t.Serve(l)
+			}
+		} else {
+			var pc net.PacketConn
+			pc, err = rc.ListenUDP(e.Listen)
+			if err == nil {
+				listened = true
+				logger.Info("reverse UDP forwarding listening on server", zap.String("addr", e.Listen), zap.String("remote", e.Remote))
+				u := &forwarding.UDPTunnel{
+					HyClient:    direct,
+					Remote:      e.Remote,
+					Timeout:     e.Timeout,
+					EventLogger: &udpLogger{},
+				}
+				err = u.Serve(pc)
+			}
+		}
+		// A rejected request on the first try is most likely a configuration problem
+		// (not allowed by the server). Later on it may also be the old listener not
+		// yet closed by the server after reconnecting, so we keep retrying.
+		var dErr hyErrors.DialError
+		if !listened && errors.As(err, &dErr) {
+			return configError{Field: "reverse.listen", Err: err}
+		}
+		logger.Warn("reverse forwarding listener closed, retrying", zap.String("network", e.Network), zap.String("addr", e.Listen), zap.Error(err))
+		time.Sleep(reverseRetryInterval)
+	}
+}
+
 func clientTCPTProxy(config tcpTProxyConfig, c client.Client) error {
 	if config.Listen == "" {
 		return configError{Field: "listen", Err: errors.New("listen address is empty")}
@@ -747,7 +1172,7 @@ func clientTCPRedirect(config tcpRedirectConfig, c client.Client) error {
 	return p.ListenAndServe(laddr)
 }
 
-func clientTUN(config tunConfig, c client.Client) error {
+func clientTUN(config tunConfig, c client.Client, fakeIPPool *fakeip.Pool) error {
 	supportedPlatforms := []string{"linux", "darwin", "windows", "android"}
 	if !slices.Contains(supportedPlatforms, runtime.GOOS) {
 		logger.Error("TUN is not supported on this platform", zap.String("platform", runtime.GOOS))
@@ -786,6 +1211,10 @@ func clientTUN(config tunConfig, c client.Client) error {
 		Inet4Address: []netip.Prefix{prefix4},
 		Inet6Address: []netip.Prefix{prefix6},
 	}
+	if fakeIPPool != nil {
+		// Only set if not nil, as a nil pointer in the interface is not nil
+		server.FakeIP = fakeIPPool
+	}
 	if config.Route != nil {
 		server.AutoRoute = true
 		server.StructRoute = config.Route.Strict
@@ -833,6 +1262,33 @@ func clientTUN(config tunConfig, c client.Client) error {
 	return server.Serve()
 }
 
+func clientDNS(config dnsConfig, c client.Client, fakeIPPool *fakeip.Pool) error {
+	if config.Listen == "" {
+		return configError{Field: "listen", Err: errors.New("listen address is empty")}
+	}
+	upstream := config.Upstream
+	if upstream == "" {
+		upstream = "1.1.1.1:53"
+	} else if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+	l, err := correctnet.ListenPacket("udp", config.Listen)
+	if err != nil {
+		return configError{Field: "listen", Err: err}
+	}
+	s := &dns.Server{
+		HyClient:    c,
+		Upstream:    upstream,
+		Direct:      config.Direct,
+		Timeout:     config.Timeout,
+		FakeIP:      fakeIPPool,
+		EventLogger: &dnsLogger{},
+	}
+	logger.Info("DNS server listening", zap.String("addr", config.Listen),
+		zap.String("upstream", upstream), zap.Bool("fakeIP", fakeIPPool != nil))
+	return s.Serve(l)
+}
+
 // parseServerAddrString parses server address string.
 // Server address can be in either "host:port" or "host" format (in which case we assume port 443).
 func parseServerAddrString(addrStr string) (host, port, hostPort string) {
@@ -849,6 +1305,12 @@ func isPortHoppingPort(port string) bool {
 	return strings.Contains(port, "-") || strings.Contains(port, ",")
 }
 
+// isUDPHopAddr returns whether the server address needs to be a udphop address,
+// i.e. it has a port hopping port, or multiple comma-separated hosts.
+func isUDPHopAddr(host, port string) bool {
+	return isPortHoppingPort(port) || strings.Contains(host, ",")
+}
+
 // normalizeCertHash normalizes a certificate hash string.
 // It converts all characters to lowercase and removes possible separators such as ":" and "-".
 func normalizeCertHash(hash string) string {
@@ -880,6 +1342,16 @@ func connectLog(info *client.HandshakeInfo, count int) {
 		zap.Bool("udpEnabled", info.UDPEnabled),
 		zap.Uint64("tx", info.Tx),
 		zap.Int("count", count))
+	if info.Fallback {
+		logger.Warn("connected using the fallback transport, QUIC over UDP may be blocked")
+	}
+	logger.Debug("server capabilities",
+		zap.Int("version", info.Capabilities.Version),
+		zap.Strings("extensions", info.Capabilities.Extensions),
+		zap.Int("udpMaxSessions", info.UDPMaxSessions))
+	for k, v := range info.Hints {
+		logger.Info("server hint", zap.String("key", k), zap.String("value", v))
+	}
 }
 
 type socks5Logger struct{}
@@ -1004,6 +1476,16 @@ func (l *tcpRedirectLogger) Error(addr, reqAddr net.Addr, err error) {
 	}
 }
 
+type dnsLogger struct{}
+
+func (l *dnsLogger) Query(addr net.Addr, name string, qType uint16) {
+	logger.Debug("DNS query", zap.String("addr", addr.String()), zap.String("name", name), zap.Uint16("type", qType))
+}
+
+func (l *dnsLogger) Error(addr net.Addr, name string, err error) {
+	logger.Warn("DNS query error", zap.String("addr", addr.String()), zap.String("name", name), zap.Error(err))
+}
+
 type tunLogger struct{}
 
 func (l *tunLogger) TCPRequest(addr, reqAddr string) {
@@ -2,17 +2,24 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/caddyserver/certmagic"
@@ -28,19 +35,27 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/apernet/hysteria/app/v2/internal/utils"
+	"github.com/apernet/hysteria/core/v2/client"
 	"github.com/apernet/hysteria/core/v2/server"
 	"github.com/apernet/hysteria/extras/v2/auth"
 	"github.com/apernet/hysteria/extras/v2/correctnet"
+	"github.com/apernet/hysteria/extras/v2/limiter"
 	"github.com/apernet/hysteria/extras/v2/masq"
 	"github.com/apernet/hysteria/extras/v2/obfs"
 	"github.com/apernet/hysteria/extras/v2/outbounds"
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl/ruleset"
 	"github.com/apernet/hysteria/extras/v2/sniff"
 	"github.com/apernet/hysteria/extras/v2/trafficlogger"
+	"github.com/apernet/hysteria/extras/v2/transport/fallback"
+	"github.com/apernet/hysteria/extras/v2/transport/multiport"
+	"github.com/apernet/hysteria/extras/v2/transport/reuseport"
 	eUtils "github.com/apernet/hysteria/extras/v2/utils"
 )
 
 const (
 	defaultListenAddr = ":443"
+
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 var serverCmd = &cobra.Command{
@@ -54,23 +69,34 @@ func init() {
 }
 
 type serverConfig struct {
-	Listen                string                      `mapstructure:"listen"`
-	Obfs                  serverConfigObfs            `mapstructure:"obfs"`
-	TLS                   *serverConfigTLS            `mapstructure:"tls"`
-	ACME                  *serverConfigACME           `mapstructure:"acme"`
-	QUIC                  serverConfigQUIC            `mapstructure:"quic"`
-	Bandwidth             serverConfigBandwidth       `mapstructure:"bandwidth"`
-	IgnoreClientBandwidth bool                        `mapstructure:"ignoreClientBandwidth"`
-	SpeedTest             bool                        `mapstructure:"speedTest"`
-	DisableUDP            bool                        `mapstructure:"disableUDP"`
-	UDPIdleTimeout        time.Duration               `mapstructure:"udpIdleTimeout"`
-	Auth                  serverConfigAuth            `mapstructure:"auth"`
-	Resolver              serverConfigResolver        `mapstructure:"resolver"`
-	Sniff                 serverConfigSniff           `mapstructure:"sniff"`
-	ACL                   serverConfigACL             `mapstructure:"acl"`
-	Outbounds             []serverConfigOutboundEntry `mapstructure:"outbounds"`
-	TrafficStats          serverConfigTrafficStats    `mapstructure:"trafficStats"`
-	Masquerade            serverConfigMasquerade      `mapstructure:"masquerade"`
+	Listen                string                       `mapstructure:"listen"`
+	Obfs                  serverConfigObfs             `mapstructure:"obfs"`
+	ReusePort             serverConfigReusePort        `mapstructure:"reusePort"`
+	TLS                   *serverConfigTLS             `mapstructure:"tls"`
+	ACME                  *serverConfigACME            `mapstructure:"acme"`
+	QUIC                  serverConfigQUIC             `mapstructure:"quic"`
+	Bandwidth             serverConfigBandwidth        `mapstructure:"bandwidth"`
+	IgnoreClientBandwidth bool                         `mapstructure:"ignoreClientBandwidth"`
+	Congestion            serverConfigCongestion       `mapstructure:"congestion"`
+	SpeedTest             bool                         `mapstructure:"speedTest"`
+	DisableUDP            bool                         `mapstructure:"disableUDP"`
+	UDPIdleTimeout        time.Duration                `mapstructure:"udpIdleTimeout"`
+	UDPMaxSessions        int                          `mapstructure:"udpMaxSessions"`
+	ShutdownTimeout       time.Duration                `mapstructure:"shutdownTimeout"`
+	Auth                  serverConfigAuth             `mapstructure:"auth"`
+	Limits                map[string]serverConfigLimit `mapstructure:"limits"`
+	Resolver              serverConfigResolver         `mapstructure:"resolver"`
+	Sniff                 serverConfigSniff            `mapstructure:"sniff"`
+	ACL                   serverConfigACL              `mapstructure:"acl"`
+	Outbounds             []serverConfigOutboundEntry  `mapstructure:"outbounds"`
+	UserRouting           []serverConfigUserRoute      `mapstructure:"userRouting"`
+	Reverse               []serverConfigReverseRule    `mapstructure:"reverse"`
+	TrafficStats          serverConfigTrafficStats     `mapstructure:"trafficStats"`
+	Masquerade            serverConfigMasquerade       `mapstructure:"masquerade"`
+
+	// userGroups is created by fillOutboundConfig when user routing is enabled,
+	// and kept across reloads so that groups assigned at runtime are not lost.
+	userGroups *outbounds.UserGroups
 }
 
 type serverConfigObfsSalamander struct {
@@ -82,6 +108,11 @@ type serverConfigObfs struct {
 	Salamander serverConfigObfsSalamander `mapstructure:"salamander"`
 }
 
+type serverConfigReusePort struct {
+	Sockets  int  `mapstructure:"sockets"`
+	Steering bool `mapstructure:"steering"`
+}
+
 type serverConfigTLS struct {
 	Cert     string `mapstructure:"cert"`
 	Key      string `mapstructure:"key"`
@@ -138,6 +169,10 @@ type serverConfigBandwidth struct {
 	Down string `mapstructure:"down"`
 }
 
+type serverConfigCongestion struct {
+	Type string `mapstructure:"type"`
+}
+
 type serverConfigAuthHTTP struct {
 	URL      string `mapstructure:"url"`
 	Insecure bool   `mapstructure:"insecure"`
@@ -151,6 +186,15 @@ type serverConfigAuth struct {
 	Command  string               `mapstructure:"command"`
 }
 
+// serverConfigLimit uses the same perspective as serverConfigBandwidth:
+// up is from the server to the client, down is from the client to the server.
+type serverConfigLimit struct {
+	Up         string `mapstructure:"up"`
+	Down       string `mapstructure:"down"`
+	Quota      string `mapstructure:"quota"`
+	QuotaReset string `mapstructure:"quotaReset"`
+}
+
 type serverConfigResolverTCP struct {
 	Addr    string        `mapstructure:"addr"`
 	Timeout time.Duration `mapstructure:"timeout"`
@@ -192,11 +236,53 @@ type serverConfigSniff struct {
 }
 
 type serverConfigACL struct {
-	File              string        `mapstructure:"file"`
-	Inline            []string      `mapstructure:"inline"`
-	GeoIP             string        `mapstructure:"geoip"`
-	GeoSite           string        `mapstructure:"geosite"`
-	GeoUpdateInterval time.Duration `mapstructure:"geoUpdateInterval"`
+	File              string                   `mapstructure:"file"`
+	Inline            []string                 `mapstructure:"inline"`
+	GeoIP             string                   `mapstructure:"geoip"`
+	GeoSite           string                   `mapstructure:"geosite"`
+	GeoUpdateInterval time.Duration            `mapstructure:"geoUpdateInterval"`
+	RuleSets          []serverConfigACLRuleSet `mapstructure:"ruleSets"`
+}
+
+// serverConfigACLRuleSet is a named rule set that can be referenced
+// in ACL rules as "ruleset:name". It's either downloaded from URL
+// (and cached at Path), or loaded from the local file at Path.
+// A downloaded rule set is updated every Interval, unless it's pinned by SHA256.
+type serverConfigACLRuleSet struct {
+	Name     string        `mapstructure:"name"`
+	URL      string        `mapstructure:"url"`
+	Path     string        `mapstructure:"path"`
+	Format   string        `mapstructure:"format"`
+	SHA256   string        `mapstructure:"sha256"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// serverConfigUserRoute is an outbound pipeline (ACL & outbounds) for a group of users.
+// Users are assigned to a route either statically by listing their IDs here, or
+// at runtime by the HTTP authenticator returning the route name as their group.
+type serverConfigUserRoute struct {
+	Name      string                      `mapstructure:"name"`
+	Users     []string                    `mapstructure:"users"`
+	ACL       serverConfigUserRouteACL    `mapstructure:"acl"`
+	Outbounds []serverConfigOutboundEntry `mapstructure:"outbounds"`
+}
+
+// serverConfigUserRouteACL is like serverConfigACL, but the GeoIP/GeoSite
+// settings are shared with the main ACL.
+type serverConfigUserRouteACL struct {
+	File   string   `mapstructure:"file"`
+	Inline []string `mapstructure:"inline"`
+}
+
+// serverConfigReverseRule allows the listed users (all users if empty) to
+// listen on the given IPs (any if empty) and ports on the server for reverse forwarding.
+// An empty host in the listen address means all interfaces, which is only allowed
+// if the IPs include an unspecified address (0.0.0.0 or ::).
+type serverConfigReverseRule struct {
+	Users   []string `mapstructure:"users"`
+	Network string   `mapstructure:"network"` // "tcp", "udp", or empty for both
+	Hosts   []string `mapstructure:"hosts"`
+	Ports   string   `mapstructure:"ports"`
 }
 
 type serverConfigOutboundDirect struct {
@@ -218,12 +304,30 @@ type serverConfigOutboundHTTP struct {
 	Insecure bool   `mapstructure:"insecure"`
 }
 
+type serverConfigOutboundWireGuardPeer struct {
+	PublicKey    string        `mapstructure:"publicKey"`
+	PresharedKey string        `mapstructure:"presharedKey"`
+	Endpoint     string        `mapstructure:"endpoint"`
+	AllowedIPs   []string      `mapstructure:"allowedIPs"`
+	KeepAlive    time.Duration `mapstructure:"keepAlive"`
+}
+
+type serverConfigOutboundWireGuard struct {
+	PrivateKey string                              `mapstructure:"privateKey"`
+	Addresses  []string                            `mapstructure:"addresses"`
+	DNS        []string                            `mapstructure:"dns"`
+	MTU        int                                 `mapstructure:"mtu"`
+	Peers      []serverConfigOutboundWireGuardPeer `mapstructure:"peers"`
+}
+
 type serverConfigOutboundEntry struct {
-	Name   string                     `mapstructure:"name"`
-	Type   string                     `mapstructure:"type"`
-	Direct serverConfigOutboundDirect `mapstructure:"direct"`
-	SOCKS5 serverConfigOutboundSOCKS5 `mapstructure:"socks5"`
-	HTTP   serverConfigOutboundHTTP   `mapstructure:"http"`
+	Name      string                        `mapstructure:"name"`
+	Type      string                        `mapstructure:"type"`
+	Direct    serverConfigOutboundDirect    `mapstructure:"direct"`
+	SOCKS5    serverConfigOutboundSOCKS5    `mapstructure:"socks5"`
+	HTTP      serverConfigOutboundHTTP      `mapstructure:"http"`
+	Hysteria  clientConfigServer            `mapstructure:"hysteria"`
+	WireGuard serverConfigOutboundWireGuard `mapstructure:"wireguard"`
 }
 
 type serverConfigTrafficStats struct {
@@ -247,14 +351,19 @@ type serverConfigMasqueradeString struct {
 	StatusCode int               `mapstructure:"statusCode"`
 }
 
+type serverConfigMasqueradeFallback struct {
+	Path string `mapstructure:"path"`
+}
+
 type serverConfigMasquerade struct {
-	Type        string                       `mapstructure:"type"`
-	File        serverConfigMasqueradeFile   `mapstructure:"file"`
-	Proxy       serverConfigMasqueradeProxy  `mapstructure:"proxy"`
-	String      serverConfigMasqueradeString `mapstructure:"string"`
-	ListenHTTP  string                       `mapstructure:"listenHTTP"`
-	ListenHTTPS string                       `mapstructure:"listenHTTPS"`
-	ForceHTTPS  bool                         `mapstructure:"forceHTTPS"`
+	Type        string                         `mapstructure:"type"`
+	File        serverConfigMasqueradeFile     `mapstructure:"file"`
+	Proxy       serverConfigMasqueradeProxy    `mapstructure:"proxy"`
+	String      serverConfigMasqueradeString   `mapstructure:"string"`
+	ListenHTTP  string                         `mapstructure:"listenHTTP"`
+	ListenHTTPS string                         `mapstructure:"listenHTTPS"`
+	ForceHTTPS  bool                           `mapstructure:"forceHTTPS"`
+	Fallback    serverConfigMasqueradeFallback `mapstructure:"fallback"`
 }
 
 func (c *serverConfig) fillConn(hyConfig *server.Config) error {
@@ -262,28 +371,91 @@ func (c *serverConfig) fillConn(hyConfig *server.Config) error {
 	if listenAddr == "" {
 		listenAddr = defaultListenAddr
 	}
-	uAddr, err := net.ResolveUDPAddr("udp", listenAddr)
-	if err != nil {
-		return configError{Field: "listen", Err: err}
-	}
-	conn, err := correctnet.ListenUDP("udp", uAddr)
+	uAddrs, err := resolveListenAddrs(listenAddr)
 	if err != nil {
 		return configError{Field: "listen", Err: err}
 	}
+	var newObfuscator func() (obfs.Obfuscator, error)
 	switch strings.ToLower(c.Obfs.Type) {
 	case "", "plain":
-		hyConfig.Conn = conn
-		return nil
+		// Nothing to do
 	case "salamander":
-		ob, err := obfs.NewSalamanderObfuscator([]byte(c.Obfs.Salamander.Password))
-		if err != nil {
+		if _, err := obfs.NewSalamanderObfuscator([]byte(c.Obfs.Salamander.Password)); err != nil {
 			return configError{Field: "obfs.salamander.password", Err: err}
 		}
-		hyConfig.Conn = obfs.WrapPacketConn(conn, ob)
-		return nil
+		newObfuscator = func() (obfs.Obfuscator, error) {
+			return obfs.NewSalamanderObfuscator([]byte(c.Obfs.Salamander.Password))
+		}
 	default:
 		return configError{Field: "obfs.type", Err: errors.New("unsupported obfuscation type")}
 	}
+	if c.ReusePort.Sockets < 0 {
+		return configError{Field: "reusePort.sockets", Err: errors.New("must not be negative")}
+	}
+	if c.ReusePort.Steering {
+		if c.ReusePort.Sockets < 2 {
+			return configError{Field: "reusePort.steering", Err: errors.New("requires at least 2 sockets")}
+		}
+		if newObfuscator != nil {
+			return configError{Field: "reusePort.steering", Err: errors.New("not supported with obfuscation")}
+		}
+	}
+	// One group of conns per socket (of each port)
+	var groups [][]net.PacketConn
+	closeGroups := func() {
+		for _, group := range groups {
+			for _, conn := range group {
+				_ = conn.Close()
+			}
+		}
+	}
+	for _, uAddr := range uAddrs {
+		var conns []net.PacketConn
+		if c.ReusePort.Sockets > 1 {
+			uConns, err := reuseport.ListenUDP(uAddr, c.ReusePort.Sockets, c.ReusePort.Steering)
+			if err != nil {
+				closeGroups()
+				return configError{Field: "reusePort", Err: err}
+			}
+			for _, conn := range uConns {
+				conns = append(conns, conn)
+			}
+		} else {
+			conn, err := correctnet.ListenUDP("udp", uAddr)
+			if err != nil {
+				closeGroups()
+				return configError{Field: "listen", Err: err}
+			}
+			conns = append(conns, conn)
+		}
+		if groups == nil {
+			groups = make([][]net.PacketConn, len(conns))
+		}
+		for i, conn := range conns {
+			groups[i] = append(groups[i], conn)
+		}
+	}
+	if c.ReusePort.Steering {
+		hyConfig.ConnIDSteering = len(groups)
+	}
+	conns := make([]net.PacketConn, len(groups))
+	for i, group := range groups {
+		if len(group) == 1 {
+			conns[i] = group[0]
+		} else {
+			conns[i], _ = multiport.NewPacketConn(group) // Never empty
+		}
+	}
+	if newObfuscator != nil {
+		// One obfuscator per conn, as they are not safe for concurrent use
+		for i, conn := range conns {
+			ob, _ := newObfuscator() // Already checked above
+			conns[i] = obfs.WrapPacketConn(conn, ob)
+		}
+	}
+	hyConfig.Conn = conns[0]
+	hyConfig.ExtraConns = append(hyConfig.ExtraConns, conns[1:]...)
+	return nil
 }
 
 func (c *serverConfig) fillTLSConfig(hyConfig *server.Config) error {
@@ -572,6 +744,99 @@ func serverConfigOutboundHTTPToOutbound(c serverConfigOutboundHTTP) (outbounds.P
 	return outbounds.NewHTTPOutbound(c.URL, c.Insecure)
 }
 
+// serverConfigOutboundHysteriaToOutbound creates an outbound that relays through another
+// Hysteria server. It uses the same fields as a client config for connecting to the server.
+// The connection is established lazily on the first request.
+func serverConfigOutboundHysteriaToOutbound(name string, c clientConfigServer) (outbounds.PluggableOutbound, error) {
+	if c.Server == "" {
+		return nil, configError{Field: "outbounds.hysteria.server", Err: errors.New("empty hysteria server address")}
+	}
+	cc := c.clientConfig()
+	hyClient, err := client.NewReconnectableClient(
+		func() (*client.Config, error) {
+			config, err := cc.Config()
+			if ce, ok := err.(configError); ok {
+				ce.Field = "outbounds.hysteria." + ce.Field
+				return nil, ce
+			}
+			return config, err
+		},
+		func(c client.Client, info *client.HandshakeInfo, count int) {
+			logger.Info("hysteria outbound connected",
+				zap.String("name", name),
+				zap.Bool("udpEnabled", info.UDPEnabled),
+				zap.Uint64("tx", info.Tx),
+				zap.Int("count", count))
+		}, true)
+	if err != nil {
+		return nil, err
+	}
+	return outbounds.NewHysteriaOutbound(hyClient), nil
+}
+
+func serverConfigOutboundWireGuardToOutbound(c serverConfigOutboundWireGuard) (outbounds.PluggableOutbound, error) {
+	if c.PrivateKey == "" {
+		return nil, configError{Field: "outbounds.wireguard.privateKey", Err: errors.New("empty private key")}
+	}
+	if len(c.Addresses) == 0 {
+		return nil, configError{Field: "outbounds.wireguard.addresses", Err: errors.New("empty addresses")}
+	}
+	if len(c.Peers) == 0 {
+		return nil, configError{Field: "outbounds.wireguard.peers", Err: errors.New("empty peers")}
+	}
+	opts := outbounds.WireGuardOutboundOptions{
+		PrivateKey: c.PrivateKey,
+		MTU:        c.MTU,
+	}
+	for _, s := range c.Addresses {
+		// Accept both "10.0.0.2" and "10.0.0.2/32"
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, configError{Field: "outbounds.wireguard.addresses", Err: err}
+			}
+			addr = prefix.Addr()
+		}
+		opts.Addresses = append(opts.Addresses, addr)
+	}
+	for _, s := range c.DNS {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, configError{Field: "outbounds.wireguard.dns", Err: err}
+		}
+		opts.DNS = append(opts.DNS, addr)
+	}
+	for _, p := range c.Peers {
+		if p.PublicKey == "" {
+			return nil, configError{Field: "outbounds.wireguard.peers.publicKey", Err: errors.New("empty public key")}
+		}
+		peer := outbounds.WireGuardPeer{
+			PublicKey:    p.PublicKey,
+			PresharedKey: p.PresharedKey,
+			Endpoint:     p.Endpoint,
+			KeepAlive:    p.KeepAlive,
+		}
+		if len(p.AllowedIPs) == 0 {
+			// Route everything through the peer by default
+			peer.AllowedIPs = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+		}
+		for _, s := range p.AllowedIPs {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, configError{Field: "outbounds.wireguard.peers.allowedIPs", Err: err}
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, prefix)
+		}
+		opts.Peers = append(opts.Peers, peer)
+	}
+	ob, err := outbounds.NewWireGuardOutbound(opts)
+	if err != nil {
+		return nil, configError{Field: "outbounds.wireguard", Err: err}
+	}
+	return ob, nil
+}
+
 func (c *serverConfig) fillRequestHook(hyConfig *server.Config) error {
 	if c.Sniff.Enable {
 		s := &sniff.Sniffer{
@@ -595,75 +860,221 @@ func (c *serverConfig) fillRequestHook(hyConfig *server.Config) error {
 	return nil
 }
 
-func (c *serverConfig) fillOutboundConfig(hyConfig *server.Config) error {
-	// Resolver, ACL, actual outbound are all implemented through the Outbound interface.
-	// Depending on the config, we build a chain like this:
-	// Resolver(ACL(Outbounds...))
-
-	// Outbounds
-	var obs []outbounds.OutboundEntry
-	if len(c.Outbounds) == 0 {
+func serverConfigOutboundEntriesToOutbounds(entries []serverConfigOutboundEntry) ([]outbounds.OutboundEntry, error) {
+	if len(entries) == 0 {
 		// Guarantee we have at least one outbound
-		obs = []outbounds.OutboundEntry{{
+		return []outbounds.OutboundEntry{{
 			Name:     "default",
 			Outbound: outbounds.NewDirectOutboundSimple(outbounds.DirectOutboundModeAuto),
-		}}
-	} else {
-		obs = make([]outbounds.OutboundEntry, len(c.Outbounds))
-		for i, entry := range c.Outbounds {
-			if entry.Name == "" {
-				return configError{Field: "outbounds.name", Err: errors.New("empty outbound name")}
-			}
-			var ob outbounds.PluggableOutbound
-			var err error
-			switch strings.ToLower(entry.Type) {
-			case "direct":
-				ob, err = serverConfigOutboundDirectToOutbound(entry.Direct)
-			case "socks5":
-				ob, err = serverConfigOutboundSOCKS5ToOutbound(entry.SOCKS5)
-			case "http":
-				ob, err = serverConfigOutboundHTTPToOutbound(entry.HTTP)
-			default:
-				err = configError{Field: "outbounds.type", Err: errors.New("unsupported outbound type")}
+		}}, nil
+	}
+	obs := make([]outbounds.OutboundEntry, len(entries))
+	for i, entry := range entries {
+		if entry.Name == "" {
+			return nil, configError{Field: "outbounds.name", Err: errors.New("empty outbound name")}
+		}
+		var ob outbounds.PluggableOutbound
+		var err error
+		switch strings.ToLower(entry.Type) {
+		case "direct":
+			ob, err = serverConfigOutboundDirectToOutbound(entry.Direct)
+		case "socks5":
+			ob, err = serverConfigOutboundSOCKS5ToOutbound(entry.SOCKS5)
+		case "http":
+			ob, err = serverConfigOutboundHTTPToOutbound(entry.HTTP)
+		case "hysteria", "hysteria2", "hy2":
+			ob, err = serverConfigOutboundHysteriaToOutbound(entry.Name, entry.Hysteria)
+		case "wireguard", "wg":
+			ob, err = serverConfigOutboundWireGuardToOutbound(entry.WireGuard)
+		default:
+			err = configError{Field: "outbounds.type", Err: errors.New("unsupported outbound type")}
+		}
+		if err != nil {
+			_ = closeAll(outboundClosers(obs[:i]))
+			return nil, err
+		}
+		obs[i] = outbounds.OutboundEntry{Name: entry.Name, Outbound: ob}
+	}
+	return obs, nil
+}
+
+// outboundClosers returns the outbounds that hold resources (e.g. a connection
+// to the next hop, or a tunnel) and need to be closed when no longer used.
+func outboundClosers(obs []outbounds.OutboundEntry) []io.Closer {
+	var closers []io.Closer
+	for _, ob := range obs {
+		if c, ok := ob.Outbound.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+	}
+	return closers
+}
+
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// aclLoader provides both GeoIP/GeoSite databases and rule sets to the ACL engine.
+type aclLoader struct {
+	*utils.GeoLoader
+	*utils.RuleSetLoader
+}
+
+func serverConfigACLRuleSetsToLoader(rss []serverConfigACLRuleSet) (*utils.RuleSetLoader, error) {
+	sources := make(map[string]utils.RuleSetSource, len(rss))
+	for _, rs := range rss {
+		name := strings.ToLower(rs.Name) // ACL rules are case-insensitive
+		if name == "" {
+			return nil, configError{Field: "acl.ruleSets.name", Err: errors.New("empty rule set name")}
+		}
+		if _, ok := sources[name]; ok {
+			return nil, configError{Field: "acl.ruleSets.name", Err: fmt.Errorf("duplicate rule set name %s", rs.Name)}
+		}
+		if rs.URL == "" && rs.Path == "" {
+			return nil, configError{Field: "acl.ruleSets.url", Err: errors.New("either url or path must be set")}
+		}
+		if rs.URL != "" {
+			u, err := url.Parse(rs.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, configError{Field: "acl.ruleSets.url", Err: errors.New("invalid rule set URL")}
 			}
-			if err != nil {
-				return err
+		}
+		format, err := ruleset.ParseFormat(rs.Format)
+		if err != nil {
+			return nil, configError{Field: "acl.ruleSets.format", Err: err}
+		}
+		if rs.SHA256 != "" {
+			if bs, err := hex.DecodeString(rs.SHA256); err != nil || len(bs) != sha256.Size {
+				return nil, configError{Field: "acl.ruleSets.sha256", Err: errors.New("invalid SHA-256 checksum")}
 			}
-			obs[i] = outbounds.OutboundEntry{Name: entry.Name, Outbound: ob}
+		}
+		if rs.Interval < 0 {
+			return nil, configError{Field: "acl.ruleSets.interval", Err: errors.New("interval must be non-negative")}
+		}
+		if rs.Interval != 0 && rs.SHA256 != "" {
+			// Any update would fail the checksum
+			return nil, configError{Field: "acl.ruleSets.interval", Err: errors.New("a rule set pinned by sha256 is never updated, interval must not be set")}
+		}
+		sources[name] = utils.RuleSetSource{
+			URL:      rs.URL,
+			Path:     rs.Path,
+			Format:   format,
+			SHA256:   rs.SHA256,
+			Interval: rs.Interval,
 		}
 	}
+	return &utils.RuleSetLoader{
+		Sources:         sources,
+		DownloadFunc:    ruleSetDownloadFunc,
+		DownloadErrFunc: ruleSetDownloadErrFunc,
+	}, nil
+}
 
-	var uOb outbounds.PluggableOutbound // "unified" outbound
-
-	// ACL
-	hasACL := false
-	if c.ACL.File != "" && len(c.ACL.Inline) > 0 {
-		return configError{Field: "acl", Err: errors.New("cannot set both acl.file and acl.inline")}
-	}
-	gLoader := &utils.GeoLoader{
-		GeoIPFilename:   c.ACL.GeoIP,
-		GeoSiteFilename: c.ACL.GeoSite,
-		UpdateInterval:  c.ACL.GeoUpdateInterval,
-		DownloadFunc:    geoDownloadFunc,
-		DownloadErrFunc: geoDownloadErrFunc,
-	}
-	if c.ACL.File != "" {
-		hasACL = true
-		acl, err := outbounds.NewACLEngineFromFile(c.ACL.File, obs, gLoader)
+// newACLOrFirstOutbound returns an ACL engine if either file or inline rules are set,
+// otherwise the first outbound. The bool indicates whether it's an ACL engine.
+func newACLOrFirstOutbound(file string, inline []string, obs []outbounds.OutboundEntry,
+	gLoader *aclLoader,
+) (outbounds.PluggableOutbound, bool, error) {
+	if file != "" && len(inline) > 0 {
+		return nil, false, configError{Field: "acl", Err: errors.New("cannot set both acl.file and acl.inline")}
+	}
+	if file != "" {
+		acl, err := outbounds.NewACLEngineFromFile(file, obs, gLoader)
 		if err != nil {
-			return configError{Field: "acl.file", Err: err}
+			return nil, false, configError{Field: "acl.file", Err: err}
 		}
-		uOb = acl
-	} else if len(c.ACL.Inline) > 0 {
-		hasACL = true
-		acl, err := outbounds.NewACLEngineFromString(strings.Join(c.ACL.Inline, "\n"), obs, gLoader)
+		return acl, true, nil
+	} else if len(inline) > 0 {
+		acl, err := outbounds.NewACLEngineFromString(strings.Join(inline, "\n"), obs, gLoader)
 		if err != nil {
-			return configError{Field: "acl.inline", Err: err}
+			return nil, false, configError{Field: "acl.inline", Err: err}
 		}
-		uOb = acl
+		return acl, true, nil
 	} else {
 		// No ACL, use the first outbound
-		uOb = obs[0].Outbound
+		return obs[0].Outbound, false, nil
+	}
+}
+
+func (c *serverConfig) fillOutboundConfig(hyConfig *server.Config) (err error) {
+	// Resolver, ACL, actual outbound are all implemented through the Outbound interface.
+	// Depending on the config, we build a chain like this:
+	// Resolver(ACL(Outbounds...))
+	// or with user routing:
+	// Resolver(UserRouter(ACL(Outbounds...), RouteACL(RouteOutbounds...)...))
+
+	// Outbounds
+	obs, err := serverConfigOutboundEntriesToOutbounds(c.Outbounds)
+	if err != nil {
+		return err
+	}
+	closers := outboundClosers(obs)
+	defer func() {
+		// Don't leave connections or tunnels behind if the config turns out to be invalid
+		if err != nil {
+			_ = closeAll(closers)
+		}
+	}()
+
+	// ACL
+	rsLoader, err := serverConfigACLRuleSetsToLoader(c.ACL.RuleSets)
+	if err != nil {
+		return err
+	}
+	gLoader := &aclLoader{
+		GeoLoader: &utils.GeoLoader{
+			GeoIPFilename:   c.ACL.GeoIP,
+			GeoSiteFilename: c.ACL.GeoSite,
+			UpdateInterval:  c.ACL.GeoUpdateInterval,
+			DownloadFunc:    geoDownloadFunc,
+			DownloadErrFunc: geoDownloadErrFunc,
+		},
+		RuleSetLoader: rsLoader,
+	}
+	// "unified" outbound
+	uOb, hasACL, err := newACLOrFirstOutbound(c.ACL.File, c.ACL.Inline, obs, gLoader)
+	if err != nil {
+		return err
+	}
+
+	// User routing
+	if len(c.UserRouting) > 0 {
+		users := make(map[string]string)
+		routes := make(map[string]outbounds.PluggableOutbound, len(c.UserRouting))
+		for _, route := range c.UserRouting {
+			if route.Name == "" {
+				return configError{Field: "userRouting.name", Err: errors.New("empty route name")}
+			}
+			if _, ok := routes[route.Name]; ok {
+				return configError{Field: "userRouting.name", Err: fmt.Errorf("duplicate route name %s", route.Name)}
+			}
+			for _, id := range route.Users {
+				users[id] = route.Name
+			}
+			rObs, err := serverConfigOutboundEntriesToOutbounds(route.Outbounds)
+			if err == nil {
+				closers = append(closers, outboundClosers(rObs)...)
+				var rHasACL bool
+				routes[route.Name], rHasACL, err = newACLOrFirstOutbound(route.ACL.File, route.ACL.Inline, rObs, gLoader)
+				hasACL = hasACL || rHasACL
+			}
+			if ce, ok := err.(configError); ok {
+				ce.Field = fmt.Sprintf("userRouting.%s.%s", route.Name, ce.Field)
+				return ce
+			} else if err != nil {
+				return err
+			}
+		}
+		if c.userGroups == nil {
+			c.userGroups = outbounds.NewUserGroups()
+		}
+		uOb = outbounds.NewUserRouter(users, c.userGroups, routes, uOb)
 	}
 
 	// Resolver
@@ -704,7 +1115,45 @@ func (c *serverConfig) fillOutboundConfig(hyConfig *server.Config) error {
 		uOb = outbounds.NewSpeedtestHandler(uOb)
 	}
 
-	hyConfig.Outbound = &outbounds.PluggableOutboundAdapter{PluggableOutbound: uOb}
+	hyConfig.Outbound = &outbounds.PluggableOutboundAdapter{PluggableOutbound: uOb, Closers: closers}
+	return nil
+}
+
+func (c *serverConfig) fillReverseAuthorizer(hyConfig *server.Config) error {
+	if len(c.Reverse) == 0 {
+		return nil
+	}
+	a := &reverseAuthorizer{}
+	for _, r := range c.Reverse {
+		rule := reverseAuthorizerRule{
+			Network: strings.ToLower(r.Network),
+		}
+		if rule.Network != "" && rule.Network != "tcp" && rule.Network != "udp" {
+			return configError{Field: "reverse.network", Err: errors.New("unsupported network")}
+		}
+		if r.Ports == "" {
+			return configError{Field: "reverse.ports", Err: errors.New("empty ports")}
+		}
+		rule.Ports = eUtils.ParsePortUnion(r.Ports)
+		if rule.Ports == nil {
+			return configError{Field: "reverse.ports", Err: errors.New("invalid port union")}
+		}
+		for _, h := range r.Hosts {
+			ip, err := netip.ParseAddr(h)
+			if err != nil {
+				return configError{Field: "reverse.hosts", Err: err}
+			}
+			rule.Hosts = append(rule.Hosts, ip.Unmap())
+		}
+		if len(r.Users) > 0 {
+			rule.Users = make(map[string]struct{}, len(r.Users))
+			for _, u := range r.Users {
+				rule.Users[u] = struct{}{}
+			}
+		}
+		a.Rules = append(a.Rules, rule)
+	}
+	hyConfig.ReverseAuthorizer = a
 	return nil
 }
 
@@ -730,6 +1179,17 @@ func (c *serverConfig) fillIgnoreClientBandwidth(hyConfig *server.Config) error
 	return nil
 }
 
+func (c *serverConfig) fillCongestionControl(hyConfig *server.Config) error {
+	switch t := strings.ToLower(c.Congestion.Type); t {
+	case "", server.CongestionControlBBR, server.CongestionControlBBR3, server.CongestionControlBrutal, server.CongestionControlAdaptiveBrutal,
+		server.CongestionControlCubic, server.CongestionControlReno:
+		hyConfig.CongestionControl = t
+		return nil
+	default:
+		return configError{Field: "congestion.type", Err: errors.New("unsupported congestion control type")}
+	}
+}
+
 func (c *serverConfig) fillDisableUDP(hyConfig *server.Config) error {
 	hyConfig.DisableUDP = c.DisableUDP
 	return nil
@@ -740,6 +1200,61 @@ func (c *serverConfig) fillUDPIdleTimeout(hyConfig *server.Config) error {
 	return nil
 }
 
+func (c *serverConfig) fillUDPMaxSessions(hyConfig *server.Config) error {
+	if c.UDPMaxSessions < 0 {
+		return configError{Field: "udpMaxSessions", Err: errors.New("must be non-negative")}
+	}
+	hyConfig.UDPMaxSessions = c.UDPMaxSessions
+	return nil
+}
+
+func (c *serverConfig) parseLimits() (map[string]limiter.Limit, error) {
+	limits := make(map[string]limiter.Limit, len(c.Limits))
+	for id, entry := range c.Limits {
+		var limit limiter.Limit
+		var err error
+		if entry.Up != "" {
+			limit.Rx, err = utils.ConvBandwidth(entry.Up)
+			if err != nil {
+				return nil, configError{Field: "limits." + id + ".up", Err: err}
+			}
+		}
+		if entry.Down != "" {
+			limit.Tx, err = utils.ConvBandwidth(entry.Down)
+			if err != nil {
+				return nil, configError{Field: "limits." + id + ".down", Err: err}
+			}
+		}
+		if entry.Quota != "" {
+			limit.Quota, err = utils.ConvBytes(entry.Quota)
+			if err != nil {
+				return nil, configError{Field: "limits." + id + ".quota", Err: err}
+			}
+		}
+		limit.QuotaPeriod, err = limiter.ParseQuotaPeriod(entry.QuotaReset)
+		if err != nil {
+			return nil, configError{Field: "limits." + id + ".quotaReset", Err: err}
+		}
+		limits[id] = limit
+	}
+	return limits, nil
+}
+
+// fillLimiter must be called before fillAuthenticator, as the HTTP authenticator
+// may also set per-user limits.
+func (c *serverConfig) fillLimiter(hyConfig *server.Config) error {
+	authType := strings.ToLower(c.Auth.Type)
+	if len(c.Limits) == 0 && authType != "http" && authType != "https" {
+		return nil
+	}
+	limits, err := c.parseLimits()
+	if err != nil {
+		return err
+	}
+	hyConfig.Limiter = limiter.NewLimiter(limits)
+	return nil
+}
+
 func (c *serverConfig) fillAuthenticator(hyConfig *server.Config) error {
 	if c.Auth.Type == "" {
 		return configError{Field: "auth.type", Err: errors.New("empty auth type")}
@@ -761,7 +1276,12 @@ func (c *serverConfig) fillAuthenticator(hyConfig *server.Config) error {
 		if c.Auth.HTTP.URL == "" {
 			return configError{Field: "auth.http.url", Err: errors.New("empty auth http url")}
 		}
-		hyConfig.Authenticator = auth.NewHTTPAuthenticator(c.Auth.HTTP.URL, c.Auth.HTTP.Insecure)
+		a := auth.NewHTTPAuthenticator(c.Auth.HTTP.URL, c.Auth.HTTP.Insecure)
+		if lim, ok := hyConfig.Limiter.(*limiter.Limiter); ok {
+			a.Limiter = lim
+		}
+		a.Groups = c.userGroups
+		hyConfig.Authenticator = a
 		return nil
 	case "command", "cmd":
 		if c.Auth.Command == "" {
@@ -788,6 +1308,43 @@ func (c *serverConfig) fillTrafficLogger(hyConfig *server.Config) error {
 	return nil
 }
 
+// resolveListenAddrs resolves a listen address whose port can also be
+// a list of ports and ranges (e.g. ":20000-30000,40000"), to one address per port.
+func resolveListenAddrs(addr string) ([]*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.ContainsAny(portStr, "-,") {
+		// Single port
+		uAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPAddr{uAddr}, nil
+	}
+	pu := eUtils.ParsePortUnion(portStr)
+	if pu == nil || pu.Contains(0) {
+		return nil, fmt.Errorf("%s is not a valid port range", portStr)
+	}
+	var ip *net.IPAddr
+	if host != "" {
+		ip, err = net.ResolveIPAddr("ip", host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var uAddrs []*net.UDPAddr
+	for _, port := range pu.Ports() {
+		uAddr := &net.UDPAddr{Port: int(port)}
+		if ip != nil {
+			uAddr.IP, uAddr.Zone = ip.IP, ip.Zone
+		}
+		uAddrs = append(uAddrs, uAddr)
+	}
+	return uAddrs, nil
+}
+
 // fillMasqHandler must be called after fillConn, as we may need to extract the QUIC
 // port number from Conn for MasqTCPServer.
 func (c *serverConfig) fillMasqHandler(hyConfig *server.Config) error {
@@ -872,6 +1429,9 @@ func (c *serverConfig) fillMasqHandler(hyConfig *server.Config) error {
 	}
 	hyConfig.MasqHandler = &masqHandlerLogWrapper{H: handler, QUIC: true}
 
+	if c.Masquerade.Fallback.Path != "" && c.Masquerade.ListenHTTPS == "" {
+		return configError{Field: "masquerade.fallback.path", Err: errors.New("fallback requires the HTTPS server (masquerade.listenHTTPS)")}
+	}
 	if c.Masquerade.ListenHTTP != "" || c.Masquerade.ListenHTTPS != "" {
 		if c.Masquerade.ListenHTTP != "" && c.Masquerade.ListenHTTPS == "" {
 			return configError{Field: "masquerade.listenHTTPS", Err: errors.New("having only HTTP server without HTTPS is not supported")}
@@ -886,6 +1446,19 @@ func (c *serverConfig) fillMasqHandler(hyConfig *server.Config) error {
 			},
 			ForceHTTPS: c.Masquerade.ForceHTTPS,
 		}
+		if c.Masquerade.Fallback.Path != "" {
+			// Clients that can't use QUIC connect through the HTTPS server
+			if !strings.HasPrefix(c.Masquerade.Fallback.Path, "/") {
+				return configError{Field: "masquerade.fallback.path", Err: errors.New("path must start with /")}
+			}
+			tcpAddr, err := net.ResolveTCPAddr("tcp", c.Masquerade.ListenHTTPS)
+			if err != nil {
+				return configError{Field: "masquerade.listenHTTPS", Err: err}
+			}
+			fs := fallback.NewServer(c.Masquerade.Fallback.Path, tcpAddr)
+			hyConfig.ExtraConns = append(hyConfig.ExtraConns, fs)
+			s.Fallback = fs
+		}
 		go runMasqTCPServer(&s, c.Masquerade.ListenHTTP, c.Masquerade.ListenHTTPS)
 	}
 	return nil
@@ -900,10 +1473,14 @@ func (c *serverConfig) Config() (*server.Config, error) {
 		c.fillQUICConfig,
 		c.fillRequestHook,
 		c.fillOutboundConfig,
+		c.fillReverseAuthorizer,
 		c.fillBandwidthConfig,
 		c.fillIgnoreClientBandwidth,
+		c.fillCongestionControl,
 		c.fillDisableUDP,
 		c.fillUDPIdleTimeout,
+		c.fillUDPMaxSessions,
+		c.fillLimiter,
 		c.fillAuthenticator,
 		c.fillEventLogger,
 		c.fillTrafficLogger,
@@ -928,6 +1505,9 @@ func runServer(cmd *cobra.Command, args []string) {
 	if err := viper.Unmarshal(&config); err != nil {
 		logger.Fatal("failed to parse server config", zap.Error(err))
 	}
+	// Created here so that it's shared across reloads even if
+	// user routing is only enabled later.
+	config.userGroups = outbounds.NewUserGroups()
 	hyConfig, err := config.Config()
 	if err != nil {
 		logger.Fatal("failed to load server config", zap.Error(err))
@@ -947,9 +1527,121 @@ func runServer(cmd *cobra.Command, args []string) {
 		go runCheckUpdateServer()
 	}
 
-	if err := s.Serve(); err != nil {
+	reloader := &serverReloader{
+		Server:     s,
+		Groups:     config.userGroups,
+		limitUsers: make(map[string]struct{}, len(config.Limits)),
+	}
+	reloader.Limiter, _ = hyConfig.Limiter.(*limiter.Limiter)
+	for id := range config.Limits {
+		reloader.limitUsers[id] = struct{}{}
+	}
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	defer signal.Stop(reloadChan)
+	go func() {
+		for range reloadChan {
+			logger.Info("received SIGHUP, reloading server config")
+			if err := reloader.Reload(); err != nil {
+				logger.Error("failed to reload server config, keeping the current one", zap.Error(err))
+			} else {
+				logger.Info("server config reloaded")
+			}
+		}
+	}()
+
+	shutdownTimeout := config.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownChan := make(chan os.Signal, 1)
+	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(shutdownChan)
+	shutdownDone := make(chan struct{})
+	var shuttingDown atomic.Bool
+	go func() {
+		defer close(shutdownDone)
+		<-shutdownChan
+		shuttingDown.Store(true)
+		logger.Info("received signal, shutting down gracefully", zap.Duration("timeout", shutdownTimeout))
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			logger.Warn("shutdown timed out, remaining connections closed", zap.Error(err))
+		}
+	}()
+
+	if err := s.Serve(); err != nil && !shuttingDown.Load() {
 		logger.Fatal("failed to serve", zap.Error(err))
 	}
+	<-shutdownDone
+	logger.Info("server shut down")
+}
+
+// serverReloader applies the parts of the config file
+// that can be changed on a running server (ACL, outbounds, authentication & limits).
+// Limits are updated in place to keep the quota users have already used.
+// User groups assigned by the HTTP authenticator are kept as well.
+// Changes to any other fields still require a restart.
+type serverReloader struct {
+	Server  server.Server
+	Limiter *limiter.Limiter
+	Groups  *outbounds.UserGroups
+
+	// limitUsers are the users with limits in the config file currently applied,
+	// so that those removed from it can be unlimited again.
+	limitUsers map[string]struct{}
+}
+
+// Reload re-reads the config file and applies it.
+func (r *serverReloader) Reload() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	var config serverConfig
+	if err := viper.Unmarshal(&config); err != nil {
+		return err
+	}
+	limits, err := config.parseLimits()
+	if err != nil {
+		return err
+	}
+	if r.Limiter == nil && len(limits) > 0 {
+		return errors.New("enabling limits requires a restart")
+	}
+	hyConfig := &server.Config{}
+	if r.Limiter != nil {
+		hyConfig.Limiter = r.Limiter
+	}
+	config.userGroups = r.Groups
+	fillers := []func(*server.Config) error{
+		config.fillOutboundConfig,
+		config.fillAuthenticator,
+	}
+	for _, f := range fillers {
+		if err := f(hyConfig); err != nil {
+			if c, ok := hyConfig.Outbound.(io.Closer); ok {
+				_ = c.Close()
+			}
+			return err
+		}
+	}
+	if r.Limiter != nil {
+		for id := range r.limitUsers {
+			if _, ok := limits[id]; !ok {
+				r.Limiter.SetLimit(id, limiter.Limit{})
+			}
+		}
+		r.limitUsers = make(map[string]struct{}, len(limits))
+		for id, limit := range limits {
+			r.Limiter.SetLimit(id, limit)
+			r.limitUsers[id] = struct{}{}
+		}
+	}
+	return r.Server.Reload(&server.ReloadConfig{
+		Outbound:      hyConfig.Outbound,
+		Authenticator: hyConfig.Authenticator,
+	})
 }
 
 func runTrafficStatsServer(listen string, handler http.Handler) {
@@ -989,6 +1681,16 @@ func geoDownloadErrFunc(err error) {
 	}
 }
 
+func ruleSetDownloadFunc(name, url string) {
+	logger.Info("downloading rule set", zap.String("name", name), zap.String("url", url))
+}
+
+func ruleSetDownloadErrFunc(name string, err error) {
+	if err != nil {
+		logger.Error("failed to download rule set", zap.String("name", name), zap.Error(err))
+	}
+}
+
 type serverLogger struct{}
 
 func (l *serverLogger) Connect(addr net.Addr, id string, tx uint64) {
@@ -1023,6 +1725,81 @@ func (l *serverLogger) UDPError(addr net.Addr, id string, sessionID uint32, err
 	}
 }
 
+func (l *serverLogger) ReverseRequest(addr net.Addr, id, network, listenAddr string) {
+	logger.Debug("reverse request", zap.String("addr", addr.String()), zap.String("id", id), zap.String("network", network), zap.String("listenAddr", listenAddr))
+}
+
+func (l *serverLogger) ReverseError(addr net.Addr, id, network, listenAddr string, err error) {
+	if err == nil {
+		logger.Debug("reverse listener closed", zap.String("addr", addr.String()), zap.String("id", id), zap.String("network", network), zap.String("listenAddr", listenAddr))
+	} else {
+		logger.Warn("reverse listener error", zap.String("addr", addr.String()), zap.String("id", id), zap.String("network", network), zap.String("listenAddr", listenAddr), zap.Error(err))
+	}
+}
+
+// reverseAuthorizer allows a reverse listener if any of the rules matches.
+type reverseAuthorizer struct {
+	Rules []reverseAuthorizerRule
+}
+
+type reverseAuthorizerRule struct {
+	Users   map[string]struct{} // nil = all users
+	Network string              // empty = both
+	Hosts   []netip.Addr        // nil = all IPs
+	Ports   eUtils.PortUnion
+}
+
+func (r *reverseAuthorizerRule) allowHost(ip netip.Addr) bool {
+	if r.Hosts == nil {
+		return true
+	}
+	for _, h := range r.Hosts {
+		// 0.0.0.0 and :: are interchangeable, as Go listens on both families for either
+		if h == ip || (h.IsUnspecified() && ip.IsUnspecified()) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowReverse checks the listen address against the rules. The host must be
+// an IP address or empty (all interfaces, treated as the unspecified address).
+// Hostnames are rejected, as what they resolve to is out of our control.
+func (a *reverseAuthorizer) AllowReverse(info *server.RequestInfo, network, addr string) bool {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return false
+	}
+	var ip netip.Addr
+	if host == "" {
+		ip = netip.IPv4Unspecified()
+	} else {
+		ip, err = netip.ParseAddr(host)
+		if err != nil {
+			return false
+		}
+		ip = ip.Unmap()
+	}
+	for _, r := range a.Rules {
+		if r.Network != "" && r.Network != network {
+			continue
+		}
+		if r.Users != nil {
+			if _, ok := r.Users[info.AuthID]; !ok {
+				continue
+			}
+		}
+		if r.allowHost(ip) && r.Ports.Contains(uint16(port)) {
+			return true
+		}
+	}
+	return false
+}
+
 type masqHandlerLogWrapper struct {
 	H    http.Handler
 	QUIC bool
@@ -7,6 +7,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/spf13/viper"
+
+	"github.com/apernet/hysteria/core/v2/server"
 )
 
 // TestServerConfig tests the parsing of the server config
@@ -25,6 +27,10 @@ func TestServerConfig(t *testing.T) {
 				Password: "cry_me_a_r1ver",
 			},
 		},
+		ReusePort: serverConfigReusePort{
+			Sockets:  4,
+			Steering: true,
+		},
 		TLS: &serverConfigTLS{
 			Cert:     "some.crt",
 			Key:      "some.key",
@@ -72,9 +78,14 @@ func TestServerConfig(t *testing.T) {
 			Down: "100 mbps",
 		},
 		IgnoreClientBandwidth: true,
-		SpeedTest:             true,
-		DisableUDP:            true,
-		UDPIdleTimeout:        120 * time.Second,
+		Congestion: serverConfigCongestion{
+			Type: "adaptive-brutal",
+		},
+		SpeedTest:       true,
+		DisableUDP:      true,
+		UDPIdleTimeout:  120 * time.Second,
+		UDPMaxSessions:  256,
+		ShutdownTimeout: 10 * time.Second,
 		Auth: serverConfigAuth{
 			Type:     "password",
 			Password: "goofy_ahh_password",
@@ -89,6 +100,17 @@ func TestServerConfig(t *testing.T) {
 			},
 			Command: "/etc/some_command",
 		},
+		Limits: map[string]serverConfigLimit{
+			"yolo": {
+				Up:         "50 mbps",
+				Down:       "20 mbps",
+				Quota:      "100 GB",
+				QuotaReset: "monthly",
+			},
+			"lol": {
+				Quota: "1 TB",
+			},
+		},
 		Resolver: serverConfigResolver{
 			Type: "udp",
 			TCP: serverConfigResolverTCP{
@@ -128,6 +150,26 @@ func TestServerConfig(t *testing.T) {
 			GeoIP:             "some.dat",
 			GeoSite:           "some_site.dat",
 			GeoUpdateInterval: 168 * time.Hour,
+			RuleSets: []serverConfigACLRuleSet{
+				{
+					Name:   "ads",
+					URL:    "https://example.com/ads.srs",
+					Path:   "ads.srs",
+					Format: "srs",
+					SHA256: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+				},
+				{
+					Name:   "local",
+					Path:   "local.txt",
+					Format: "domain",
+				},
+				{
+					Name:     "cn",
+					URL:      "https://example.com/cn.txt",
+					Format:   "cidr",
+					Interval: 12 * time.Hour,
+				},
+			},
 		},
 		Outbounds: []serverConfigOutboundEntry{
 			{
@@ -158,6 +200,78 @@ func TestServerConfig(t *testing.T) {
 					Insecure: true,
 				},
 			},
+			{
+				Name: "exitnode",
+				Type: "hysteria",
+				Hysteria: clientConfigServer{
+					Server: "exit.example.com:8443",
+					Auth:   "next_hop_password",
+					Obfs: clientConfigObfs{
+						Type: "salamander",
+						Salamander: clientConfigObfsSalamander{
+							Password: "exit_obfs",
+						},
+					},
+					TLS: clientConfigTLS{
+						SNI: "exit.example.com",
+					},
+					Bandwidth: clientConfigBandwidth{
+						Up:   "500 mbps",
+						Down: "500 mbps",
+					},
+				},
+			},
+			{
+				Name: "wgexit",
+				Type: "wireguard",
+				WireGuard: serverConfigOutboundWireGuard{
+					PrivateKey: "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+					Addresses:  []string{"10.66.66.2/32", "fd42:42:42::2/128"},
+					DNS:        []string{"10.66.66.1"},
+					MTU:        1280,
+					Peers: []serverConfigOutboundWireGuardPeer{
+						{
+							PublicKey:    "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+							PresharedKey: "/UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=",
+							Endpoint:     "wg.example.com:51820",
+							AllowedIPs:   []string{"0.0.0.0/0", "::/0"},
+							KeepAlive:    25 * time.Second,
+						},
+					},
+				},
+			},
+		},
+		UserRouting: []serverConfigUserRoute{
+			{
+				Name:  "premium",
+				Users: []string{"yolo", "Lol"},
+				ACL: serverConfigUserRouteACL{
+					Inline: []string{
+						"reject(geosite:cn)",
+						"fast(all) user:yolo",
+					},
+				},
+				Outbounds: []serverConfigOutboundEntry{
+					{
+						Name: "fast",
+						Type: "socks5",
+						SOCKS5: serverConfigOutboundSOCKS5{
+							Addr: "premium.proxy.net:1080",
+						},
+					},
+				},
+			},
+		},
+		Reverse: []serverConfigReverseRule{
+			{
+				Users:   []string{"yolo"},
+				Network: "tcp",
+				Ports:   "8000-9000",
+			},
+			{
+				Hosts: []string{"127.0.0.1", "::1"},
+				Ports: "10022",
+			},
 		},
 		TrafficStats: serverConfigTrafficStats{
 			Listen: ":9999",
@@ -184,6 +298,138 @@ func TestServerConfig(t *testing.T) {
 			ListenHTTP:  ":80",
 			ListenHTTPS: ":443",
 			ForceHTTPS:  true,
+			Fallback: serverConfigMasqueradeFallback{
+				Path: "/fallback-tunnel",
+			},
 		},
 	})
 }
+
+func TestResolveListenAddrs(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    []string
+		wantErr bool
+	}{
+		{addr: ":443", want: []string{":443"}},
+		{addr: "127.0.0.1:443", want: []string{"127.0.0.1:443"}},
+		{addr: "127.0.0.1:20000-20002", want: []string{"127.0.0.1:20000", "127.0.0.1:20001", "127.0.0.1:20002"}},
+		{addr: ":443,8443,20001-20000", want: []string{":443", ":8443", ":20000", ":20001"}},
+		{addr: "[::1]:5000-5001", want: []string{"[::1]:5000", "[::1]:5001"}},
+		{addr: ":0-10", wantErr: true},
+		{addr: ":1-abc", wantErr: true},
+		{addr: "443", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			uAddrs, err := resolveListenAddrs(tt.addr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var got []string
+			for _, uAddr := range uAddrs {
+				got = append(got, uAddr.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServerConfigACLRuleSetsToLoader(t *testing.T) {
+	const sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		name    string
+		rs      serverConfigACLRuleSet
+		wantErr string
+	}{
+		{
+			name: "remote",
+			rs:   serverConfigACLRuleSet{Name: "a", URL: "https://example.com/a.txt", Format: "domain", Interval: time.Hour},
+		},
+		{
+			name: "pinned",
+			rs:   serverConfigACLRuleSet{Name: "a", URL: "https://example.com/a.txt", Format: "domain", SHA256: sum},
+		},
+		{
+			name:    "pinned with interval",
+			rs:      serverConfigACLRuleSet{Name: "a", URL: "https://example.com/a.txt", Format: "domain", SHA256: sum, Interval: time.Hour},
+			wantErr: "acl.ruleSets.interval",
+		},
+		{
+			name:    "bad checksum",
+			rs:      serverConfigACLRuleSet{Name: "a", URL: "https://example.com/a.txt", Format: "domain", SHA256: "abc"},
+			wantErr: "acl.ruleSets.sha256",
+		},
+		{
+			name:    "no source",
+			rs:      serverConfigACLRuleSet{Name: "a"},
+			wantErr: "acl.ruleSets.url",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := serverConfigACLRuleSetsToLoader([]serverConfigACLRuleSet{tt.rs})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReverseAuthorizer(t *testing.T) {
+	c := &serverConfig{
+		Reverse: []serverConfigReverseRule{
+			{
+				Users:   []string{"yolo"},
+				Network: "tcp",
+				Ports:   "8000-9000",
+			},
+			{
+				Hosts: []string{"127.0.0.1", "::1"},
+				Ports: "10022",
+			},
+			{
+				Hosts: []string{"0.0.0.0"},
+				Ports: "10023",
+			},
+		},
+	}
+	hyConfig := &server.Config{}
+	assert.NoError(t, c.fillReverseAuthorizer(hyConfig))
+	a := hyConfig.ReverseAuthorizer
+
+	tests := []struct {
+		id      string
+		network string
+		addr    string
+		want    bool
+	}{
+		{id: "yolo", network: "tcp", addr: ":8080", want: true},
+		{id: "yolo", network: "tcp", addr: "10.0.0.1:8080", want: true},
+		{id: "yolo", network: "udp", addr: ":8080", want: false},
+		{id: "nobody", network: "tcp", addr: ":8080", want: false},
+		{id: "yolo", network: "tcp", addr: "localhost:8080", want: false},
+		{id: "nobody", network: "udp", addr: "127.0.0.1:10022", want: true},
+		{id: "nobody", network: "tcp", addr: "[::1]:10022", want: true},
+		{id: "nobody", network: "tcp", addr: "[::ffff:127.0.0.1]:10022", want: true},
+		{id: "nobody", network: "tcp", addr: "10.0.0.1:10022", want: false},
+		{id: "nobody", network: "tcp", addr: ":10022", want: false},
+		{id: "nobody", network: "tcp", addr: "0.0.0.0:10022", want: false},
+		{id: "nobody", network: "tcp", addr: ":10023", want: true},
+		{id: "nobody", network: "tcp", addr: "[::]:10023", want: true},
+		{id: "nobody", network: "tcp", addr: "127.0.0.1:10023", want: false},
+		{id: "nobody", network: "tcp", addr: "127.0.0.1:10024", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id+"/"+tt.network+"/"+tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.AllowReverse(&server.RequestInfo{AuthID: tt.id}, tt.network, tt.addr))
+		})
+	}
+
+	c.Reverse = []serverConfigReverseRule{{Hosts: []string{"localhost"}, Ports: "80"}}
+	assert.Error(t, c.fillReverseAuthorizer(hyConfig))
+}
@@ -40,16 +40,22 @@ func runShare(cmd *cobra.Command, args []string) {
 	if err := viper.Unmarshal(&config); err != nil {
 		logger.Fatal("failed to parse client config", zap.Error(err))
 	}
-	if _, err := config.Config(); err != nil {
-		logger.Fatal("failed to load client config", zap.Error(err))
+	configs := []*clientConfig{&config}
+	if len(config.Servers) > 0 {
+		configs = config.serverConfigs()
 	}
-
-	u := config.URI()
-
-	if !noText {
-		fmt.Println(u)
-	}
-	if withQR {
-		utils.PrintQR(u)
+	for _, c := range configs {
+		if _, err := c.Config(); err != nil {
+			logger.Fatal("failed to load client config", zap.Error(err))
+		}
+
+		u := c.URI()
+
+		if !noText {
+			fmt.Println(u)
+		}
+		if withQR {
+			utils.PrintQR(u)
+		}
 	}
 }
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/apernet/hysteria/app/v2/internal/fakeip"
+	"github.com/apernet/hysteria/core/v2/client"
+)
+
+const (
+	udpBufferSize = 4096
+
+	defaultTimeout = 5 * time.Second
+	fakeIPTTL      = 1 // seconds, so that clients don't hold on to fake IPs
+)
+
+// Server is a DNS server (UDP only) that forwards queries to an upstream
+// DNS server, either through a Hysteria client or directly.
+// If FakeIP is set, A & AAAA queries are instead answered with fake IPs
+// from the pool, which can be mapped back to the domains later (e.g. by TUN),
+// so that the Hysteria server receives the domains instead of the IPs.
+type Server struct {
+	HyClient    client.Client
+	Upstream    string // host:port
+	Direct      bool   // query upstream directly instead of through HyClient
+	Timeout     time.Duration
+	FakeIP      *fakeip.Pool // nil = disabled
+	EventLogger EventLogger
+}
+
+type EventLogger interface {
+	Query(addr net.Addr, name string, qType uint16)
+	Error(addr net.Addr, name string, err error)
+}
+
+func (s *Server) Serve(pc net.PacketConn) error {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go s.handle(pc, addr, query)
+	}
+}
+
+func (s *Server) handle(pc net.PacketConn, addr net.Addr, query []byte) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil || len(req.Question) != 1 {
+		// Ignore invalid queries
+		return
+	}
+	q := req.Question[0]
+	if s.EventLogger != nil {
+		s.EventLogger.Query(addr, q.Name, q.Qtype)
+	}
+	var resp []byte
+	var err error
+	if s.FakeIP != nil && q.Qclass == dns.ClassINET &&
+		(q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) {
+		resp, err = s.fakeIPAnswer(req).Pack()
+	} else {
+		resp, err = s.exchange(query)
+	}
+	if err != nil {
+		if s.EventLogger != nil {
+			s.EventLogger.Error(addr, q.Name, err)
+		}
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		resp, err = m.Pack()
+		if err != nil {
+			return
+		}
+	}
+	_, _ = pc.WriteTo(resp, addr)
+}
+
+// fakeIPAnswer answers an A or AAAA query with a fake IP.
+// If the pool has no range for the requested type, the answer is empty,
+// so that clients fall back to the other type.
+func (s *Server) fakeIPAnswer(req *dns.Msg) *dns.Msg {
+	q := req.Question[0]
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	ip, ok := s.FakeIP.IP(strings.TrimSuffix(q.Name, "."), q.Qtype == dns.TypeAAAA)
+	if !ok {
+		return m
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: fakeIPTTL}
+	if q.Qtype == dns.TypeA {
+		m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip.AsSlice()})
+	} else {
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip.AsSlice()})
+	}
+	return m
+}
+
+// exchange sends the raw query to the upstream server and returns the raw response.
+func (s *Server) exchange(query []byte) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	if s.Direct {
+		conn, err := net.DialTimeout("udp", s.Upstream, timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, udpBufferSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	hyConn, err := s.HyClient.UDP()
+	if err != nil {
+		return nil, err
+	}
+	defer hyConn.Close()
+	if err := hyConn.Send(query, s.Upstream); err != nil {
+		return nil, err
+	}
+	respCh := make(chan []byte, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		bs, _, err := hyConn.Receive()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		respCh <- bs
+	}()
+	select {
+	case bs := <-respCh:
+		return bs, nil
+	case err := <-errCh:
+		return nil, err
+	case <-time.After(timeout):
+		// Receive will return once the conn is closed
+		return nil, errors.New("upstream timeout")
+	}
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/app/v2/internal/fakeip"
+	"github.com/apernet/hysteria/core/v2/client"
+)
+
+// mockHyClient answers every DNS query with a fixed A record,
+// as if it was sent to a real upstream through the tunnel.
+type mockHyClient struct {
+	Upstream string
+	Fail     bool
+}
+
+func (c *mockHyClient) TCP(addr string) (net.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *mockHyClient) UDP() (client.HyUDPConn, error) {
+	if c.Fail {
+		return nil, errors.New("no UDP for you")
+	}
+	return &mockHyUDPConn{Upstream: c.Upstream, Ch: make(chan []byte, 1)}, nil
+}
+
+func (c *mockHyClient) Close() error {
+	return nil
+}
+
+type mockHyUDPConn struct {
+	Upstream string
+	Ch       chan []byte
+}
+
+func (c *mockHyUDPConn) Receive() ([]byte, string, error) {
+	bs, ok := <-c.Ch
+	if !ok {
+		return nil, "", errors.New("closed")
+	}
+	return bs, c.Upstream, nil
+}
+
+func (c *mockHyUDPConn) Send(bs []byte, addr string) error {
+	if addr != c.Upstream {
+		return errors.New("wrong upstream")
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(bs); err != nil {
+		return err
+	}
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("93.184.216.34"),
+	})
+	resp, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	c.Ch <- resp
+	return nil
+}
+
+func (c *mockHyUDPConn) Close() error {
+	return nil
+}
+
+func startTestServer(t *testing.T, s *Server) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() { _ = s.Serve(pc) }()
+	return pc.LocalAddr().String()
+}
+
+func query(t *testing.T, addr, name string, qType uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qType)
+	c := &dns.Client{Timeout: 2 * time.Second}
+	resp, _, err := c.Exchange(m, addr)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestServerForward(t *testing.T) {
+	addr := startTestServer(t, &Server{
+		HyClient: &mockHyClient{Upstream: "1.1.1.1:53"},
+		Upstream: "1.1.1.1:53",
+	})
+	resp := query(t, addr, "example.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Len(t, resp.Answer, 1)
+	assert.Equal(t, "93.184.216.34", resp.Answer[0].(*dns.A).A.String())
+
+	addr = startTestServer(t, &Server{
+		HyClient: &mockHyClient{Upstream: "1.1.1.1:53", Fail: true},
+		Upstream: "1.1.1.1:53",
+	})
+	resp = query(t, addr, "example.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+}
+
+func TestServerFakeIP(t *testing.T) {
+	pool, err := fakeip.NewPool(netip.MustParsePrefix("198.18.0.0/15"), netip.Prefix{})
+	assert.NoError(t, err)
+	addr := startTestServer(t, &Server{
+		HyClient: &mockHyClient{Upstream: "1.1.1.1:53"},
+		Upstream: "1.1.1.1:53",
+		FakeIP:   pool,
+	})
+
+	resp := query(t, addr, "Example.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Len(t, resp.Answer, 1)
+	ip, ok := netip.AddrFromSlice(resp.Answer[0].(*dns.A).A)
+	assert.True(t, ok)
+	domain, ok := pool.Lookup(ip)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	// No IPv6 range, empty answer
+	resp = query(t, addr, "example.com", dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+
+	// Other types are forwarded
+	resp = query(t, addr, "example.com", dns.TypeMX)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Len(t, resp.Answer, 1)
+}
@@ -0,0 +1,175 @@
+package fakeip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// maxEntries limits the number of domains each range can hold at the same time,
+// mainly to bound memory usage for large (IPv6) ranges.
+const maxEntries = 1 << 16
+
+// Pool hands out fake IPs for domains, and maps them back to the domains.
+// IPs are allocated sequentially from the ranges. When a range is full,
+// the oldest mapping is reused, so a fake IP is only valid for so long
+// (typically much longer than the TTL of the DNS answers it's used in).
+type Pool struct {
+	mutex sync.Mutex
+	v4    *ring
+	v6    *ring
+}
+
+// NewPool creates a Pool with the given ranges.
+// Either of them can be invalid (zero), but not both.
+func NewPool(prefix4, prefix6 netip.Prefix) (*Pool, error) {
+	if !prefix4.IsValid() && !prefix6.IsValid() {
+		return nil, errors.New("no fake IP range")
+	}
+	p := &Pool{}
+	if prefix4.IsValid() {
+		if !prefix4.Addr().Is4() {
+			return nil, errors.New("invalid IPv4 range")
+		}
+		r, err := newRing(prefix4)
+		if err != nil {
+			return nil, err
+		}
+		p.v4 = r
+	}
+	if prefix6.IsValid() {
+		if !prefix6.Addr().Is6() || prefix6.Addr().Is4In6() {
+			return nil, errors.New("invalid IPv6 range")
+		}
+		r, err := newRing(prefix6)
+		if err != nil {
+			return nil, err
+		}
+		p.v6 = r
+	}
+	return p, nil
+}
+
+// HasIPv4 returns whether the pool has an IPv4 range.
+func (p *Pool) HasIPv4() bool {
+	return p.v4 != nil
+}
+
+// HasIPv6 returns whether the pool has an IPv6 range.
+func (p *Pool) HasIPv6() bool {
+	return p.v6 != nil
+}
+
+// IP returns the fake IP for the domain, allocating one if necessary.
+// ok is false if the pool has no range for the requested IP version.
+func (p *Pool) IP(domain string, ipv6 bool) (addr netip.Addr, ok bool) {
+	r := p.v4
+	if ipv6 {
+		r = p.v6
+	}
+	if r == nil {
+		return netip.Addr{}, false
+	}
+	domain = normalizeDomain(domain)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return r.Get(domain), true
+}
+
+// Lookup returns the domain that the fake IP maps to.
+func (p *Pool) Lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	var r *ring
+	if p.v4 != nil && p.v4.Prefix.Contains(addr) {
+		r = p.v4
+	} else if p.v6 != nil && p.v6.Prefix.Contains(addr) {
+		r = p.v6
+	} else {
+		return "", false
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return r.Lookup(addr)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// ring allocates IPs from a prefix in a circular manner.
+// The first IP of the prefix is skipped, as it's usually the network address.
+type ring struct {
+	Prefix  netip.Prefix
+	Size    int
+	Next    int
+	Domains []string       // index -> domain, empty if unused
+	Indices map[string]int // domain -> index
+}
+
+func newRing(prefix netip.Prefix) (*ring, error) {
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	size := maxEntries
+	if hostBits < 17 {
+		size = 1<<hostBits - 2 // no network & broadcast addresses
+	}
+	if size < 1 {
+		return nil, errors.New("fake IP range is too small")
+	}
+	return &ring{
+		Prefix:  prefix,
+		Size:    size,
+		Domains: make([]string, size),
+		Indices: make(map[string]int),
+	}, nil
+}
+
+func (r *ring) Get(domain string) netip.Addr {
+	if i, ok := r.Indices[domain]; ok {
+		return r.addr(i)
+	}
+	i := r.Next
+	r.Next = (r.Next + 1) % r.Size
+	if old := r.Domains[i]; old != "" {
+		delete(r.Indices, old)
+	}
+	r.Domains[i] = domain
+	r.Indices[domain] = i
+	return r.addr(i)
+}
+
+func (r *ring) Lookup(addr netip.Addr) (string, bool) {
+	i, ok := r.index(addr)
+	if !ok || r.Domains[i] == "" {
+		return "", false
+	}
+	return r.Domains[i], true
+}
+
+// addr returns the IP at index i. Since the index is always smaller
+// than the size of the prefix, only the lowest 64 bits need to be changed.
+func (r *ring) addr(i int) netip.Addr {
+	bs := r.Prefix.Addr().As16()
+	low := binary.BigEndian.Uint64(bs[8:]) + uint64(i) + 1
+	binary.BigEndian.PutUint64(bs[8:], low)
+	a := netip.AddrFrom16(bs)
+	if r.Prefix.Addr().Is4() {
+		return a.Unmap()
+	}
+	return a
+}
+
+func (r *ring) index(addr netip.Addr) (int, bool) {
+	base := r.Prefix.Addr().As16()
+	bs := addr.As16()
+	if [8]byte(base[:8]) != [8]byte(bs[:8]) {
+		return 0, false
+	}
+	i := binary.BigEndian.Uint64(bs[8:]) - binary.BigEndian.Uint64(base[8:]) - 1
+	if i >= uint64(r.Size) {
+		return 0, false
+	}
+	return int(i), true
+}
@@ -0,0 +1,73 @@
+package fakeip
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p, err := NewPool(netip.MustParsePrefix("198.18.0.0/15"), netip.MustParsePrefix("fc00::/18"))
+	assert.NoError(t, err)
+	assert.True(t, p.HasIPv4())
+	assert.True(t, p.HasIPv6())
+
+	ip, ok := p.IP("Example.com.", false)
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("198.18.0.1"), ip)
+	ip, _ = p.IP("example.org", false)
+	assert.Equal(t, netip.MustParseAddr("198.18.0.2"), ip)
+	ip, _ = p.IP("example.com", false)
+	assert.Equal(t, netip.MustParseAddr("198.18.0.1"), ip)
+	ip, _ = p.IP("example.com", true)
+	assert.Equal(t, netip.MustParseAddr("fc00::1"), ip)
+
+	domain, ok := p.Lookup(netip.MustParseAddr("198.18.0.2"))
+	assert.True(t, ok)
+	assert.Equal(t, "example.org", domain)
+	domain, ok = p.Lookup(netip.MustParseAddr("::ffff:198.18.0.1"))
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+	domain, ok = p.Lookup(netip.MustParseAddr("fc00::1"))
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	_, ok = p.Lookup(netip.MustParseAddr("198.18.0.3")) // not allocated
+	assert.False(t, ok)
+	_, ok = p.Lookup(netip.MustParseAddr("198.18.0.0")) // network address
+	assert.False(t, ok)
+	_, ok = p.Lookup(netip.MustParseAddr("1.1.1.1")) // not in range
+	assert.False(t, ok)
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, err := NewPool(netip.MustParsePrefix("10.0.0.0/30"), netip.Prefix{})
+	assert.NoError(t, err)
+	assert.False(t, p.HasIPv6())
+	_, ok := p.IP("a.com", true)
+	assert.False(t, ok)
+
+	// Only 10.0.0.1 & 10.0.0.2 are usable
+	ipA, _ := p.IP("a.com", false)
+	ipB, _ := p.IP("b.com", false)
+	ipC, _ := p.IP("c.com", false)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), ipA)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.2"), ipB)
+	assert.Equal(t, ipA, ipC)
+	domain, ok := p.Lookup(ipA)
+	assert.True(t, ok)
+	assert.Equal(t, "c.com", domain)
+	// a.com gets a new IP
+	ipA, _ = p.IP("a.com", false)
+	assert.Equal(t, ipB, ipA)
+}
+
+func TestNewPoolInvalid(t *testing.T) {
+	_, err := NewPool(netip.Prefix{}, netip.Prefix{})
+	assert.Error(t, err)
+	_, err = NewPool(netip.MustParsePrefix("fc00::/18"), netip.Prefix{})
+	assert.Error(t, err)
+	_, err = NewPool(netip.MustParsePrefix("10.0.0.0/31"), netip.Prefix{})
+	assert.Error(t, err)
+}
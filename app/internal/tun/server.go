@@ -6,6 +6,8 @@ import (
 	"io"
 	"net"
 	"net/netip"
+	"strconv"
+	"sync"
 
 	tun "github.com/apernet/sing-tun"
 	"github.com/sagernet/sing/common/buf"
@@ -39,6 +41,14 @@ type Server struct {
 	Inet6RouteAddress        []netip.Prefix
 	Inet4RouteExcludeAddress []netip.Prefix
 	Inet6RouteExcludeAddress []netip.Prefix
+
+	// FakeIP maps fake IPs (handed out by the DNS server) back to domains,
+	// so that requests to them are sent as domain requests. nil = disabled
+	FakeIP FakeIPLookup
+}
+
+type FakeIPLookup interface {
+	Lookup(addr netip.Addr) (string, bool)
 }
 
 type EventLogger interface {
@@ -100,11 +110,22 @@ type tunHandler struct {
 	*Server
 }
 
+// reqAddr returns the address to request from HyClient,
+// which is the domain instead of the IP for fake IPs.
+func (t *tunHandler) reqAddr(addr metadata.Socksaddr) string {
+	if t.FakeIP != nil && addr.IsIP() {
+		if domain, ok := t.FakeIP.Lookup(addr.Addr); ok {
+			return net.JoinHostPort(domain, strconv.Itoa(int(addr.Port)))
+		}
+	}
+	return addr.String()
+}
+
 var _ tun.Handler = (*tunHandler)(nil)
 
 func (t *tunHandler) NewConnection(ctx context.Context, conn net.Conn, m metadata.Metadata) error {
 	addr := m.Source.String()
-	reqAddr := m.Destination.String()
+	reqAddr := t.reqAddr(m.Destination)
 	if t.EventLogger != nil {
 		t.EventLogger.TCPRequest(addr, reqAddr)
 	}
@@ -159,6 +180,20 @@ func (t *tunHandler) NewPacketConnection(ctx context.Context, conn network.Packe
 	}
 	defer rc.Close()
 
+	// Requests to fake IPs are sent to their domains, each in a session of its own.
+	// Replies come from whatever the domain resolved to on the server, but since
+	// there's only one destination per session, they must all be from the fake IP.
+	var fakeMutex sync.Mutex
+	fakeRCs := make(map[string]client.HyUDPConn) // reqAddr -> session, nil when closed
+	defer func() {
+		fakeMutex.Lock()
+		defer fakeMutex.Unlock()
+		for _, frc := range fakeRCs {
+			_ = frc.Close()
+		}
+		fakeRCs = nil
+	}()
+
 	// start forwarding
 	copyErrChan := make(chan error, 3)
 	go func() {
@@ -167,24 +202,7 @@ func (t *tunHandler) NewPacketConnection(ctx context.Context, conn network.Packe
 	}()
 	// local <- remote
 	go func() {
-		for {
-			bs, from, err := rc.Receive()
-			if err != nil {
-				copyErrChan <- err
-				return
-			}
-			var fromAddr metadata.Socksaddr
-			if ap, perr := netip.ParseAddrPort(from); perr == nil {
-				fromAddr = metadata.SocksaddrFromNetIP(ap)
-			} else {
-				fromAddr.Fqdn = from
-			}
-			err = conn.WritePacket(buf.As(bs), fromAddr)
-			if err != nil {
-				copyErrChan <- err
-				return
-			}
-		}
+		copyErrChan <- forwardUDPReplies(conn, rc, nil)
 	}()
 	// local -> remote
 	go func() {
@@ -198,7 +216,33 @@ func (t *tunHandler) NewPacketConnection(ctx context.Context, conn network.Packe
 				copyErrChan <- err
 				return
 			}
-			err = rc.Send(buffer.Bytes(), addr.String())
+			reqAddr := t.reqAddr(addr)
+			sendRC := rc
+			if reqAddr != addr.String() {
+				fakeMutex.Lock()
+				if fakeRCs == nil {
+					// Closed
+					fakeMutex.Unlock()
+					return
+				}
+				sendRC = fakeRCs[reqAddr]
+				if sendRC == nil {
+					sendRC, err = t.HyClient.UDP()
+					if err != nil {
+						fakeMutex.Unlock()
+						copyErrChan <- err
+						return
+					}
+					fakeRCs[reqAddr] = sendRC
+					go func() {
+						// The error is only from the session being closed or the connection
+						// failing, in which case the main session fails as well
+						_ = forwardUDPReplies(conn, sendRC, &addr)
+					}()
+				}
+				fakeMutex.Unlock()
+			}
+			err = sendRC.Send(buffer.Bytes(), reqAddr)
 			if err != nil {
 				copyErrChan <- err
 				return
@@ -209,6 +253,29 @@ func (t *tunHandler) NewPacketConnection(ctx context.Context, conn network.Packe
 	return nil
 }
 
+// forwardUDPReplies writes the packets received from rc to conn, until either fails.
+// If fromAddr is not nil, all packets appear to come from it.
+func forwardUDPReplies(conn network.PacketConn, rc client.HyUDPConn, fromAddr *metadata.Socksaddr) error {
+	for {
+		bs, from, err := rc.Receive()
+		if err != nil {
+			return err
+		}
+		var addr metadata.Socksaddr
+		if fromAddr != nil {
+			addr = *fromAddr
+		} else if ap, perr := netip.ParseAddrPort(from); perr == nil {
+			addr = metadata.SocksaddrFromNetIP(ap)
+		} else {
+			addr.Fqdn = from
+		}
+		err = conn.WritePacket(buf.As(bs), addr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func (t *tunHandler) NewError(ctx context.Context, err error) {
 	// unused
 }
@@ -0,0 +1,148 @@
+package tun
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/metadata"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/client"
+)
+
+type mapFakeIPLookup map[netip.Addr]string
+
+func (m mapFakeIPLookup) Lookup(addr netip.Addr) (string, bool) {
+	domain, ok := m[addr]
+	return domain, ok
+}
+
+// resolvingEchoHyClient echoes UDP packets back, from the address that the
+// destination resolves to (like a real server would), not the domain itself.
+type resolvingEchoHyClient struct {
+	Hosts map[string]string // domain -> IP
+}
+
+func (c *resolvingEchoHyClient) TCP(addr string) (net.Conn, error) {
+	panic("not implemented")
+}
+
+func (c *resolvingEchoHyClient) UDP() (client.HyUDPConn, error) {
+	return &resolvingEchoUDPConn{Hosts: c.Hosts, Ch: make(chan udpPacket, 10)}, nil
+}
+
+func (c *resolvingEchoHyClient) Close() error {
+	return nil
+}
+
+type udpPacket struct {
+	Data []byte
+	Addr string
+}
+
+type resolvingEchoUDPConn struct {
+	Hosts map[string]string
+	Ch    chan udpPacket
+}
+
+func (c *resolvingEchoUDPConn) Receive() ([]byte, string, error) {
+	p, ok := <-c.Ch
+	if !ok {
+		return nil, "", io.EOF
+	}
+	return p.Data, p.Addr, nil
+}
+
+func (c *resolvingEchoUDPConn) Send(bs []byte, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip, ok := c.Hosts[host]; ok {
+		host = ip
+	}
+	c.Ch <- udpPacket{Data: append([]byte(nil), bs...), Addr: net.JoinHostPort(host, port)}
+	return nil
+}
+
+func (c *resolvingEchoUDPConn) Close() error {
+	close(c.Ch)
+	return nil
+}
+
+// chanPacketConn is a network.PacketConn on the TUN side, fed and drained through channels.
+type chanPacketConn struct {
+	In  chan udpPacket // Addr is the destination
+	Out chan udpPacket // Addr is the source
+}
+
+func (c *chanPacketConn) ReadPacket(buffer *buf.Buffer) (metadata.Socksaddr, error) {
+	p, ok := <-c.In
+	if !ok {
+		return metadata.Socksaddr{}, io.EOF
+	}
+	_, _ = buffer.Write(p.Data)
+	return metadata.ParseSocksaddr(p.Addr), nil
+}
+
+func (c *chanPacketConn) WritePacket(buffer *buf.Buffer, destination metadata.Socksaddr) error {
+	c.Out <- udpPacket{Data: append([]byte(nil), buffer.Bytes()...), Addr: destination.String()}
+	return nil
+}
+
+func (c *chanPacketConn) Close() error                       { return nil }
+func (c *chanPacketConn) LocalAddr() net.Addr                { return nil }
+func (c *chanPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *chanPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *chanPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// TestUDPFakeIP tests that replies to UDP packets sent to fake IPs come from the fake IPs,
+// even when their domains resolve to the same IP on the server.
+func TestUDPFakeIP(t *testing.T) {
+	h := &tunHandler{&Server{
+		HyClient: &resolvingEchoHyClient{Hosts: map[string]string{
+			"one.example.com": "93.184.216.34",
+			"two.example.com": "93.184.216.34",
+		}},
+		FakeIP: mapFakeIPLookup{
+			netip.MustParseAddr("198.18.0.1"): "one.example.com",
+			netip.MustParseAddr("198.18.0.2"): "two.example.com",
+		},
+	}}
+	conn := &chanPacketConn{
+		In:  make(chan udpPacket, 10),
+		Out: make(chan udpPacket, 10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = h.NewPacketConnection(ctx, conn, metadata.Metadata{
+			Source: metadata.ParseSocksaddr("172.19.0.1:40000"),
+		})
+	}()
+
+	tests := []struct {
+		data string
+		addr string
+	}{
+		{data: "fake one", addr: "198.18.0.1:53"},
+		{data: "fake two", addr: "198.18.0.2:53"},
+		{data: "real", addr: "93.184.216.34:53"},
+		{data: "fake one again", addr: "198.18.0.1:53"},
+	}
+	for _, tt := range tests {
+		conn.In <- udpPacket{Data: []byte(tt.data), Addr: tt.addr}
+		select {
+		case p := <-conn.Out:
+			assert.Equal(t, tt.data, string(p.Data))
+			assert.Equal(t, tt.addr, p.Addr)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no reply for " + tt.addr)
+		}
+	}
+}
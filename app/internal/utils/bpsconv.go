@@ -52,6 +52,43 @@ func StringToBps(s string) (uint64, error) {
 	}
 }
 
+// StringToBytes converts a string to a size value in bytes.
+// E.g. "100 GB", "512 mb", "1t" are all valid.
+func StringToBytes(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	spl := 0
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			spl = i
+			break
+		}
+	}
+	if spl == 0 {
+		// No unit or no value
+		return 0, errors.New("invalid format")
+	}
+	v, err := strconv.ParseUint(s[:spl], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := strings.TrimSpace(s[spl:])
+
+	switch unit {
+	case "b":
+		return v * Byte, nil
+	case "k", "kb":
+		return v * Kilobyte, nil
+	case "m", "mb":
+		return v * Megabyte, nil
+	case "g", "gb":
+		return v * Gigabyte, nil
+	case "t", "tb":
+		return v * Terabyte, nil
+	default:
+		return 0, errors.New("unsupported unit")
+	}
+}
+
 // ConvBandwidth handles both string and int types for bandwidth.
 // When using string, it will be parsed as a bandwidth string with units.
 // When using int, it will be parsed as a raw bandwidth in bytes per second.
@@ -66,3 +103,18 @@ func ConvBandwidth(bw interface{}) (uint64, error) {
 		return 0, fmt.Errorf("invalid type %T for bandwidth", bwT)
 	}
 }
+
+// ConvBytes handles both string and int types for sizes.
+// When using string, it will be parsed as a size string with units.
+// When using int, it will be parsed as a raw size in bytes.
+// It does NOT support float types.
+func ConvBytes(b interface{}) (uint64, error) {
+	switch bT := b.(type) {
+	case string:
+		return StringToBytes(bT)
+	case int:
+		return uint64(bT), nil
+	default:
+		return 0, fmt.Errorf("invalid type %T for size", bT)
+	}
+}
@@ -38,3 +38,40 @@ func TestStringToBps(t *testing.T) {
 		})
 	}
 }
+
+func TestStringToBytes(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    uint64
+		wantErr bool
+	}{
+		{"b", args{"800 b"}, 800, false},
+		{"kb", args{"800 kb"}, 800_000, false},
+		{"mb", args{"800 mb"}, 800_000_000, false},
+		{"gb", args{"800 gb"}, 800_000_000_000, false},
+		{"tb", args{"800 tb"}, 800_000_000_000_000, false},
+		{"gb simp", args{"100g"}, 100_000_000_000, false},
+		{"tb simp upper", args{"2T"}, 2_000_000_000_000, false},
+		{"invalid 1", args{"damn"}, 0, true},
+		{"invalid 2", args{"6444"}, 0, true},
+		{"invalid 3", args{"5.4 gb"}, 0, true},
+		{"invalid 4", args{"gb"}, 0, true},
+		{"invalid 5", args{"100 gbps"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StringToBytes(tt.args.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("StringToBytes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("StringToBytes() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
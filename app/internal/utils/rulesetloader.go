@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl"
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl/ruleset"
+)
+
+const (
+	ruleSetDlTmpPattern = ".hysteria-rulesetloader.dlpart.*"
+	ruleSetDlTimeout    = 30 * time.Second
+	ruleSetDlMaxSize    = 64 << 20 // 64 MiB, way more than any sane rule set
+
+	ruleSetDefaultUpdateInterval = 24 * time.Hour
+)
+
+var _ acl.RuleSetLoader = (*RuleSetLoader)(nil)
+
+var errRuleSetTooLarge = fmt.Errorf("rule set larger than %d bytes", ruleSetDlMaxSize)
+
+// RuleSetSource describes where a rule set comes from.
+// If URL is empty, Path is a local file that is loaded as is.
+// Otherwise, the rule set is downloaded from URL and cached at Path
+// (or "ruleset_<name>.<ext>" if empty), and downloaded again
+// every Interval (24 hours if zero).
+// A rule set pinned by SHA256 can't change, so it's only downloaded
+// if there's no valid cache file, and never again after that.
+type RuleSetSource struct {
+	URL      string
+	Path     string
+	Format   ruleset.Format
+	SHA256   string // optional, hex encoded
+	Interval time.Duration
+}
+
+// RuleSetLoader provides the on-demand rule set loading
+// functionality required by the ACL engine.
+// Remote rule sets are refreshed in the background when they are
+// used after their update interval has passed, and the ACL engine
+// picks up the new content automatically.
+type RuleSetLoader struct {
+	Sources map[string]RuleSetSource // key: lower case name
+
+	DownloadFunc    func(name, url string)
+	DownloadErrFunc func(name string, err error)
+
+	mutex     sync.Mutex
+	providers map[string]*ruleSetProvider
+}
+
+func (l *RuleSetLoader) LoadRuleSet(name string) (acl.RuleSetProvider, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if p, ok := l.providers[name]; ok {
+		return p, nil
+	}
+	src, ok := l.Sources[name]
+	if !ok {
+		return nil, fmt.Errorf("rule set %s not found", name)
+	}
+	p := &ruleSetProvider{Name: name, Source: src, Loader: l}
+	if src.URL != "" && src.Path == "" {
+		ext := ".txt"
+		if src.Format == ruleset.FormatSRS {
+			ext = ".srs"
+		}
+		p.Source.Path = "ruleset_" + name + ext
+	}
+	if p.Source.Interval == 0 {
+		p.Source.Interval = ruleSetDefaultUpdateInterval
+	}
+	if err := p.load(); err != nil {
+		return nil, fmt.Errorf("failed to load rule set %s: %w", name, err)
+	}
+	if l.providers == nil {
+		l.providers = make(map[string]*ruleSetProvider)
+	}
+	l.providers[name] = p
+	return p, nil
+}
+
+type ruleSetProvider struct {
+	Name   string
+	Source RuleSetSource
+	Loader *RuleSetLoader
+
+	rs         atomic.Pointer[ruleset.RuleSet]
+	lastUpdate atomic.Int64 // unix nano
+	refreshing atomic.Bool
+}
+
+func (p *ruleSetProvider) RuleSet() *ruleset.RuleSet {
+	if p.Source.URL != "" && p.Source.SHA256 == "" &&
+		time.Since(time.Unix(0, p.lastUpdate.Load())) > p.Source.Interval &&
+		p.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer p.refreshing.Store(false)
+			if rs, err := p.download(); err == nil {
+				p.rs.Store(rs)
+			}
+			// Even if the download failed, we don't retry
+			// until the next interval to avoid hammering the server.
+			p.lastUpdate.Store(time.Now().UnixNano())
+		}()
+	}
+	return p.rs.Load()
+}
+
+// load loads the rule set for the first time.
+// For remote rule sets, a cache file that is newer than the update interval
+// (or any valid one, if pinned by SHA256) is used as is. Otherwise the rule set
+// is downloaded, and if that fails, the existing cache file (if any) is used instead.
+func (p *ruleSetProvider) load() error {
+	if p.Source.URL == "" {
+		rs, err := p.loadFile()
+		if err != nil {
+			return err
+		}
+		p.rs.Store(rs)
+		return nil
+	}
+	if info, err := os.Stat(p.Source.Path); err == nil && (p.Source.SHA256 != "" || time.Since(info.ModTime()) <= p.Source.Interval) {
+		if rs, err := p.loadFile(); err == nil {
+			p.rs.Store(rs)
+			p.lastUpdate.Store(info.ModTime().UnixNano())
+			return nil
+		}
+		// file is broken, download it again
+	}
+	rs, err := p.download()
+	if err != nil {
+		// as long as the previous download exists, fallback to it
+		var ferr error
+		rs, ferr = p.loadFile()
+		if ferr != nil {
+			return err
+		}
+	}
+	p.rs.Store(rs)
+	p.lastUpdate.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *ruleSetProvider) loadFile() (*ruleset.RuleSet, error) {
+	bs, err := os.ReadFile(p.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	return p.check(bs)
+}
+
+// check verifies the checksum (if set) and parses the rule set.
+func (p *ruleSetProvider) check(bs []byte) (*ruleset.RuleSet, error) {
+	if p.Source.SHA256 != "" {
+		sum := sha256.Sum256(bs)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), p.Source.SHA256) {
+			return nil, errors.New("checksum mismatch")
+		}
+	}
+	return ruleset.Parse(p.Source.Format, bs)
+}
+
+// download downloads the rule set, checks it,
+// and saves it to the cache file if it's valid.
+func (p *ruleSetProvider) download() (*ruleset.RuleSet, error) {
+	rs, err := p.downloadAndCheck()
+	if err != nil && p.Loader.DownloadErrFunc != nil {
+		p.Loader.DownloadErrFunc(p.Name, err)
+	}
+	return rs, err
+}
+
+func (p *ruleSetProvider) downloadAndCheck() (*ruleset.RuleSet, error) {
+	if p.Loader.DownloadFunc != nil {
+		p.Loader.DownloadFunc(p.Name, p.Source.URL)
+	}
+
+	client := &http.Client{Timeout: ruleSetDlTimeout}
+	resp, err := client.Get(p.Source.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > ruleSetDlMaxSize {
+		return nil, errRuleSetTooLarge
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, ruleSetDlMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) > ruleSetDlMaxSize {
+		return nil, errRuleSetTooLarge
+	}
+
+	rs, err := p.check(bs)
+	if err != nil {
+		return nil, fmt.Errorf("integrity check failed: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p.Source.Path), ruleSetDlTmpPattern)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(bs)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(f.Name(), p.Source.Path)
+	if err != nil {
+		return nil, fmt.Errorf("rename failed: %w", err)
+	}
+
+	return rs, nil
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl/ruleset"
+)
+
+type testRuleSetServer struct {
+	mutex   sync.Mutex
+	content string
+	fail    bool
+	hits    int
+}
+
+func (s *testRuleSetServer) set(content string, fail bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.content, s.fail = content, fail
+}
+
+func (s *testRuleSetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hits++
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(s.content))
+}
+
+func (s *testRuleSetServer) getHits() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hits
+}
+
+func TestRuleSetLoader(t *testing.T) {
+	srv := &testRuleSetServer{content: "ads.example.com\n"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "ads.txt")
+	newLoader := func(interval time.Duration) *RuleSetLoader {
+		return &RuleSetLoader{
+			Sources: map[string]RuleSetSource{
+				"ads": {
+					URL:      ts.URL,
+					Path:     cacheFile,
+					Format:   ruleset.FormatDomain,
+					Interval: interval,
+				},
+			},
+		}
+	}
+
+	// Initial download
+	p, err := newLoader(time.Hour).LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ads.example.com"}, p.RuleSet().Domains)
+	assert.Equal(t, 1, srv.getHits())
+	bs, err := os.ReadFile(cacheFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "ads.example.com\n", string(bs))
+
+	// Fresh cache file, no download
+	srv.set("new.example.com\n", false)
+	p, err = newLoader(time.Hour).LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ads.example.com"}, p.RuleSet().Domains)
+	assert.Equal(t, 1, srv.getHits())
+
+	// Stale cache file, download fails, fallback to the cache file
+	srv.set("", true)
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(cacheFile, old, old))
+	p, err = newLoader(time.Hour).LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ads.example.com"}, p.RuleSet().Domains)
+	assert.Equal(t, 2, srv.getHits())
+
+	// Background refresh
+	srv.set("new.example.com\n", false)
+	l := newLoader(100 * time.Millisecond)
+	p, err = l.LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new.example.com"}, p.RuleSet().Domains)
+	srv.set("newer.example.com\n", false)
+	time.Sleep(200 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		domains := p.RuleSet().Domains
+		return len(domains) == 1 && domains[0] == "newer.example.com"
+	}, 2*time.Second, 50*time.Millisecond)
+
+	// Same provider for the same name
+	p2, err := l.LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Same(t, p, p2)
+
+	// Unknown name
+	_, err = l.LoadRuleSet("nope")
+	assert.Error(t, err)
+}
+
+func TestRuleSetLoaderChecksum(t *testing.T) {
+	content := "10.0.0.0/8\n"
+	srv := &testRuleSetServer{content: content}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	sum := sha256.Sum256([]byte(content))
+
+	dir := t.TempDir()
+	l := &RuleSetLoader{
+		Sources: map[string]RuleSetSource{
+			"good": {
+				URL:    ts.URL,
+				Path:   filepath.Join(dir, "good.txt"),
+				Format: ruleset.FormatCIDR,
+				SHA256: hex.EncodeToString(sum[:]),
+			},
+			"bad": {
+				URL:    ts.URL,
+				Path:   filepath.Join(dir, "bad.txt"),
+				Format: ruleset.FormatCIDR,
+				SHA256: "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+		},
+	}
+	p, err := l.LoadRuleSet("good")
+	assert.NoError(t, err)
+	assert.Len(t, p.RuleSet().IPs, 1)
+
+	_, err = l.LoadRuleSet("bad")
+	assert.ErrorContains(t, err, "checksum mismatch")
+	_, err = os.Stat(filepath.Join(dir, "bad.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Pinned rule sets are never updated, even with an old cache file
+	srv.set("", true)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "good.txt"), old, old))
+	l = &RuleSetLoader{Sources: l.Sources}
+	p, err = l.LoadRuleSet("good")
+	assert.NoError(t, err)
+	assert.Len(t, p.RuleSet().IPs, 1)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 2, srv.getHits())
+}
+
+func TestRuleSetLoaderTooLarge(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !chunked {
+				w.Header().Set("Content-Length", strconv.Itoa(ruleSetDlMaxSize+1))
+			}
+			line := []byte(strings.Repeat("a", 1023) + "\n")
+			for i := 0; i <= ruleSetDlMaxSize/len(line); i++ {
+				if _, err := w.Write(line); err != nil {
+					return
+				}
+			}
+		}))
+		dir := t.TempDir()
+		l := &RuleSetLoader{
+			Sources: map[string]RuleSetSource{
+				"huge": {
+					URL:    ts.URL,
+					Path:   filepath.Join(dir, "huge.txt"),
+					Format: ruleset.FormatDomain,
+				},
+			},
+		}
+		_, err := l.LoadRuleSet("huge")
+		assert.ErrorIs(t, err, errRuleSetTooLarge)
+		_, err = os.Stat(filepath.Join(dir, "huge.txt"))
+		assert.True(t, os.IsNotExist(err))
+		ts.Close()
+	}
+}
+
+func TestRuleSetLoaderLocal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("DOMAIN-SUFFIX,example.com\n"), 0o644))
+	l := &RuleSetLoader{
+		Sources: map[string]RuleSetSource{
+			"local": {Path: path, Format: ruleset.FormatClassical},
+		},
+	}
+	p, err := l.LoadRuleSet("local")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, p.RuleSet().DomainSuffixes)
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -20,6 +22,10 @@ import (
 const (
 	closeErrCodeOK            = 0x100 // HTTP3 ErrCodeNoError
 	closeErrCodeProtocolError = 0x101 // HTTP3 ErrCodeGeneralProtocolError
+	closeErrCodeShutdown      = 0x10b // HTTP3 ErrCodeRequestRejected, server is shutting down
+
+	// Streams opened by the server, one per reverse TCP connection
+	maxIncomingStreams = 1024
 )
 
 type Client interface {
@@ -34,11 +40,54 @@ type HyUDPConn interface {
 	Close() error
 }
 
+var (
+	_ UDPStreamClient = (*clientImpl)(nil)
+	_ UDPStreamClient = (*reconnectableClientImpl)(nil)
+	_ UDPStreamClient = (*multiClientImpl)(nil)
+)
+
+// UDPStreamClient is implemented by clients that can create UDP sessions
+// carried over QUIC streams instead of datagrams, regardless of Config.UDPStreamMode.
+// This is for sessions with packets too large for datagrams, or that can't afford
+// to lose them. If the server doesn't support streams, datagrams are used instead.
+// All clients returned by this package implement it.
+type UDPStreamClient interface {
+	UDPStream() (HyUDPConn, error)
+}
+
+// UDPStream creates a UDP session carried over a stream through c, if possible.
+// If c doesn't implement UDPStreamClient, a regular session is created instead.
+func UDPStream(c Client) (HyUDPConn, error) {
+	if sc, ok := c.(UDPStreamClient); ok {
+		return sc.UDPStream()
+	}
+	return c.UDP()
+}
+
 type HandshakeInfo struct {
-	UDPEnabled bool
-	Tx         uint64 // 0 if using BBR
+	UDPEnabled   bool
+	Tx           uint64 // 0 if using BBR
+	Capabilities Capabilities
+	Fallback     bool // Connected with Config.FallbackConnFactory
+
+	// The fields below are only sent by servers that advertise capabilities
+
+	UDPMaxSessions int               // 0 = unlimited or unknown
+	Hints          map[string]string // Free-form, e.g. from the server's authenticator
 }
 
+// Capabilities are the protocol extensions supported by the server,
+// as advertised in its authentication response.
+// Version is 0 for servers that don't advertise any (older versions).
+type Capabilities = protocol.Capabilities
+
+// Protocol extensions that can be advertised in Capabilities.
+const (
+	ExtensionUDPClose  = protocol.ExtensionUDPClose
+	ExtensionReverse   = protocol.ExtensionReverse
+	ExtensionUDPStream = protocol.ExtensionUDPStream
+)
+
 func NewClient(config *Config) (Client, *HandshakeInfo, error) {
 	if err := config.verifyAndFill(); err != nil {
 		return nil, nil, err
@@ -59,11 +108,32 @@ type clientImpl struct {
 	pktConn net.PacketConn
 	conn    quic.Connection
 
-	udpSM *udpSessionManager
+	udpSM   *udpSessionManager
+	reverse *reverseManager
+	caps    Capabilities
 }
 
+// connect tries ConnFactory first, and if the server can't be reached with it
+// (e.g. UDP is blocked), FallbackConnFactory if set.
 func (c *clientImpl) connect() (*HandshakeInfo, error) {
-	pktConn, err := c.config.ConnFactory.New(c.config.ServerAddr)
+	info, err := c.connectWith(c.config.ConnFactory)
+	if c.config.FallbackConnFactory == nil {
+		return info, err
+	}
+	var connErr coreErrs.ConnectError
+	if !errors.As(err, &connErr) {
+		return info, err
+	}
+	info, err = c.connectWith(c.config.FallbackConnFactory)
+	if err != nil {
+		return nil, err
+	}
+	info.Fallback = true
+	return info, nil
+}
+
+func (c *clientImpl) connectWith(factory ConnFactory) (*HandshakeInfo, error) {
+	pktConn, err := factory.New(c.config.ServerAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -82,13 +152,16 @@ func (c *clientImpl) connect() (*HandshakeInfo, error) {
 		MaxIdleTimeout:                 c.config.QUICConfig.MaxIdleTimeout,
 		KeepAlivePeriod:                c.config.QUICConfig.KeepAlivePeriod,
 		DisablePathMTUDiscovery:        c.config.QUICConfig.DisablePathMTUDiscovery,
+		MaxIncomingStreams:             maxIncomingStreams,
 		EnableDatagrams:                true,
 	}
 	// Prepare RoundTripper
 	var conn quic.EarlyConnection
+	c.reverse = newReverseManager()
 	rt := &http3.RoundTripper{
 		TLSClientConfig: tlsConfig,
 		QUICConfig:      quicConfig,
+		StreamHijacker:  c.streamHijacker,
 		Dial: func(ctx context.Context, _ string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
 			qc, err := quic.DialEarly(ctx, pktConn, c.config.ServerAddr, tlsCfg, cfg)
 			if err != nil {
@@ -111,6 +184,10 @@ func (c *clientImpl) connect() (*HandshakeInfo, error) {
 	protocol.AuthRequestToHeader(req.Header, protocol.AuthRequest{
 		Auth: c.config.Auth,
 		Rx:   c.config.BandwidthConfig.MaxRx,
+		Capabilities: protocol.Capabilities{
+			Version:    protocol.CapVersion,
+			Extensions: []string{protocol.ExtensionUDPClose, protocol.ExtensionReverse, protocol.ExtensionUDPStream},
+		},
 	})
 	resp, err := rt.RoundTrip(req)
 	if err != nil {
@@ -128,34 +205,35 @@ func (c *clientImpl) connect() (*HandshakeInfo, error) {
 	// Auth OK
 	authResp := protocol.AuthResponseFromHeader(resp.Header)
 	var actualTx uint64
-	if authResp.RxAuto {
-		// Server asks client to use bandwidth detection,
-		// ignore local bandwidth config and use BBR
-		congestion.UseBBR(conn)
-	} else {
+	if !authResp.RxAuto {
 		// actualTx = min(serverRx, clientTx)
 		actualTx = authResp.Rx
 		if actualTx == 0 || actualTx > c.config.BandwidthConfig.MaxTx {
 			// Server doesn't have a limit, or our clientTx is smaller than serverRx
 			actualTx = c.config.BandwidthConfig.MaxTx
 		}
-		if actualTx > 0 {
-			congestion.UseBrutal(conn, actualTx)
-		} else {
-			// We don't know our own bandwidth either, use BBR
-			congestion.UseBBR(conn)
-		}
 	}
+	// If the server asks us to use bandwidth detection (RxAuto),
+	// or we don't know our own bandwidth either, Brutal falls back to BBR
+	congestion.Use(conn, c.config.CongestionControl, actualTx)
 	_ = resp.Body.Close()
 
 	c.pktConn = pktConn
 	c.conn = conn
+	c.caps = authResp.Capabilities
 	if authResp.UDPEnabled {
-		c.udpSM = newUDPSessionManager(&udpIOImpl{Conn: conn})
+		streamMode := c.config.UDPStreamMode
+		if !c.caps.Has(ExtensionUDPStream) {
+			streamMode = UDPStreamModeNever
+		}
+		c.udpSM = newUDPSessionManager(&udpIOImpl{Conn: conn}, c.caps.Has(ExtensionUDPClose), streamMode)
 	}
 	return &HandshakeInfo{
-		UDPEnabled: authResp.UDPEnabled,
-		Tx:         actualTx,
+		UDPEnabled:     authResp.UDPEnabled,
+		Tx:             actualTx,
+		Capabilities:   c.caps,
+		UDPMaxSessions: authResp.UDPMaxSessions,
+		Hints:          authResp.Hints,
 	}, nil
 }
 
@@ -169,20 +247,39 @@ func (c *clientImpl) openStream() (quic.Stream, error) {
 }
 
 func (c *clientImpl) TCP(addr string) (net.Conn, error) {
+	return c.TCPContext(context.Background(), addr)
+}
+
+func (c *clientImpl) TCPContext(ctx context.Context, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	stream, err := c.openStream()
 	if err != nil {
 		return nil, wrapIfConnectionClosed(err)
 	}
+	// Unblock the request & response below when the context is done.
+	// stop() returns false if that has already happened.
+	stop := context.AfterFunc(ctx, func() {
+		_ = stream.SetDeadline(time.Now())
+	})
 	// Send request
 	err = protocol.WriteTCPRequest(stream, addr)
 	if err != nil {
 		_ = stream.Close()
+		if !stop() {
+			return nil, ctx.Err()
+		}
 		return nil, wrapIfConnectionClosed(err)
 	}
 	if c.config.FastOpen {
 		// Don't wait for the response when fast open is enabled.
 		// Return the connection immediately, defer the response handling
 		// to the first Read() call.
+		if !stop() {
+			_ = stream.Close()
+			return nil, ctx.Err()
+		}
 		return &tcpConn{
 			Orig:             stream,
 			PseudoLocalAddr:  c.conn.LocalAddr(),
@@ -192,6 +289,10 @@ func (c *clientImpl) TCP(addr string) (net.Conn, error) {
 	}
 	// Read response
 	ok, msg, err := protocol.ReadTCPResponse(stream)
+	if !stop() {
+		_ = stream.Close()
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		_ = stream.Close()
 		return nil, wrapIfConnectionClosed(err)
@@ -215,6 +316,27 @@ func (c *clientImpl) UDP() (HyUDPConn, error) {
 	return c.udpSM.NewUDP()
 }
 
+func (c *clientImpl) UDPStream() (HyUDPConn, error) {
+	if c.udpSM == nil {
+		return nil, coreErrs.DialError{Message: "UDP not enabled"}
+	}
+	if !c.caps.Has(ExtensionUDPStream) {
+		// Older servers don't support streams
+		return c.udpSM.NewUDP()
+	}
+	return c.udpSM.NewUDPStream()
+}
+
+// waitServerShutdown blocks until the connection is closed,
+// and returns whether it was closed by the server because it's shutting down.
+func (c *clientImpl) waitServerShutdown() bool {
+	ctx := c.conn.Context()
+	<-ctx.Done()
+	var appErr *quic.ApplicationError
+	return errors.As(context.Cause(ctx), &appErr) &&
+		appErr.Remote && appErr.ErrorCode == closeErrCodeShutdown
+}
+
 func (c *clientImpl) Close() error {
 	_ = c.conn.CloseWithError(closeErrCodeOK, "")
 	_ = c.pktConn.Close()
@@ -313,3 +435,18 @@ func (io *udpIOImpl) SendMessage(buf []byte, msg *protocol.UDPMessage) error {
 	}
 	return io.Conn.SendDatagram(buf[:msgN])
 }
+
+func (io *udpIOImpl) OpenStream(sessionID uint32) (io.ReadWriteCloser, error) {
+	stream, err := io.Conn.OpenStream()
+	if err != nil {
+		return nil, wrapIfConnectionClosed(err)
+	}
+	// Wraps the stream with QStream, which handles Close() properly
+	qs := &utils.QStream{Stream: stream}
+	err = protocol.WriteUDPStreamRequest(qs, sessionID)
+	if err != nil {
+		_ = qs.Close()
+		return nil, wrapIfConnectionClosed(err)
+	}
+	return qs, nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/congestion"
 	"github.com/apernet/hysteria/core/v2/internal/pmtud"
 )
 
@@ -17,13 +18,16 @@ const (
 )
 
 type Config struct {
-	ConnFactory     ConnFactory
-	ServerAddr      net.Addr
-	Auth            string
-	TLSConfig       TLSConfig
-	QUICConfig      QUICConfig
-	BandwidthConfig BandwidthConfig
-	FastOpen        bool
+	ConnFactory         ConnFactory
+	FallbackConnFactory ConnFactory // Optional, used when the server can't be reached with ConnFactory (e.g. UDP blocked)
+	ServerAddr          net.Addr
+	Auth                string
+	TLSConfig           TLSConfig
+	QUICConfig          QUICConfig
+	BandwidthConfig     BandwidthConfig
+	CongestionControl   string // Optional, one of the CongestionControl* types, empty = Brutal if MaxTx is set, BBR otherwise
+	FastOpen            bool
+	UDPStreamMode       UDPStreamMode
 
 	filled bool // whether the fields have been verified and filled
 }
@@ -71,11 +75,40 @@ func (c *Config) verifyAndFill() error {
 		return errors.ConfigError{Field: "QUICConfig.KeepAlivePeriod", Reason: "must be between 2s and 60s"}
 	}
 	c.QUICConfig.DisablePathMTUDiscovery = c.QUICConfig.DisablePathMTUDiscovery || pmtud.DisablePathMTUDiscovery
+	if !congestion.IsValidType(c.CongestionControl) {
+		return errors.ConfigError{Field: "CongestionControl", Reason: "invalid type"}
+	}
+	if c.UDPStreamMode < UDPStreamModeNever || c.UDPStreamMode > UDPStreamModeAlways {
+		return errors.ConfigError{Field: "UDPStreamMode", Reason: "invalid mode"}
+	}
 
 	c.filled = true
 	return nil
 }
 
+// Congestion control types for Config.CongestionControl, see the congestion package.
+// Brutal's rate is the lower of MaxTx and the server's receive limit, and it falls back
+// to BBR if neither is known or the server asks for bandwidth detection.
+const (
+	CongestionControlBBR            = congestion.TypeBBR
+	CongestionControlBBR3           = congestion.TypeBBR3
+	CongestionControlBrutal         = congestion.TypeBrutal
+	CongestionControlAdaptiveBrutal = congestion.TypeAdaptiveBrutal
+	CongestionControlCubic          = congestion.TypeCubic
+	CongestionControlReno           = congestion.TypeReno
+)
+
+// UDPStreamMode controls when UDP sessions are carried over QUIC streams instead of datagrams.
+// Streams have no size limit and no loss, but add latency when there is loss.
+// Sessions always use datagrams if the server doesn't support streams.
+type UDPStreamMode int
+
+const (
+	UDPStreamModeNever  UDPStreamMode = iota // Datagrams only, unless requested with UDPStream
+	UDPStreamModeAuto                        // Switch to a stream when fragmentation is repeatedly needed
+	UDPStreamModeAlways                      // Streams only
+)
+
 type ConnFactory interface {
 	New(net.Addr) (net.PacketConn, error)
 }
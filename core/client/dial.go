@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"net"
+)
+
+var (
+	_ ContextClient = (*clientImpl)(nil)
+	_ ContextClient = (*reconnectableClientImpl)(nil)
+	_ ContextClient = (*multiClientImpl)(nil)
+)
+
+// ContextClient is implemented by clients that can cancel a TCP request
+// when the context is done, e.g. while still waiting for the server's response.
+// All clients returned by this package implement it.
+type ContextClient interface {
+	TCPContext(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// TCPContext makes a TCP request to addr through c, and returns as soon as ctx is done.
+// If c doesn't implement ContextClient, the request is made in a separate goroutine,
+// and the connection is closed if it's established after ctx is done.
+func TCPContext(ctx context.Context, c Client, addr string) (net.Conn, error) {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.TCPContext(ctx, addr)
+	}
+	return withContext(ctx, func() (net.Conn, error) {
+		return c.TCP(addr)
+	})
+}
+
+// UDPContext creates a UDP session through c, and returns as soon as ctx is done.
+// Creating a session doesn't involve the server, but it may block
+// when the client needs to (re)connect first.
+func UDPContext(ctx context.Context, c Client) (HyUDPConn, error) {
+	return withContext(ctx, c.UDP)
+}
+
+// withContext calls f in a separate goroutine, and returns early with ctx.Err()
+// if ctx is done before f returns. In that case, whatever f returns later is closed.
+func withContext[T interface{ Close() error }](ctx context.Context, f func() (T, error)) (T, error) {
+	var zero T
+	if ctx.Done() == nil {
+		// Can never be cancelled
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	type result struct {
+		Conn T
+		Err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := f()
+		ch <- result{conn, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.Conn, r.Err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.Err == nil {
+				_ = r.Conn.Close()
+			}
+		}()
+		return zero, ctx.Err()
+	}
+}
+
+// Dialer dials TCP & UDP connections through a Hysteria client.
+// It has the same Dial & DialContext methods as net.Dialer, so it can be used
+// with http.Transport, and as a golang.org/x/net/proxy.Dialer & ContextDialer.
+//
+// Since the server resolves the addresses, "tcp4"/"tcp6" and "udp4"/"udp6"
+// are treated the same as "tcp" and "udp".
+type Dialer struct {
+	Client Client
+}
+
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return TCPContext(ctx, d.Client, addr)
+	case "udp", "udp4", "udp6":
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, err
+		}
+		conn, err := UDPContext(ctx, d.Client)
+		if err != nil {
+			return nil, err
+		}
+		return &connectedPacketConn{
+			packetConn: newPacketConn(conn),
+			Remote:     parseUDPAddr(addr),
+		}, nil
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+}
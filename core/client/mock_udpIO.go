@@ -3,6 +3,8 @@
 package client
 
 import (
+	io "io"
+
 	protocol "github.com/apernet/hysteria/core/v2/internal/protocol"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -20,6 +22,64 @@ func (_m *mockUDPIO) EXPECT() *mockUDPIO_Expecter {
 	return &mockUDPIO_Expecter{mock: &_m.Mock}
 }
 
+// OpenStream provides a mock function with given fields: sessionID
+func (_m *mockUDPIO) OpenStream(sessionID uint32) (io.ReadWriteCloser, error) {
+	ret := _m.Called(sessionID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for OpenStream")
+	}
+
+	var r0 io.ReadWriteCloser
+	var r1 error
+	if rf, ok := ret.Get(0).(func(uint32) (io.ReadWriteCloser, error)); ok {
+		return rf(sessionID)
+	}
+	if rf, ok := ret.Get(0).(func(uint32) io.ReadWriteCloser); ok {
+		r0 = rf(sessionID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(io.ReadWriteCloser)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(sessionID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// mockUDPIO_OpenStream_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'OpenStream'
+type mockUDPIO_OpenStream_Call struct {
+	*mock.Call
+}
+
+// OpenStream is a helper method to define mock.On call
+//   - sessionID uint32
+func (_e *mockUDPIO_Expecter) OpenStream(sessionID interface{}) *mockUDPIO_OpenStream_Call {
+	return &mockUDPIO_OpenStream_Call{Call: _e.mock.On("OpenStream", sessionID)}
+}
+
+func (_c *mockUDPIO_OpenStream_Call) Run(run func(sessionID uint32)) *mockUDPIO_OpenStream_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(uint32))
+	})
+	return _c
+}
+
+func (_c *mockUDPIO_OpenStream_Call) Return(_a0 io.ReadWriteCloser, _a1 error) *mockUDPIO_OpenStream_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *mockUDPIO_OpenStream_Call) RunAndReturn(run func(uint32) (io.ReadWriteCloser, error)) *mockUDPIO_OpenStream_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // ReceiveMessage provides a mock function with given fields:
 func (_m *mockUDPIO) ReceiveMessage() (*protocol.UDPMessage, error) {
 	ret := _m.Called()
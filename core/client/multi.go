@@ -0,0 +1,469 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+// MultiStrategy determines how multiClientImpl picks a server for each new TCP stream or UDP session.
+type MultiStrategy int
+
+const (
+	// MultiStrategyFailover always uses the first healthy server in the list.
+	MultiStrategyFailover MultiStrategy = iota
+	// MultiStrategyRoundRobin rotates through the healthy servers.
+	MultiStrategyRoundRobin
+	// MultiStrategyLowestRTT uses the healthy server with the lowest handshake RTT.
+	MultiStrategyLowestRTT
+	// MultiStrategySticky hashes the destination host, so that all connections
+	// to the same host go through the same server as long as it stays healthy.
+	MultiStrategySticky
+)
+
+type MultiConfig struct {
+	// ConfigFuncs has one function per server. Same as in NewReconnectableClient,
+	// they are called before every connection attempt to that server.
+	ConfigFuncs []func() (*Config, error)
+	Strategy    MultiStrategy
+	// HealthCheckInterval is how often servers that failed are checked again
+	// (and, for MultiStrategyLowestRTT, how often all servers are measured).
+	// The check is a full handshake with the server.
+	HealthCheckInterval time.Duration
+	// ConnectedFunc is called when successfully connected to a server.
+	// index is the position of the server in ConfigFuncs,
+	// and count is the total number of connections made by the client.
+	ConnectedFunc func(c Client, index int, info *HandshakeInfo, count int)
+}
+
+func (c *MultiConfig) verifyAndFill() error {
+	if len(c.ConfigFuncs) == 0 {
+		return coreErrs.ConfigError{Field: "ConfigFuncs", Reason: "must have at least one server"}
+	}
+	if c.Strategy < MultiStrategyFailover || c.Strategy > MultiStrategySticky {
+		return coreErrs.ConfigError{Field: "Strategy", Reason: "unsupported strategy"}
+	}
+	if c.HealthCheckInterval == 0 {
+		c.HealthCheckInterval = defaultHealthCheckInterval
+	} else if c.HealthCheckInterval < 0 {
+		return coreErrs.ConfigError{Field: "HealthCheckInterval", Reason: "must be positive"}
+	}
+	return nil
+}
+
+// multiClientImpl is a Client that spreads TCP streams and UDP sessions across multiple servers,
+// and fails over to the next server when one cannot be connected to.
+// Each server has its own underlying connection, which is reconnected
+// when closed, just like in reconnectableClientImpl.
+type multiClientImpl struct {
+	config  *MultiConfig
+	members []*multiMember
+
+	next   atomic.Uint32 // for round-robin
+	count  atomic.Int32  // total number of connections
+	closed atomic.Bool
+	stopCh chan struct{}
+}
+
+// NewMultiClient creates a client that connects to multiple servers.
+// If lazy is true, the client will not connect until the first call to TCP() or UDP().
+func NewMultiClient(config *MultiConfig, lazy bool) (Client, error) {
+	if err := config.verifyAndFill(); err != nil {
+		return nil, err
+	}
+	mc := &multiClientImpl{
+		config:  config,
+		members: make([]*multiMember, len(config.ConfigFuncs)),
+		stopCh:  make(chan struct{}),
+	}
+	for i, f := range config.ConfigFuncs {
+		mc.members[i] = &multiMember{
+			index:      i,
+			configFunc: f,
+			state:      multiMemberState{Healthy: true},
+		}
+	}
+	if !lazy {
+		if config.Strategy == MultiStrategyLowestRTT {
+			mc.healthCheck()
+		}
+		if _, err := mc.do("", func(Client) (interface{}, error) { return nil, nil }); err != nil {
+			return nil, err
+		}
+	}
+	go mc.healthCheckLoop()
+	return mc, nil
+}
+
+// candidates returns the members in the order they should be tried for the key.
+// Healthy members always come before unhealthy ones, which are only
+// tried as a last resort when all healthy members have failed.
+func (mc *multiClientImpl) candidates(key string) []*multiMember {
+	n := len(mc.members)
+	ms := make([]*multiMember, 0, n)
+	start := 0
+	switch mc.config.Strategy {
+	case MultiStrategyRoundRobin:
+		start = int((mc.next.Add(1) - 1) % uint32(n))
+	case MultiStrategySticky:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		start = int(h.Sum32() % uint32(n))
+	}
+	for i := 0; i < n; i++ {
+		ms = append(ms, mc.members[(start+i)%n])
+	}
+	states := make(map[*multiMember]multiMemberState, n)
+	for _, m := range ms {
+		states[m] = m.State()
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		si, sj := states[ms[i]], states[ms[j]]
+		if si.Healthy != sj.Healthy {
+			return si.Healthy
+		}
+		if mc.config.Strategy == MultiStrategyLowestRTT {
+			// Unmeasured members go after the measured ones
+			if (si.RTT == 0) != (sj.RTT == 0) {
+				return si.RTT != 0
+			}
+			return si.RTT < sj.RTT
+		}
+		return false
+	})
+	return ms
+}
+
+// do calls f with the client of the first candidate member that can be connected to.
+// If f fails because the connection has been closed, the next candidate is tried.
+func (mc *multiClientImpl) do(key string, f func(Client) (interface{}, error)) (interface{}, error) {
+	var lastErr error
+	for _, m := range mc.candidates(key) {
+		if mc.closed.Load() {
+			return nil, coreErrs.ClosedError{}
+		}
+		client, err := m.Get(mc)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ret, err := f(client)
+		if _, ok := err.(coreErrs.ClosedError); ok {
+			// Connection closed, reset for reconnect next time and try the next server
+			m.Reset(client)
+			lastErr = err
+			continue
+		}
+		return ret, err
+	}
+	return nil, lastErr
+}
+
+func (mc *multiClientImpl) healthCheckLoop() {
+	ticker := time.NewTicker(mc.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mc.healthCheck()
+		case <-mc.stopCh:
+			return
+		}
+	}
+}
+
+// healthCheck probes the members that have failed, or all members when
+// using MultiStrategyLowestRTT, in parallel.
+func (mc *multiClientImpl) healthCheck() {
+	var wg sync.WaitGroup
+	for _, m := range mc.members {
+		if mc.config.Strategy != MultiStrategyLowestRTT && m.State().Healthy {
+			continue
+		}
+		wg.Add(1)
+		go func(m *multiMember) {
+			defer wg.Done()
+			m.Probe()
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (mc *multiClientImpl) TCP(addr string) (net.Conn, error) {
+	if c, err := mc.do(stickyKey(addr), func(client Client) (interface{}, error) {
+		return client.TCP(addr)
+	}); err != nil {
+		return nil, err
+	} else {
+		return c.(net.Conn), nil
+	}
+}
+
+// TCPContext is like TCP, but returns as soon as ctx is done,
+// even if it's still (re)connecting to the servers.
+func (mc *multiClientImpl) TCPContext(ctx context.Context, addr string) (net.Conn, error) {
+	return withContext(ctx, func() (net.Conn, error) {
+		if c, err := mc.do(stickyKey(addr), func(client Client) (interface{}, error) {
+			return TCPContext(ctx, client, addr)
+		}); err != nil {
+			return nil, err
+		} else {
+			return c.(net.Conn), nil
+		}
+	})
+}
+
+func (mc *multiClientImpl) UDP() (HyUDPConn, error) {
+	return mc.udp(false)
+}
+
+func (mc *multiClientImpl) UDPStream() (HyUDPConn, error) {
+	return mc.udp(true)
+}
+
+func (mc *multiClientImpl) udp(stream bool) (HyUDPConn, error) {
+	if mc.closed.Load() {
+		return nil, coreErrs.ClosedError{}
+	}
+	if mc.config.Strategy == MultiStrategySticky {
+		// We don't know the destination until the first message is sent
+		return &stickyUDPConn{mc: mc, stream: stream, ready: make(chan struct{})}, nil
+	}
+	if c, err := mc.do("", func(client Client) (interface{}, error) {
+		return newUDP(client, stream)
+	}); err != nil {
+		return nil, err
+	} else {
+		return c.(HyUDPConn), nil
+	}
+}
+
+// ListenTCP listens on the server picked the same way as for UDP sessions.
+func (mc *multiClientImpl) ListenTCP(addr string) (net.Listener, error) {
+	if l, err := mc.do("", func(client Client) (interface{}, error) {
+		return client.(ReverseClient).ListenTCP(addr)
+	}); err != nil {
+		return nil, err
+	} else {
+		return l.(net.Listener), nil
+	}
+}
+
+// ListenUDP listens on the server picked the same way as for UDP sessions.
+func (mc *multiClientImpl) ListenUDP(addr string) (net.PacketConn, error) {
+	if pc, err := mc.do("", func(client Client) (interface{}, error) {
+		return client.(ReverseClient).ListenUDP(addr)
+	}); err != nil {
+		return nil, err
+	} else {
+		return pc.(net.PacketConn), nil
+	}
+}
+
+func (mc *multiClientImpl) Close() error {
+	if mc.closed.Swap(true) {
+		return nil
+	}
+	close(mc.stopCh)
+	for _, m := range mc.members {
+		m.Close()
+	}
+	return nil
+}
+
+// stickyKey returns the host part of addr, so that the same host
+// sticks to the same server regardless of the port.
+func stickyKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+type multiMemberState struct {
+	Healthy bool
+	RTT     time.Duration // handshake RTT, 0 if not measured yet
+}
+
+// multiMember is a single server of multiClientImpl.
+// Members that have not been tried yet are considered healthy.
+type multiMember struct {
+	index      int
+	configFunc func() (*Config, error)
+
+	m      sync.Mutex // held while connecting
+	client Client
+	closed bool
+
+	stateM sync.Mutex
+	state  multiMemberState
+}
+
+func (m *multiMember) State() multiMemberState {
+	m.stateM.Lock()
+	defer m.stateM.Unlock()
+	return m.state
+}
+
+func (m *multiMember) setState(healthy bool, rtt time.Duration) {
+	m.stateM.Lock()
+	defer m.stateM.Unlock()
+	m.state.Healthy = healthy
+	if rtt > 0 {
+		m.state.RTT = rtt
+	}
+}
+
+// connect performs a handshake with the server and updates the member state.
+func (m *multiMember) connect() (Client, *HandshakeInfo, error) {
+	config, err := m.configFunc()
+	if err != nil {
+		m.setState(false, 0)
+		return nil, nil, err
+	}
+	start := time.Now()
+	client, info, err := NewClient(config)
+	if err != nil {
+		m.setState(false, 0)
+		return nil, nil, err
+	}
+	m.setState(true, time.Since(start))
+	return client, info, nil
+}
+
+// Get returns the client of this member, connecting first if necessary.
+func (m *multiMember) Get(mc *multiClientImpl) (Client, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.closed {
+		return nil, coreErrs.ClosedError{}
+	}
+	if m.client != nil {
+		return m.client, nil
+	}
+	client, info, err := m.connect()
+	if err != nil {
+		return nil, err
+	}
+	m.client = client
+	if ci, ok := client.(*clientImpl); ok {
+		go m.watchShutdown(ci)
+	}
+	count := int(mc.count.Add(1))
+	if mc.config.ConnectedFunc != nil {
+		mc.config.ConnectedFunc(mc, m.index, info, count)
+	}
+	return client, nil
+}
+
+// Reset sets the client to nil for reconnect next time, if it's still the same client.
+func (m *multiMember) Reset(client Client) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.client == client {
+		// This check is in case the client is already changed by another goroutine
+		m.client = nil
+	}
+}
+
+// watchShutdown marks the member as unhealthy when its server closes the connection
+// because it's shutting down, so that new requests go to the other servers right away.
+// The member is back once a health check succeeds.
+func (m *multiMember) watchShutdown(client *clientImpl) {
+	if !client.waitServerShutdown() {
+		return
+	}
+	m.Reset(client)
+	m.setState(false, 0)
+	_ = client.Close()
+}
+
+// Probe performs a handshake with the server just to check its health and measure RTT.
+// The connection is closed right away, it doesn't replace the member's client.
+func (m *multiMember) Probe() {
+	client, _, err := m.connect()
+	if err == nil {
+		_ = client.Close()
+	}
+}
+
+func (m *multiMember) Close() {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.closed = true
+	if m.client != nil {
+		_ = m.client.Close()
+		m.client = nil
+	}
+}
+
+// stickyUDPConn is a HyUDPConn that picks its server based on
+// the destination of the first message sent.
+type stickyUDPConn struct {
+	mc     *multiClientImpl
+	stream bool // Over a stream, see UDPStreamClient
+	once   sync.Once
+	ready  chan struct{} // closed when conn or err is set
+	conn   HyUDPConn
+	err    error
+}
+
+func (u *stickyUDPConn) init(addr string) {
+	u.once.Do(func() {
+		c, err := u.mc.do(stickyKey(addr), func(client Client) (interface{}, error) {
+			return newUDP(client, u.stream)
+		})
+		if err != nil {
+			u.err = err
+		} else {
+			u.conn = c.(HyUDPConn)
+		}
+		close(u.ready)
+	})
+}
+
+func (u *stickyUDPConn) Receive() ([]byte, string, error) {
+	<-u.ready
+	if u.err != nil {
+		return nil, "", u.err
+	}
+	return u.conn.Receive()
+}
+
+func (u *stickyUDPConn) Send(data []byte, addr string) error {
+	u.init(addr)
+	if u.err != nil {
+		return u.err
+	}
+	return u.conn.Send(data, addr)
+}
+
+func (u *stickyUDPConn) Close() error {
+	u.once.Do(func() {
+		u.err = coreErrs.ClosedError{}
+		close(u.ready)
+	})
+	if u.conn != nil {
+		return u.conn.Close()
+	}
+	return nil
+}
+
+// newUDP creates a UDP session through client, over a stream if stream is true.
+func newUDP(client Client, stream bool) (HyUDPConn, error) {
+	if stream {
+		return UDPStream(client)
+	}
+	return client.UDP()
+}
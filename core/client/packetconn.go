@@ -0,0 +1,241 @@
+package client
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	_ net.PacketConn = (*packetConn)(nil)
+	_ net.Conn       = (*connectedPacketConn)(nil)
+)
+
+// NewPacketConn wraps a HyUDPConn as a net.PacketConn, with support for deadlines.
+// Addresses from ReadFrom are *net.UDPAddr, unless the server reports a domain name,
+// in which case they are of network "udp" with the domain:port as their String().
+// WriteTo accepts any net.Addr whose String() is host:port.
+func NewPacketConn(conn HyUDPConn) net.PacketConn {
+	return newPacketConn(conn)
+}
+
+type udpPacket struct {
+	Data []byte
+	Addr net.Addr
+}
+
+type packetConn struct {
+	conn HyUDPConn
+
+	recvCh   chan udpPacket // closed when Receive fails, with recvErr set
+	recvErr  error
+	closeCh  chan struct{}
+	closeErr error
+	once     sync.Once
+
+	sendMutex sync.Mutex // HyUDPConn.Send is not thread-safe
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newPacketConn(conn HyUDPConn) *packetConn {
+	c := &packetConn{
+		conn:          conn,
+		recvCh:        make(chan udpPacket),
+		closeCh:       make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+	go c.receiveLoop()
+	return c
+}
+
+func (c *packetConn) receiveLoop() {
+	for {
+		bs, addr, err := c.conn.Receive()
+		if err != nil {
+			c.recvErr = err
+			close(c.recvCh)
+			return
+		}
+		select {
+		case c.recvCh <- udpPacket{bs, parseUDPAddr(addr)}:
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closeCh:
+		return 0, nil, c.opError("read", net.ErrClosed)
+	case <-c.readDeadline.wait():
+		return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+	default:
+	}
+	select {
+	case pkt, ok := <-c.recvCh:
+		if !ok {
+			return 0, nil, c.opError("read", c.recvErr)
+		}
+		return copy(b, pkt.Data), pkt.Addr, nil
+	case <-c.closeCh:
+		return 0, nil, c.opError("read", net.ErrClosed)
+	case <-c.readDeadline.wait():
+		return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closeCh:
+		return 0, c.opError("write", net.ErrClosed)
+	case <-c.writeDeadline.wait():
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	default:
+	}
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if err := c.conn.Send(b, addr.String()); err != nil {
+		return 0, c.opError("write", err)
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) Close() error {
+	c.once.Do(func() {
+		close(c.closeCh)
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+// LocalAddr returns a pseudo address, as the actual local address
+// of a UDP session is only known by the server.
+func (c *packetConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *packetConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.LocalAddr(), Err: err}
+}
+
+// connectedPacketConn is a packetConn that only sends to a single address,
+// returned by Dialer for UDP. Like a connected UDP socket, Read and Write
+// work without addresses, but unlike one, Read doesn't filter by source address,
+// as the server may report it differently (e.g. an IP instead of a domain name).
+type connectedPacketConn struct {
+	*packetConn
+	Remote net.Addr
+}
+
+func (c *connectedPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *connectedPacketConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.Remote)
+}
+
+func (c *connectedPacketConn) RemoteAddr() net.Addr {
+	return c.Remote
+}
+
+// domainUDPAddr is a UDP address with a domain name instead of an IP.
+type domainUDPAddr string
+
+func (a domainUDPAddr) Network() string {
+	return "udp"
+}
+
+func (a domainUDPAddr) String() string {
+	return string(a)
+}
+
+func parseUDPAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return domainUDPAddr(addr)
+}
+
+// deadline is a resettable deadline for blocking operations,
+// the same as the one used by net.Pipe.
+type deadline struct {
+	mutex  sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline is exceeded
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero value for t means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	// Time in the past, exceed immediately
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHyUDPConn struct {
+	ReceiveCh chan *udpPacket // nil = EOF
+	SendCh    chan udpPacket
+	Closed    bool
+}
+
+func (c *testHyUDPConn) Receive() ([]byte, string, error) {
+	pkt := <-c.ReceiveCh
+	if pkt == nil {
+		return nil, "", io.EOF
+	}
+	return pkt.Data, pkt.Addr.String(), nil
+}
+
+func (c *testHyUDPConn) Send(bs []byte, addr string) error {
+	c.SendCh <- udpPacket{bs, parseUDPAddr(addr)}
+	return nil
+}
+
+func (c *testHyUDPConn) Close() error {
+	c.Closed = true
+	close(c.ReceiveCh)
+	return nil
+}
+
+func TestPacketConn(t *testing.T) {
+	hc := &testHyUDPConn{
+		ReceiveCh: make(chan *udpPacket, 4),
+		SendCh:    make(chan udpPacket, 4),
+	}
+	pc := NewPacketConn(hc)
+
+	// Write
+	n, err := pc.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 53})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	pkt := <-hc.SendCh
+	assert.Equal(t, "ping", string(pkt.Data))
+	assert.Equal(t, "1.2.3.4:53", pkt.Addr.String())
+
+	// Read, with IP & domain addresses
+	hc.ReceiveCh <- &udpPacket{[]byte("pong"), domainUDPAddr("1.2.3.4:53")}
+	hc.ReceiveCh <- &udpPacket{[]byte("pong2"), domainUDPAddr("example.com:53")}
+	buf := make([]byte, 16)
+	n, addr, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:n]))
+	assert.Equal(t, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4).To4(), Port: 53}, addr)
+	n, addr, err = pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong2", string(buf[:n]))
+	assert.Equal(t, "udp", addr.Network())
+	assert.Equal(t, "example.com:53", addr.String())
+
+	// Read deadline
+	assert.NoError(t, pc.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = pc.ReadFrom(buf)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout())
+	// Reset deadline, read works again
+	assert.NoError(t, pc.SetReadDeadline(time.Time{}))
+	hc.ReceiveCh <- &udpPacket{[]byte("pong3"), domainUDPAddr("1.2.3.4:53")}
+	n, _, err = pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong3", string(buf[:n]))
+
+	// Write deadline in the past
+	assert.NoError(t, pc.SetWriteDeadline(time.Now().Add(-time.Second)))
+	_, err = pc.WriteTo([]byte("ping"), domainUDPAddr("example.com:53"))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	assert.NoError(t, pc.SetWriteDeadline(time.Time{}))
+
+	// Close unblocks pending reads
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(buf)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, pc.Close())
+	assert.True(t, hc.Closed)
+	assert.True(t, errors.Is(<-errCh, net.ErrClosed))
+	_, err = pc.WriteTo([]byte("ping"), domainUDPAddr("example.com:53"))
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	assert.NoError(t, pc.Close())
+}
+
+func TestPacketConnReceiveError(t *testing.T) {
+	hc := &testHyUDPConn{
+		ReceiveCh: make(chan *udpPacket, 4),
+		SendCh:    make(chan udpPacket, 4),
+	}
+	pc := NewPacketConn(hc)
+	hc.ReceiveCh <- nil
+	_, _, err := pc.ReadFrom(make([]byte, 16))
+	assert.True(t, errors.Is(err, io.EOF))
+}
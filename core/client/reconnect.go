@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"net"
 	"sync"
 
@@ -48,6 +49,9 @@ func (rc *reconnectableClientImpl) reconnect() error {
 	if err != nil {
 		return err
 	} else {
+		if ci, ok := rc.client.(*clientImpl); ok {
+			go rc.watchShutdown(ci)
+		}
 		rc.count++
 		if rc.connectedFunc != nil {
 			rc.connectedFunc(rc, info, rc.count)
@@ -56,6 +60,26 @@ func (rc *reconnectableClientImpl) reconnect() error {
 	}
 }
 
+// watchShutdown reconnects right away when the server closes the connection
+// because it's shutting down, instead of waiting for the next request.
+// This gives new requests (and a new server, e.g. during a rolling deploy)
+// a fresh connection without the latency of reconnecting on demand.
+func (rc *reconnectableClientImpl) watchShutdown(client *clientImpl) {
+	if !client.waitServerShutdown() {
+		return
+	}
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	if rc.closed || rc.client != client {
+		// Closed by the user, or already replaced by another goroutine
+		return
+	}
+	if err := rc.reconnect(); err != nil {
+		// Try again on the next request
+		rc.client = nil
+	}
+}
+
 // clientDo calls f with the current client.
 // If the client is nil, it will first reconnect.
 // It will also detect if the client is closed, and if so,
@@ -99,6 +123,20 @@ func (rc *reconnectableClientImpl) TCP(addr string) (net.Conn, error) {
 	}
 }
 
+// TCPContext is like TCP, but returns as soon as ctx is done,
+// even if it's still (re)connecting to the server.
+func (rc *reconnectableClientImpl) TCPContext(ctx context.Context, addr string) (net.Conn, error) {
+	return withContext(ctx, func() (net.Conn, error) {
+		if c, err := rc.clientDo(func(client Client) (interface{}, error) {
+			return TCPContext(ctx, client, addr)
+		}); err != nil {
+			return nil, err
+		} else {
+			return c.(net.Conn), nil
+		}
+	})
+}
+
 func (rc *reconnectableClientImpl) UDP() (HyUDPConn, error) {
 	if c, err := rc.clientDo(func(client Client) (interface{}, error) {
 		return client.UDP()
@@ -109,6 +147,36 @@ func (rc *reconnectableClientImpl) UDP() (HyUDPConn, error) {
 	}
 }
 
+func (rc *reconnectableClientImpl) UDPStream() (HyUDPConn, error) {
+	if c, err := rc.clientDo(func(client Client) (interface{}, error) {
+		return UDPStream(client)
+	}); err != nil {
+		return nil, err
+	} else {
+		return c.(HyUDPConn), nil
+	}
+}
+
+func (rc *reconnectableClientImpl) ListenTCP(addr string) (net.Listener, error) {
+	if l, err := rc.clientDo(func(client Client) (interface{}, error) {
+		return client.(ReverseClient).ListenTCP(addr)
+	}); err != nil {
+		return nil, err
+	} else {
+		return l.(net.Listener), nil
+	}
+}
+
+func (rc *reconnectableClientImpl) ListenUDP(addr string) (net.PacketConn, error) {
+	if pc, err := rc.clientDo(func(client Client) (interface{}, error) {
+		return client.(ReverseClient).ListenUDP(addr)
+	}); err != nil {
+		return nil, err
+	} else {
+		return pc.(net.PacketConn), nil
+	}
+}
+
 func (rc *reconnectableClientImpl) Close() error {
 	rc.m.Lock()
 	defer rc.m.Unlock()
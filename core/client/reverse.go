@@ -0,0 +1,279 @@
+package client
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/http3"
+
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/protocol"
+	"github.com/apernet/hysteria/core/v2/internal/utils"
+)
+
+const (
+	reverseAcceptBacklog = 16
+)
+
+var (
+	_ ReverseClient = (*clientImpl)(nil)
+	_ ReverseClient = (*reconnectableClientImpl)(nil)
+	_ ReverseClient = (*multiClientImpl)(nil)
+)
+
+// ReverseClient is implemented by clients that support reverse forwarding,
+// i.e. listening on an address on the server side. The server must allow it
+// for the user, otherwise a DialError is returned.
+// All clients returned by this package implement it.
+//
+// The listeners are tied to the connection to the server, they are closed
+// (and must be opened again by the caller) when the connection is lost.
+type ReverseClient interface {
+	// ListenTCP listens on addr on the server. The remote addresses of the
+	// accepted connections are the addresses of the peers as seen by the server.
+	ListenTCP(addr string) (net.Listener, error)
+	// ListenUDP listens on addr on the server. Packets are exchanged with
+	// the peers of the listener, whose addresses are used in ReadFrom & WriteTo.
+	ListenUDP(addr string) (net.PacketConn, error)
+}
+
+// reverseManager keeps track of the reverse TCP listeners of a connection,
+// and hands out listener IDs for both TCP & UDP.
+type reverseManager struct {
+	nextID atomic.Uint32
+
+	mutex     sync.Mutex
+	listeners map[uint32]*reverseListener
+}
+
+func newReverseManager() *reverseManager {
+	return &reverseManager{
+		listeners: make(map[uint32]*reverseListener),
+	}
+}
+
+func (m *reverseManager) NewID() uint32 {
+	return m.nextID.Add(1) &^ protocol.ReverseSessionIDFlag
+}
+
+func (m *reverseManager) Add(l *reverseListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners[l.ID] = l
+}
+
+func (m *reverseManager) Remove(id uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.listeners, id)
+}
+
+func (m *reverseManager) Get(id uint32) *reverseListener {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.listeners[id]
+}
+
+// StreamHijacker handles the streams opened by the server for reverse TCP connections.
+func (c *clientImpl) streamHijacker(ft http3.FrameType, _ quic.ConnectionTracingID, stream quic.Stream, err error) (bool, error) {
+	if err != nil || ft != protocol.FrameTypeReverseConnect {
+		return false, nil
+	}
+	// Wraps the stream with QStream, which handles Close() properly
+	stream = &utils.QStream{Stream: stream}
+	go c.handleReverseConnect(stream)
+	return true, nil
+}
+
+func (c *clientImpl) handleReverseConnect(stream quic.Stream) {
+	id, addr, err := protocol.ReadReverseConnect(stream)
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	l := c.reverse.Get(id)
+	if l == nil {
+		// Listener already closed
+		_ = stream.Close()
+		return
+	}
+	conn := &tcpConn{
+		Orig:             stream,
+		PseudoLocalAddr:  l.addr,
+		PseudoRemoteAddr: parseTCPAddr(addr),
+		Established:      true,
+	}
+	select {
+	case l.connCh <- conn:
+	case <-l.closeCh:
+		_ = stream.Close()
+	}
+}
+
+// reverseRequest opens a control stream and sends a ReverseRequest on it.
+// The stream is returned if the server accepts the request.
+func (c *clientImpl) reverseRequest(id uint32, network protocol.ReverseNetwork, addr string) (quic.Stream, error) {
+	if !c.caps.Has(ExtensionReverse) {
+		// Older servers don't understand the request at all
+		return nil, coreErrs.DialError{Message: "reverse forwarding not enabled on the server"}
+	}
+	stream, err := c.openStream()
+	if err != nil {
+		return nil, wrapIfConnectionClosed(err)
+	}
+	err = protocol.WriteReverseRequest(stream, &protocol.ReverseRequest{
+		ID:      id,
+		Network: network,
+		Addr:    addr,
+	})
+	if err != nil {
+		_ = stream.Close()
+		return nil, wrapIfConnectionClosed(err)
+	}
+	ok, msg, err := protocol.ReadTCPResponse(stream)
+	if err != nil {
+		_ = stream.Close()
+		return nil, wrapIfConnectionClosed(err)
+	}
+	if !ok {
+		_ = stream.Close()
+		return nil, coreErrs.DialError{Message: msg}
+	}
+	return stream, nil
+}
+
+func (c *clientImpl) ListenTCP(addr string) (net.Listener, error) {
+	// Register the listener before sending the request,
+	// as connections may come right after the server accepts it
+	l := &reverseListener{
+		ID:      c.reverse.NewID(),
+		addr:    parseTCPAddr(addr),
+		manager: c.reverse,
+		connCh:  make(chan net.Conn, reverseAcceptBacklog),
+		closeCh: make(chan struct{}),
+	}
+	c.reverse.Add(l)
+	control, err := c.reverseRequest(l.ID, protocol.ReverseNetworkTCP, addr)
+	if err != nil {
+		c.reverse.Remove(l.ID)
+		return nil, err
+	}
+	l.control = control
+	go func() {
+		// The server closes the control stream when the listener fails
+		_, _ = io.Copy(io.Discard, control)
+		_ = l.Close()
+	}()
+	return l, nil
+}
+
+func (c *clientImpl) ListenUDP(addr string) (net.PacketConn, error) {
+	if c.udpSM == nil {
+		return nil, coreErrs.DialError{Message: "UDP not enabled"}
+	}
+	id := c.reverse.NewID()
+	conn, err := c.udpSM.newReverseUDP(id)
+	if err != nil {
+		return nil, err
+	}
+	control, err := c.reverseRequest(id, protocol.ReverseNetworkUDP, addr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	pc := &reverseUDPConn{
+		packetConn: newPacketConn(conn),
+		control:    control,
+		addr:       parseUDPAddr(addr),
+	}
+	go func() {
+		// The server closes the control stream when the listener fails
+		_, _ = io.Copy(io.Discard, control)
+		_ = pc.Close()
+	}()
+	return pc, nil
+}
+
+// reverseListener is a net.Listener for a reverse TCP listener on the server.
+type reverseListener struct {
+	ID uint32
+
+	addr    net.Addr
+	manager *reverseManager
+	control quic.Stream
+	connCh  chan net.Conn
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func (l *reverseListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+func (l *reverseListener) Close() error {
+	l.once.Do(func() {
+		close(l.closeCh)
+		l.manager.Remove(l.ID)
+		_ = l.control.Close()
+		// Close the connections that have not been accepted
+		for {
+			select {
+			case conn := <-l.connCh:
+				_ = conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Addr returns the address the listener was requested with on the server.
+func (l *reverseListener) Addr() net.Addr {
+	return l.addr
+}
+
+// reverseUDPConn is a net.PacketConn for a reverse UDP listener on the server.
+type reverseUDPConn struct {
+	*packetConn
+	control quic.Stream
+	addr    net.Addr
+}
+
+func (c *reverseUDPConn) Close() error {
+	_ = c.control.Close()
+	return c.packetConn.Close()
+}
+
+// LocalAddr returns the address the listener was requested with on the server.
+func (c *reverseUDPConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// domainTCPAddr is a TCP address that can't be parsed as an IP address & port,
+// e.g. one with a domain name or an empty host.
+type domainTCPAddr string
+
+func (a domainTCPAddr) Network() string {
+	return "tcp"
+}
+
+func (a domainTCPAddr) String() string {
+	return string(a)
+}
+
+func parseTCPAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	return domainTCPAddr(addr)
+}
@@ -15,29 +15,48 @@ import (
 
 const (
 	udpMessageChanSize = 1024
+
+	// Number of sends that needed fragmentation before
+	// a session switches to a stream in UDPStreamModeAuto
+	udpStreamFragThreshold = 3
 )
 
 type udpIO interface {
 	ReceiveMessage() (*protocol.UDPMessage, error)
 	SendMessage([]byte, *protocol.UDPMessage) error
+	// OpenStream opens a stream to carry the session with the given ID,
+	// see protocol.FrameTypeUDPStream.
+	OpenStream(sessionID uint32) (io.ReadWriteCloser, error)
 }
 
 type udpConn struct {
 	ID        uint32
 	D         *frag.Defragger
 	ReceiveCh chan *protocol.UDPMessage
+	CloseCh   chan struct{} // Closed along with the session
 	SendBuf   []byte
 	SendFunc  func([]byte, *protocol.UDPMessage) error
 	CloseFunc func()
 	Closed    bool
+
+	StreamFunc func() error       // Switches the session to a stream
+	AutoStream bool               // Call StreamFunc after repeated fragmentation
+	Stream     io.ReadWriteCloser // Set once the session is carried over a stream
+	fragSends  int
 }
 
 func (u *udpConn) Receive() ([]byte, string, error) {
 	for {
-		msg := <-u.ReceiveCh
-		if msg == nil {
-			// Closed
-			return nil, "", io.EOF
+		var msg *protocol.UDPMessage
+		select {
+		case msg = <-u.ReceiveCh:
+		case <-u.CloseCh:
+			// Return what was received before the session was closed first
+			select {
+			case msg = <-u.ReceiveCh:
+			default:
+				return nil, "", io.EOF
+			}
 		}
 		dfMsg := u.D.Feed(msg)
 		if dfMsg == nil {
@@ -59,9 +78,19 @@ func (u *udpConn) Send(data []byte, addr string) error {
 		Addr:      addr,
 		Data:      data,
 	}
+	if u.Stream != nil {
+		return protocol.WriteUDPStreamPacket(u.Stream, u.SendBuf, msg)
+	}
 	err := u.SendFunc(u.SendBuf, msg)
 	var errTooLarge *quic.DatagramTooLargeError
 	if errors.As(err, &errTooLarge) {
+		if u.AutoStream {
+			u.fragSends++
+			if u.fragSends >= udpStreamFragThreshold && u.StreamFunc() == nil {
+				return protocol.WriteUDPStreamPacket(u.Stream, u.SendBuf, msg)
+			}
+			// Keep using datagrams if the stream can't be opened
+		}
 		// Message too large, try fragmentation
 		msg.PacketID = uint16(rand.Intn(0xFFFF)) + 1
 		fMsgs := frag.FragUDPMessage(msg, int(errTooLarge.MaxDataLen))
@@ -82,8 +111,15 @@ func (u *udpConn) Close() error {
 	return nil
 }
 
+// udpSessionManager manages the UDP sessions of a connection.
+// If sendClose is true (the server supports UDPClose), closing a session
+// also closes it on the server, instead of waiting for the idle timeout.
+// streamMode decides which sessions are carried over streams, and must be
+// UDPStreamModeNever if the server doesn't support them.
 type udpSessionManager struct {
-	io udpIO
+	io         udpIO
+	sendClose  bool
+	streamMode UDPStreamMode
 
 	mutex  sync.RWMutex
 	m      map[uint32]*udpConn
@@ -92,11 +128,13 @@ type udpSessionManager struct {
 	closed bool
 }
 
-func newUDPSessionManager(io udpIO) *udpSessionManager {
+func newUDPSessionManager(io udpIO, sendClose bool, streamMode UDPStreamMode) *udpSessionManager {
 	m := &udpSessionManager{
-		io:     io,
-		m:      make(map[uint32]*udpConn),
-		nextID: 1,
+		io:         io,
+		sendClose:  sendClose,
+		streamMode: streamMode,
+		m:          make(map[uint32]*udpConn),
+		nextID:     1,
 	}
 	go m.run()
 	return m
@@ -124,6 +162,16 @@ func (m *udpSessionManager) closeCleanup() {
 }
 
 func (m *udpSessionManager) feed(msg *protocol.UDPMessage) {
+	if msg.IsClose() {
+		// Closed (rejected) by the server
+		m.mutex.Lock()
+		if conn, ok := m.m[msg.SessionID]; ok {
+			m.close(conn)
+		}
+		m.mutex.Unlock()
+		return
+	}
+
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
@@ -141,8 +189,55 @@ func (m *udpSessionManager) feed(msg *protocol.UDPMessage) {
 	}
 }
 
-// NewUDP creates a new UDP session.
+// feedStream delivers a message received from the stream of a session. Unlike
+// feed for datagrams, it waits for the channel to have room instead of dropping
+// the message, so that the stream applies backpressure to the server rather than
+// losing packets it has already delivered reliably. It returns false once the
+// session is closed.
+func (m *udpSessionManager) feedStream(conn *udpConn, msg *protocol.UDPMessage) bool {
+	select {
+	case conn.ReceiveCh <- msg:
+		return true
+	case <-conn.CloseCh:
+		return false
+	}
+}
+
+// NewUDP creates a new UDP session, carried over a stream in UDPStreamModeAlways.
 func (m *udpSessionManager) NewUDP() (HyUDPConn, error) {
+	return m.newUDP(m.streamMode == UDPStreamModeAlways)
+}
+
+// NewUDPStream creates a new UDP session carried over a stream, regardless of the mode.
+func (m *udpSessionManager) NewUDPStream() (HyUDPConn, error) {
+	return m.newUDP(true)
+}
+
+func (m *udpSessionManager) newUDP(stream bool) (HyUDPConn, error) {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil, coreErrs.ClosedError{}
+	}
+	id := m.nextID
+	m.nextID++
+	conn := m.newConn(id)
+	m.mutex.Unlock()
+
+	if stream {
+		if err := conn.StreamFunc(); err != nil {
+			m.mutex.Lock()
+			m.close(conn)
+			m.mutex.Unlock()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// newReverseUDP creates a session for a reverse UDP listener,
+// using ReverseSessionIDFlag | listener ID as the session ID.
+func (m *udpSessionManager) newReverseUDP(listenerID uint32) (HyUDPConn, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -150,32 +245,89 @@ func (m *udpSessionManager) NewUDP() (HyUDPConn, error) {
 		return nil, coreErrs.ClosedError{}
 	}
 
-	id := m.nextID
-	m.nextID++
+	return m.newConn(protocol.ReverseSessionIDFlag | listenerID), nil
+}
 
+// newConn must be called with the mutex held.
+func (m *udpSessionManager) newConn(id uint32) *udpConn {
 	conn := &udpConn{
 		ID:        id,
 		D:         &frag.Defragger{},
 		ReceiveCh: make(chan *protocol.UDPMessage, udpMessageChanSize),
+		CloseCh:   make(chan struct{}),
 		SendBuf:   make([]byte, protocol.MaxUDPSize),
 		SendFunc:  m.io.SendMessage,
+		// Reverse UDP listeners always use datagrams
+		AutoStream: m.streamMode == UDPStreamModeAuto && id&protocol.ReverseSessionIDFlag == 0,
+	}
+	conn.StreamFunc = func() error {
+		return m.switchToStream(conn)
 	}
 	conn.CloseFunc = func() {
 		m.mutex.Lock()
-		defer m.mutex.Unlock()
-		m.close(conn)
+		closed := m.close(conn)
+		hasStream := conn.Stream != nil
+		m.mutex.Unlock()
+		// Sessions over streams are closed with the streams, and
+		// reverse UDP listeners are closed with their control streams instead
+		if closed && m.sendClose && !hasStream && id&protocol.ReverseSessionIDFlag == 0 {
+			closeMsg := &protocol.UDPMessage{SessionID: id}
+			_ = m.io.SendMessage(make([]byte, closeMsg.Size()), closeMsg)
+		}
 	}
 	m.m[id] = conn
+	return conn
+}
 
-	return conn, nil
+// switchToStream opens a stream for the session and starts receiving from it.
+// The session sends over the stream from then on, and is closed along with it.
+func (m *udpSessionManager) switchToStream(conn *udpConn) error {
+	stream, err := m.io.OpenStream(conn.ID)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	if conn.Closed {
+		m.mutex.Unlock()
+		_ = stream.Close()
+		return coreErrs.ClosedError{}
+	}
+	conn.Stream = stream
+	m.mutex.Unlock()
+	go m.receiveStream(conn, stream)
+	return nil
 }
 
-func (m *udpSessionManager) close(conn *udpConn) {
-	if !conn.Closed {
-		conn.Closed = true
-		close(conn.ReceiveCh)
-		delete(m.m, conn.ID)
+func (m *udpSessionManager) receiveStream(conn *udpConn, stream io.ReadWriteCloser) {
+	for {
+		msg, err := protocol.ReadUDPStreamPacket(stream)
+		if err != nil {
+			// Closed by either side, or the connection is gone
+			m.mutex.Lock()
+			m.close(conn)
+			m.mutex.Unlock()
+			return
+		}
+		msg.SessionID = conn.ID
+		if !m.feedStream(conn, msg) {
+			return
+		}
+	}
+}
+
+// close returns false if the session is already closed.
+// Must be called with the mutex held.
+func (m *udpSessionManager) close(conn *udpConn) bool {
+	if conn.Closed {
+		return false
+	}
+	conn.Closed = true
+	close(conn.CloseCh)
+	delete(m.m, conn.ID)
+	if conn.Stream != nil {
+		_ = conn.Stream.Close()
 	}
+	return true
 }
 
 func (m *udpSessionManager) Count() int {
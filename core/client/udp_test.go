@@ -3,9 +3,11 @@ package client
 import (
 	"errors"
 	io2 "io"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/apernet/quic-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/goleak"
@@ -24,7 +26,7 @@ func TestUDPSessionManager(t *testing.T) {
 		}
 		return m, nil
 	})
-	sm := newUDPSessionManager(io)
+	sm := newUDPSessionManager(io, true, UDPStreamModeNever)
 
 	// Test UDP session IO
 	udpConn1, err := sm.NewUDP()
@@ -95,14 +97,30 @@ func TestUDPSessionManager(t *testing.T) {
 	receiveCh <- respMsg3
 	// No test for this, just make sure it doesn't panic
 
-	// Test close UDP connection unblocks Receive()
+	// Test close UDP connection unblocks Receive() and notifies the server
 	errChan := make(chan error, 1)
 	go func() {
 		_, _, err := udpConn1.Receive()
 		errChan <- err
 	}()
+	io.EXPECT().SendMessage(mock.Anything, &protocol.UDPMessage{SessionID: 1}).Return(nil).Once()
 	assert.NoError(t, udpConn1.Close())
 	assert.Equal(t, <-errChan, io2.EOF)
+	// Only once
+	assert.NoError(t, udpConn1.Close())
+
+	// Test close from the server unblocks Receive()
+	udpConn3, err := sm.NewUDP()
+	assert.NoError(t, err)
+	errChan = make(chan error, 1)
+	go func() {
+		_, _, err := udpConn3.Receive()
+		errChan <- err
+	}()
+	receiveCh <- &protocol.UDPMessage{SessionID: 3}
+	assert.Equal(t, <-errChan, io2.EOF)
+	// Already closed, no need to notify the server
+	assert.NoError(t, udpConn3.Close())
 
 	// Test close IO unblocks Receive() and blocks new UDP creation
 	errChan = make(chan error, 1)
@@ -120,3 +138,98 @@ func TestUDPSessionManager(t *testing.T) {
 	assert.Zero(t, sm.Count(), "session count should be 0")
 	goleak.VerifyNone(t)
 }
+
+func TestUDPSessionManagerStream(t *testing.T) {
+	io := newMockUDPIO(t)
+	receiveCh := make(chan *protocol.UDPMessage, 4)
+	io.EXPECT().ReceiveMessage().RunAndReturn(func() (*protocol.UDPMessage, error) {
+		m := <-receiveCh
+		if m == nil {
+			return nil, errors.New("closed")
+		}
+		return m, nil
+	})
+	sm := newUDPSessionManager(io, true, UDPStreamModeAuto)
+
+	// Switched to a stream after repeated fragmentation
+	udpConn1, err := sm.NewUDP()
+	assert.NoError(t, err)
+	largeData := make([]byte, 3000)
+	io.EXPECT().SendMessage(mock.Anything, mock.MatchedBy(func(m *protocol.UDPMessage) bool {
+		return m.FragCount == 1
+	})).Return(&quic.DatagramTooLargeError{MaxDataLen: 1200}).Times(udpStreamFragThreshold)
+	io.EXPECT().SendMessage(mock.Anything, mock.MatchedBy(func(m *protocol.UDPMessage) bool {
+		return m.FragCount > 1
+	})).Return(nil)
+	for i := 0; i < udpStreamFragThreshold-1; i++ {
+		assert.NoError(t, udpConn1.Send(largeData, "voip.example.com:5060"))
+	}
+	client1, server1 := net.Pipe()
+	io.EXPECT().OpenStream(uint32(1)).Return(client1, nil).Once()
+	msgCh := make(chan *protocol.UDPMessage, 1)
+	go func() {
+		msg, _ := protocol.ReadUDPStreamPacket(server1)
+		msgCh <- msg
+	}()
+	assert.NoError(t, udpConn1.Send(largeData, "voip.example.com:5060"))
+	msg := <-msgCh
+	assert.Equal(t, "voip.example.com:5060", msg.Addr)
+	assert.Equal(t, largeData, msg.Data)
+
+	// Receive from the stream
+	go func() {
+		_ = protocol.WriteUDPStreamPacket(server1, nil, &protocol.UDPMessage{Addr: "voip.example.com:5060", Data: largeData})
+	}()
+	data, addr, err := udpConn1.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, largeData, data)
+	assert.Equal(t, "voip.example.com:5060", addr)
+
+	// Close closes the stream instead of sending UDPClose
+	assert.NoError(t, udpConn1.Close())
+	_, err = protocol.ReadUDPStreamPacket(server1)
+	assert.Error(t, err)
+
+	// Explicitly over a stream from the start, closed by the server
+	client2, server2 := net.Pipe()
+	io.EXPECT().OpenStream(uint32(2)).Return(client2, nil).Once()
+	udpConn2, err := sm.NewUDPStream()
+	assert.NoError(t, err)
+	_ = server2.Close()
+	_, _, err = udpConn2.Receive()
+	assert.Equal(t, io2.EOF, err)
+	assert.NoError(t, udpConn2.Close())
+
+	// Packets from a stream are not dropped when the receiver falls behind
+	client3, server3 := net.Pipe()
+	io.EXPECT().OpenStream(uint32(3)).Return(client3, nil).Once()
+	udpConn3, err := sm.NewUDPStream()
+	assert.NoError(t, err)
+	const count = udpMessageChanSize + 100
+	go func() {
+		for i := 0; i < count; i++ {
+			_ = protocol.WriteUDPStreamPacket(server3, nil, &protocol.UDPMessage{Addr: "media.example.com:5004", Data: []byte{byte(i)}})
+		}
+		_ = server3.Close()
+	}()
+	time.Sleep(500 * time.Millisecond)
+	for i := 0; i < count; i++ {
+		data, _, err := udpConn3.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(i)}, data)
+	}
+	_, _, err = udpConn3.Receive()
+	assert.Equal(t, io2.EOF, err)
+	assert.NoError(t, udpConn3.Close())
+
+	// Failing to open a stream fails the session
+	io.EXPECT().OpenStream(uint32(4)).Return(nil, coreErrs.ClosedError{}).Once()
+	_, err = sm.NewUDPStream()
+	assert.Equal(t, coreErrs.ClosedError{}, err)
+
+	// Leak checks
+	close(receiveCh)
+	time.Sleep(1 * time.Second)
+	assert.Zero(t, sm.Count(), "session count should be 0")
+	goleak.VerifyNone(t)
+}
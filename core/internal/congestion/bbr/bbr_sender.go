@@ -11,6 +11,7 @@ import (
 	"github.com/apernet/quic-go/congestion"
 
 	"github.com/apernet/hysteria/core/v2/internal/congestion/common"
+	"github.com/apernet/hysteria/core/v2/internal/utils"
 )
 
 // BbrSender implements BBR congestion control algorithm.  BBR aims to estimate
@@ -961,7 +962,7 @@ func bdpFromRttAndBandwidth(rtt time.Duration, bandwidth Bandwidth) congestion.B
 func GetInitialPacketSize(addr net.Addr) congestion.ByteCount {
 	// If this is not a UDP address, we don't know anything about the MTU.
 	// Use the minimum size of an Initial packet as the max packet size.
-	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+	if udpAddr, ok := utils.UnwrapAddr(addr).(*net.UDPAddr); ok {
 		if udpAddr.IP.To4() != nil {
 			return congestion.InitialPacketSizeIPv4
 		} else {
@@ -0,0 +1,1095 @@
+// Package bbr3 implements BBRv3 as described in
+// https://datatracker.ietf.org/doc/html/draft-ietf-ccwg-bbr
+//
+// Compared to BBRv1 (package bbr), it also reacts to loss: it keeps the
+// amount of data in flight under the level where more than 2% of it
+// gets lost (inflight_hi), backs off its model of the path when it sees
+// congestion (bw_lo, inflight_lo), and spaces its bandwidth probes out to
+// coexist better with loss-based flows like Cubic and Reno.
+package bbr3
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+
+	"github.com/apernet/hysteria/core/v2/internal/congestion/bbr"
+	"github.com/apernet/hysteria/core/v2/internal/congestion/common"
+)
+
+const (
+	minBps = 65536 // 64 kbps
+
+	infBandwidth = bbr.Bandwidth(math.MaxUint64)
+	infByteCount = congestion.ByteCount(math.MaxInt64)
+	infRTT       = time.Duration(math.MaxInt64)
+
+	initialCongestionWindowPackets = 32
+	minPipeCwndPackets             = 4
+
+	// Pacing and cwnd gains of each state.
+	startupPacingGain   = 2.77 // 4 * ln(2)
+	startupCwndGain     = 2.0
+	drainPacingGain     = 1 / startupPacingGain
+	defaultCwndGain     = 2.0
+	probeDownPacingGain = 0.9
+	probeUpPacingGain   = 1.25
+	probeUpCwndGain     = 2.25
+	probeRTTCwndGain    = 0.5
+
+	// Pace slightly below the estimated bandwidth to keep queues low.
+	pacingMarginPercent = 1
+
+	// The highest tolerated loss rate per round trip.
+	lossThreshold = 0.02
+	// The multiplicative decrease on congestion.
+	beta = 0.7
+	// The fraction of inflight_hi left unused in steady state, for other flows.
+	headroom = 0.15
+
+	// Startup ends when the bandwidth doesn't grow by 25% for 3 rounds,
+	// or when the loss rate is too high with at least 6 loss events in a round.
+	startupGrowthTarget  = 1.25
+	startupFullBwRounds  = 3
+	startupFullLossCount = 6
+
+	minRTTFilterLen  = 10 * time.Second
+	probeRTTInterval = 5 * time.Second
+	probeRTTDuration = 200 * time.Millisecond
+
+	// Bandwidth probes happen every 2-3 seconds, or sooner if a Reno flow
+	// would have grown its window by then (capped at 63 rounds).
+	bwProbeWaitBase   = 2 * time.Second
+	bwProbeWaitRand   = time.Second
+	maxRenoRounds     = 63
+	maxBwProbeUpRound = 30
+
+	// The ack aggregation filter covers 2 windows of this many rounds.
+	extraAckedWindowRounds = 5
+
+	maxSendQuantum = 64 * 1024
+
+	// quic-go declares a packet lost once a packet 3 numbers after it is acked.
+	packetThreshold = 3
+
+	debugEnv = "HYSTERIA_BBR3_DEBUG"
+)
+
+type bbrMode int
+
+const (
+	bbrModeStartup bbrMode = iota
+	bbrModeDrain
+	bbrModeProbeBwDown
+	bbrModeProbeBwCruise
+	bbrModeProbeBwRefill
+	bbrModeProbeBwUp
+	bbrModeProbeRtt
+)
+
+func (m bbrMode) String() string {
+	switch m {
+	case bbrModeStartup:
+		return "STARTUP"
+	case bbrModeDrain:
+		return "DRAIN"
+	case bbrModeProbeBwDown:
+		return "PROBE_BW_DOWN"
+	case bbrModeProbeBwCruise:
+		return "PROBE_BW_CRUISE"
+	case bbrModeProbeBwRefill:
+		return "PROBE_BW_REFILL"
+	case bbrModeProbeBwUp:
+		return "PROBE_BW_UP"
+	case bbrModeProbeRtt:
+		return "PROBE_RTT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Where we are in getting feedback (ACKs) from a bandwidth probe.
+type bbrAckPhase int
+
+const (
+	bbrAckPhaseInit bbrAckPhase = iota
+	bbrAckPhaseProbeStarting
+	bbrAckPhaseProbeFeedback
+	bbrAckPhaseProbeStopping
+	bbrAckPhaseRefilling
+)
+
+type bbr3Sender struct {
+	rttStats congestion.RTTStatsProvider
+	clock    bbr.Clock
+	pacer    *common.Pacer
+
+	maxDatagramSize congestion.ByteCount
+	bytesInFlight   congestion.ByteCount
+	priorInFlight   congestion.ByteCount
+
+	// Delivery rate estimation.
+	packets       sentPacketQueue
+	rs            rateSample
+	delivered     congestion.ByteCount
+	deliveredTime time.Time
+	firstSentTime time.Time
+	lost          congestion.ByteCount
+	// Packets sent before this much is delivered produce app-limited samples.
+	// Zero if the sender is not app-limited.
+	appLimited   congestion.ByteCount
+	largestAcked congestion.PacketNumber
+
+	mode       bbrMode
+	pacingGain float64
+	cwndGain   float64
+	pacingRate bbr.Bandwidth
+
+	congestionWindow        congestion.ByteCount
+	initialCongestionWindow congestion.ByteCount
+	priorCongestionWindow   congestion.ByteCount
+	maxInflight             congestion.ByteCount
+
+	// Round trip counting.
+	roundCount         uint64
+	roundStart         bool
+	nextRoundDelivered congestion.ByteCount
+
+	// Bandwidth model. maxBw is the max over the current and the last probe
+	// cycle, bwLo the lower bound from congestion, bw the one in use.
+	maxBw      bbr.Bandwidth
+	bwHi       [2]bbr.Bandwidth
+	cycleCount uint64
+	bwLo       bbr.Bandwidth
+	bw         bbr.Bandwidth
+
+	// Inflight model, the upper and lower bounds of the data in flight.
+	inflightHi congestion.ByteCount
+	inflightLo congestion.ByteCount
+
+	// Congestion signals of the latest loss round.
+	bwLatest           bbr.Bandwidth
+	inflightLatest     congestion.ByteCount
+	lossRoundStart     bool
+	lossRoundDelivered congestion.ByteCount
+	lossInRound        bool
+	lossEventsInRound  int
+
+	// Startup.
+	filledPipe  bool
+	fullBw      bbr.Bandwidth
+	fullBwCount int
+
+	// ProbeBW.
+	ackPhase           bbrAckPhase
+	cycleStamp         time.Time
+	roundsSinceBwProbe uint64
+	bwProbeWait        time.Duration
+	bwProbeSamples     bool
+	bwProbeUpRounds    int
+	bwProbeUpAcks      congestion.ByteCount
+	probeUpCount       congestion.ByteCount
+
+	// Min RTT and ProbeRTT.
+	minRtt            time.Duration
+	minRttStamp       time.Time
+	probeRttMinDelay  time.Duration
+	probeRttMinStamp  time.Time
+	probeRttExpired   bool
+	probeRttDoneStamp time.Time
+	probeRttRoundDone bool
+	idleRestart       bool
+
+	// Ack aggregation.
+	extraAcked              [2]congestion.ByteCount
+	extraAckedIdx           int
+	extraAckedWindowRounds  int
+	extraAckedIntervalStart time.Time
+	extraAckedDelivered     congestion.ByteCount
+
+	debug bool
+}
+
+var _ congestion.CongestionControl = &bbr3Sender{}
+
+func NewBbr3Sender(
+	clock bbr.Clock,
+	initialMaxDatagramSize congestion.ByteCount,
+) *bbr3Sender {
+	debug, _ := strconv.ParseBool(os.Getenv(debugEnv))
+	now := clock.Now()
+	b := &bbr3Sender{
+		clock:                   clock,
+		maxDatagramSize:         initialMaxDatagramSize,
+		largestAcked:            -1,
+		congestionWindow:        initialCongestionWindowPackets * initialMaxDatagramSize,
+		initialCongestionWindow: initialCongestionWindowPackets * initialMaxDatagramSize,
+		bwLo:                    infBandwidth,
+		inflightHi:              infByteCount,
+		inflightLo:              infByteCount,
+		probeUpCount:            infByteCount,
+		minRtt:                  infRTT,
+		minRttStamp:             now,
+		probeRttMinDelay:        infRTT,
+		probeRttMinStamp:        now,
+		cycleStamp:              now,
+		debug:                   debug,
+	}
+	b.pacer = common.NewPacer(b.bandwidthForPacer)
+	b.enterStartup()
+	return b
+}
+
+func (b *bbr3Sender) SetRTTStatsProvider(provider congestion.RTTStatsProvider) {
+	b.rttStats = provider
+}
+
+func (b *bbr3Sender) TimeUntilSend(bytesInFlight congestion.ByteCount) time.Time {
+	return b.pacer.TimeUntilSend()
+}
+
+func (b *bbr3Sender) HasPacingBudget(now time.Time) bool {
+	return b.pacer.Budget(now) >= b.maxDatagramSize
+}
+
+func (b *bbr3Sender) OnPacketSent(
+	sentTime time.Time,
+	bytesInFlight congestion.ByteCount,
+	packetNumber congestion.PacketNumber,
+	bytes congestion.ByteCount,
+	isRetransmittable bool,
+) {
+	b.pacer.SentPacket(sentTime, bytes)
+	if !isRetransmittable {
+		// Never acked nor lost on its own
+		return
+	}
+	if bytesInFlight <= bytes {
+		// Nothing else in flight, start a new sample interval
+		b.firstSentTime = sentTime
+		b.deliveredTime = sentTime
+		if b.appLimited != 0 {
+			b.handleRestartFromIdle(sentTime)
+		}
+	}
+	b.bytesInFlight = bytesInFlight
+	b.packets.Add(packetNumber, sentPacket{
+		sentTime:      sentTime,
+		size:          bytes,
+		delivered:     b.delivered,
+		deliveredTime: b.deliveredTime,
+		firstSentTime: b.firstSentTime,
+		lost:          b.lost,
+		txInFlight:    bytesInFlight,
+		isAppLimited:  b.appLimited != 0,
+	})
+}
+
+func (b *bbr3Sender) CanSend(bytesInFlight congestion.ByteCount) bool {
+	return bytesInFlight < b.GetCongestionWindow()
+}
+
+func (b *bbr3Sender) MaybeExitSlowStart() {
+	// Do nothing
+}
+
+func (b *bbr3Sender) OnPacketAcked(number congestion.PacketNumber, ackedBytes, priorInFlight congestion.ByteCount, eventTime time.Time) {
+	// Do nothing
+}
+
+func (b *bbr3Sender) OnCongestionEvent(number congestion.PacketNumber, lostBytes, priorInFlight congestion.ByteCount) {
+	// Do nothing
+}
+
+func (b *bbr3Sender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	// Do nothing
+}
+
+func (b *bbr3Sender) SetMaxDatagramSize(s congestion.ByteCount) {
+	if s < b.maxDatagramSize {
+		panic(fmt.Sprintf("congestion BUG: decreased max datagram size from %d to %d", b.maxDatagramSize, s))
+	}
+	cwndIsMinCwnd := b.congestionWindow == b.minPipeCwnd()
+	b.maxDatagramSize = s
+	if cwndIsMinCwnd {
+		b.congestionWindow = b.minPipeCwnd()
+	}
+	b.pacer.SetMaxDatagramSize(s)
+}
+
+func (b *bbr3Sender) InSlowStart() bool {
+	return b.mode == bbrModeStartup
+}
+
+// InRecovery always returns false, as BBRv3 handles loss with its model
+// (inflight_hi, inflight_lo, bw_lo) instead of a separate recovery state.
+func (b *bbr3Sender) InRecovery() bool {
+	return false
+}
+
+func (b *bbr3Sender) GetCongestionWindow() congestion.ByteCount {
+	return b.congestionWindow
+}
+
+func (b *bbr3Sender) OnCongestionEventEx(priorInFlight congestion.ByteCount, eventTime time.Time, ackedPackets []congestion.AckedPacketInfo, lostPackets []congestion.LostPacketInfo) {
+	b.priorInFlight = priorInFlight
+	b.bytesInFlight = priorInFlight
+	for _, p := range ackedPackets {
+		b.bytesInFlight -= p.BytesAcked
+	}
+	for _, p := range lostPackets {
+		b.bytesInFlight -= p.BytesLost
+	}
+
+	b.maybeAppLimited(eventTime)
+
+	for _, p := range lostPackets {
+		b.lost += p.BytesLost
+		if sp, ok := b.packets.Remove(p.PacketNumber); ok {
+			b.handleLostPacket(&sp, eventTime)
+		}
+	}
+	if len(lostPackets) != 0 {
+		b.lossInRound = true
+		b.lossEventsInRound++
+	}
+
+	if len(ackedPackets) == 0 || !b.generateRateSample(eventTime, ackedPackets, lostPackets) {
+		// Loss only, or none of the acked packets are ours
+		b.boundCwndForModel()
+		return
+	}
+
+	b.updateModelAndState(eventTime)
+	b.updateControlParameters()
+
+	// quic-go has declared all packets this far behind the largest acked
+	// either acked or lost by now, anything left is obsolete.
+	b.packets.RemoveBefore(b.largestAcked - packetThreshold)
+}
+
+// maybeAppLimited marks the sender as app-limited if it has not been limited
+// by either the congestion window or pacing, i.e. it had nothing to send.
+func (b *bbr3Sender) maybeAppLimited(now time.Time) {
+	if b.priorInFlight < b.congestionWindow && b.pacer.Budget(now) >= b.pacer.MaxBurstSize() {
+		b.markAppLimited()
+	}
+}
+
+func (b *bbr3Sender) markAppLimited() {
+	b.appLimited = max(b.delivered+b.bytesInFlight, 1)
+}
+
+// generateRateSample updates the delivery state with the acked packets and
+// computes the rate sample. Returns false if none of the packets are known.
+func (b *bbr3Sender) generateRateSample(now time.Time, ackedPackets []congestion.AckedPacketInfo, lostPackets []congestion.LostPacketInfo) bool {
+	rs := rateSample{rtt: -1}
+	var found bool
+	var sendElapsed, ackElapsed time.Duration
+	for _, p := range lostPackets {
+		rs.newlyLost += p.BytesLost
+	}
+	for _, p := range ackedPackets {
+		b.largestAcked = max(b.largestAcked, p.PacketNumber)
+		sp, ok := b.packets.Remove(p.PacketNumber)
+		if !ok {
+			continue
+		}
+		b.delivered += p.BytesAcked
+		b.deliveredTime = now
+		rs.newlyAcked += p.BytesAcked
+		if rtt := now.Sub(sp.sentTime); rs.rtt < 0 || rtt < rs.rtt {
+			rs.rtt = rtt
+		}
+		// Use the most recently sent packet for the sample
+		if !found || sp.delivered >= rs.priorDelivered {
+			found = true
+			rs.priorDelivered = sp.delivered
+			rs.isAppLimited = sp.isAppLimited
+			rs.txInFlight = sp.txInFlight
+			sendElapsed = sp.sentTime.Sub(sp.firstSentTime)
+			ackElapsed = now.Sub(sp.deliveredTime)
+			b.firstSentTime = sp.sentTime
+			rs.lost = b.lost - sp.lost
+		}
+	}
+	if !found {
+		return false
+	}
+	if b.appLimited != 0 && b.delivered > b.appLimited {
+		// All packets sent while app-limited are delivered
+		b.appLimited = 0
+	}
+	rs.delivered = b.delivered - rs.priorDelivered
+	// Use the longer of the two intervals, to not overestimate the rate
+	// when ACKs are compressed, or when packets were sent in a burst.
+	interval := max(sendElapsed, ackElapsed)
+	if interval > 0 && (b.minRtt == infRTT || interval >= b.minRtt) {
+		rs.deliveryRate = bbr.BandwidthFromDelta(rs.delivered, interval)
+	}
+	b.rs = rs
+	return true
+}
+
+func (b *bbr3Sender) updateModelAndState(now time.Time) {
+	b.updateLatestDeliverySignals()
+	b.updateCongestionSignals()
+	b.updateAckAggregation(now)
+	b.checkFullBwReached()
+	b.checkStartupDone()
+	b.checkDrainDone(now)
+	b.updateProbeBwCyclePhase(now)
+	b.updateMinRtt(now)
+	b.checkProbeRtt(now)
+	b.advanceLatestDeliverySignals()
+	b.boundBwForModel()
+}
+
+func (b *bbr3Sender) updateControlParameters() {
+	b.setPacingRate()
+	b.setCwnd()
+}
+
+// Round trips
+
+func (b *bbr3Sender) startRound() {
+	b.nextRoundDelivered = b.delivered
+}
+
+func (b *bbr3Sender) updateRound() {
+	if b.rs.priorDelivered >= b.nextRoundDelivered {
+		b.startRound()
+		b.roundCount++
+		b.roundsSinceBwProbe++
+		b.roundStart = true
+	} else {
+		b.roundStart = false
+	}
+}
+
+// Bandwidth and congestion signals
+
+func (b *bbr3Sender) updateMaxBw() {
+	b.updateRound()
+	if b.rs.deliveryRate > 0 && (b.rs.deliveryRate >= b.maxBw || !b.rs.isAppLimited) {
+		b.bwHi[1] = max(b.bwHi[1], b.rs.deliveryRate)
+		b.maxBw = max(b.bwHi[0], b.bwHi[1])
+	}
+}
+
+func (b *bbr3Sender) advanceMaxBwFilter() {
+	b.cycleCount++
+	b.bwHi[0] = b.bwHi[1]
+	b.bwHi[1] = 0
+	if b.bwHi[0] == 0 {
+		// Keep the old estimate if there were no samples in the last cycle
+		b.bwHi[0] = b.maxBw
+	}
+	b.maxBw = b.bwHi[0]
+}
+
+func (b *bbr3Sender) updateLatestDeliverySignals() {
+	b.lossRoundStart = false
+	b.bwLatest = max(b.bwLatest, b.rs.deliveryRate)
+	b.inflightLatest = max(b.inflightLatest, b.rs.delivered)
+	if b.rs.priorDelivered >= b.lossRoundDelivered {
+		b.lossRoundDelivered = b.delivered
+		b.lossRoundStart = true
+	}
+}
+
+func (b *bbr3Sender) advanceLatestDeliverySignals() {
+	if b.lossRoundStart {
+		b.bwLatest = b.rs.deliveryRate
+		b.inflightLatest = b.rs.delivered
+	}
+}
+
+func (b *bbr3Sender) resetCongestionSignals() {
+	b.lossInRound = false
+	b.bwLatest = 0
+	b.inflightLatest = 0
+}
+
+func (b *bbr3Sender) updateCongestionSignals() {
+	b.updateMaxBw()
+	if !b.lossRoundStart {
+		return
+	}
+	b.adaptLowerBoundsFromCongestion()
+	b.lossInRound = false
+}
+
+func (b *bbr3Sender) adaptLowerBoundsFromCongestion() {
+	if b.isProbingBw() {
+		return
+	}
+	if b.lossInRound {
+		b.initLowerBounds()
+		b.lossLowerBounds()
+	}
+}
+
+func (b *bbr3Sender) initLowerBounds() {
+	if b.bwLo == infBandwidth {
+		b.bwLo = b.maxBw
+	}
+	if b.inflightLo == infByteCount {
+		b.inflightLo = b.congestionWindow
+	}
+}
+
+func (b *bbr3Sender) lossLowerBounds() {
+	b.bwLo = max(b.bwLatest, bbr.Bandwidth(beta*float64(b.bwLo)))
+	b.inflightLo = max(b.inflightLatest, congestion.ByteCount(beta*float64(b.inflightLo)))
+}
+
+func (b *bbr3Sender) resetLowerBounds() {
+	b.bwLo = infBandwidth
+	b.inflightLo = infByteCount
+}
+
+func (b *bbr3Sender) boundBwForModel() {
+	b.bw = min(b.maxBw, b.bwLo)
+}
+
+// Loss handling
+
+func (b *bbr3Sender) isInflightTooHigh() bool {
+	return b.rs.lost > congestion.ByteCount(float64(b.rs.txInFlight)*lossThreshold)
+}
+
+func (b *bbr3Sender) handleLostPacket(p *sentPacket, now time.Time) {
+	if !b.bwProbeSamples {
+		return
+	}
+	b.rs.txInFlight = p.txInFlight
+	b.rs.lost = b.lost - p.lost
+	b.rs.isAppLimited = p.isAppLimited
+	if b.isInflightTooHigh() {
+		b.rs.txInFlight = b.inflightHiFromLostPacket(p)
+		b.handleInflightTooHigh(now)
+	}
+}
+
+// inflightHiFromLostPacket estimates the amount in flight at which the
+// loss rate crossed the threshold, somewhere within the lost packet.
+func (b *bbr3Sender) inflightHiFromLostPacket(p *sentPacket) congestion.ByteCount {
+	if b.rs.txInFlight < p.size || b.rs.lost < p.size {
+		return b.rs.txInFlight
+	}
+	inflightPrev := float64(b.rs.txInFlight - p.size)
+	lostPrev := float64(b.rs.lost - p.size)
+	lostPrefix := (lossThreshold*inflightPrev - lostPrev) / (1 - lossThreshold)
+	return congestion.ByteCount(max(inflightPrev+lostPrefix, 0))
+}
+
+func (b *bbr3Sender) handleInflightTooHigh(now time.Time) {
+	b.bwProbeSamples = false
+	if !b.rs.isAppLimited {
+		b.inflightHi = max(b.rs.txInFlight, congestion.ByteCount(float64(b.targetInflight())*beta))
+	}
+	if b.mode == bbrModeProbeBwUp {
+		b.startProbeBwDown(now)
+	}
+}
+
+func (b *bbr3Sender) checkInflightTooHigh(now time.Time) bool {
+	if b.isInflightTooHigh() {
+		if b.bwProbeSamples {
+			b.handleInflightTooHigh(now)
+		}
+		return true
+	}
+	return false
+}
+
+// Startup and Drain
+
+func (b *bbr3Sender) enterStartup() {
+	b.setMode(bbrModeStartup)
+	b.pacingGain = startupPacingGain
+	b.cwndGain = startupCwndGain
+}
+
+func (b *bbr3Sender) checkFullBwReached() {
+	if b.filledPipe || !b.roundStart || b.rs.isAppLimited {
+		return
+	}
+	if float64(b.maxBw) >= float64(b.fullBw)*startupGrowthTarget {
+		b.fullBw = b.maxBw
+		b.fullBwCount = 0
+		return
+	}
+	b.fullBwCount++
+	if b.fullBwCount >= startupFullBwRounds {
+		b.filledPipe = true
+	}
+}
+
+func (b *bbr3Sender) checkStartupHighLoss() {
+	if b.filledPipe {
+		return
+	}
+	if b.lossRoundStart {
+		if b.lossEventsInRound >= startupFullLossCount && b.isInflightTooHigh() {
+			b.filledPipe = true
+			b.inflightHi = max(b.bdpMultiple(b.maxBw, 1), b.inflightLatest)
+		}
+		b.lossEventsInRound = 0
+	}
+}
+
+func (b *bbr3Sender) checkStartupDone() {
+	b.checkStartupHighLoss()
+	if b.mode == bbrModeStartup && b.filledPipe {
+		b.setMode(bbrModeDrain)
+		b.pacingGain = drainPacingGain
+		b.cwndGain = startupCwndGain
+	}
+}
+
+func (b *bbr3Sender) checkDrainDone(now time.Time) {
+	if b.mode == bbrModeDrain && b.bytesInFlight <= b.inflight(b.maxBw, 1) {
+		b.startProbeBwDown(now)
+	}
+}
+
+// ProbeBW
+
+func (b *bbr3Sender) isInProbeBwState() bool {
+	switch b.mode {
+	case bbrModeProbeBwDown, bbrModeProbeBwCruise, bbrModeProbeBwRefill, bbrModeProbeBwUp:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *bbr3Sender) isProbingBw() bool {
+	return b.mode == bbrModeStartup || b.mode == bbrModeProbeBwRefill || b.mode == bbrModeProbeBwUp
+}
+
+func (b *bbr3Sender) startProbeBwDown(now time.Time) {
+	b.resetCongestionSignals()
+	b.probeUpCount = infByteCount
+	b.pickProbeWait()
+	b.cycleStamp = now
+	b.ackPhase = bbrAckPhaseProbeStopping
+	b.startRound()
+	b.setMode(bbrModeProbeBwDown)
+	b.pacingGain = probeDownPacingGain
+	b.cwndGain = defaultCwndGain
+}
+
+func (b *bbr3Sender) startProbeBwCruise() {
+	b.setMode(bbrModeProbeBwCruise)
+	b.pacingGain = 1
+	b.cwndGain = defaultCwndGain
+}
+
+func (b *bbr3Sender) startProbeBwRefill() {
+	b.resetLowerBounds()
+	b.bwProbeUpRounds = 0
+	b.bwProbeUpAcks = 0
+	b.ackPhase = bbrAckPhaseRefilling
+	b.startRound()
+	b.setMode(bbrModeProbeBwRefill)
+	b.pacingGain = 1
+	b.cwndGain = defaultCwndGain
+}
+
+func (b *bbr3Sender) startProbeBwUp(now time.Time) {
+	b.ackPhase = bbrAckPhaseProbeStarting
+	b.startRound()
+	b.cycleStamp = now
+	b.setMode(bbrModeProbeBwUp)
+	b.pacingGain = probeUpPacingGain
+	b.cwndGain = probeUpCwndGain
+	b.raiseInflightHiSlope()
+}
+
+func (b *bbr3Sender) pickProbeWait() {
+	b.roundsSinceBwProbe = uint64(rand.Intn(2))
+	b.bwProbeWait = bwProbeWaitBase + time.Duration(rand.Int63n(int64(bwProbeWaitRand)))
+}
+
+func (b *bbr3Sender) hasElapsedInPhase(now time.Time, interval time.Duration) bool {
+	return now.Sub(b.cycleStamp) > interval
+}
+
+// isRenoCoexistenceProbeTime returns whether a Reno flow sharing the path
+// would have grown its window back to the current BDP by now.
+func (b *bbr3Sender) isRenoCoexistenceProbeTime() bool {
+	renoRounds := uint64(b.targetInflight() / b.maxDatagramSize)
+	return b.roundsSinceBwProbe >= min(renoRounds, maxRenoRounds)
+}
+
+func (b *bbr3Sender) checkTimeToProbeBw(now time.Time) bool {
+	if b.hasElapsedInPhase(now, b.bwProbeWait) || b.isRenoCoexistenceProbeTime() {
+		b.startProbeBwRefill()
+		return true
+	}
+	return false
+}
+
+func (b *bbr3Sender) checkTimeToCruise() bool {
+	if b.bytesInFlight > b.inflightWithHeadroom() {
+		return false
+	}
+	return b.bytesInFlight <= b.inflight(b.maxBw, 1)
+}
+
+func (b *bbr3Sender) checkTimeToGoDown(now time.Time) bool {
+	return b.hasElapsedInPhase(now, b.getMinRtt()) && b.priorInFlight > b.inflight(b.maxBw, probeUpPacingGain)
+}
+
+func (b *bbr3Sender) updateProbeBwCyclePhase(now time.Time) {
+	if !b.filledPipe {
+		return
+	}
+	b.adaptUpperBounds(now)
+	if !b.isInProbeBwState() {
+		return
+	}
+	switch b.mode {
+	case bbrModeProbeBwDown:
+		if b.checkTimeToProbeBw(now) {
+			return
+		}
+		if b.checkTimeToCruise() {
+			b.startProbeBwCruise()
+		}
+	case bbrModeProbeBwCruise:
+		b.checkTimeToProbeBw(now)
+	case bbrModeProbeBwRefill:
+		// After one round of refilling, start probing
+		if b.roundStart {
+			b.bwProbeSamples = true
+			b.startProbeBwUp(now)
+		}
+	case bbrModeProbeBwUp:
+		if b.checkTimeToGoDown(now) {
+			b.startProbeBwDown(now)
+		}
+	}
+}
+
+func (b *bbr3Sender) adaptUpperBounds(now time.Time) {
+	if b.ackPhase == bbrAckPhaseProbeStarting && b.roundStart {
+		// Starting to get feedback from the bandwidth probe
+		b.ackPhase = bbrAckPhaseProbeFeedback
+	}
+	if b.ackPhase == bbrAckPhaseProbeStopping && b.roundStart {
+		// End of the feedback from the bandwidth probe
+		if b.isInProbeBwState() && !b.rs.isAppLimited {
+			b.advanceMaxBwFilter()
+			b.ackPhase = bbrAckPhaseInit
+		}
+	}
+	if !b.checkInflightTooHigh(now) {
+		// Loss rate is fine, we can raise the upper bound
+		if b.inflightHi == infByteCount {
+			return
+		}
+		if b.rs.txInFlight > b.inflightHi {
+			b.inflightHi = b.rs.txInFlight
+		}
+		if b.mode == bbrModeProbeBwUp {
+			b.probeInflightHiUpward()
+		}
+	}
+}
+
+func (b *bbr3Sender) isCwndLimited() bool {
+	return b.priorInFlight+b.maxDatagramSize >= b.congestionWindow
+}
+
+func (b *bbr3Sender) probeInflightHiUpward() {
+	if !b.isCwndLimited() || b.congestionWindow < b.inflightHi {
+		return
+	}
+	b.bwProbeUpAcks += b.rs.newlyAcked
+	if b.bwProbeUpAcks >= b.probeUpCount {
+		delta := b.bwProbeUpAcks / b.probeUpCount
+		b.bwProbeUpAcks -= delta * b.probeUpCount
+		b.inflightHi += delta * b.maxDatagramSize
+	}
+	if b.roundStart {
+		b.raiseInflightHiSlope()
+	}
+}
+
+// raiseInflightHiSlope doubles the growth of inflight_hi every round.
+func (b *bbr3Sender) raiseInflightHiSlope() {
+	growthThisRound := b.maxDatagramSize << b.bwProbeUpRounds
+	b.bwProbeUpRounds = min(b.bwProbeUpRounds+1, maxBwProbeUpRound)
+	b.probeUpCount = max(b.congestionWindow/growthThisRound, 1)
+}
+
+// Min RTT and ProbeRTT
+
+func (b *bbr3Sender) getMinRtt() time.Duration {
+	if b.minRtt != infRTT {
+		return b.minRtt
+	}
+	if b.rttStats != nil && b.rttStats.MinRTT() != 0 {
+		return b.rttStats.MinRTT()
+	}
+	return 100 * time.Millisecond
+}
+
+func (b *bbr3Sender) updateMinRtt(now time.Time) {
+	b.probeRttExpired = now.After(b.probeRttMinStamp.Add(probeRTTInterval))
+	if b.rs.rtt >= 0 && (b.rs.rtt < b.probeRttMinDelay || b.probeRttExpired) {
+		b.probeRttMinDelay = b.rs.rtt
+		b.probeRttMinStamp = now
+	}
+	minRttExpired := now.After(b.minRttStamp.Add(minRTTFilterLen))
+	if b.probeRttMinDelay < b.minRtt || minRttExpired {
+		b.minRtt = b.probeRttMinDelay
+		b.minRttStamp = b.probeRttMinStamp
+	}
+}
+
+func (b *bbr3Sender) checkProbeRtt(now time.Time) {
+	if b.mode != bbrModeProbeRtt && b.probeRttExpired && !b.idleRestart {
+		b.priorCongestionWindow = b.congestionWindow
+		b.setMode(bbrModeProbeRtt)
+		b.pacingGain = 1
+		b.cwndGain = probeRTTCwndGain
+		b.probeRttDoneStamp = time.Time{}
+		b.ackPhase = bbrAckPhaseProbeStopping
+		b.startRound()
+	}
+	if b.mode == bbrModeProbeRtt {
+		b.handleProbeRtt(now)
+	}
+	if b.rs.delivered > 0 {
+		b.idleRestart = false
+	}
+}
+
+func (b *bbr3Sender) handleProbeRtt(now time.Time) {
+	// Ignore low rate samples during ProbeRTT
+	b.markAppLimited()
+	if b.probeRttDoneStamp.IsZero() && b.bytesInFlight <= b.probeRttCwnd() {
+		// Wait for at least probeRTTDuration and one round trip
+		b.probeRttDoneStamp = now.Add(probeRTTDuration)
+		b.probeRttRoundDone = false
+		b.startRound()
+	} else if !b.probeRttDoneStamp.IsZero() {
+		if b.roundStart {
+			b.probeRttRoundDone = true
+		}
+		if b.probeRttRoundDone {
+			b.checkProbeRttDone(now)
+		}
+	}
+}
+
+func (b *bbr3Sender) checkProbeRttDone(now time.Time) {
+	if !b.probeRttDoneStamp.IsZero() && now.After(b.probeRttDoneStamp) {
+		// Schedule the next ProbeRTT
+		b.probeRttMinStamp = now
+		b.congestionWindow = max(b.congestionWindow, b.priorCongestionWindow)
+		b.resetLowerBounds()
+		if b.filledPipe {
+			b.startProbeBwDown(now)
+			b.startProbeBwCruise()
+		} else {
+			b.enterStartup()
+		}
+	}
+}
+
+func (b *bbr3Sender) handleRestartFromIdle(now time.Time) {
+	b.idleRestart = true
+	b.extraAckedIntervalStart = now
+	if b.isInProbeBwState() {
+		b.setPacingRateWithGain(1)
+	} else if b.mode == bbrModeProbeRtt {
+		b.checkProbeRttDone(now)
+	}
+}
+
+// Ack aggregation
+
+func (b *bbr3Sender) updateAckAggregation(now time.Time) {
+	if b.roundStart {
+		b.extraAckedWindowRounds++
+		if b.extraAckedWindowRounds >= extraAckedWindowRounds {
+			b.extraAckedWindowRounds = 0
+			b.extraAckedIdx ^= 1
+			b.extraAcked[b.extraAckedIdx] = 0
+		}
+	}
+	expectedDelivered := bdp(b.bw, now.Sub(b.extraAckedIntervalStart))
+	if b.extraAckedDelivered <= expectedDelivered {
+		// Reset the interval if ACKs are not ahead of the expected rate
+		b.extraAckedDelivered = 0
+		b.extraAckedIntervalStart = now
+		expectedDelivered = 0
+	}
+	b.extraAckedDelivered += b.rs.newlyAcked
+	extra := min(b.extraAckedDelivered-expectedDelivered, b.congestionWindow)
+	b.extraAcked[b.extraAckedIdx] = max(b.extraAcked[b.extraAckedIdx], extra)
+}
+
+func (b *bbr3Sender) maxExtraAcked() congestion.ByteCount {
+	return max(b.extraAcked[0], b.extraAcked[1])
+}
+
+// Pacing rate and congestion window
+
+func (b *bbr3Sender) setPacingRateWithGain(gain float64) {
+	rate := bbr.Bandwidth(gain * float64(b.bw) * (100 - pacingMarginPercent) / 100)
+	if b.filledPipe || rate > b.pacingRate {
+		b.pacingRate = rate
+	}
+}
+
+func (b *bbr3Sender) setPacingRate() {
+	b.setPacingRateWithGain(b.pacingGain)
+}
+
+func (b *bbr3Sender) bandwidthForPacer() congestion.ByteCount {
+	rate := b.pacingRate
+	if rate == 0 {
+		// No bandwidth estimate yet, pace the initial window over an RTT
+		rtt := b.getMinRtt()
+		if b.rttStats != nil && b.rttStats.SmoothedRTT() != 0 {
+			rtt = b.rttStats.SmoothedRTT()
+		}
+		rate = bbr.Bandwidth(startupPacingGain * float64(bbr.BandwidthFromDelta(b.initialCongestionWindow, rtt)))
+	}
+	bps := congestion.ByteCount(rate / bbr.BytesPerSecond)
+	if bps < minBps {
+		// Same as in bbr, the pacer must never get a zero bandwidth
+		return minBps
+	}
+	return bps
+}
+
+func (b *bbr3Sender) minPipeCwnd() congestion.ByteCount {
+	return minPipeCwndPackets * b.maxDatagramSize
+}
+
+func (b *bbr3Sender) maxCongestionWindow() congestion.ByteCount {
+	return congestion.MaxCongestionWindowPackets * b.maxDatagramSize
+}
+
+func bdp(bw bbr.Bandwidth, rtt time.Duration) congestion.ByteCount {
+	return congestion.ByteCount(float64(bw) / float64(bbr.BytesPerSecond) * rtt.Seconds())
+}
+
+func (b *bbr3Sender) bdpMultiple(bw bbr.Bandwidth, gain float64) congestion.ByteCount {
+	if b.minRtt == infRTT {
+		// No valid RTT samples yet
+		return b.initialCongestionWindow
+	}
+	return congestion.ByteCount(gain * float64(bdp(bw, b.minRtt)))
+}
+
+// sendQuantum is the amount sent in a burst at the current pacing rate.
+func (b *bbr3Sender) sendQuantum() congestion.ByteCount {
+	quantum := bdp(b.pacingRate, time.Millisecond)
+	return min(max(quantum, 2*b.maxDatagramSize), maxSendQuantum)
+}
+
+// quantizationBudget leaves room for the pacer's bursts and ack aggregation.
+func (b *bbr3Sender) quantizationBudget(inflight congestion.ByteCount) congestion.ByteCount {
+	inflight = max(inflight, 3*b.sendQuantum(), b.minPipeCwnd())
+	if b.mode == bbrModeProbeBwUp {
+		inflight += 2 * b.maxDatagramSize
+	}
+	return inflight
+}
+
+func (b *bbr3Sender) inflight(bw bbr.Bandwidth, gain float64) congestion.ByteCount {
+	return b.quantizationBudget(b.bdpMultiple(bw, gain))
+}
+
+func (b *bbr3Sender) targetInflight() congestion.ByteCount {
+	return min(b.bdpMultiple(b.bw, 1), b.congestionWindow)
+}
+
+func (b *bbr3Sender) inflightWithHeadroom() congestion.ByteCount {
+	if b.inflightHi == infByteCount {
+		return infByteCount
+	}
+	room := max(b.maxDatagramSize, congestion.ByteCount(headroom*float64(b.inflightHi)))
+	if b.inflightHi < room {
+		return b.minPipeCwnd()
+	}
+	return max(b.inflightHi-room, b.minPipeCwnd())
+}
+
+func (b *bbr3Sender) probeRttCwnd() congestion.ByteCount {
+	return max(b.bdpMultiple(b.bw, probeRTTCwndGain), b.minPipeCwnd())
+}
+
+func (b *bbr3Sender) updateMaxInflight() {
+	inflight := b.bdpMultiple(b.bw, b.cwndGain) + b.maxExtraAcked()
+	b.maxInflight = b.quantizationBudget(inflight)
+}
+
+func (b *bbr3Sender) setCwnd() {
+	b.updateMaxInflight()
+	if b.filledPipe {
+		b.congestionWindow = min(b.congestionWindow+b.rs.newlyAcked, b.maxInflight)
+	} else if b.congestionWindow < b.maxInflight || b.delivered < b.initialCongestionWindow {
+		// Never decrease the window in Startup
+		b.congestionWindow += b.rs.newlyAcked
+	}
+	b.congestionWindow = max(b.congestionWindow, b.minPipeCwnd())
+	if b.mode == bbrModeProbeRtt {
+		b.congestionWindow = min(b.congestionWindow, b.probeRttCwnd())
+	}
+	b.boundCwndForModel()
+}
+
+func (b *bbr3Sender) boundCwndForModel() {
+	bound := infByteCount
+	if b.isInProbeBwState() && b.mode != bbrModeProbeBwCruise {
+		bound = b.inflightHi
+	} else if b.mode == bbrModeProbeRtt || b.mode == bbrModeProbeBwCruise {
+		bound = b.inflightWithHeadroom()
+	}
+	bound = min(bound, b.inflightLo, b.maxCongestionWindow())
+	bound = max(bound, b.minPipeCwnd())
+	b.congestionWindow = min(b.congestionWindow, bound)
+}
+
+func (b *bbr3Sender) setMode(mode bbrMode) {
+	if b.mode == mode && mode != bbrModeStartup {
+		return
+	}
+	b.mode = mode
+	if b.debug {
+		b.debugPrint("Phase: %s, Bandwidth: %s, MinRTT: %s, CWND: %d, InflightHi: %d",
+			mode, formatSpeed(b.bw), b.getMinRtt(), b.congestionWindow, b.inflightHi)
+	}
+}
+
+func (b *bbr3Sender) debugPrint(format string, a ...any) {
+	fmt.Printf("[BBR3Sender] [%s] %s\n",
+		time.Now().Format("15:04:05"),
+		fmt.Sprintf(format, a...))
+}
+
+func formatSpeed(bw bbr.Bandwidth) string {
+	bwf := float64(bw)
+	units := []string{"bps", "Kbps", "Mbps", "Gbps"}
+	unitIndex := 0
+	for bwf > 1000 && unitIndex < len(units)-1 {
+		bwf /= 1000
+		unitIndex++
+	}
+	return fmt.Sprintf("%.2f %s", bwf, units[unitIndex])
+}
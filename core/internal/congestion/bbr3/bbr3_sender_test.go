@@ -0,0 +1,224 @@
+package bbr3
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/internal/congestion/bbr"
+)
+
+const simPacketSize = 1200
+
+type mockClock struct {
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	return c.now
+}
+
+// simLink is a bottleneck link with a drop-tail queue.
+type simLink struct {
+	Rate      congestion.ByteCount // bytes per second
+	RTT       time.Duration        // without queueing
+	QueueSize congestion.ByteCount // bytes
+	LossRate  float64              // random loss on top of queue overflow
+}
+
+type simEvent struct {
+	Time time.Time
+	PN   congestion.PacketNumber
+	Lost bool
+}
+
+// bbr3Sim runs a bbr3Sender that always has data to send over a simLink.
+type bbr3Sim struct {
+	Link  simLink
+	Clock *mockClock
+	B     *bbr3Sender
+	Modes []bbrMode // every mode entered, in order
+
+	rand     *rand.Rand
+	nextPN   congestion.PacketNumber
+	inFlight congestion.ByteCount
+	linkFree time.Time
+	events   []simEvent // sorted by time
+}
+
+func newBBR3Sim(link simLink) *bbr3Sim {
+	clock := &mockClock{now: time.Unix(1, 0)}
+	b := NewBbr3Sender(clock, simPacketSize)
+	return &bbr3Sim{
+		Link:     link,
+		Clock:    clock,
+		B:        b,
+		Modes:    []bbrMode{b.mode},
+		rand:     rand.New(rand.NewSource(1)),
+		linkFree: clock.now,
+	}
+}
+
+func (s *bbr3Sim) send() {
+	now := s.Clock.now
+	pn := s.nextPN
+	s.nextPN++
+	s.inFlight += simPacketSize
+	s.B.OnPacketSent(now, s.inFlight, pn, simPacketSize, true)
+	queued := congestion.ByteCount(float64(s.Link.Rate) * max(s.linkFree.Sub(now), 0).Seconds())
+	if queued+simPacketSize > s.Link.QueueSize || s.rand.Float64() < s.Link.LossRate {
+		// quic-go detects the loss about an RTT later
+		s.addEvent(simEvent{Time: now.Add(s.Link.RTT), PN: pn, Lost: true})
+		return
+	}
+	if s.linkFree.Before(now) {
+		s.linkFree = now
+	}
+	s.linkFree = s.linkFree.Add(time.Duration(float64(time.Second) * simPacketSize / float64(s.Link.Rate)))
+	s.addEvent(simEvent{Time: s.linkFree.Add(s.Link.RTT), PN: pn})
+}
+
+func (s *bbr3Sim) addEvent(e simEvent) {
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].Time.After(e.Time) })
+	s.events = append(s.events, simEvent{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = e
+}
+
+// Run runs the simulation for d.
+func (s *bbr3Sim) Run(d time.Duration) {
+	end := s.Clock.now.Add(d)
+	for s.Clock.now.Before(end) {
+		for s.B.CanSend(s.inFlight) && s.B.HasPacingBudget(s.Clock.now) {
+			s.send()
+		}
+		// Advance to the next event, or when the pacer allows sending again
+		next := end
+		if len(s.events) > 0 && s.events[0].Time.Before(next) {
+			next = s.events[0].Time
+		}
+		if s.B.CanSend(s.inFlight) {
+			if t := s.B.TimeUntilSend(s.inFlight); t.Before(next) {
+				next = t
+			}
+		}
+		if !next.After(s.Clock.now) {
+			next = s.Clock.now.Add(congestion.MinPacingDelay)
+		}
+		s.Clock.now = next
+
+		var acked []congestion.AckedPacketInfo
+		var lost []congestion.LostPacketInfo
+		for len(s.events) > 0 && !s.events[0].Time.After(s.Clock.now) {
+			e := s.events[0]
+			s.events = s.events[1:]
+			if e.Lost {
+				lost = append(lost, congestion.LostPacketInfo{PacketNumber: e.PN, BytesLost: simPacketSize})
+			} else {
+				acked = append(acked, congestion.AckedPacketInfo{PacketNumber: e.PN, BytesAcked: simPacketSize, ReceivedTime: s.Clock.now})
+			}
+		}
+		if len(acked) == 0 && len(lost) == 0 {
+			continue
+		}
+		prior := s.inFlight
+		s.inFlight -= congestion.ByteCount(len(acked)+len(lost)) * simPacketSize
+		s.B.OnCongestionEventEx(prior, s.Clock.now, acked, lost)
+		if m := s.B.mode; m != s.Modes[len(s.Modes)-1] {
+			s.Modes = append(s.Modes, m)
+		}
+	}
+}
+
+// bytesPerSecond returns bw in bytes per second.
+func bytesPerSecond(bw bbr.Bandwidth) float64 {
+	return float64(bw / bbr.BytesPerSecond)
+}
+
+func TestBBR3StateTransitions(t *testing.T) {
+	link := simLink{
+		Rate:      10_000_000,
+		RTT:       40 * time.Millisecond,
+		QueueSize: 400_000, // 1 BDP
+	}
+	s := newBBR3Sim(link)
+	s.Run(20 * time.Second)
+
+	// Startup until the pipe is full, drain the queue, then cycle through ProbeBW.
+	// Drain ends with inflight low enough that ProbeBW_DOWN may go on to CRUISE
+	// on the same ACK, so it's not always seen here.
+	if assert.GreaterOrEqual(t, len(s.Modes), 3) {
+		assert.Equal(t, []bbrMode{bbrModeStartup, bbrModeDrain}, s.Modes[:2])
+		assert.Contains(t, []bbrMode{bbrModeProbeBwDown, bbrModeProbeBwCruise}, s.Modes[2])
+	}
+	visited := make(map[bbrMode]bool)
+	for _, m := range s.Modes {
+		visited[m] = true
+	}
+	assert.True(t, visited[bbrModeProbeBwDown])
+	assert.True(t, visited[bbrModeProbeBwCruise])
+	assert.True(t, visited[bbrModeProbeBwRefill])
+	assert.True(t, visited[bbrModeProbeBwUp])
+	// ProbeRTT every 5 seconds
+	assert.True(t, visited[bbrModeProbeRtt])
+
+	// The model matches the link
+	assert.InEpsilon(t, float64(link.Rate), bytesPerSecond(s.B.maxBw), 0.1)
+	assert.GreaterOrEqual(t, s.B.minRtt, link.RTT)
+	assert.Less(t, s.B.minRtt, link.RTT+5*time.Millisecond)
+}
+
+func TestBBR3StartupHighLoss(t *testing.T) {
+	// A queue far too small for the burst of Startup
+	link := simLink{
+		Rate:      10_000_000,
+		RTT:       40 * time.Millisecond,
+		QueueSize: 20 * simPacketSize,
+	}
+	s := newBBR3Sim(link)
+	for s.B.mode == bbrModeStartup && s.Clock.now.Before(time.Unix(11, 0)) {
+		s.Run(10 * time.Millisecond)
+	}
+	assert.Equal(t, bbrModeDrain, s.B.mode)
+	assert.True(t, s.B.filledPipe)
+	// Leaves Startup with an upper bound on inflight from the loss
+	assert.Less(t, s.B.inflightHi, infByteCount)
+	assert.Less(t, s.B.inflightHi, 2*bdp(s.B.maxBw, link.RTT)+link.QueueSize)
+}
+
+func TestBBR3LossResponse(t *testing.T) {
+	link := simLink{
+		Rate:      10_000_000,
+		RTT:       40 * time.Millisecond,
+		QueueSize: 400_000,
+	}
+	s := newBBR3Sim(link)
+	s.Run(5 * time.Second)
+	assert.True(t, s.B.isInProbeBwState() || s.B.mode == bbrModeProbeRtt)
+	cwnd := s.B.GetCongestionWindow()
+
+	// Loss well over the threshold (2%) that the link doesn't explain
+	s.Link.LossRate = 0.1
+	// The lower bounds are reset on every ProbeBW_REFILL, so check them along the way
+	var bwLoSet bool
+	for i := 0; i < 500; i++ {
+		s.Run(10 * time.Millisecond)
+		bwLoSet = bwLoSet || s.B.bwLo < infBandwidth
+	}
+	// Backs off both bounds of the model
+	assert.Less(t, s.B.inflightHi, infByteCount)
+	assert.True(t, bwLoSet)
+	assert.Less(t, s.B.GetCongestionWindow(), cwnd)
+	assert.Less(t, bytesPerSecond(s.B.bw), float64(link.Rate))
+	assert.GreaterOrEqual(t, s.B.GetCongestionWindow(), s.B.minPipeCwnd())
+
+	// Loss gone, recovers
+	s.Link.LossRate = 0
+	s.Run(20 * time.Second)
+	assert.InEpsilon(t, float64(link.Rate), bytesPerSecond(s.B.maxBw), 0.1)
+	assert.Greater(t, s.B.GetCongestionWindow(), bdp(s.B.maxBw, link.RTT))
+}
@@ -0,0 +1,114 @@
+package bbr3
+
+import (
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+
+	"github.com/apernet/hysteria/core/v2/internal/congestion/bbr"
+)
+
+// maxPacketGap is the largest jump in packet numbers the queue fills with
+// placeholders. Anything larger (which quic-go never does) starts it over.
+const maxPacketGap = 1 << 16
+
+// sentPacket is the state of the connection when a packet was sent,
+// used to compute a rate sample when it's acked.
+type sentPacket struct {
+	valid bool
+
+	sentTime time.Time
+	size     congestion.ByteCount
+	// Total bytes delivered before this packet was sent.
+	delivered congestion.ByteCount
+	// The time at which delivered was last updated.
+	deliveredTime time.Time
+	// The send time of the packet that was most recently acked when this one was sent.
+	firstSentTime time.Time
+	// Total bytes lost before this packet was sent.
+	lost congestion.ByteCount
+	// Bytes in flight right after this packet was sent.
+	txInFlight   congestion.ByteCount
+	isAppLimited bool
+}
+
+// sentPacketQueue holds the sent packets that are neither acked nor lost yet,
+// indexed by packet number.
+type sentPacketQueue struct {
+	first   congestion.PacketNumber
+	packets []sentPacket
+}
+
+func (q *sentPacketQueue) Add(pn congestion.PacketNumber, p sentPacket) {
+	if len(q.packets) == 0 {
+		q.first = pn
+	}
+	next := q.first + congestion.PacketNumber(len(q.packets))
+	if pn < next {
+		// Should not happen, packet numbers are always increasing
+		return
+	}
+	if pn-next > maxPacketGap {
+		q.first = pn
+		q.packets = q.packets[:0]
+	}
+	// Skipped packet numbers
+	for ; next < pn; next++ {
+		q.packets = append(q.packets, sentPacket{})
+	}
+	p.valid = true
+	q.packets = append(q.packets, p)
+}
+
+// Remove removes a packet and returns its state. The second return value
+// is false if the packet is unknown, e.g. it was sent before we took over.
+func (q *sentPacketQueue) Remove(pn congestion.PacketNumber) (sentPacket, bool) {
+	if pn < q.first || pn >= q.first+congestion.PacketNumber(len(q.packets)) {
+		return sentPacket{}, false
+	}
+	i := pn - q.first
+	p := q.packets[i]
+	if !p.valid {
+		return sentPacket{}, false
+	}
+	q.packets[i].valid = false
+	q.trim()
+	return p, true
+}
+
+// RemoveBefore drops all packets with numbers lower than pn.
+func (q *sentPacketQueue) RemoveBefore(pn congestion.PacketNumber) {
+	for len(q.packets) > 0 && q.first < pn {
+		q.packets = q.packets[1:]
+		q.first++
+	}
+	q.trim()
+}
+
+func (q *sentPacketQueue) trim() {
+	for len(q.packets) > 0 && !q.packets[0].valid {
+		q.packets = q.packets[1:]
+		q.first++
+	}
+}
+
+// rateSample is the delivery rate sample of an ACK event, see
+// https://datatracker.ietf.org/doc/html/draft-cheng-iccrg-delivery-rate-estimation
+type rateSample struct {
+	// Delivery rate over the sample interval, 0 if the interval is too short to be valid.
+	deliveryRate bbr.Bandwidth
+	isAppLimited bool
+	// Bytes delivered over the sample interval.
+	delivered congestion.ByteCount
+	// Total bytes delivered when the most recently acked packet was sent.
+	priorDelivered congestion.ByteCount
+	// Bytes in flight right after the most recently acked packet was sent.
+	txInFlight congestion.ByteCount
+	// Bytes lost while the most recently acked packet was in flight.
+	lost congestion.ByteCount
+	// Bytes acked and lost in this event.
+	newlyAcked congestion.ByteCount
+	newlyLost  congestion.ByteCount
+	// Smallest RTT of the packets acked in this event, -1 if none.
+	rtt time.Duration
+}
@@ -0,0 +1,178 @@
+package brutal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+const (
+	adaptiveMinWindow      = 500 * time.Millisecond
+	adaptiveWindowRTTs     = 4         // window length in smoothed RTTs, if longer than adaptiveMinWindow
+	adaptiveLossThreshold  = 0.1       // loss rate over which we consider the link congested
+	adaptiveBackoffFactor  = 0.9       // rate cap after backing off, relative to the delivery rate
+	adaptiveProbeGain      = 1.25      // rate cap growth per window when probing, up to the last delivery rate
+	adaptiveCruiseGain     = 1.05      // rate cap growth per window when probing past the last delivery rate
+	adaptiveMinUtilization = 0.8       // below this fraction of the cap, a window is app-limited
+	adaptiveMinRate        = 64 * 1024 // bytes per second
+)
+
+// AdaptiveState is the state of a BrutalSender in adaptive mode.
+type AdaptiveState int
+
+const (
+	// AdaptiveStateFull indicates that the sender sends at the configured rate, like regular Brutal.
+	AdaptiveStateFull AdaptiveState = iota
+
+	// AdaptiveStateBackoff indicates that the loss rate exceeded the threshold in the last window,
+	// and the send rate has been capped just below the measured delivery rate.
+	AdaptiveStateBackoff
+
+	// AdaptiveStateProbe indicates that the send rate is capped, but the loss rate is back
+	// under the threshold, so the cap is being raised towards the configured rate.
+	AdaptiveStateProbe
+)
+
+func (s AdaptiveState) String() string {
+	switch s {
+	case AdaptiveStateFull:
+		return "full"
+	case AdaptiveStateBackoff:
+		return "backoff"
+	case AdaptiveStateProbe:
+		return "probe"
+	default:
+		return "unknown"
+	}
+}
+
+// AdaptiveStats is a snapshot of the adaptive mode of a BrutalSender,
+// updated at the end of every measurement window.
+type AdaptiveStats struct {
+	State        AdaptiveState
+	Rate         uint64  // Send rate cap in bytes per second, 0 when not capped (full state)
+	DeliveryRate uint64  // Delivery rate measured in the last window, in bytes per second
+	LossRate     float64 // Loss rate measured in the last window
+	Backoffs     uint64  // Number of times the sender has backed off
+}
+
+// adaptiveController measures the delivery rate and loss rate over windows of
+// a few RTTs, and caps the send rate of a BrutalSender when the loss rate shows
+// that it's sending faster than the link can take, which is usually because
+// the configured bandwidth is overstated.
+// With the cap in place it probes back up, and lifts the cap once it reaches
+// the configured rate again.
+// All methods except Stats must be called from the congestion control's goroutine.
+type adaptiveController struct {
+	state   AdaptiveState
+	cap     congestion.ByteCount // 0 = not capped
+	ceiling congestion.ByteCount // delivery rate measured when last backing off
+
+	windowStart time.Time
+	ackedBytes  congestion.ByteCount
+	lostBytes   congestion.ByteCount
+	ackedCount  uint64
+	lostCount   uint64
+
+	statsMutex sync.Mutex
+	stats      AdaptiveStats
+}
+
+// Cap applies the current rate cap to the uncapped send rate.
+func (a *adaptiveController) Cap(rate congestion.ByteCount) congestion.ByteCount {
+	if a.cap > 0 && a.cap < rate {
+		return a.cap
+	}
+	return rate
+}
+
+// OnCongestionEvent accounts the acked and lost packets to the current window,
+// and evaluates the window once it's over. rate is the uncapped send rate.
+// It returns whether the state has changed.
+func (a *adaptiveController) OnCongestionEvent(eventTime time.Time, rtt time.Duration, rate congestion.ByteCount,
+	ackedPackets []congestion.AckedPacketInfo, lostPackets []congestion.LostPacketInfo,
+) bool {
+	if a.windowStart.IsZero() {
+		a.windowStart = eventTime
+	}
+	for _, p := range ackedPackets {
+		a.ackedBytes += p.BytesAcked
+	}
+	for _, p := range lostPackets {
+		a.lostBytes += p.BytesLost
+	}
+	a.ackedCount += uint64(len(ackedPackets))
+	a.lostCount += uint64(len(lostPackets))
+
+	elapsed := eventTime.Sub(a.windowStart)
+	if elapsed < max(adaptiveMinWindow, adaptiveWindowRTTs*rtt) {
+		return false
+	}
+	// Windows without enough samples to judge (most likely app-limited) are simply discarded
+	changed := false
+	if a.ackedCount+a.lostCount >= minSampleCount {
+		changed = a.evaluateWindow(elapsed, rate)
+	}
+	a.windowStart = eventTime
+	a.ackedBytes, a.lostBytes = 0, 0
+	a.ackedCount, a.lostCount = 0, 0
+	return changed
+}
+
+func (a *adaptiveController) evaluateWindow(elapsed time.Duration, rate congestion.ByteCount) bool {
+	lossRate := float64(a.lostCount) / float64(a.ackedCount+a.lostCount)
+	deliveryRate := congestion.ByteCount(float64(a.ackedBytes) / elapsed.Seconds())
+	sendRate := congestion.ByteCount(float64(a.ackedBytes+a.lostBytes) / elapsed.Seconds())
+	oldState := a.state
+	backoff := false
+
+	switch {
+	case lossRate > adaptiveLossThreshold:
+		// Sending faster than the link can take, cap the rate just below what actually gets through,
+		// so that the queue can drain. Never raise the cap when backing off.
+		a.cap = max(congestion.ByteCount(float64(deliveryRate)*adaptiveBackoffFactor), adaptiveMinRate)
+		if capped := a.Cap(rate); capped < a.cap {
+			a.cap = capped
+		}
+		a.ceiling = deliveryRate
+		a.state = AdaptiveStateBackoff
+		backoff = true
+	case a.state == AdaptiveStateFull:
+		// Nothing to do
+	case float64(sendRate) < float64(a.cap)*adaptiveMinUtilization:
+		// App-limited, this window tells us nothing about whether we can go faster
+	default:
+		// Quickly get back to where the link got congested last time, then carefully go beyond
+		if a.cap < a.ceiling {
+			a.cap = min(congestion.ByteCount(float64(a.cap)*adaptiveProbeGain), a.ceiling)
+		} else {
+			a.cap = congestion.ByteCount(float64(a.cap) * adaptiveCruiseGain)
+		}
+		if a.cap >= rate {
+			a.cap = 0
+			a.state = AdaptiveStateFull
+		} else {
+			a.state = AdaptiveStateProbe
+		}
+	}
+
+	a.statsMutex.Lock()
+	a.stats.State = a.state
+	a.stats.Rate = uint64(a.cap)
+	a.stats.DeliveryRate = uint64(deliveryRate)
+	a.stats.LossRate = lossRate
+	if backoff {
+		a.stats.Backoffs++
+	}
+	a.statsMutex.Unlock()
+
+	return a.state != oldState || backoff
+}
+
+// Stats returns a snapshot of the controller. Safe to call from any goroutine.
+func (a *adaptiveController) Stats() AdaptiveStats {
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+	return a.stats
+}
@@ -0,0 +1,171 @@
+package brutal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPacketSize = 1000
+	testRate       = 10_000_000 // configured rate in bytes per second
+	testRTT        = 50 * time.Millisecond
+	testWindow     = time.Second
+)
+
+// adaptivePhase is a number of windows over a link with a fixed capacity.
+type adaptivePhase struct {
+	Capacity congestion.ByteCount // bytes per second, whatever is sent beyond it is lost
+	AppRate  congestion.ByteCount // bytes per second the app has to send, 0 = unlimited
+	Windows  int
+}
+
+// simulateAdaptive runs the controller through the phases, sending at the capped rate
+// in each window, and returns the stats after each window.
+func simulateAdaptive(a *adaptiveController, phases []adaptivePhase) []AdaptiveStats {
+	now := time.Unix(0, 0)
+	a.OnCongestionEvent(now, testRTT, testRate, nil, nil) // Starts the first window
+	var stats []AdaptiveStats
+	for _, p := range phases {
+		for i := 0; i < p.Windows; i++ {
+			send := a.Cap(testRate)
+			if p.AppRate > 0 {
+				send = min(send, p.AppRate)
+			}
+			delivered := min(send, p.Capacity)
+			acked := make([]congestion.AckedPacketInfo, delivered/testPacketSize)
+			for j := range acked {
+				acked[j].BytesAcked = testPacketSize
+			}
+			lost := make([]congestion.LostPacketInfo, (send-delivered)/testPacketSize)
+			for j := range lost {
+				lost[j].BytesLost = testPacketSize
+			}
+			now = now.Add(testWindow)
+			a.OnCongestionEvent(now, testRTT, testRate, acked, lost)
+			stats = append(stats, a.Stats())
+		}
+	}
+	return stats
+}
+
+func TestAdaptiveController(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases []adaptivePhase
+		check  func(t *testing.T, stats []AdaptiveStats)
+	}{
+		{
+			name:   "no loss",
+			phases: []adaptivePhase{{Capacity: 2 * testRate, Windows: 10}},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				for _, s := range stats {
+					assert.Equal(t, AdaptiveStateFull, s.State)
+					assert.Equal(t, uint64(0), s.Rate)
+				}
+				assert.Equal(t, uint64(0), stats[len(stats)-1].Backoffs)
+			},
+		},
+		{
+			name:   "back off",
+			phases: []adaptivePhase{{Capacity: testRate / 2, Windows: 1}},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				s := stats[0]
+				assert.Equal(t, AdaptiveStateBackoff, s.State)
+				assert.Equal(t, uint64(testRate/2), s.DeliveryRate)
+				assert.InDelta(t, 0.5, s.LossRate, 0.001)
+				// Just below the delivery rate
+				assert.Equal(t, uint64(float64(testRate/2)*adaptiveBackoffFactor), s.Rate)
+				assert.Equal(t, uint64(1), s.Backoffs)
+			},
+		},
+		{
+			name:   "stay bounded",
+			phases: []adaptivePhase{{Capacity: testRate / 2, Windows: 50}},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				// Probes past the capacity carefully, and backs off again
+				// every time loss builds up, but never lifts the cap.
+				// The highest cap with loss under the threshold is capacity / (1 - threshold),
+				// which can go up once more before backing off.
+				maxCap := float64(testRate/2) / (1 - adaptiveLossThreshold) * adaptiveCruiseGain
+				for _, s := range stats {
+					assert.NotEqual(t, AdaptiveStateFull, s.State)
+					assert.GreaterOrEqual(t, s.Rate, uint64(float64(testRate/2)*adaptiveBackoffFactor))
+					assert.LessOrEqual(t, s.Rate, uint64(maxCap))
+				}
+				assert.Greater(t, stats[len(stats)-1].Backoffs, uint64(1))
+				// Far less loss than plain Brutal, which would lose half
+				var lossSum float64
+				for _, s := range stats[1:] {
+					lossSum += s.LossRate
+				}
+				assert.Less(t, lossSum/float64(len(stats)-1), adaptiveLossThreshold)
+			},
+		},
+		{
+			name: "recover",
+			phases: []adaptivePhase{
+				{Capacity: testRate / 2, Windows: 5},
+				{Capacity: 2 * testRate, Windows: 30},
+			},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				assert.Equal(t, AdaptiveStateBackoff, stats[0].State)
+				s := stats[len(stats)-1]
+				assert.Equal(t, AdaptiveStateFull, s.State)
+				assert.Equal(t, uint64(0), s.Rate)
+				// Rises steadily once the capacity is back
+				for i := 6; i < len(stats); i++ {
+					if stats[i].State == AdaptiveStateFull {
+						break
+					}
+					assert.Equal(t, AdaptiveStateProbe, stats[i].State)
+					assert.Greater(t, stats[i].Rate, stats[i-1].Rate)
+				}
+			},
+		},
+		{
+			name: "app limited",
+			phases: []adaptivePhase{
+				{Capacity: testRate / 2, Windows: 1},
+				{Capacity: 2 * testRate, AppRate: testRate / 4, Windows: 10},
+			},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				// Sending well below the cap tells nothing about the capacity, so the cap stays
+				for _, s := range stats {
+					assert.Equal(t, AdaptiveStateBackoff, s.State)
+					assert.Equal(t, stats[0].Rate, s.Rate)
+				}
+			},
+		},
+		{
+			name: "too few samples",
+			phases: []adaptivePhase{
+				{Capacity: testRate / 2, Windows: 1},
+				{Capacity: 0, AppRate: (minSampleCount - 1) * testPacketSize, Windows: 10},
+			},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				// Windows with all packets lost, but too few of them to judge
+				for _, s := range stats {
+					assert.Equal(t, stats[0], s)
+				}
+			},
+		},
+		{
+			name:   "min rate",
+			phases: []adaptivePhase{{Capacity: 10_000, Windows: 10}},
+			check: func(t *testing.T, stats []AdaptiveStats) {
+				for _, s := range stats {
+					assert.Equal(t, AdaptiveStateBackoff, s.State)
+					assert.Equal(t, uint64(adaptiveMinRate), s.Rate)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, simulateAdaptive(&adaptiveController{}, tt.phases))
+		})
+	}
+}
@@ -32,6 +32,8 @@ type BrutalSender struct {
 	pktInfoSlots [pktInfoSlotCount]pktInfo
 	ackRate      float64
 
+	adaptive *adaptiveController // nil if not in adaptive mode
+
 	debug                 bool
 	lastAckPrintTimestamp int64
 }
@@ -50,12 +52,38 @@ func NewBrutalSender(bps uint64) *BrutalSender {
 		ackRate:         1,
 		debug:           debug,
 	}
-	bs.pacer = common.NewPacer(func() congestion.ByteCount {
-		return congestion.ByteCount(float64(bs.bps) / bs.ackRate)
-	})
+	bs.pacer = common.NewPacer(bs.sendRate)
+	return bs
+}
+
+// NewAdaptiveBrutalSender creates a BrutalSender in adaptive mode, which treats bps
+// as an upper bound rather than a given, and backs off under sustained loss.
+// See adaptiveController for details.
+func NewAdaptiveBrutalSender(bps uint64) *BrutalSender {
+	bs := NewBrutalSender(bps)
+	bs.adaptive = &adaptiveController{}
 	return bs
 }
 
+// AdaptiveStats returns a snapshot of the adaptive mode state,
+// or false if the sender is not in adaptive mode. Safe to call from any goroutine.
+func (b *BrutalSender) AdaptiveStats() (AdaptiveStats, bool) {
+	if b.adaptive == nil {
+		return AdaptiveStats{}, false
+	}
+	return b.adaptive.Stats(), true
+}
+
+// sendRate returns the rate to send at, in bytes per second.
+// It's the configured rate, compensated for loss, and capped in adaptive mode.
+func (b *BrutalSender) sendRate() congestion.ByteCount {
+	rate := congestion.ByteCount(float64(b.bps) / b.ackRate)
+	if b.adaptive != nil {
+		rate = b.adaptive.Cap(rate)
+	}
+	return rate
+}
+
 func (b *BrutalSender) SetRTTStatsProvider(rttStats congestion.RTTStatsProvider) {
 	b.rttStats = rttStats
 }
@@ -77,7 +105,7 @@ func (b *BrutalSender) GetCongestionWindow() congestion.ByteCount {
 	if rtt <= 0 {
 		return 10240
 	}
-	cwnd := congestion.ByteCount(float64(b.bps) * rtt.Seconds() * congestionWindowMultiplier / b.ackRate)
+	cwnd := congestion.ByteCount(float64(b.sendRate()) * rtt.Seconds() * congestionWindowMultiplier)
 	if cwnd < b.maxDatagramSize {
 		cwnd = b.maxDatagramSize
 	}
@@ -115,6 +143,14 @@ func (b *BrutalSender) OnCongestionEventEx(priorInFlight congestion.ByteCount, e
 		b.pktInfoSlots[slot].LossCount = uint64(len(lostPackets))
 	}
 	b.updateAckRate(currentTimestamp)
+	if b.adaptive != nil {
+		uncapped := congestion.ByteCount(float64(b.bps) / b.ackRate)
+		if b.adaptive.OnCongestionEvent(eventTime, b.rttStats.SmoothedRTT(), uncapped, ackedPackets, lostPackets) && b.debug {
+			stats := b.adaptive.Stats()
+			b.debugPrint("Adaptive: %s (cap=%d, delivery=%d, loss=%.2f, rtt=%d)",
+				stats.State, stats.Rate, stats.DeliveryRate, stats.LossRate, b.rttStats.SmoothedRTT().Milliseconds())
+		}
+	}
 }
 
 func (b *BrutalSender) SetMaxDatagramSize(size congestion.ByteCount) {
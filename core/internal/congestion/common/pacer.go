@@ -40,16 +40,17 @@ func (p *Pacer) SentPacket(sendTime time.Time, size congestion.ByteCount) {
 
 func (p *Pacer) Budget(now time.Time) congestion.ByteCount {
 	if p.lastSentTime.IsZero() {
-		return p.maxBurstSize()
+		return p.MaxBurstSize()
 	}
 	budget := p.budgetAtLastSent + (p.getBandwidth()*congestion.ByteCount(now.Sub(p.lastSentTime).Nanoseconds()))/1e9
 	if budget < 0 { // protect against overflows
 		budget = congestion.ByteCount(1<<62 - 1)
 	}
-	return min(p.maxBurstSize(), budget)
+	return min(p.MaxBurstSize(), budget)
 }
 
-func (p *Pacer) maxBurstSize() congestion.ByteCount {
+// MaxBurstSize returns the largest budget the pacer can accumulate while idle.
+func (p *Pacer) MaxBurstSize() congestion.ByteCount {
 	return max(
 		congestion.ByteCount((maxBurstPacingDelayMultiplier*congestion.MinPacingDelay).Nanoseconds())*p.getBandwidth()/1e9,
 		maxBurstPackets*p.maxDatagramSize,
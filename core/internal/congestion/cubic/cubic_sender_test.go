@@ -0,0 +1,241 @@
+package cubic
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPacketSize = congestion.ByteCount(congestion.InitialPacketSizeIPv4)
+	testRTT        = 50 * time.Millisecond
+)
+
+// fixedRTTStats is a congestion.RTTStatsProvider with a constant RTT.
+type fixedRTTStats struct {
+	RTT time.Duration
+}
+
+func (s *fixedRTTStats) MinRTT() time.Duration                             { return s.RTT }
+func (s *fixedRTTStats) LatestRTT() time.Duration                          { return s.RTT }
+func (s *fixedRTTStats) SmoothedRTT() time.Duration                        { return s.RTT }
+func (s *fixedRTTStats) MeanDeviation() time.Duration                      { return 0 }
+func (s *fixedRTTStats) MaxAckDelay() time.Duration                        { return 0 }
+func (s *fixedRTTStats) PTO(bool) time.Duration                            { return 3 * s.RTT }
+func (s *fixedRTTStats) UpdateRTT(time.Duration, time.Duration, time.Time) {}
+func (s *fixedRTTStats) SetMaxAckDelay(time.Duration)                      {}
+func (s *fixedRTTStats) SetInitialRTT(time.Duration)                       {}
+
+// testSender drives a CubicSender the way quic-go does, with packets acked or lost in order.
+type testSender struct {
+	C   *CubicSender
+	Now time.Time
+
+	nextPN   congestion.PacketNumber
+	ackedPN  congestion.PacketNumber // all packets below are acked or lost
+	inFlight congestion.ByteCount
+}
+
+func newTestSender(reno bool) *testSender {
+	c := NewCubicSender(testPacketSize, reno)
+	c.SetRTTStatsProvider(&fixedRTTStats{RTT: testRTT})
+	return &testSender{C: c, Now: time.Unix(1, 0)}
+}
+
+// Fill sends packets until the congestion window is full.
+func (s *testSender) Fill() {
+	for s.C.CanSend(s.inFlight) {
+		s.C.OnPacketSent(s.Now, s.inFlight, s.nextPN, testPacketSize, true)
+		s.nextPN++
+		s.inFlight += testPacketSize
+	}
+}
+
+// Ack acks the n oldest packets in flight, one ACK each,
+// filling the window again after each of them.
+func (s *testSender) Ack(n int) {
+	for i := 0; i < n; i++ {
+		s.C.OnPacketAcked(s.ackedPN, testPacketSize, s.inFlight, s.Now)
+		s.ackedPN++
+		s.inFlight -= testPacketSize
+		s.Fill()
+	}
+}
+
+// AckUntil acks all packets in flight up to (but not including) pn.
+func (s *testSender) AckUntil(pn congestion.PacketNumber) {
+	s.Ack(int(pn - s.ackedPN))
+}
+
+// Lose declares the n oldest packets in flight lost.
+func (s *testSender) Lose(n int) {
+	for i := 0; i < n; i++ {
+		s.C.OnCongestionEvent(s.ackedPN, testPacketSize, s.inFlight)
+		s.ackedPN++
+		s.inFlight -= testPacketSize
+	}
+}
+
+// Round fills the window and acks all of it an RTT later.
+func (s *testSender) Round() {
+	s.Fill()
+	s.Now = s.Now.Add(testRTT)
+	s.AckUntil(s.nextPN)
+}
+
+func TestCubicSenderSlowStart(t *testing.T) {
+	for _, reno := range []bool{false, true} {
+		s := newTestSender(reno)
+		cwnd := s.C.GetCongestionWindow()
+		assert.Equal(t, initialCongestionWindow*testPacketSize, cwnd)
+		assert.True(t, s.C.InSlowStart())
+
+		// One packet more for each ACK, so it doubles every round
+		for i := 0; i < 5; i++ {
+			s.Round()
+			assert.Equal(t, 2*cwnd, s.C.GetCongestionWindow())
+			cwnd = s.C.GetCongestionWindow()
+		}
+		assert.True(t, s.C.InSlowStart())
+	}
+}
+
+func TestCubicSenderAppLimited(t *testing.T) {
+	for _, reno := range []bool{false, true} {
+		s := newTestSender(reno)
+		cwnd := s.C.GetCongestionWindow()
+		// Using less than half the window doesn't grow it
+		for i := 0; i < 5; i++ {
+			s.C.OnPacketSent(s.Now, s.inFlight, s.nextPN, testPacketSize, true)
+			s.nextPN++
+			s.inFlight += testPacketSize
+			s.Now = s.Now.Add(testRTT)
+			s.C.OnPacketAcked(s.ackedPN, testPacketSize, s.inFlight, s.Now)
+			s.ackedPN++
+			s.inFlight -= testPacketSize
+		}
+		assert.Equal(t, cwnd, s.C.GetCongestionWindow())
+	}
+}
+
+func TestCubicSenderLoss(t *testing.T) {
+	tests := []struct {
+		name string
+		reno bool
+		beta float64
+	}{
+		{name: "cubic", reno: false, beta: float64(beta)},
+		{name: "reno", reno: true, beta: renoBeta},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSender(tt.reno)
+			s.Round()
+			s.Fill()
+			cwnd := s.C.GetCongestionWindow()
+			sent := s.nextPN
+
+			// Multiplicative decrease, and leaves slow start
+			s.Now = s.Now.Add(testRTT)
+			s.Lose(1)
+			expected := float64(cwnd) * tt.beta
+			assert.InDelta(t, expected, float64(s.C.GetCongestionWindow()), 1)
+			assert.False(t, s.C.InSlowStart())
+			assert.True(t, s.C.InRecovery())
+
+			// Further losses of packets sent before the first are the same loss event
+			s.Lose(3)
+			assert.InDelta(t, expected, float64(s.C.GetCongestionWindow()), 1)
+			// and no growth from ACKs during recovery
+			s.AckUntil(sent)
+			assert.InDelta(t, expected, float64(s.C.GetCongestionWindow()), 1)
+			assert.True(t, s.C.InRecovery())
+
+			// Recovery ends with the first ACK of a packet sent after the loss,
+			// and losing one of those is a new loss event
+			s.Now = s.Now.Add(testRTT)
+			s.Ack(1)
+			assert.False(t, s.C.InRecovery())
+			cwnd = s.C.GetCongestionWindow()
+			s.Lose(1)
+			assert.Less(t, s.C.GetCongestionWindow(), cwnd)
+		})
+	}
+}
+
+func TestCubicSenderMinWindow(t *testing.T) {
+	s := newTestSender(false)
+	for i := 0; i < 20; i++ {
+		s.Fill()
+		sent := s.nextPN
+		s.Lose(1)
+		s.AckUntil(sent)
+	}
+	assert.Equal(t, minCongestionWindowPackets*testPacketSize, s.C.GetCongestionWindow())
+}
+
+func TestCubicSenderRenoCongestionAvoidance(t *testing.T) {
+	s := newTestSender(true)
+	s.Fill()
+	sent := s.nextPN
+	s.Lose(1)
+	s.AckUntil(sent)
+
+	// One packet more for each window of ACKs
+	for i := 0; i < 10; i++ {
+		cwnd := s.C.GetCongestionWindow()
+		s.Round()
+		assert.Equal(t, cwnd+testPacketSize, s.C.GetCongestionWindow())
+	}
+}
+
+func TestCubicSenderCubicCongestionAvoidance(t *testing.T) {
+	s := newTestSender(false)
+	for i := 0; i < 3; i++ {
+		s.Round()
+	}
+	s.Fill()
+	sent := s.nextPN
+	lastMax := s.C.GetCongestionWindow()
+	s.Lose(1)
+	s.AckUntil(sent)
+	assert.InDelta(t, float64(lastMax)*float64(beta), float64(s.C.GetCongestionWindow()), 1)
+
+	// Back to the window before the loss in about K = cbrt(W_max * (1 - beta) / C) seconds,
+	// with C = 0.4 packets/s^3, quickly at first and slowly on approach (concave),
+	// then quicker again past it (convex).
+	var growth []congestion.ByteCount
+	var reachedAt time.Duration
+	start := s.Now
+	for i := 0; i < 200; i++ {
+		cwnd := s.C.GetCongestionWindow()
+		s.Round()
+		growth = append(growth, s.C.GetCongestionWindow()-cwnd)
+		if reachedAt == 0 && s.C.GetCongestionWindow() >= lastMax {
+			reachedAt = s.Now.Sub(start)
+		}
+	}
+	k := time.Duration(math.Cbrt(float64(lastMax/testPacketSize)*(1-float64(beta))/0.4) * float64(time.Second))
+	assert.InDelta(t, k.Seconds(), reachedAt.Seconds(), 0.5)
+	for _, g := range growth {
+		assert.GreaterOrEqual(t, g, congestion.ByteCount(0))
+	}
+	// Grows the least around the old max
+	reachedRound := int(reachedAt / testRTT)
+	assert.Less(t, growth[reachedRound], growth[0])
+	assert.Less(t, growth[reachedRound], growth[len(growth)-1])
+}
+
+func TestCubicSenderRetransmissionTimeout(t *testing.T) {
+	s := newTestSender(false)
+	s.Round()
+	cwnd := s.C.GetCongestionWindow()
+	s.C.OnRetransmissionTimeout(true)
+	assert.Equal(t, minCongestionWindowPackets*testPacketSize, s.C.GetCongestionWindow())
+	// Slow start again, up to half the window before the timeout
+	assert.True(t, s.C.InSlowStart())
+	assert.Equal(t, cwnd/2, s.C.slowStartThreshold)
+}
@@ -2,17 +2,111 @@ package congestion
 
 import (
 	"github.com/apernet/hysteria/core/v2/internal/congestion/bbr"
+	"github.com/apernet/hysteria/core/v2/internal/congestion/bbr3"
 	"github.com/apernet/hysteria/core/v2/internal/congestion/brutal"
+	"github.com/apernet/hysteria/core/v2/internal/congestion/cubic"
 	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/congestion"
 )
 
-func UseBBR(conn quic.Connection) {
-	conn.SetCongestionControl(bbr.NewBbrSender(
+// Congestion control types that can be chosen explicitly, shared by the client & server configs.
+// An empty type means the default: Brutal if the bandwidth is known, BBR otherwise.
+const (
+	// TypeBBR models the bandwidth and RTT of the path.
+	TypeBBR = "bbr"
+	// TypeBBR3 is BBR version 3, which also reacts to loss, and is fairer to other flows.
+	TypeBBR3 = "bbr3"
+	// TypeBrutal sends at a fixed rate, regardless of loss. Falls back to BBR if the rate is unknown.
+	TypeBrutal = "brutal"
+	// TypeAdaptiveBrutal is Brutal that treats the rate as an upper bound, and backs off
+	// under sustained loss in case it's more than the path can take.
+	TypeAdaptiveBrutal = "adaptive-brutal"
+	// TypeCubic is loss-based like TCP's default.
+	TypeCubic = "cubic"
+	// TypeReno is loss-based like classic TCP.
+	TypeReno = "reno"
+)
+
+// IsValidType returns whether t is one of the types above, or empty.
+func IsValidType(t string) bool {
+	switch t {
+	case "", TypeBBR, TypeBBR3, TypeBrutal, TypeAdaptiveBrutal, TypeCubic, TypeReno:
+		return true
+	default:
+		return false
+	}
+}
+
+// Use sets the congestion control of conn to the given type. tx is the
+// bandwidth in bytes per second that Brutal sends at, 0 if unknown.
+// Brutal (adaptive or not) falls back to BBR when tx is unknown, so does an empty type.
+// It returns the type actually in use, and the congestion control itself.
+func Use(conn quic.Connection, t string, tx uint64) (string, congestion.CongestionControl) {
+	switch t {
+	case TypeBBR:
+		return t, UseBBR(conn)
+	case TypeBBR3:
+		return t, UseBBR3(conn)
+	case TypeCubic:
+		return t, UseCubic(conn)
+	case TypeReno:
+		return t, UseReno(conn)
+	}
+	if tx == 0 {
+		return TypeBBR, UseBBR(conn)
+	}
+	if t == TypeAdaptiveBrutal {
+		return t, UseAdaptiveBrutal(conn, tx)
+	}
+	return TypeBrutal, UseBrutal(conn, tx)
+}
+
+// AdaptiveStats returns the state of cc if it's an adaptive Brutal set by Use.
+// Safe to call from any goroutine.
+func AdaptiveStats(cc congestion.CongestionControl) (brutal.AdaptiveStats, bool) {
+	if b, ok := cc.(*brutal.BrutalSender); ok {
+		return b.AdaptiveStats()
+	}
+	return brutal.AdaptiveStats{}, false
+}
+
+func UseBBR(conn quic.Connection) congestion.CongestionControl {
+	return use(conn, bbr.NewBbrSender(
+		bbr.DefaultClock{},
+		bbr.GetInitialPacketSize(conn.RemoteAddr()),
+	))
+}
+
+func UseBBR3(conn quic.Connection) congestion.CongestionControl {
+	return use(conn, bbr3.NewBbr3Sender(
 		bbr.DefaultClock{},
 		bbr.GetInitialPacketSize(conn.RemoteAddr()),
 	))
 }
 
-func UseBrutal(conn quic.Connection, tx uint64) {
-	conn.SetCongestionControl(brutal.NewBrutalSender(tx))
+func UseBrutal(conn quic.Connection, tx uint64) congestion.CongestionControl {
+	return use(conn, brutal.NewBrutalSender(tx))
+}
+
+func UseAdaptiveBrutal(conn quic.Connection, tx uint64) congestion.CongestionControl {
+	return use(conn, brutal.NewAdaptiveBrutalSender(tx))
+}
+
+func UseCubic(conn quic.Connection) congestion.CongestionControl {
+	return use(conn, cubic.NewCubicSender(
+		bbr.GetInitialPacketSize(conn.RemoteAddr()),
+		false,
+	))
+}
+
+func UseReno(conn quic.Connection) congestion.CongestionControl {
+	return use(conn, cubic.NewCubicSender(
+		bbr.GetInitialPacketSize(conn.RemoteAddr()),
+		true,
+	))
+}
+
+func use(conn quic.Connection, cc congestion.CongestionControl) congestion.CongestionControl {
+	conn.SetCongestionControl(cc)
+	return cc
 }
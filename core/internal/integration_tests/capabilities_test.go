@@ -0,0 +1,99 @@
+package integration_tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// capsAuthenticator is an AuthenticatorEx that records the capabilities
+// of the client, and sends back some hints.
+type capsAuthenticator struct {
+	server.Authenticator
+	Caps chan server.Capabilities
+}
+
+func (a *capsAuthenticator) AuthenticateEx(addr net.Addr, auth string, tx uint64, caps server.Capabilities) (bool, string, map[string]string) {
+	a.Caps <- caps
+	return true, "nobody", map[string]string{"motd": "welcome aboard"}
+}
+
+// TestClientServerCapabilities tests that the client & server exchange their
+// capabilities, and that the server's are exposed in the HandshakeInfo.
+func TestClientServerCapabilities(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := &capsAuthenticator{Caps: make(chan server.Capabilities, 1)}
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:         serverTLSConfig(),
+		Conn:              udpConn,
+		UDPMaxSessions:    64,
+		Authenticator:     auth,
+		ReverseAuthorizer: mocks.NewMockReverseAuthorizer(t),
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, info, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	clientCaps := <-auth.Caps
+	assert.Greater(t, clientCaps.Version, 0)
+	assert.True(t, clientCaps.Has(server.ExtensionUDPClose))
+	assert.True(t, clientCaps.Has(server.ExtensionReverse))
+	assert.True(t, clientCaps.Has(server.ExtensionUDPStream))
+
+	assert.Greater(t, info.Capabilities.Version, 0)
+	assert.True(t, info.Capabilities.Has(client.ExtensionUDPClose))
+	assert.True(t, info.Capabilities.Has(client.ExtensionReverse))
+	assert.True(t, info.Capabilities.Has(client.ExtensionUDPStream))
+	assert.Equal(t, 64, info.UDPMaxSessions)
+	assert.Equal(t, map[string]string{"motd": "welcome aboard"}, info.Hints)
+}
+
+// TestClientServerCapabilitiesPlain tests that a plain Authenticator still works,
+// and that the server only advertises the extensions it has enabled.
+func TestClientServerCapabilitiesPlain(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		DisableUDP:    true,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, info, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.Greater(t, info.Capabilities.Version, 0)
+	assert.False(t, info.Capabilities.Has(client.ExtensionUDPClose))
+	assert.False(t, info.Capabilities.Has(client.ExtensionReverse))
+	assert.False(t, info.Capabilities.Has(client.ExtensionUDPStream))
+	assert.Zero(t, info.UDPMaxSessions)
+	assert.Nil(t, info.Hints)
+}
@@ -1,7 +1,10 @@
 package integration_tests
 
 import (
+	"context"
 	"io"
+	"net"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -177,6 +180,110 @@ func TestClientServerUDPIdleTimeout(t *testing.T) {
 	time.Sleep(3 * time.Second)
 }
 
+// TestClientServerUDPCloseAndLimit tests whether closing a UDP session on the client side
+// closes it on the server, and whether the server rejects sessions over its limit.
+func TestClientServerUDPCloseAndLimit(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	serverOb := mocks.NewMockOutbound(t)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	eventLogger := mocks.NewMockEventLogger(t)
+	eventLogger.EXPECT().Connect(mock.Anything, "nobody", mock.Anything).Once()
+	eventLogger.EXPECT().Disconnect(mock.Anything, "nobody", mock.Anything).Maybe()
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:      serverTLSConfig(),
+		Conn:           udpConn,
+		Outbound:       serverOb,
+		UDPMaxSessions: 1,
+		Authenticator:  auth,
+		EventLogger:    eventLogger,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	addr := "dns.resolver.lol:53"
+
+	newSobConn := func() (*mocks.MockUDPConn, chan struct{}) {
+		sobConn := mocks.NewMockUDPConn(t)
+		closeCh := make(chan struct{})
+		sobConn.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(bs []byte) (int, string, error) {
+			<-closeCh
+			return 0, "", io.EOF
+		})
+		sobConn.EXPECT().WriteTo([]byte("query"), addr).Return(5, nil).Once()
+		return sobConn, closeCh
+	}
+
+	// First session
+	sobConn1, sobConn1CloseCh := newSobConn()
+	serverOb.EXPECT().UDP(addr).Return(sobConn1, nil).Once()
+	eventLogger.EXPECT().UDPRequest(mock.Anything, mock.Anything, uint32(1), addr).Once()
+	cu1, err := c.UDP()
+	assert.NoError(t, err)
+	assert.NoError(t, cu1.Send([]byte("query"), addr))
+	time.Sleep(500 * time.Millisecond)
+
+	// Second session, over the limit
+	eventLogger.EXPECT().UDPError(mock.Anything, mock.Anything, uint32(2), mock.Anything).Once()
+	cu2, err := c.UDP()
+	assert.NoError(t, err)
+	assert.NoError(t, cu2.Send([]byte("query"), addr))
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := cu2.Receive()
+		errChan <- err
+	}()
+	select {
+	case err := <-errChan:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("rejected session not closed")
+	}
+
+	// Close the first session, the server should close it right away
+	closedCh := make(chan struct{})
+	sobConn1.EXPECT().Close().RunAndReturn(func() error {
+		close(sobConn1CloseCh)
+		return nil
+	}).Once()
+	eventLogger.EXPECT().UDPError(mock.Anything, mock.Anything, uint32(1), nil).Call.Run(func(mock.Arguments) {
+		close(closedCh)
+	}).Once()
+	assert.NoError(t, cu1.Close())
+	select {
+	case <-closedCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not closed on the server")
+	}
+
+	// Now there's room for a new session
+	sobConn3, sobConn3CloseCh := newSobConn()
+	serverOb.EXPECT().UDP(addr).Return(sobConn3, nil).Once()
+	eventLogger.EXPECT().UDPRequest(mock.Anything, mock.Anything, uint32(3), addr).Once()
+	cu3, err := c.UDP()
+	assert.NoError(t, err)
+	assert.NoError(t, cu3.Send([]byte("query"), addr))
+	time.Sleep(500 * time.Millisecond)
+
+	// Cleanup when the server closes
+	sobConn3.EXPECT().Close().RunAndReturn(func() error {
+		close(sobConn3CloseCh)
+		return nil
+	}).Maybe()
+	eventLogger.EXPECT().UDPError(mock.Anything, mock.Anything, uint32(3), mock.Anything).Maybe()
+}
+
 // TestClientServerClientShutdown tests whether the server can handle the client's shutdown correctly.
 func TestClientServerClientShutdown(t *testing.T) {
 	// Create server
@@ -250,3 +357,152 @@ func TestClientServerServerShutdown(t *testing.T) {
 
 	assert.NoError(t, c.Close())
 }
+
+// TestClientServerGracefulShutdown tests that the server drains existing TCP requests on Shutdown,
+// rejects new ones, and that the client reconnects right away after the server closes the connection.
+func TestClientServerGracefulShutdown(t *testing.T) {
+	// Create server 1
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create server 2, which the client connects to after server 1 shuts down
+	udpAddr2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 14515}
+	udpConn2, err := net.ListenUDP("udp", udpAddr2)
+	assert.NoError(t, err)
+	s2, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn2,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s2.Close()
+	go s2.Serve()
+
+	// Create TCP echo server
+	echoAddr := "127.0.0.1:22333"
+	echoListener, err := net.Listen("tcp", echoAddr)
+	assert.NoError(t, err)
+	echoServer := &tcpEchoServer{Listener: echoListener}
+	defer echoServer.Close()
+	go echoServer.Serve()
+
+	// Create client
+	addrs := []net.Addr{udpAddr, udpAddr2}
+	connectedCh := make(chan int, 2)
+	c, err := client.NewReconnectableClient(func() (*client.Config, error) {
+		addr := addrs[0]
+		addrs = addrs[1:]
+		return &client.Config{
+			ServerAddr: addr,
+			TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+		}, nil
+	}, func(_ client.Client, _ *client.HandshakeInfo, count int) {
+		connectedCh <- count
+	}, false)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 1, <-connectedCh)
+
+	conn, err := c.TCP(echoAddr)
+	assert.NoError(t, err)
+
+	// Start shutting down server 1
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	// New requests are rejected
+	_, err = c.TCP(echoAddr)
+	_, ok := err.(errors.DialError)
+	assert.True(t, ok)
+
+	// The existing request still works
+	sData := []byte("still here")
+	_, err = conn.Write(sData)
+	assert.NoError(t, err)
+	rData := make([]byte, len(sData))
+	_, err = io.ReadFull(conn, rData)
+	assert.NoError(t, err)
+	assert.Equal(t, sData, rData)
+	select {
+	case <-shutdownCh:
+		t.Fatal("shutdown returned before the request finished")
+	default:
+	}
+
+	// Shutdown completes once the request is done,
+	// and the client connects to server 2 without waiting for the next request
+	_ = conn.Close()
+	select {
+	case err := <-shutdownCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown timed out")
+	}
+	select {
+	case count := <-connectedCh:
+		assert.Equal(t, 2, count)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't reconnect")
+	}
+}
+
+// TestClientServerGracefulShutdownTimeout tests that Shutdown closes the remaining connections
+// when the context is done before the requests finish.
+func TestClientServerGracefulShutdownTimeout(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create TCP echo server
+	echoAddr := "127.0.0.1:22333"
+	echoListener, err := net.Listen("tcp", echoAddr)
+	assert.NoError(t, err)
+	echoServer := &tcpEchoServer{Listener: echoListener}
+	defer echoServer.Close()
+	go echoServer.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	conn, err := c.TCP(echoAddr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// The connection has been closed by the server
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.False(t, os.IsTimeout(err))
+}
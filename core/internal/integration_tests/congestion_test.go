@@ -0,0 +1,213 @@
+package integration_tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// ccAuthenticator is a CongestionControlAuthenticator that picks a
+// congestion control for the user, and records the ID it was asked about.
+type ccAuthenticator struct {
+	Type string
+	IDs  chan string
+}
+
+func (a *ccAuthenticator) Authenticate(addr net.Addr, auth string, tx uint64) (bool, string) {
+	return true, auth
+}
+
+func (a *ccAuthenticator) CongestionControl(id string) string {
+	a.IDs <- id
+	return a.Type
+}
+
+// TestClientServerCongestionControl tests TCP forwarding with each congestion
+// control on both sides, with the server's chosen per user by the authenticator.
+func TestClientServerCongestionControl(t *testing.T) {
+	types := []string{
+		"",
+		client.CongestionControlBBR,
+		client.CongestionControlBBR3,
+		client.CongestionControlBrutal,
+		client.CongestionControlAdaptiveBrutal,
+		client.CongestionControlCubic,
+		client.CongestionControlReno,
+	}
+	for _, ccType := range types {
+		t.Run("type="+ccType, func(t *testing.T) {
+			// Create server
+			udpConn, udpAddr, err := serverConn()
+			assert.NoError(t, err)
+			auth := &ccAuthenticator{Type: ccType, IDs: make(chan string, 1)}
+			s, err := server.NewServer(&server.Config{
+				TLSConfig:         serverTLSConfig(),
+				Conn:              udpConn,
+				CongestionControl: server.CongestionControlReno, // Overridden by the authenticator
+				Authenticator:     auth,
+			})
+			assert.NoError(t, err)
+			defer s.Close()
+			go s.Serve()
+
+			// Create TCP echo server
+			echoAddr := "127.0.0.1:22333"
+			echoListener, err := net.Listen("tcp", echoAddr)
+			assert.NoError(t, err)
+			echoServer := &tcpEchoServer{Listener: echoListener}
+			defer echoServer.Close()
+			go echoServer.Serve()
+
+			// Create client
+			c, _, err := client.NewClient(&client.Config{
+				ServerAddr: udpAddr,
+				Auth:       "user" + ccType,
+				TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+				BandwidthConfig: client.BandwidthConfig{
+					MaxTx: 100_000_000,
+					MaxRx: 100_000_000,
+				},
+				CongestionControl: ccType,
+			})
+			assert.NoError(t, err)
+			defer c.Close()
+			assert.Equal(t, "user"+ccType, <-auth.IDs)
+
+			conn, err := c.TCP(echoAddr)
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			// Send and receive enough data for the congestion control to kick in
+			sData := make([]byte, 4*1024*1024)
+			_, _ = rand.Read(sData)
+			go func() {
+				_, _ = conn.Write(sData)
+			}()
+			rData := make([]byte, len(sData))
+			_, err = io.ReadFull(conn, rData)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(sData, rData))
+		})
+	}
+}
+
+// congestionTracer is a TrafficLogger that only keeps track of the congestion control of connections.
+type congestionTracer struct {
+	Traced   chan server.CongestionStatsProvider
+	Untraced chan uint32
+}
+
+func (t *congestionTracer) LogTraffic(id string, tx, rx uint64) bool     { return true }
+func (t *congestionTracer) LogOnlineState(id string, online bool)        {}
+func (t *congestionTracer) TraceStream(quic.Stream, *server.StreamStats) {}
+func (t *congestionTracer) UntraceStream(quic.Stream)                    {}
+func (t *congestionTracer) UntraceCongestion(connID uint32)              { t.Untraced <- connID }
+func (t *congestionTracer) TraceCongestion(id string, connID uint32, p server.CongestionStatsProvider) {
+	t.Traced <- p
+}
+
+// TestCongestionTracer tests that the server reports the congestion control of each
+// connection to a CongestionTracer, with the fallbacks resolved.
+func TestCongestionTracer(t *testing.T) {
+	tests := []struct {
+		name   string
+		ccType string
+		rx     uint64
+		want   server.CongestionStats
+	}{
+		{
+			name:   "adaptive brutal",
+			ccType: server.CongestionControlAdaptiveBrutal,
+			rx:     100_000_000,
+			want: server.CongestionStats{
+				Type:     server.CongestionControlAdaptiveBrutal,
+				Rate:     100_000_000,
+				Adaptive: &server.AdaptiveBrutalStats{State: "full"},
+			},
+		},
+		{
+			name:   "brutal fallback",
+			ccType: server.CongestionControlAdaptiveBrutal,
+			want:   server.CongestionStats{Type: server.CongestionControlBBR},
+		},
+		{
+			name: "default",
+			rx:   100_000_000,
+			want: server.CongestionStats{Type: server.CongestionControlBrutal, Rate: 100_000_000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			udpConn, udpAddr, err := serverConn()
+			assert.NoError(t, err)
+			tracer := &congestionTracer{
+				Traced:   make(chan server.CongestionStatsProvider, 1),
+				Untraced: make(chan uint32, 1),
+			}
+			s, err := server.NewServer(&server.Config{
+				TLSConfig:         serverTLSConfig(),
+				Conn:              udpConn,
+				CongestionControl: tt.ccType,
+				Authenticator:     &ccAuthenticator{IDs: make(chan string, 1)},
+				TrafficLogger:     tracer,
+			})
+			assert.NoError(t, err)
+			defer s.Close()
+			go s.Serve()
+
+			c, _, err := client.NewClient(&client.Config{
+				ServerAddr:      udpAddr,
+				Auth:            "nobody",
+				TLSConfig:       client.TLSConfig{InsecureSkipVerify: true},
+				BandwidthConfig: client.BandwidthConfig{MaxRx: tt.rx},
+			})
+			assert.NoError(t, err)
+
+			select {
+			case p := <-tracer.Traced:
+				assert.Equal(t, tt.want, p.CongestionStats())
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection not traced")
+			}
+
+			_ = c.Close()
+			select {
+			case <-tracer.Untraced:
+			case <-time.After(2 * time.Second):
+				t.Fatal("connection not untraced")
+			}
+		})
+	}
+}
+
+// TestCongestionControlInvalidType tests that an invalid congestion
+// control type is rejected by both the client and the server.
+func TestCongestionControlInvalidType(t *testing.T) {
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	defer udpConn.Close()
+	_, err = server.NewServer(&server.Config{
+		TLSConfig:         serverTLSConfig(),
+		Conn:              udpConn,
+		CongestionControl: "vegas",
+		Authenticator:     &ccAuthenticator{},
+	})
+	assert.Equal(t, errors.ConfigError{Field: "CongestionControl", Reason: "invalid type"}, err)
+
+	_, _, err = client.NewClient(&client.Config{
+		ServerAddr:        udpAddr,
+		TLSConfig:         client.TLSConfig{InsecureSkipVerify: true},
+		CongestionControl: "vegas",
+	})
+	assert.Equal(t, errors.ConfigError{Field: "CongestionControl", Reason: "invalid type"}, err)
+}
@@ -0,0 +1,126 @@
+package integration_tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// TestClientServerDialContext tests that a TCP request is cancelled by its context,
+// even when the server never responds to it.
+func TestClientServerDialContext(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	serverOb := mocks.NewMockOutbound(t)
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	serverOb.EXPECT().TCP("blackhole.com:80").RunAndReturn(func(string) (net.Conn, error) {
+		<-blockCh
+		return nil, io.EOF
+	})
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      serverOb,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, err := client.NewReconnectableClient(func() (*client.Config, error) {
+		return &client.Config{
+			ServerAddr: udpAddr,
+			TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+		}, nil
+	}, nil, false)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Cancelled by timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	conn, err := client.TCPContext(ctx, c, "blackhole.com:80")
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Already cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	conn, err = (&client.Dialer{Client: c}).DialContext(ctx, "tcp", "blackhole.com:80")
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestClientServerDialer tests TCP & UDP through client.Dialer, using echo servers.
+func TestClientServerDialer(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create echo servers
+	echoAddr := "127.0.0.1:22333"
+	echoListener, err := net.Listen("tcp", echoAddr)
+	assert.NoError(t, err)
+	tcpEcho := &tcpEchoServer{Listener: echoListener}
+	defer tcpEcho.Close()
+	go tcpEcho.Serve()
+	echoConn, err := net.ListenPacket("udp", echoAddr)
+	assert.NoError(t, err)
+	udpEcho := &udpEchoServer{Conn: echoConn}
+	defer udpEcho.Close()
+	go udpEcho.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	d := &client.Dialer{Client: c}
+
+	for _, network := range []string{"tcp", "udp"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		conn, err := d.DialContext(ctx, network, echoAddr)
+		cancel()
+		assert.NoError(t, err)
+		sData := []byte("hello " + network)
+		_, err = conn.Write(sData)
+		assert.NoError(t, err)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		rData := make([]byte, len(sData))
+		_, err = io.ReadFull(conn, rData)
+		assert.NoError(t, err)
+		assert.Equal(t, sData, rData)
+		_ = conn.Close()
+	}
+
+	_, err = d.Dial("unix", "/tmp/nope")
+	assert.Error(t, err)
+}
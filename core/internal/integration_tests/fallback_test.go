@@ -0,0 +1,121 @@
+package integration_tests
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// blockedConnFactory creates conns that fail all writes, as if UDP were blocked.
+type blockedConnFactory struct{}
+
+func (f *blockedConnFactory) New(addr net.Addr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &blockedConn{conn}, nil
+}
+
+type blockedConn struct {
+	net.PacketConn
+}
+
+func (c *blockedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return 0, errors.New("udp blocked")
+}
+
+// redirectConnFactory creates conns that send everything to Addr,
+// regardless of the server address, like a fallback transport would.
+type redirectConnFactory struct {
+	Addr net.Addr
+}
+
+func (f *redirectConnFactory) New(addr net.Addr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &redirectConn{PacketConn: conn, Addr: f.Addr}, nil
+}
+
+type redirectConn struct {
+	net.PacketConn
+	Addr net.Addr
+}
+
+func (c *redirectConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(p, c.Addr)
+}
+
+// TestClientServerFallback tests that the client connects with FallbackConnFactory
+// when it can't with ConnFactory, and that the server accepts clients from ExtraConns.
+func TestClientServerFallback(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	extraConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		ExtraConns:    []net.PacketConn{extraConn},
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create TCP echo server
+	echoAddr := "127.0.0.1:22335"
+	echoListener, err := net.Listen("tcp", echoAddr)
+	assert.NoError(t, err)
+	echoServer := &tcpEchoServer{Listener: echoListener}
+	defer echoServer.Close()
+	go echoServer.Serve()
+
+	// Without fallback
+	_, _, err = client.NewClient(&client.Config{
+		ConnFactory: &blockedConnFactory{},
+		ServerAddr:  udpAddr,
+		TLSConfig:   client.TLSConfig{InsecureSkipVerify: true},
+	})
+	var connErr coreErrs.ConnectError
+	assert.ErrorAs(t, err, &connErr)
+
+	// With fallback
+	c, info, err := client.NewClient(&client.Config{
+		ConnFactory:         &blockedConnFactory{},
+		FallbackConnFactory: &redirectConnFactory{Addr: extraConn.LocalAddr()},
+		ServerAddr:          udpAddr,
+		TLSConfig:           client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.True(t, info.Fallback)
+
+	// Dial TCP
+	conn, err := c.TCP(echoAddr)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Send and receive data
+	sData := []byte("hello fallback")
+	_, err = conn.Write(sData)
+	assert.NoError(t, err)
+	rData := make([]byte, len(sData))
+	_, err = io.ReadFull(conn, rData)
+	assert.NoError(t, err)
+	assert.Equal(t, sData, rData)
+}
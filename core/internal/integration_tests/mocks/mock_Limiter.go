@@ -0,0 +1,194 @@
+// Code generated by mockery v2.43.0. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// MockLimiter is an autogenerated mock type for the Limiter type
+type MockLimiter struct {
+	mock.Mock
+}
+
+type MockLimiter_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockLimiter) EXPECT() *MockLimiter_Expecter {
+	return &MockLimiter_Expecter{mock: &_m.Mock}
+}
+
+// AllowTraffic provides a mock function with given fields: id, tx, rx
+func (_m *MockLimiter) AllowTraffic(id string, tx uint64, rx uint64) (bool, bool) {
+	ret := _m.Called(id, tx, rx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AllowTraffic")
+	}
+
+	var r0 bool
+	var r1 bool
+	if rf, ok := ret.Get(0).(func(string, uint64, uint64) (bool, bool)); ok {
+		return rf(id, tx, rx)
+	}
+	if rf, ok := ret.Get(0).(func(string, uint64, uint64) bool); ok {
+		r0 = rf(id, tx, rx)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, uint64, uint64) bool); ok {
+		r1 = rf(id, tx, rx)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
+}
+
+// MockLimiter_AllowTraffic_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'AllowTraffic'
+type MockLimiter_AllowTraffic_Call struct {
+	*mock.Call
+}
+
+// AllowTraffic is a helper method to define mock.On call
+//   - id string
+//   - tx uint64
+//   - rx uint64
+func (_e *MockLimiter_Expecter) AllowTraffic(id interface{}, tx interface{}, rx interface{}) *MockLimiter_AllowTraffic_Call {
+	return &MockLimiter_AllowTraffic_Call{Call: _e.mock.On("AllowTraffic", id, tx, rx)}
+}
+
+func (_c *MockLimiter_AllowTraffic_Call) Run(run func(id string, tx uint64, rx uint64)) *MockLimiter_AllowTraffic_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(uint64), args[2].(uint64))
+	})
+	return _c
+}
+
+func (_c *MockLimiter_AllowTraffic_Call) Return(allow bool, ok bool) *MockLimiter_AllowTraffic_Call {
+	_c.Call.Return(allow, ok)
+	return _c
+}
+
+func (_c *MockLimiter_AllowTraffic_Call) RunAndReturn(run func(string, uint64, uint64) (bool, bool)) *MockLimiter_AllowTraffic_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Bandwidth provides a mock function with given fields: id
+func (_m *MockLimiter) Bandwidth(id string) (uint64, uint64) {
+	ret := _m.Called(id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Bandwidth")
+	}
+
+	var r0 uint64
+	var r1 uint64
+	if rf, ok := ret.Get(0).(func(string) (uint64, uint64)); ok {
+		return rf(id)
+	}
+	if rf, ok := ret.Get(0).(func(string) uint64); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) uint64); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Get(1).(uint64)
+	}
+
+	return r0, r1
+}
+
+// MockLimiter_Bandwidth_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Bandwidth'
+type MockLimiter_Bandwidth_Call struct {
+	*mock.Call
+}
+
+// Bandwidth is a helper method to define mock.On call
+//   - id string
+func (_e *MockLimiter_Expecter) Bandwidth(id interface{}) *MockLimiter_Bandwidth_Call {
+	return &MockLimiter_Bandwidth_Call{Call: _e.mock.On("Bandwidth", id)}
+}
+
+func (_c *MockLimiter_Bandwidth_Call) Run(run func(id string)) *MockLimiter_Bandwidth_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimiter_Bandwidth_Call) Return(tx uint64, rx uint64) *MockLimiter_Bandwidth_Call {
+	_c.Call.Return(tx, rx)
+	return _c
+}
+
+func (_c *MockLimiter_Bandwidth_Call) RunAndReturn(run func(string) (uint64, uint64)) *MockLimiter_Bandwidth_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// WaitTraffic provides a mock function with given fields: id, tx, rx
+func (_m *MockLimiter) WaitTraffic(id string, tx uint64, rx uint64) bool {
+	ret := _m.Called(id, tx, rx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for WaitTraffic")
+	}
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string, uint64, uint64) bool); ok {
+		r0 = rf(id, tx, rx)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// MockLimiter_WaitTraffic_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'WaitTraffic'
+type MockLimiter_WaitTraffic_Call struct {
+	*mock.Call
+}
+
+// WaitTraffic is a helper method to define mock.On call
+//   - id string
+//   - tx uint64
+//   - rx uint64
+func (_e *MockLimiter_Expecter) WaitTraffic(id interface{}, tx interface{}, rx interface{}) *MockLimiter_WaitTraffic_Call {
+	return &MockLimiter_WaitTraffic_Call{Call: _e.mock.On("WaitTraffic", id, tx, rx)}
+}
+
+func (_c *MockLimiter_WaitTraffic_Call) Run(run func(id string, tx uint64, rx uint64)) *MockLimiter_WaitTraffic_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(uint64), args[2].(uint64))
+	})
+	return _c
+}
+
+func (_c *MockLimiter_WaitTraffic_Call) Return(ok bool) *MockLimiter_WaitTraffic_Call {
+	_c.Call.Return(ok)
+	return _c
+}
+
+func (_c *MockLimiter_WaitTraffic_Call) RunAndReturn(run func(string, uint64, uint64) bool) *MockLimiter_WaitTraffic_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockLimiter creates a new instance of MockLimiter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockLimiter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockLimiter {
+	mock := &MockLimiter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
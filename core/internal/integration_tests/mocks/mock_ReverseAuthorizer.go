@@ -0,0 +1,83 @@
+// Code generated by mockery v2.43.0. DO NOT EDIT.
+
+package mocks
+
+import (
+	server "github.com/apernet/hysteria/core/v2/server"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockReverseAuthorizer is an autogenerated mock type for the ReverseAuthorizer type
+type MockReverseAuthorizer struct {
+	mock.Mock
+}
+
+type MockReverseAuthorizer_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockReverseAuthorizer) EXPECT() *MockReverseAuthorizer_Expecter {
+	return &MockReverseAuthorizer_Expecter{mock: &_m.Mock}
+}
+
+// AllowReverse provides a mock function with given fields: info, network, addr
+func (_m *MockReverseAuthorizer) AllowReverse(info *server.RequestInfo, network string, addr string) bool {
+	ret := _m.Called(info, network, addr)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AllowReverse")
+	}
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(*server.RequestInfo, string, string) bool); ok {
+		r0 = rf(info, network, addr)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// MockReverseAuthorizer_AllowReverse_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'AllowReverse'
+type MockReverseAuthorizer_AllowReverse_Call struct {
+	*mock.Call
+}
+
+// AllowReverse is a helper method to define mock.On call
+//   - info *server.RequestInfo
+//   - network string
+//   - addr string
+func (_e *MockReverseAuthorizer_Expecter) AllowReverse(info interface{}, network interface{}, addr interface{}) *MockReverseAuthorizer_AllowReverse_Call {
+	return &MockReverseAuthorizer_AllowReverse_Call{Call: _e.mock.On("AllowReverse", info, network, addr)}
+}
+
+func (_c *MockReverseAuthorizer_AllowReverse_Call) Run(run func(info *server.RequestInfo, network string, addr string)) *MockReverseAuthorizer_AllowReverse_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(*server.RequestInfo), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *MockReverseAuthorizer_AllowReverse_Call) Return(_a0 bool) *MockReverseAuthorizer_AllowReverse_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockReverseAuthorizer_AllowReverse_Call) RunAndReturn(run func(*server.RequestInfo, string, string) bool) *MockReverseAuthorizer_AllowReverse_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockReverseAuthorizer creates a new instance of MockReverseAuthorizer. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockReverseAuthorizer(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockReverseAuthorizer {
+	mock := &MockReverseAuthorizer{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
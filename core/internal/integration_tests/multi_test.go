@@ -0,0 +1,129 @@
+package integration_tests
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+func multiTestServer(t *testing.T, authOK bool) (net.Addr, *mocks.MockOutbound, server.Server) {
+	// Can't use serverConn() as we need multiple servers on different ports
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	ob := mocks.NewMockOutbound(t)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(authOK, "nobody").Maybe()
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      ob,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	go s.Serve()
+	return udpConn.LocalAddr(), ob, s
+}
+
+func multiTestConfigFunc(addr net.Addr) func() (*client.Config, error) {
+	return func() (*client.Config, error) {
+		return &client.Config{
+			ServerAddr: addr,
+			TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+		}, nil
+	}
+}
+
+// TestClientServerMultiFailover tests that the multi-server client
+// fails over to the next server when the first one can't be connected to.
+func TestClientServerMultiFailover(t *testing.T) {
+	addr1, _, s1 := multiTestServer(t, false)
+	defer s1.Close()
+	addr2, ob2, s2 := multiTestServer(t, true)
+	defer s2.Close()
+
+	var connected []int
+	c, err := client.NewMultiClient(&client.MultiConfig{
+		ConfigFuncs: []func() (*client.Config, error){
+			multiTestConfigFunc(addr1),
+			multiTestConfigFunc(addr2),
+		},
+		Strategy: client.MultiStrategyFailover,
+		ConnectedFunc: func(c client.Client, index int, info *client.HandshakeInfo, count int) {
+			connected = append(connected, index)
+		},
+	}, false)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, []int{1}, connected)
+
+	ob2.EXPECT().TCP("failover.test:80").Return(nil, errors.New("second server")).Once()
+	_, err = c.TCP("failover.test:80")
+	assert.Equal(t, coreErrs.DialError{Message: "second server"}, err)
+}
+
+// TestClientServerMultiRoundRobin tests that the multi-server client
+// spreads new TCP streams across all servers with round-robin.
+func TestClientServerMultiRoundRobin(t *testing.T) {
+	addr1, ob1, s1 := multiTestServer(t, true)
+	defer s1.Close()
+	addr2, ob2, s2 := multiTestServer(t, true)
+	defer s2.Close()
+
+	c, err := client.NewMultiClient(&client.MultiConfig{
+		ConfigFuncs: []func() (*client.Config, error){
+			multiTestConfigFunc(addr1),
+			multiTestConfigFunc(addr2),
+		},
+		Strategy: client.MultiStrategyRoundRobin,
+	}, true)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	ob1.EXPECT().TCP("rr.test:80").Return(nil, errors.New("server 1")).Twice()
+	ob2.EXPECT().TCP("rr.test:80").Return(nil, errors.New("server 2")).Twice()
+	for _, want := range []string{"server 1", "server 2", "server 1", "server 2"} {
+		_, err = c.TCP("rr.test:80")
+		assert.Equal(t, coreErrs.DialError{Message: want}, err)
+	}
+}
+
+// TestClientServerMultiSticky tests that the multi-server client always uses
+// the same server for the same destination host.
+func TestClientServerMultiSticky(t *testing.T) {
+	addr1, ob1, s1 := multiTestServer(t, true)
+	defer s1.Close()
+	addr2, ob2, s2 := multiTestServer(t, true)
+	defer s2.Close()
+
+	c, err := client.NewMultiClient(&client.MultiConfig{
+		ConfigFuncs: []func() (*client.Config, error){
+			multiTestConfigFunc(addr1),
+			multiTestConfigFunc(addr2),
+		},
+		Strategy: client.MultiStrategySticky,
+	}, true)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var hits [2]int
+	ob1.EXPECT().TCP(mock.Anything).RunAndReturn(func(string) (net.Conn, error) {
+		hits[0]++
+		return nil, errors.New("nope")
+	}).Maybe()
+	ob2.EXPECT().TCP(mock.Anything).RunAndReturn(func(string) (net.Conn, error) {
+		hits[1]++
+		return nil, errors.New("nope")
+	}).Maybe()
+	for _, port := range []string{"80", "443", "8080", "8443"} {
+		_, _ = c.TCP(net.JoinHostPort("sticky.test", port))
+	}
+	assert.True(t, hits == [2]int{4, 0} || hits == [2]int{0, 4})
+}
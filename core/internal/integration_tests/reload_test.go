@@ -0,0 +1,255 @@
+package integration_tests
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// TestClientServerReload tests that the server uses the new Outbound & Authenticator
+// after a reload, and that clients connected before the reload stay connected.
+func TestClientServerReload(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	ob1 := mocks.NewMockOutbound(t)
+	auth1 := mocks.NewMockAuthenticator(t)
+	auth1.EXPECT().Authenticate(mock.Anything, "old", mock.Anything).Return(true, "nobody").Once()
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      ob1,
+		Authenticator: auth1,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		Auth:       "old",
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Request through the old outbound
+	ob1.EXPECT().TCP("before.reload:80").Return(nil, errors.New("old outbound")).Once()
+	_, err = c.TCP("before.reload:80")
+	assert.Equal(t, coreErrs.DialError{Message: "old outbound"}, err)
+
+	// Reload
+	ob2 := mocks.NewMockOutbound(t)
+	auth2 := mocks.NewMockAuthenticator(t)
+	err = s.Reload(&server.ReloadConfig{
+		Outbound:      ob2,
+		Authenticator: auth2,
+	})
+	assert.NoError(t, err)
+
+	// The existing client should now go through the new outbound
+	ob2.EXPECT().TCP("after.reload:80").Return(nil, errors.New("new outbound")).Once()
+	_, err = c.TCP("after.reload:80")
+	assert.Equal(t, coreErrs.DialError{Message: "new outbound"}, err)
+
+	// New clients should be checked by the new authenticator
+	auth2.EXPECT().Authenticate(mock.Anything, "old", mock.Anything).Return(false, "").Once()
+	c2, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		Auth:       "old",
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.Nil(t, c2)
+	_, ok := err.(coreErrs.AuthError)
+	assert.True(t, ok)
+}
+
+// blockingOutbound is a closable Outbound whose TCP dials block until released.
+type blockingOutbound struct {
+	Dialing chan struct{}
+	Release chan struct{}
+	Closed  chan struct{}
+}
+
+func (o *blockingOutbound) TCP(reqAddr string) (net.Conn, error) {
+	close(o.Dialing)
+	<-o.Release
+	return nil, errors.New("released")
+}
+
+func (o *blockingOutbound) UDP(reqAddr string) (server.UDPConn, error) {
+	return nil, errors.New("not supported")
+}
+
+func (o *blockingOutbound) Close() error {
+	close(o.Closed)
+	return nil
+}
+
+// TestClientServerReloadCloseOutbound tests that the old outbound is closed
+// after a reload, but only once the dials in flight on it are done.
+func TestClientServerReloadCloseOutbound(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	ob1 := &blockingOutbound{
+		Dialing: make(chan struct{}),
+		Release: make(chan struct{}),
+		Closed:  make(chan struct{}),
+	}
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      ob1,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Start a dial on the old outbound
+	dialErr := make(chan error, 1)
+	go func() {
+		_, err := c.TCP("in.flight:80")
+		dialErr <- err
+	}()
+	<-ob1.Dialing
+
+	// Reload
+	ob2 := mocks.NewMockOutbound(t)
+	err = s.Reload(&server.ReloadConfig{Outbound: ob2})
+	assert.NoError(t, err)
+
+	// The old outbound must not be closed while the dial is in flight
+	select {
+	case <-ob1.Closed:
+		t.Fatal("outbound closed with a dial in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Release the dial, then it should be closed
+	close(ob1.Release)
+	assert.Equal(t, coreErrs.DialError{Message: "released"}, <-dialErr)
+	select {
+	case <-ob1.Closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("outbound not closed")
+	}
+}
+
+// pipeOutbound is a closable Outbound that hands out one end of a pipe for each TCP
+// request, and closes the pipes it handed out when it's closed.
+type pipeOutbound struct {
+	Conns  chan net.Conn // the other end of the pipes
+	Closed chan struct{}
+
+	mutex sync.Mutex
+	pipes []net.Conn
+}
+
+func (o *pipeOutbound) TCP(reqAddr string) (net.Conn, error) {
+	c1, c2 := net.Pipe()
+	o.mutex.Lock()
+	o.pipes = append(o.pipes, c1)
+	o.mutex.Unlock()
+	o.Conns <- c2
+	return c1, nil
+}
+
+func (o *pipeOutbound) UDP(reqAddr string) (server.UDPConn, error) {
+	return nil, errors.New("not supported")
+}
+
+func (o *pipeOutbound) Close() error {
+	o.mutex.Lock()
+	for _, c := range o.pipes {
+		_ = c.Close()
+	}
+	o.mutex.Unlock()
+	close(o.Closed)
+	return nil
+}
+
+// TestClientServerReloadKeepConns tests that the old outbound is not closed
+// after a reload while connections it handed out are still open.
+func TestClientServerReloadKeepConns(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	ob1 := &pipeOutbound{
+		Conns:  make(chan net.Conn, 1),
+		Closed: make(chan struct{}),
+	}
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      ob1,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Open a connection through the old outbound
+	conn, err := c.TCP("before.reload:80")
+	assert.NoError(t, err)
+	rConn := <-ob1.Conns
+
+	// Reload
+	ob2 := mocks.NewMockOutbound(t)
+	err = s.Reload(&server.ReloadConfig{Outbound: ob2})
+	assert.NoError(t, err)
+
+	// The old outbound must not be closed, and the connection still relays
+	select {
+	case <-ob1.Closed:
+		t.Fatal("outbound closed with a connection open")
+	case <-time.After(200 * time.Millisecond):
+	}
+	_, err = rConn.Write([]byte("hello"))
+	assert.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// Close the connection, then it should be closed
+	_ = conn.Close()
+	select {
+	case <-ob1.Closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("outbound not closed")
+	}
+}
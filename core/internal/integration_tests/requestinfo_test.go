@@ -0,0 +1,114 @@
+package integration_tests
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apernet/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// infoOutbound is an OutboundEx that records the RequestInfo it receives.
+type infoOutbound struct {
+	server.Outbound
+	Infos chan *server.RequestInfo
+}
+
+func (o *infoOutbound) TCPEx(info *server.RequestInfo, reqAddr string) (net.Conn, error) {
+	o.Infos <- info
+	return nil, errors.New("nope")
+}
+
+func (o *infoOutbound) UDPEx(info *server.RequestInfo, reqAddr string) (server.UDPConn, error) {
+	o.Infos <- info
+	return nil, errors.New("nope")
+}
+
+// infoHook is a RequestHookEx that records the RequestInfo it receives,
+// without actually hooking any request.
+type infoHook struct {
+	server.RequestHook
+	Infos chan *server.RequestInfo
+}
+
+func (h *infoHook) CheckEx(info *server.RequestInfo, isUDP bool, reqAddr string) bool {
+	h.Infos <- info
+	return false
+}
+
+func (h *infoHook) TCPEx(info *server.RequestInfo, stream quic.Stream, reqAddr *string) ([]byte, error) {
+	panic("not hooked")
+}
+
+func (h *infoHook) UDPEx(info *server.RequestInfo, data []byte, reqAddr *string) error {
+	panic("not hooked")
+}
+
+// TestClientServerRequestInfo tests that an Outbound implementing OutboundEx
+// and a RequestHook implementing RequestHookEx receive information about
+// the client with each request.
+func TestClientServerRequestInfo(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	ob := &infoOutbound{
+		Outbound: mocks.NewMockOutbound(t), // TCP & UDP must not be called
+		Infos:    make(chan *server.RequestInfo, 1),
+	}
+	hook := &infoHook{
+		RequestHook: mocks.NewMockRequestHook(t), // Check, TCP & UDP must not be called
+		Infos:       make(chan *server.RequestInfo, 1),
+	}
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "pekora")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Outbound:      ob,
+		RequestHook:   hook,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create client
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// TCP
+	_, err = c.TCP("example.com:80")
+	assert.Error(t, err)
+	hookInfo := <-hook.Infos
+	info := <-ob.Infos
+	assert.Equal(t, hookInfo, info)
+	assert.Equal(t, "pekora", info.AuthID)
+	assert.True(t, info.Addr.(*net.UDPAddr).IP.IsLoopback())
+	assert.Equal(t, uint32(0), info.SessionID)
+	tcpInfo := info
+
+	// UDP
+	uc, err := c.UDP()
+	assert.NoError(t, err)
+	defer uc.Close()
+	err = uc.Send([]byte("hello"), "example.com:53")
+	assert.NoError(t, err)
+	hookInfo = <-hook.Infos
+	info = <-ob.Infos
+	assert.Equal(t, hookInfo, info)
+	assert.Equal(t, "pekora", info.AuthID)
+	assert.True(t, info.Addr.(*net.UDPAddr).IP.IsLoopback())
+	// Same connection as the TCP request
+	assert.Equal(t, tcpInfo.ConnID, info.ConnID)
+	assert.Equal(t, tcpInfo.TracingID, info.TracingID)
+}
@@ -0,0 +1,77 @@
+package integration_tests
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// sameAddrConn reports Addr as its local address,
+// like the sockets of a SO_REUSEPORT group do.
+type sameAddrConn struct {
+	net.PacketConn
+	Addr net.Addr
+}
+
+func (c *sameAddrConn) LocalAddr() net.Addr {
+	return c.Addr
+}
+
+// TestClientServerSharedAddr tests that the server accepts clients from
+// multiple conns on the same local address, which quic-go doesn't take as is.
+func TestClientServerSharedAddr(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	extraConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:      serverTLSConfig(),
+		Conn:           udpConn,
+		ExtraConns:     []net.PacketConn{&sameAddrConn{PacketConn: extraConn, Addr: udpConn.LocalAddr()}},
+		ConnIDSteering: 2,
+		Authenticator:  auth,
+	})
+	assert.NoError(t, err)
+	go s.Serve()
+
+	// Through Conn
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	_ = c.Close()
+
+	// Through the extra conn
+	c, _, err = client.NewClient(&client.Config{
+		ConnFactory: &redirectConnFactory{Addr: extraConn.LocalAddr()},
+		ServerAddr:  udpAddr,
+		TLSConfig:   client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	_ = c.Close()
+
+	// Closes all the transports
+	closed := make(chan struct{})
+	go func() {
+		_ = s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not close")
+	}
+	_, err = extraConn.WriteTo([]byte("hello"), udpAddr)
+	assert.Error(t, err)
+}
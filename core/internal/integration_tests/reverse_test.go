@@ -0,0 +1,314 @@
+package integration_tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+func reverseTestServer(t *testing.T, authorizer server.ReverseAuthorizer) (net.Addr, server.Server) {
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:         serverTLSConfig(),
+		Conn:              udpConn,
+		Authenticator:     auth,
+		ReverseAuthorizer: authorizer,
+	})
+	assert.NoError(t, err)
+	go s.Serve()
+	return udpAddr, s
+}
+
+// TestClientServerReverseTCP tests reverse TCP forwarding:
+// connections to the listener on the server side come out of the listener on the client side.
+func TestClientServerReverseTCP(t *testing.T) {
+	authorizer := mocks.NewMockReverseAuthorizer(t)
+	authorizer.EXPECT().AllowReverse(mock.Anything, "tcp", "127.0.0.1:22334").Return(true).Once()
+	authorizer.EXPECT().AllowReverse(mock.Anything, "tcp", "127.0.0.1:22335").Return(false).Once()
+	udpAddr, s := reverseTestServer(t, authorizer)
+	defer s.Close()
+
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	rc := c.(client.ReverseClient)
+
+	// Not allowed
+	_, err = rc.ListenTCP("127.0.0.1:22335")
+	_, ok := err.(coreErrs.DialError)
+	assert.True(t, ok)
+
+	l, err := rc.ListenTCP("127.0.0.1:22334")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:22334", l.Addr().String())
+
+	// Echo on the client side
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:22334")
+	assert.NoError(t, err)
+	sData := []byte("knock knock")
+	_, err = conn.Write(sData)
+	assert.NoError(t, err)
+	rData := make([]byte, len(sData))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadFull(conn, rData)
+	assert.NoError(t, err)
+	assert.Equal(t, sData, rData)
+	_ = conn.Close()
+
+	// Closing the listener on the client side closes it on the server
+	assert.NoError(t, l.Close())
+	time.Sleep(500 * time.Millisecond)
+	_, err = net.DialTimeout("tcp", "127.0.0.1:22334", time.Second)
+	assert.Error(t, err)
+}
+
+// TestClientServerReverseUDP tests reverse UDP forwarding.
+func TestClientServerReverseUDP(t *testing.T) {
+	authorizer := mocks.NewMockReverseAuthorizer(t)
+	authorizer.EXPECT().AllowReverse(mock.Anything, "udp", "127.0.0.1:22334").Return(true).Once()
+	udpAddr, s := reverseTestServer(t, authorizer)
+	defer s.Close()
+
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	pc, err := c.(client.ReverseClient).ListenUDP("127.0.0.1:22334")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	// Echo on the client side
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	conn, err := net.Dial("udp", "127.0.0.1:22334")
+	assert.NoError(t, err)
+	defer conn.Close()
+	sData := []byte("marco")
+	_, err = conn.Write(sData)
+	assert.NoError(t, err)
+	rData := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(rData)
+	assert.NoError(t, err)
+	assert.Equal(t, sData, rData[:n])
+}
+
+// TestClientServerReverseDisabled tests that reverse forwarding is rejected
+// when the server has no ReverseAuthorizer.
+func TestClientServerReverseDisabled(t *testing.T) {
+	udpAddr, s := reverseTestServer(t, nil)
+	defer s.Close()
+
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.(client.ReverseClient).ListenTCP("127.0.0.1:22334")
+	_, ok := err.(coreErrs.DialError)
+	assert.True(t, ok)
+}
+
+// TestClientServerReverseUDPTrafficLogger tests that reverse UDP traffic is logged with the traffic logger,
+// that the client can only send to peers that have sent to the listener, and that the client is
+// disconnected when the traffic logger returns false.
+func TestClientServerReverseUDPTrafficLogger(t *testing.T) {
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	authorizer := mocks.NewMockReverseAuthorizer(t)
+	authorizer.EXPECT().AllowReverse(mock.Anything, "udp", "127.0.0.1:22336").Return(true).Once()
+	trafficLogger := mocks.NewMockTrafficLogger(t)
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:         serverTLSConfig(),
+		Conn:              udpConn,
+		Authenticator:     auth,
+		TrafficLogger:     trafficLogger,
+		ReverseAuthorizer: authorizer,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	trafficLogger.EXPECT().LogOnlineState("nobody", true).Return().Once()
+	c, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	pc, err := c.(client.ReverseClient).ListenUDP("127.0.0.1:22336")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	peer, err := net.Dial("udp", "127.0.0.1:22336")
+	assert.NoError(t, err)
+	defer peer.Close()
+	stranger, err := net.ListenPacket("udp", "127.0.0.1:22337")
+	assert.NoError(t, err)
+	defer stranger.Close()
+
+	// Peer to client
+	trafficLogger.EXPECT().LogTraffic("nobody", uint64(0), uint64(5)).Return(true).Once()
+	_, err = peer.Write([]byte("marco"))
+	assert.NoError(t, err)
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, peerAddr, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "marco", string(buf[:n]))
+	assert.Equal(t, peer.LocalAddr().String(), peerAddr.String())
+
+	// Client to peer
+	trafficLogger.EXPECT().LogTraffic("nobody", uint64(4), uint64(0)).Return(true).Once()
+	_, err = pc.WriteTo([]byte("polo"), peerAddr)
+	assert.NoError(t, err)
+	_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = peer.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "polo", string(buf[:n]))
+
+	// Client to an address that has never sent anything to the listener, dropped
+	trafficLogger.EXPECT().LogTraffic("nobody", uint64(5), uint64(0)).Return(true).Once()
+	_, err = pc.WriteTo([]byte("stray"), stranger.LocalAddr())
+	assert.NoError(t, err)
+	_ = stranger.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, _, err = stranger.ReadFrom(buf)
+	assert.Error(t, err)
+
+	// Peer to client again but blocked
+	trafficLogger.EXPECT().LogTraffic("nobody", uint64(0), uint64(3)).Return(false).Once()
+	trafficLogger.EXPECT().LogOnlineState("nobody", false).Return().Once()
+	_, err = peer.Write([]byte("bye"))
+	assert.NoError(t, err)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = pc.ReadFrom(buf)
+	assert.Error(t, err)
+
+	// The client should be disconnected.
+	// The listener may be closed a bit before the client notices, wait for it.
+	time.Sleep(500 * time.Millisecond)
+	_, err = c.UDP()
+	assert.Error(t, err)
+}
+
+// TestClientServerReverseGracefulShutdown tests that idle reverse listeners don't hold up
+// a graceful shutdown, and that the server stops accepting new connections right away.
+func TestClientServerReverseGracefulShutdown(t *testing.T) {
+	authorizer := mocks.NewMockReverseAuthorizer(t)
+	authorizer.EXPECT().AllowReverse(mock.Anything, "tcp", "127.0.0.1:22334").Return(true).Once()
+	udpAddr, s := reverseTestServer(t, authorizer)
+	defer s.Close()
+
+	// Create TCP echo server
+	echoAddr := "127.0.0.1:22333"
+	echoListener, err := net.Listen("tcp", echoAddr)
+	assert.NoError(t, err)
+	echoServer := &tcpEchoServer{Listener: echoListener}
+	defer echoServer.Close()
+	go echoServer.Serve()
+
+	// One client with an idle reverse listener, another with a request in progress
+	c1, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c1.Close()
+	l, err := c1.(client.ReverseClient).ListenTCP("127.0.0.1:22334")
+	assert.NoError(t, err)
+	defer l.Close()
+	c2, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c2.Close()
+	conn, err := c2.TCP(echoAddr)
+	assert.NoError(t, err)
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown(context.Background())
+	}()
+
+	// The connection with only the reverse listener is closed right away
+	acceptCh := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		acceptCh <- err
+	}()
+	select {
+	case err := <-acceptCh:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("reverse listener not closed")
+	}
+
+	// New connections are not accepted
+	c3, _, err := client.NewClient(&client.Config{
+		ServerAddr: udpAddr,
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.Nil(t, c3)
+	assert.Error(t, err)
+
+	// Shutdown completes once the request is done
+	select {
+	case <-shutdownCh:
+		t.Fatal("shutdown returned before the request finished")
+	default:
+	}
+	_ = conn.Close()
+	select {
+	case err := <-shutdownCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown timed out")
+	}
+}
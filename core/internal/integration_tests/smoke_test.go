@@ -209,6 +209,10 @@ func TestClientServerHandshakeInfo(t *testing.T) {
 	assert.Equal(t, &client.HandshakeInfo{
 		UDPEnabled: true,
 		Tx:         123456,
+		Capabilities: client.Capabilities{
+			Version:    1,
+			Extensions: []string{client.ExtensionUDPClose, client.ExtensionUDPStream},
+		},
 	}, info)
 
 	// Close server 1 and client 1
@@ -242,6 +246,9 @@ func TestClientServerHandshakeInfo(t *testing.T) {
 	assert.Equal(t, &client.HandshakeInfo{
 		UDPEnabled: false,
 		Tx:         100000,
+		Capabilities: client.Capabilities{
+			Version: 1,
+		},
 	}, info)
 
 	// Close server 2 and client 2
@@ -272,6 +279,10 @@ func TestClientServerHandshakeInfo(t *testing.T) {
 	assert.Equal(t, &client.HandshakeInfo{
 		UDPEnabled: true,
 		Tx:         0,
+		Capabilities: client.Capabilities{
+			Version:    1,
+			Extensions: []string{client.ExtensionUDPClose, client.ExtensionUDPStream},
+		},
 	}, info)
 
 	// Close server 3 and client 3
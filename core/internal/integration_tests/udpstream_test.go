@@ -0,0 +1,75 @@
+package integration_tests
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/internal/integration_tests/mocks"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+// TestClientServerUDPStream tests UDP sessions carried over streams,
+// both requested explicitly and switched to automatically.
+func TestClientServerUDPStream(t *testing.T) {
+	// Create server
+	udpConn, udpAddr, err := serverConn()
+	assert.NoError(t, err)
+	auth := mocks.NewMockAuthenticator(t)
+	auth.EXPECT().Authenticate(mock.Anything, mock.Anything, mock.Anything).Return(true, "nobody")
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     serverTLSConfig(),
+		Conn:          udpConn,
+		Authenticator: auth,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// Create UDP echo server
+	echoAddr := "127.0.0.1:22334"
+	echoConn, err := net.ListenPacket("udp", echoAddr)
+	assert.NoError(t, err)
+	echoServer := &udpEchoServer{Conn: echoConn}
+	defer echoServer.Close()
+	go echoServer.Serve()
+
+	// Create client
+	c, info, err := client.NewClient(&client.Config{
+		ServerAddr:    udpAddr,
+		TLSConfig:     client.TLSConfig{InsecureSkipVerify: true},
+		UDPStreamMode: client.UDPStreamModeAuto,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.True(t, info.Capabilities.Has(client.ExtensionUDPStream))
+
+	echo := func(conn client.HyUDPConn, data []byte) {
+		err := conn.Send(data, echoAddr)
+		assert.NoError(t, err)
+		rData, rAddr, err := conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, data, rData)
+		assert.Equal(t, echoAddr, rAddr)
+	}
+
+	// Larger than datagrams (even fragmented) from the start
+	conn, err := client.UDPStream(c)
+	assert.NoError(t, err)
+	echo(conn, bytes.Repeat([]byte("s"), 20000))
+	echo(conn, []byte("small"))
+	assert.NoError(t, conn.Close())
+
+	// Fragmented first, then switched to a stream
+	conn, err = c.UDP()
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		echo(conn, bytes.Repeat([]byte{byte(i)}, 3000))
+	}
+	echo(conn, bytes.Repeat([]byte("a"), 20000))
+	assert.NoError(t, conn.Close())
+}
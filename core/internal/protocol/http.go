@@ -2,45 +2,107 @@ package protocol
 
 import (
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 const (
 	URLHost = "hysteria"
 	URLPath = "/auth"
 
-	RequestHeaderAuth        = "Hysteria-Auth"
-	ResponseHeaderUDPEnabled = "Hysteria-UDP"
-	CommonHeaderCCRX         = "Hysteria-CC-RX"
-	CommonHeaderPadding      = "Hysteria-Padding"
+	RequestHeaderAuth            = "Hysteria-Auth"
+	ResponseHeaderUDPEnabled     = "Hysteria-UDP"
+	ResponseHeaderUDPMaxSessions = "Hysteria-UDP-Max-Sessions"
+	ResponseHeaderHints          = "Hysteria-Hints"
+	CommonHeaderCCRX             = "Hysteria-CC-RX"
+	CommonHeaderPadding          = "Hysteria-Padding"
+	CommonHeaderCapVersion       = "Hysteria-Cap-Version"
+	CommonHeaderExtensions       = "Hysteria-Extensions"
 
 	StatusAuthOK = 233
+
+	// CapVersion is the version of the capability headers.
+	// It's bumped when the meaning of the existing headers changes,
+	// new extensions don't need a new version.
+	CapVersion = 1
+
+	ExtensionUDPClose  = "udp-close"  // UDPClose messages, see proxy.go
+	ExtensionReverse   = "reverse"    // Reverse forwarding, see reverse.go
+	ExtensionUDPStream = "udp-stream" // UDP sessions over streams, see udpstream.go
 )
 
+// Capabilities are the optional features a side supports.
+// Version is 0 if the peer doesn't send capability headers (older versions),
+// in which case it must be assumed to support none of the extensions.
+type Capabilities struct {
+	Version    int
+	Extensions []string
+}
+
+func (c Capabilities) Has(ext string) bool {
+	return slices.Contains(c.Extensions, ext)
+}
+
+func capabilitiesFromHeader(h http.Header) Capabilities {
+	var caps Capabilities
+	caps.Version, _ = strconv.Atoi(h.Get(CommonHeaderCapVersion))
+	if caps.Version <= 0 {
+		return Capabilities{}
+	}
+	for _, ext := range strings.Split(h.Get(CommonHeaderExtensions), ",") {
+		ext = strings.TrimSpace(ext)
+		if ext != "" {
+			caps.Extensions = append(caps.Extensions, ext)
+		}
+	}
+	return caps
+}
+
+func capabilitiesToHeader(h http.Header, caps Capabilities) {
+	if caps.Version <= 0 {
+		return
+	}
+	h.Set(CommonHeaderCapVersion, strconv.Itoa(caps.Version))
+	if len(caps.Extensions) > 0 {
+		h.Set(CommonHeaderExtensions, strings.Join(caps.Extensions, ","))
+	}
+}
+
 // AuthRequest is what client sends to server for authentication.
 type AuthRequest struct {
-	Auth string
-	Rx   uint64 // 0 = unknown, client asks server to use bandwidth detection
+	Auth         string
+	Rx           uint64 // 0 = unknown, client asks server to use bandwidth detection
+	Capabilities Capabilities
 }
 
 // AuthResponse is what server sends to client when authentication is passed.
 type AuthResponse struct {
-	UDPEnabled bool
-	Rx         uint64 // 0 = unlimited
-	RxAuto     bool   // true = server asks client to use bandwidth detection
+	UDPEnabled   bool
+	Rx           uint64 // 0 = unlimited
+	RxAuto       bool   // true = server asks client to use bandwidth detection
+	Capabilities Capabilities
+
+	// The fields below are only sent along with capabilities
+
+	UDPMaxSessions int               // 0 = unlimited
+	Hints          map[string]string // Free-form hints for the client, e.g. from the authenticator
 }
 
 func AuthRequestFromHeader(h http.Header) AuthRequest {
 	rx, _ := strconv.ParseUint(h.Get(CommonHeaderCCRX), 10, 64)
 	return AuthRequest{
-		Auth: h.Get(RequestHeaderAuth),
-		Rx:   rx,
+		Auth:         h.Get(RequestHeaderAuth),
+		Rx:           rx,
+		Capabilities: capabilitiesFromHeader(h),
 	}
 }
 
 func AuthRequestToHeader(h http.Header, req AuthRequest) {
 	h.Set(RequestHeaderAuth, req.Auth)
 	h.Set(CommonHeaderCCRX, strconv.FormatUint(req.Rx, 10))
+	capabilitiesToHeader(h, req.Capabilities)
 	h.Set(CommonHeaderPadding, authRequestPadding.String())
 }
 
@@ -54,6 +116,16 @@ func AuthResponseFromHeader(h http.Header) AuthResponse {
 	} else {
 		resp.Rx, _ = strconv.ParseUint(rxStr, 10, 64)
 	}
+	resp.Capabilities = capabilitiesFromHeader(h)
+	if resp.Capabilities.Version > 0 {
+		resp.UDPMaxSessions, _ = strconv.Atoi(h.Get(ResponseHeaderUDPMaxSessions))
+		if hints, err := url.ParseQuery(h.Get(ResponseHeaderHints)); err == nil && len(hints) > 0 {
+			resp.Hints = make(map[string]string, len(hints))
+			for k := range hints {
+				resp.Hints[k] = hints.Get(k)
+			}
+		}
+	}
 	return resp
 }
 
@@ -64,5 +136,18 @@ func AuthResponseToHeader(h http.Header, resp AuthResponse) {
 	} else {
 		h.Set(CommonHeaderCCRX, strconv.FormatUint(resp.Rx, 10))
 	}
+	capabilitiesToHeader(h, resp.Capabilities)
+	if resp.Capabilities.Version > 0 {
+		if resp.UDPMaxSessions > 0 {
+			h.Set(ResponseHeaderUDPMaxSessions, strconv.Itoa(resp.UDPMaxSessions))
+		}
+		if len(resp.Hints) > 0 {
+			hints := make(url.Values, len(resp.Hints))
+			for k, v := range resp.Hints {
+				hints.Set(k, v)
+			}
+			h.Set(ResponseHeaderHints, hints.Encode())
+		}
+	}
 	h.Set(CommonHeaderPadding, authResponsePadding.String())
 }
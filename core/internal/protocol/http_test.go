@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAuthRequestHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		req  AuthRequest
+	}{
+		{
+			name: "no capabilities",
+			req:  AuthRequest{Auth: "hello", Rx: 12345},
+		},
+		{
+			name: "capabilities",
+			req: AuthRequest{
+				Auth: "hello",
+				Capabilities: Capabilities{
+					Version:    CapVersion,
+					Extensions: []string{ExtensionUDPClose, "future-thing"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			AuthRequestToHeader(h, tt.req)
+			if got := AuthRequestFromHeader(h); !reflect.DeepEqual(got, tt.req) {
+				t.Errorf("AuthRequestFromHeader() = %v, want %v", got, tt.req)
+			}
+		})
+	}
+}
+
+func TestAuthResponseHeader(t *testing.T) {
+	serverCaps := Capabilities{
+		Version:    CapVersion,
+		Extensions: []string{ExtensionUDPClose, ExtensionReverse},
+	}
+	hints := map[string]string{"motd": "hello & welcome", "region": "eu"}
+	tests := []struct {
+		name string
+		resp AuthResponse
+		want AuthResponse
+	}{
+		{
+			name: "no capabilities",
+			resp: AuthResponse{
+				UDPEnabled:     true,
+				RxAuto:         true,
+				UDPMaxSessions: 100, // Not sent without capabilities
+			},
+			want: AuthResponse{UDPEnabled: true, RxAuto: true},
+		},
+		{
+			name: "capabilities",
+			resp: AuthResponse{
+				UDPEnabled:     true,
+				Rx:             1000000,
+				Capabilities:   serverCaps,
+				UDPMaxSessions: 100,
+				Hints:          hints,
+			},
+			want: AuthResponse{
+				UDPEnabled:     true,
+				Rx:             1000000,
+				Capabilities:   serverCaps,
+				UDPMaxSessions: 100,
+				Hints:          hints,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			AuthResponseToHeader(h, tt.resp)
+			if got := AuthResponseFromHeader(h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AuthResponseFromHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesFromHeader(t *testing.T) {
+	h := make(http.Header)
+	h.Set(CommonHeaderCapVersion, "2")
+	h.Set(CommonHeaderExtensions, " udp-close, ,reverse")
+	caps := capabilitiesFromHeader(h)
+	if caps.Version != 2 || !caps.Has(ExtensionUDPClose) || !caps.Has(ExtensionReverse) || len(caps.Extensions) != 2 {
+		t.Errorf("capabilitiesFromHeader() = %v", caps)
+	}
+	// Extensions without a version are ignored
+	h.Del(CommonHeaderCapVersion)
+	if caps := capabilitiesFromHeader(h); caps.Has(ExtensionUDPClose) {
+		t.Errorf("capabilitiesFromHeader() = %v, want none", caps)
+	}
+}
@@ -155,6 +155,11 @@ func WriteTCPResponse(w io.Writer, ok bool, msg string) error {
 // Address length (QUIC varint)
 // Address (bytes)
 // Data...
+//
+// A UDPMessage with a fragment count of 0 is a UDPClose message, which closes
+// the session. It has an empty address and no data, and is only sent when the
+// other side has announced support for it in the auth headers (ExtensionUDPClose).
+// Older peers discard it as an invalid message due to the empty address.
 
 type UDPMessage struct {
 	SessionID uint32 // 4
@@ -165,6 +170,11 @@ type UDPMessage struct {
 	Data      []byte
 }
 
+// IsClose returns true if the message is a UDPClose message.
+func (m *UDPMessage) IsClose() bool {
+	return m.FragCount == 0
+}
+
 func (m *UDPMessage) HeaderSize() int {
 	lAddr := len(m.Addr)
 	return 4 + 2 + 1 + 1 + int(quicvarint.Len(uint64(lAddr))) + lAddr
@@ -208,6 +218,12 @@ func ParseUDPMessage(msg []byte) (*UDPMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if m.IsClose() {
+		if lAddr != 0 || buf.Len() != 0 {
+			return nil, errors.ProtocolError{Message: "invalid close message"}
+		}
+		return m, nil
+	}
 	if lAddr == 0 || lAddr > MaxMessageLength {
 		return nil, errors.ProtocolError{Message: "invalid address length"}
 	}
@@ -0,0 +1,186 @@
+package protocol
+
+import (
+	"io"
+
+	"github.com/apernet/hysteria/core/v2/errors"
+
+	"github.com/apernet/quic-go/quicvarint"
+)
+
+const (
+	FrameTypeReverseRequest = 0x402
+	FrameTypeReverseConnect = 0x403
+
+	// ReverseSessionIDFlag is set in the session IDs of UDP messages that belong
+	// to reverse UDP listeners. The rest of the session ID is the listener ID.
+	// Regular UDP sessions never have it set.
+	ReverseSessionIDFlag = 1 << 31
+)
+
+type ReverseNetwork byte
+
+const (
+	ReverseNetworkTCP ReverseNetwork = 0
+	ReverseNetworkUDP ReverseNetwork = 1
+)
+
+func (n ReverseNetwork) String() string {
+	switch n {
+	case ReverseNetworkTCP:
+		return "tcp"
+	case ReverseNetworkUDP:
+		return "udp"
+	default:
+		return "unknown"
+	}
+}
+
+// Reverse forwarding lets the client listen on an address on the server side.
+//
+// The client opens a stream with a ReverseRequest, and the server answers with
+// a TCPResponse. The listener lives as long as this (control) stream stays open,
+// and either side closes the stream to close the listener.
+// The listener ID is chosen by the client and must be unique within the connection.
+//
+// For TCP, the server opens a new stream with a ReverseConnect for every
+// incoming connection, followed by the data of the connection.
+// For UDP, packets are carried in UDP messages (datagrams) with the session ID
+// set to ReverseSessionIDFlag | listener ID, and the address set to the address
+// of the remote peer on the server side, in both directions.
+
+// ReverseRequest format:
+// 0x402 (QUIC varint)
+// Listener ID (QUIC varint)
+// Network (byte, 0=TCP, 1=UDP)
+// Address length (QUIC varint)
+// Address (bytes)
+// Padding length (QUIC varint)
+// Padding (bytes)
+
+type ReverseRequest struct {
+	ID      uint32
+	Network ReverseNetwork
+	Addr    string
+}
+
+func ReadReverseRequest(r io.Reader) (*ReverseRequest, error) {
+	bReader := quicvarint.NewReader(r)
+	id, err := quicvarint.Read(bReader)
+	if err != nil {
+		return nil, err
+	}
+	if id >= ReverseSessionIDFlag {
+		return nil, errors.ProtocolError{Message: "invalid listener ID"}
+	}
+	var network [1]byte
+	if _, err := io.ReadFull(r, network[:]); err != nil {
+		return nil, err
+	}
+	if ReverseNetwork(network[0]) != ReverseNetworkTCP && ReverseNetwork(network[0]) != ReverseNetworkUDP {
+		return nil, errors.ProtocolError{Message: "invalid network"}
+	}
+	addr, err := readReverseAddr(r, bReader)
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseRequest{
+		ID:      uint32(id),
+		Network: ReverseNetwork(network[0]),
+		Addr:    addr,
+	}, nil
+}
+
+func WriteReverseRequest(w io.Writer, req *ReverseRequest) error {
+	padding := tcpRequestPadding.String()
+	paddingLen := len(padding)
+	addrLen := len(req.Addr)
+	sz := int(quicvarint.Len(FrameTypeReverseRequest)) +
+		int(quicvarint.Len(uint64(req.ID))) + 1 +
+		int(quicvarint.Len(uint64(addrLen))) + addrLen +
+		int(quicvarint.Len(uint64(paddingLen))) + paddingLen
+	buf := make([]byte, sz)
+	i := varintPut(buf, FrameTypeReverseRequest)
+	i += varintPut(buf[i:], uint64(req.ID))
+	buf[i] = byte(req.Network)
+	i++
+	i += varintPut(buf[i:], uint64(addrLen))
+	i += copy(buf[i:], req.Addr)
+	i += varintPut(buf[i:], uint64(paddingLen))
+	copy(buf[i:], padding)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReverseConnect format:
+// 0x403 (QUIC varint)
+// Listener ID (QUIC varint)
+// Address length (QUIC varint)
+// Address (bytes, the remote address of the incoming connection)
+// Padding length (QUIC varint)
+// Padding (bytes)
+
+func ReadReverseConnect(r io.Reader) (uint32, string, error) {
+	bReader := quicvarint.NewReader(r)
+	id, err := quicvarint.Read(bReader)
+	if err != nil {
+		return 0, "", err
+	}
+	if id >= ReverseSessionIDFlag {
+		return 0, "", errors.ProtocolError{Message: "invalid listener ID"}
+	}
+	addr, err := readReverseAddr(r, bReader)
+	if err != nil {
+		return 0, "", err
+	}
+	return uint32(id), addr, nil
+}
+
+func WriteReverseConnect(w io.Writer, id uint32, addr string) error {
+	padding := tcpResponsePadding.String()
+	paddingLen := len(padding)
+	addrLen := len(addr)
+	sz := int(quicvarint.Len(FrameTypeReverseConnect)) +
+		int(quicvarint.Len(uint64(id))) +
+		int(quicvarint.Len(uint64(addrLen))) + addrLen +
+		int(quicvarint.Len(uint64(paddingLen))) + paddingLen
+	buf := make([]byte, sz)
+	i := varintPut(buf, FrameTypeReverseConnect)
+	i += varintPut(buf[i:], uint64(id))
+	i += varintPut(buf[i:], uint64(addrLen))
+	i += copy(buf[i:], addr)
+	i += varintPut(buf[i:], uint64(paddingLen))
+	copy(buf[i:], padding)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readReverseAddr reads the address & padding part shared by ReverseRequest and ReverseConnect.
+func readReverseAddr(r io.Reader, bReader quicvarint.Reader) (string, error) {
+	addrLen, err := quicvarint.Read(bReader)
+	if err != nil {
+		return "", err
+	}
+	if addrLen == 0 || addrLen > MaxAddressLength {
+		return "", errors.ProtocolError{Message: "invalid address length"}
+	}
+	addrBuf := make([]byte, addrLen)
+	_, err = io.ReadFull(r, addrBuf)
+	if err != nil {
+		return "", err
+	}
+	paddingLen, err := quicvarint.Read(bReader)
+	if err != nil {
+		return "", err
+	}
+	if paddingLen > MaxPaddingLength {
+		return "", errors.ProtocolError{Message: "invalid padding length"}
+	}
+	if paddingLen > 0 {
+		_, err = io.CopyN(io.Discard, r, int64(paddingLen))
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(addrBuf), nil
+}
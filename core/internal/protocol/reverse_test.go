@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReverseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *ReverseRequest
+		wantErr bool
+	}{
+		{
+			name: "tcp no padding",
+			data: []byte("\x05\x00\x05:8080\x00"),
+			want: &ReverseRequest{ID: 5, Network: ReverseNetworkTCP, Addr: ":8080"},
+		},
+		{
+			name: "udp with padding",
+			data: []byte("\x40\x64\x01\x0c0.0.0.0:5353\x02gg"),
+			want: &ReverseRequest{ID: 100, Network: ReverseNetworkUDP, Addr: "0.0.0.0:5353"},
+		},
+		{
+			name:    "invalid network",
+			data:    []byte("\x05\x02\x05:8080\x00"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid ID",
+			data:    []byte("\xc0\x00\x00\x00\x80\x00\x00\x00\x00\x05:8080\x00"),
+			wantErr: true,
+		},
+		{
+			name:    "empty address",
+			data:    []byte("\x05\x00\x00\x00"),
+			wantErr: true,
+		},
+		{
+			name:    "incomplete",
+			data:    []byte("\x05\x00\x05:80"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadReverseRequest(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadReverseRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadReverseRequest() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteReverseRequest(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := WriteReverseRequest(w, &ReverseRequest{ID: 7, Network: ReverseNetworkUDP, Addr: ":5353"})
+	if err != nil {
+		t.Fatalf("WriteReverseRequest() error = %v", err)
+	}
+	if !strings.HasPrefix(w.String(), "\x44\x02\x07\x01\x05:5353") {
+		t.Errorf("WriteReverseRequest() got = %q", w.String())
+	}
+	// Round trip, skipping the frame type
+	got, err := ReadReverseRequest(bytes.NewReader(w.Bytes()[2:]))
+	if err != nil {
+		t.Fatalf("ReadReverseRequest() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, &ReverseRequest{ID: 7, Network: ReverseNetworkUDP, Addr: ":5353"}) {
+		t.Errorf("ReadReverseRequest() got = %v", got)
+	}
+}
+
+func TestReverseConnect(t *testing.T) {
+	w := &bytes.Buffer{}
+	if err := WriteReverseConnect(w, 3, "1.2.3.4:5678"); err != nil {
+		t.Fatalf("WriteReverseConnect() error = %v", err)
+	}
+	if !strings.HasPrefix(w.String(), "\x44\x03\x03\x0c1.2.3.4:5678") {
+		t.Errorf("WriteReverseConnect() got = %q", w.String())
+	}
+	id, addr, err := ReadReverseConnect(bytes.NewReader(w.Bytes()[2:]))
+	if err != nil {
+		t.Fatalf("ReadReverseConnect() error = %v", err)
+	}
+	if id != 3 || addr != "1.2.3.4:5678" {
+		t.Errorf("ReadReverseConnect() got = %v, %v", id, addr)
+	}
+	_, _, err = ReadReverseConnect(bytes.NewReader([]byte("\x03\x0c1.2.3")))
+	if err == nil {
+		t.Error("ReadReverseConnect() expected error for incomplete data")
+	}
+}
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/apernet/hysteria/core/v2/errors"
+
+	"github.com/apernet/quic-go/quicvarint"
+)
+
+const (
+	FrameTypeUDPStream = 0x404
+
+	// MaxUDPStreamSize is the max size of the data in a UDPStreamPacket,
+	// i.e. the largest UDP payload possible.
+	MaxUDPStreamSize = 65535
+)
+
+// A UDP session can be carried over a stream instead of datagrams, which has no
+// size limit other than that of UDP itself, and no loss (as well as the added
+// latency when there is loss). Only used when both sides have announced support
+// for it in the auth headers (ExtensionUDPStream).
+//
+// The client opens a stream with a UDPStreamRequest, followed by UDPStreamPackets
+// in both directions. There is no response. If the session already exists (e.g.
+// the client switches to a stream after repeated fragmentation), the server keeps
+// the session and sends its packets over the stream from then on.
+// Either side closes the stream to close the session.
+
+// UDPStreamRequest format:
+// 0x404 (QUIC varint)
+// Session ID (uint32 BE)
+// Padding length (QUIC varint)
+// Padding (bytes)
+
+// UDPStreamPacket format:
+// Address length (QUIC varint)
+// Address (bytes)
+// Data length (QUIC varint)
+// Data (bytes)
+
+func ReadUDPStreamRequest(r io.Reader) (uint32, error) {
+	var idBuf [4]byte
+	if _, err := io.ReadFull(r, idBuf[:]); err != nil {
+		return 0, err
+	}
+	id := binary.BigEndian.Uint32(idBuf[:])
+	if id&ReverseSessionIDFlag != 0 {
+		return 0, errors.ProtocolError{Message: "invalid session ID"}
+	}
+	bReader := quicvarint.NewReader(r)
+	paddingLen, err := quicvarint.Read(bReader)
+	if err != nil {
+		return 0, err
+	}
+	if paddingLen > MaxPaddingLength {
+		return 0, errors.ProtocolError{Message: "invalid padding length"}
+	}
+	if paddingLen > 0 {
+		_, err = io.CopyN(io.Discard, r, int64(paddingLen))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+func WriteUDPStreamRequest(w io.Writer, id uint32) error {
+	padding := udpStreamRequestPadding.String()
+	paddingLen := len(padding)
+	sz := int(quicvarint.Len(FrameTypeUDPStream)) + 4 +
+		int(quicvarint.Len(uint64(paddingLen))) + paddingLen
+	buf := make([]byte, sz)
+	i := varintPut(buf, FrameTypeUDPStream)
+	binary.BigEndian.PutUint32(buf[i:], id)
+	i += 4
+	i += varintPut(buf[i:], uint64(paddingLen))
+	copy(buf[i:], padding)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadUDPStreamPacket reads a packet as a complete (unfragmented) UDPMessage.
+// The session ID is not part of the packet and is left as 0.
+func ReadUDPStreamPacket(r io.Reader) (*UDPMessage, error) {
+	bReader := quicvarint.NewReader(r)
+	addrLen, err := quicvarint.Read(bReader)
+	if err != nil {
+		return nil, err
+	}
+	if addrLen == 0 || addrLen > MaxAddressLength {
+		return nil, errors.ProtocolError{Message: "invalid address length"}
+	}
+	addrBuf := make([]byte, addrLen)
+	_, err = io.ReadFull(r, addrBuf)
+	if err != nil {
+		return nil, err
+	}
+	dataLen, err := quicvarint.Read(bReader)
+	if err != nil {
+		return nil, err
+	}
+	if dataLen > MaxUDPStreamSize {
+		return nil, errors.ProtocolError{Message: "invalid data length"}
+	}
+	data := make([]byte, dataLen)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPMessage{
+		FragCount: 1,
+		Addr:      string(addrBuf),
+		Data:      data,
+	}, nil
+}
+
+// WriteUDPStreamPacket writes the address & data of msg as a single packet.
+// buf is used for serialization if it's large enough, otherwise a new one is allocated.
+func WriteUDPStreamPacket(w io.Writer, buf []byte, msg *UDPMessage) error {
+	addrLen, dataLen := len(msg.Addr), len(msg.Data)
+	if dataLen > MaxUDPStreamSize {
+		return errors.ProtocolError{Message: "data too large"}
+	}
+	sz := int(quicvarint.Len(uint64(addrLen))) + addrLen +
+		int(quicvarint.Len(uint64(dataLen))) + dataLen
+	if len(buf) < sz {
+		buf = make([]byte, sz)
+	}
+	i := varintPut(buf, uint64(addrLen))
+	i += copy(buf[i:], msg.Addr)
+	i += varintPut(buf[i:], uint64(dataLen))
+	i += copy(buf[i:], msg.Data)
+	_, err := w.Write(buf[:i])
+	return err
+}
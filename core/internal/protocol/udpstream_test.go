@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUDPStreamRequest(t *testing.T) {
+	w := &bytes.Buffer{}
+	if err := WriteUDPStreamRequest(w, 0x01020304); err != nil {
+		t.Fatalf("WriteUDPStreamRequest() error = %v", err)
+	}
+	if !strings.HasPrefix(w.String(), "\x44\x04\x01\x02\x03\x04") {
+		t.Errorf("WriteUDPStreamRequest() got = %q", w.String())
+	}
+	// Round trip, skipping the frame type
+	id, err := ReadUDPStreamRequest(bytes.NewReader(w.Bytes()[2:]))
+	if err != nil {
+		t.Fatalf("ReadUDPStreamRequest() error = %v", err)
+	}
+	if id != 0x01020304 {
+		t.Errorf("ReadUDPStreamRequest() got = %v", id)
+	}
+	_, err = ReadUDPStreamRequest(bytes.NewReader([]byte("\x80\x00\x00\x01\x00")))
+	if err == nil {
+		t.Error("ReadUDPStreamRequest() expected error for reverse session ID")
+	}
+	_, err = ReadUDPStreamRequest(bytes.NewReader([]byte("\x00\x00\x00\x01\x05gg")))
+	if err == nil {
+		t.Error("ReadUDPStreamRequest() expected error for incomplete padding")
+	}
+}
+
+func TestReadUDPStreamPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *UDPMessage
+		wantErr bool
+	}{
+		{
+			name: "normal",
+			data: []byte("\x0a1.1.1.1:53\x05hello"),
+			want: &UDPMessage{FragCount: 1, Addr: "1.1.1.1:53", Data: []byte("hello")},
+		},
+		{
+			name: "empty data",
+			data: []byte("\x0a1.1.1.1:53\x00"),
+			want: &UDPMessage{FragCount: 1, Addr: "1.1.1.1:53", Data: []byte{}},
+		},
+		{
+			name:    "empty address",
+			data:    []byte("\x00\x05hello"),
+			wantErr: true,
+		},
+		{
+			name:    "data too large",
+			data:    []byte("\x0a1.1.1.1:53\x80\x01\x00\x00"),
+			wantErr: true,
+		},
+		{
+			name:    "incomplete",
+			data:    []byte("\x0a1.1.1.1:53\x05hel"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadUDPStreamPacket(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadUDPStreamPacket() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadUDPStreamPacket() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteUDPStreamPacket(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 10000)
+	w := &bytes.Buffer{}
+	// Small buffer, must be reallocated
+	buf := make([]byte, 16)
+	msgs := []*UDPMessage{
+		{FragCount: 1, Addr: "example.com:443", Data: []byte("hi")},
+		{FragCount: 1, Addr: "[::1]:8888", Data: large},
+	}
+	for _, msg := range msgs {
+		if err := WriteUDPStreamPacket(w, buf, msg); err != nil {
+			t.Fatalf("WriteUDPStreamPacket() error = %v", err)
+		}
+	}
+	if !strings.HasPrefix(w.String(), "\x0fexample.com:443\x02hi\x0a[::1]:8888\x67\x10") {
+		t.Errorf("WriteUDPStreamPacket() got = %q", w.String()[:32])
+	}
+	for _, msg := range msgs {
+		got, err := ReadUDPStreamPacket(w)
+		if err != nil {
+			t.Fatalf("ReadUDPStreamPacket() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("ReadUDPStreamPacket() got = %v, want %v", got.Addr, msg.Addr)
+		}
+	}
+	err := WriteUDPStreamPacket(w, buf, &UDPMessage{Addr: "a:1", Data: make([]byte, MaxUDPStreamSize+1)})
+	if err == nil {
+		t.Error("WriteUDPStreamPacket() expected error for data too large")
+	}
+}
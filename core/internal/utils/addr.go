@@ -0,0 +1,17 @@
+package utils
+
+import "net"
+
+// UnwrapAddr returns the address of the peer that addr stands for.
+// Transports that return their own type of address for a peer (e.g. to remember
+// the socket its packets came from) implement Unwrap() net.Addr to expose the
+// underlying one. If addr doesn't, it's returned as is.
+func UnwrapAddr(addr net.Addr) net.Addr {
+	for {
+		u, ok := addr.(interface{ Unwrap() net.Addr })
+		if !ok {
+			return addr
+		}
+		addr = u.Unwrap()
+	}
+}
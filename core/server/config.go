@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/apernet/hysteria/core/v2/errors"
+	"github.com/apernet/hysteria/core/v2/internal/congestion"
 	"github.com/apernet/hysteria/core/v2/internal/pmtud"
+	"github.com/apernet/hysteria/core/v2/internal/protocol"
 	"github.com/apernet/hysteria/core/v2/internal/utils"
 	"github.com/apernet/quic-go"
 )
@@ -25,16 +27,22 @@ type Config struct {
 	TLSConfig             TLSConfig
 	QUICConfig            QUICConfig
 	Conn                  net.PacketConn
+	ExtraConns            []net.PacketConn // Optional, also accept clients from these, e.g. from a fallback transport
+	ConnIDSteering        int              // Optional, number of conns (from Conn on) in a SO_REUSEPORT group steered by connection ID
 	RequestHook           RequestHook
 	Outbound              Outbound
 	BandwidthConfig       BandwidthConfig
 	IgnoreClientBandwidth bool
+	CongestionControl     string // Optional, one of the CongestionControl* types, empty = Brutal if the bandwidth is known, BBR otherwise
 	DisableUDP            bool
 	UDPIdleTimeout        time.Duration
+	UDPMaxSessions        int // Per connection, 0 = unlimited
 	Authenticator         Authenticator
 	EventLogger           EventLogger
 	TrafficLogger         TrafficLogger
+	Limiter               Limiter
 	MasqHandler           http.Handler
+	ReverseAuthorizer     ReverseAuthorizer
 }
 
 // fill fills the fields that are not set by the user with default values when possible,
@@ -77,6 +85,14 @@ func (c *Config) fill() error {
 	if c.Conn == nil {
 		return errors.ConfigError{Field: "Conn", Reason: "must be set"}
 	}
+	for _, conn := range c.ExtraConns {
+		if conn == nil {
+			return errors.ConfigError{Field: "ExtraConns", Reason: "must not contain nil"}
+		}
+	}
+	if c.ConnIDSteering < 0 || c.ConnIDSteering > 256 {
+		return errors.ConfigError{Field: "ConnIDSteering", Reason: "must be between 0 and 256"}
+	}
 	if c.Outbound == nil {
 		c.Outbound = &defaultOutbound{}
 	}
@@ -86,11 +102,17 @@ func (c *Config) fill() error {
 	if c.BandwidthConfig.MaxRx != 0 && c.BandwidthConfig.MaxRx < 65536 {
 		return errors.ConfigError{Field: "BandwidthConfig.MaxRx", Reason: "must be at least 65536"}
 	}
+	if !congestion.IsValidType(c.CongestionControl) {
+		return errors.ConfigError{Field: "CongestionControl", Reason: "invalid type"}
+	}
 	if c.UDPIdleTimeout == 0 {
 		c.UDPIdleTimeout = defaultUDPIdleTimeout
 	} else if c.UDPIdleTimeout < 2*time.Second || c.UDPIdleTimeout > 600*time.Second {
 		return errors.ConfigError{Field: "UDPIdleTimeout", Reason: "must be between 2s and 600s"}
 	}
+	if c.UDPMaxSessions < 0 {
+		return errors.ConfigError{Field: "UDPMaxSessions", Reason: "must be non-negative"}
+	}
 	if c.Authenticator == nil {
 		return errors.ConfigError{Field: "Authenticator", Reason: "must be set"}
 	}
@@ -127,6 +149,40 @@ type RequestHook interface {
 	UDP(data []byte, reqAddr *string) error
 }
 
+// RequestHookEx is an optional interface that a RequestHook can implement to
+// receive information about the client along with each request.
+// If implemented, the server calls CheckEx, TCPEx & UDPEx instead of
+// Check, TCP & UDP.
+type RequestHookEx interface {
+	CheckEx(info *RequestInfo, isUDP bool, reqAddr string) bool
+	TCPEx(info *RequestInfo, stream quic.Stream, reqAddr *string) ([]byte, error)
+	UDPEx(info *RequestInfo, data []byte, reqAddr *string) error
+}
+
+// RequestHookExAdapter turns a RequestHook into a RequestHookEx that ignores RequestInfo.
+type RequestHookExAdapter struct {
+	RequestHook
+}
+
+func (a RequestHookExAdapter) CheckEx(info *RequestInfo, isUDP bool, reqAddr string) bool {
+	return a.RequestHook.Check(isUDP, reqAddr)
+}
+
+func (a RequestHookExAdapter) TCPEx(info *RequestInfo, stream quic.Stream, reqAddr *string) ([]byte, error) {
+	return a.RequestHook.TCP(stream, reqAddr)
+}
+
+func (a RequestHookExAdapter) UDPEx(info *RequestInfo, data []byte, reqAddr *string) error {
+	return a.RequestHook.UDP(data, reqAddr)
+}
+
+func toRequestHookEx(hook RequestHook) RequestHookEx {
+	if hookEx, ok := hook.(RequestHookEx); ok {
+		return hookEx
+	}
+	return RequestHookExAdapter{hook}
+}
+
 // Outbound provides the implementation of how the server should connect to remote servers.
 // Although UDP includes a reqAddr, the implementation does not necessarily have to use it
 // to make a "connected" UDP connection that does not accept packets from other addresses.
@@ -136,6 +192,58 @@ type Outbound interface {
 	UDP(reqAddr string) (UDPConn, error)
 }
 
+// RequestInfo contains information about the client that made a request.
+type RequestInfo struct {
+	AuthID    string                   // ID returned by the Authenticator
+	Addr      net.Addr                 // Remote address of the client, see AddrIP
+	ConnID    uint32                   // Same as StreamStats.ConnID
+	TracingID quic.ConnectionTracingID // Tracing ID of the QUIC connection
+	SessionID uint32                   // UDP session ID, only set for UDP requests
+}
+
+// AddrIP returns the IP address of the client at addr, or nil if it has none.
+// Transports that wrap the addresses of their clients (e.g. to remember the socket
+// their packets came from) must implement Unwrap() net.Addr for it to be found.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := utils.UnwrapAddr(addr).(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+// OutboundEx is an optional interface that an Outbound can implement to
+// receive information about the client along with each request, e.g. for
+// per-user routing. If implemented, the server calls TCPEx & UDPEx instead
+// of TCP & UDP.
+type OutboundEx interface {
+	TCPEx(info *RequestInfo, reqAddr string) (net.Conn, error)
+	UDPEx(info *RequestInfo, reqAddr string) (UDPConn, error)
+}
+
+// OutboundExAdapter turns an Outbound into an OutboundEx that ignores RequestInfo.
+type OutboundExAdapter struct {
+	Outbound
+}
+
+func (a OutboundExAdapter) TCPEx(info *RequestInfo, reqAddr string) (net.Conn, error) {
+	return a.Outbound.TCP(reqAddr)
+}
+
+func (a OutboundExAdapter) UDPEx(info *RequestInfo, reqAddr string) (UDPConn, error) {
+	return a.Outbound.UDP(reqAddr)
+}
+
+func toOutboundEx(ob Outbound) OutboundEx {
+	if obEx, ok := ob.(OutboundEx); ok {
+		return obEx
+	}
+	return OutboundExAdapter{ob}
+}
+
 // UDPConn is like net.PacketConn, but uses string for addresses.
 type UDPConn interface {
 	ReadFrom(b []byte) (int, string, error)
@@ -193,6 +301,63 @@ type Authenticator interface {
 	Authenticate(addr net.Addr, auth string, tx uint64) (ok bool, id string)
 }
 
+// AuthenticatorEx is an optional interface that an Authenticator can implement to
+// see the capabilities of the client, and to send hints (free-form key-value pairs)
+// back to it. If implemented, the server calls AuthenticateEx instead of Authenticate.
+type AuthenticatorEx interface {
+	AuthenticateEx(addr net.Addr, auth string, tx uint64, caps Capabilities) (ok bool, id string, hints map[string]string)
+}
+
+// AuthenticatorExAdapter turns an Authenticator into an AuthenticatorEx that
+// ignores the capabilities and sends no hints.
+type AuthenticatorExAdapter struct {
+	Authenticator
+}
+
+func (a AuthenticatorExAdapter) AuthenticateEx(addr net.Addr, auth string, tx uint64, caps Capabilities) (bool, string, map[string]string) {
+	ok, id := a.Authenticator.Authenticate(addr, auth, tx)
+	return ok, id, nil
+}
+
+func toAuthenticatorEx(auth Authenticator) AuthenticatorEx {
+	if authEx, ok := auth.(AuthenticatorEx); ok {
+		return authEx
+	}
+	return AuthenticatorExAdapter{auth}
+}
+
+// CongestionControlAuthenticator is an optional interface that an Authenticator can
+// implement to choose the congestion control for each user, e.g. something fairer than
+// Brutal for users on shared links. An empty or invalid type means Config.CongestionControl.
+// CongestionControl is called exactly once after each successful authentication of the user.
+type CongestionControlAuthenticator interface {
+	CongestionControl(id string) string
+}
+
+// Congestion control types for Config.CongestionControl and CongestionControlAuthenticator,
+// see the congestion package. Brutal's rate is the lower of the client's receive bandwidth
+// and MaxTx, and it falls back to BBR if neither is known or IgnoreClientBandwidth is set.
+const (
+	CongestionControlBBR            = congestion.TypeBBR
+	CongestionControlBBR3           = congestion.TypeBBR3
+	CongestionControlBrutal         = congestion.TypeBrutal
+	CongestionControlAdaptiveBrutal = congestion.TypeAdaptiveBrutal
+	CongestionControlCubic          = congestion.TypeCubic
+	CongestionControlReno           = congestion.TypeReno
+)
+
+// Capabilities are the protocol extensions supported by the client, as advertised
+// in its authentication request. Version is 0 for clients that don't advertise
+// any (older versions).
+type Capabilities = protocol.Capabilities
+
+// Protocol extensions that can be advertised in Capabilities.
+const (
+	ExtensionUDPClose  = protocol.ExtensionUDPClose
+	ExtensionReverse   = protocol.ExtensionReverse
+	ExtensionUDPStream = protocol.ExtensionUDPStream
+)
+
 // EventLogger is an interface that provides logging logic.
 type EventLogger interface {
 	Connect(addr net.Addr, id string, tx uint64)
@@ -218,6 +383,90 @@ type TrafficLogger interface {
 	UntraceStream(stream quic.Stream)
 }
 
+// Limiter is an interface that provides per-user rate limiting and quota enforcement.
+// Tx/Rx follow the same definition as in TrafficLogger (server-remote perspective).
+// WaitTraffic is used for TCP, and should block until the traffic is allowed
+// under the user's rate limit.
+// AllowTraffic is used for UDP and must not block. It returns whether the packet
+// should be forwarded, as packets over the rate limit are simply dropped.
+// Both return ok = false to signal that the client should be disconnected,
+// e.g. when the user has used up their quota.
+// Bandwidth returns the user's maximum rates in bytes per second (0 = unlimited),
+// which the server also uses to cap the bandwidth negotiated with the client,
+// so that congestion control doesn't send faster than the user is allowed to.
+// The implementation of this interface must be thread-safe.
+type Limiter interface {
+	WaitTraffic(id string, tx, rx uint64) (ok bool)
+	AllowTraffic(id string, tx, rx uint64) (allow, ok bool)
+	Bandwidth(id string) (tx, rx uint64)
+}
+
+// ReverseAuthorizer decides whether a client may open a listener on the server
+// for reverse forwarding. Network is either "tcp" or "udp", and addr is the address
+// the client wants to listen on, as requested by the client.
+// Reverse forwarding is disabled when no ReverseAuthorizer is set.
+type ReverseAuthorizer interface {
+	AllowReverse(info *RequestInfo, network, addr string) bool
+}
+
+// ReverseEventLogger is an optional interface that an EventLogger can implement
+// to be notified of reverse listeners. ReverseError is called when the listener
+// is closed (err is nil if it's closed by the client), or when it fails to open.
+type ReverseEventLogger interface {
+	ReverseRequest(addr net.Addr, id, network, listenAddr string)
+	ReverseError(addr net.Addr, id, network, listenAddr string, err error)
+}
+
+// AuthLogger is an optional interface that a TrafficLogger can implement
+// to be notified of every authentication attempt, successful or not.
+type AuthLogger interface {
+	LogAuth(addr net.Addr, id string, ok bool)
+}
+
+// CongestionStats is a snapshot of the congestion control state of a connection.
+type CongestionStats struct {
+	Type     string               // One of the CongestionControl* types, never empty as the fallbacks are resolved
+	Rate     uint64               // Configured send rate of Brutal in bytes per second, 0 for other types
+	Adaptive *AdaptiveBrutalStats // Only set for adaptive Brutal
+}
+
+// AdaptiveBrutalStats is the state of adaptive Brutal, as of the end of its last measurement window.
+type AdaptiveBrutalStats struct {
+	State        string  // "full" (sending at the configured rate), "backoff" or "probe"
+	RateCap      uint64  // Send rate cap in bytes per second, 0 when not capped
+	DeliveryRate uint64  // Bytes per second
+	LossRate     float64 // 0 to 1
+	Backoffs     uint64  // Number of times it has backed off
+}
+
+// CongestionStatsProvider reports the congestion control state of a connection.
+type CongestionStatsProvider interface {
+	CongestionStats() CongestionStats
+}
+
+// CongestionTracer is an optional interface that a TrafficLogger can implement
+// to keep track of the congestion control state of each connection.
+// TraceCongestion is called when a connection is authenticated,
+// and UntraceCongestion when it's closed.
+type CongestionTracer interface {
+	TraceCongestion(id string, connID uint32, provider CongestionStatsProvider)
+	UntraceCongestion(connID uint32)
+}
+
+// UDPSessionCounter reports the number of active UDP sessions of a connection.
+type UDPSessionCounter interface {
+	Count() int
+}
+
+// UDPSessionTracer is an optional interface that a TrafficLogger can implement
+// to keep track of the number of active UDP sessions of each connection.
+// TraceUDPSessions is called when UDP relaying starts for an authenticated
+// connection, and UntraceUDPSessions when it stops.
+type UDPSessionTracer interface {
+	TraceUDPSessions(id string, connID uint32, counter UDPSessionCounter)
+	UntraceUDPSessions(connID uint32)
+}
+
 type StreamState int
 
 const (
@@ -6,7 +6,7 @@ import (
 	"time"
 )
 
-var errDisconnect = errors.New("traffic logger requested disconnect")
+var errDisconnect = errors.New("traffic logger or limiter requested disconnect")
 
 func copyBufferLog(dst io.Writer, src io.Reader, log func(n uint64) bool) error {
 	buf := make([]byte, 32*1024)
@@ -32,27 +32,33 @@ func copyBufferLog(dst io.Writer, src io.Reader, log func(n uint64) bool) error
 	}
 }
 
-func copyTwoWayEx(id string, serverRw, remoteRw io.ReadWriter, l TrafficLogger, stats *StreamStats) error {
+func copyTwoWayEx(id string, serverRw, remoteRw io.ReadWriter, l TrafficLogger, lim Limiter, stats *StreamStats) error {
 	errChan := make(chan error, 2)
 	go func() {
 		errChan <- copyBufferLog(serverRw, remoteRw, func(n uint64) bool {
 			stats.LastActiveTime.Store(time.Now())
 			stats.Rx.Add(n)
-			return l.LogTraffic(id, 0, n)
+			if lim != nil && !lim.WaitTraffic(id, 0, n) {
+				return false
+			}
+			return l == nil || l.LogTraffic(id, 0, n)
 		})
 	}()
 	go func() {
 		errChan <- copyBufferLog(remoteRw, serverRw, func(n uint64) bool {
 			stats.LastActiveTime.Store(time.Now())
 			stats.Tx.Add(n)
-			return l.LogTraffic(id, n, 0)
+			if lim != nil && !lim.WaitTraffic(id, n, 0) {
+				return false
+			}
+			return l == nil || l.LogTraffic(id, n, 0)
 		})
 	}()
 	// Block until one of the two goroutines returns
 	return <-errChan
 }
 
-// copyTwoWay is the "fast-path" version of copyTwoWayEx that does not log traffic or update stream stats.
+// copyTwoWay is the "fast-path" version of copyTwoWayEx that does not log or limit traffic, or update stream stats.
 // It uses the built-in io.Copy instead of our own copyBufferLog.
 func copyTwoWay(serverRw, remoteRw io.ReadWriter) error {
 	errChan := make(chan error, 2)
@@ -20,17 +20,17 @@ func (_m *mockUDPIO) EXPECT() *mockUDPIO_Expecter {
 	return &mockUDPIO_Expecter{mock: &_m.Mock}
 }
 
-// Hook provides a mock function with given fields: data, reqAddr
-func (_m *mockUDPIO) Hook(data []byte, reqAddr *string) error {
-	ret := _m.Called(data, reqAddr)
+// Hook provides a mock function with given fields: sessionID, data, reqAddr
+func (_m *mockUDPIO) Hook(sessionID uint32, data []byte, reqAddr *string) error {
+	ret := _m.Called(sessionID, data, reqAddr)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Hook")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]byte, *string) error); ok {
-		r0 = rf(data, reqAddr)
+	if rf, ok := ret.Get(0).(func(uint32, []byte, *string) error); ok {
+		r0 = rf(sessionID, data, reqAddr)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -44,15 +44,16 @@ type mockUDPIO_Hook_Call struct {
 }
 
 // Hook is a helper method to define mock.On call
+//   - sessionID uint32
 //   - data []byte
 //   - reqAddr *string
-func (_e *mockUDPIO_Expecter) Hook(data interface{}, reqAddr interface{}) *mockUDPIO_Hook_Call {
-	return &mockUDPIO_Hook_Call{Call: _e.mock.On("Hook", data, reqAddr)}
+func (_e *mockUDPIO_Expecter) Hook(sessionID interface{}, data interface{}, reqAddr interface{}) *mockUDPIO_Hook_Call {
+	return &mockUDPIO_Hook_Call{Call: _e.mock.On("Hook", sessionID, data, reqAddr)}
 }
 
-func (_c *mockUDPIO_Hook_Call) Run(run func(data []byte, reqAddr *string)) *mockUDPIO_Hook_Call {
+func (_c *mockUDPIO_Hook_Call) Run(run func(sessionID uint32, data []byte, reqAddr *string)) *mockUDPIO_Hook_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].([]byte), args[1].(*string))
+		run(args[0].(uint32), args[1].([]byte), args[2].(*string))
 	})
 	return _c
 }
@@ -62,7 +63,7 @@ func (_c *mockUDPIO_Hook_Call) Return(_a0 error) *mockUDPIO_Hook_Call {
 	return _c
 }
 
-func (_c *mockUDPIO_Hook_Call) RunAndReturn(run func([]byte, *string) error) *mockUDPIO_Hook_Call {
+func (_c *mockUDPIO_Hook_Call) RunAndReturn(run func(uint32, []byte, *string) error) *mockUDPIO_Hook_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -171,9 +172,9 @@ func (_c *mockUDPIO_SendMessage_Call) RunAndReturn(run func([]byte, *protocol.UD
 	return _c
 }
 
-// UDP provides a mock function with given fields: reqAddr
-func (_m *mockUDPIO) UDP(reqAddr string) (UDPConn, error) {
-	ret := _m.Called(reqAddr)
+// UDP provides a mock function with given fields: sessionID, reqAddr
+func (_m *mockUDPIO) UDP(sessionID uint32, reqAddr string) (UDPConn, error) {
+	ret := _m.Called(sessionID, reqAddr)
 
 	if len(ret) == 0 {
 		panic("no return value specified for UDP")
@@ -181,19 +182,19 @@ func (_m *mockUDPIO) UDP(reqAddr string) (UDPConn, error) {
 
 	var r0 UDPConn
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (UDPConn, error)); ok {
-		return rf(reqAddr)
+	if rf, ok := ret.Get(0).(func(uint32, string) (UDPConn, error)); ok {
+		return rf(sessionID, reqAddr)
 	}
-	if rf, ok := ret.Get(0).(func(string) UDPConn); ok {
-		r0 = rf(reqAddr)
+	if rf, ok := ret.Get(0).(func(uint32, string) UDPConn); ok {
+		r0 = rf(sessionID, reqAddr)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(UDPConn)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(reqAddr)
+	if rf, ok := ret.Get(1).(func(uint32, string) error); ok {
+		r1 = rf(sessionID, reqAddr)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -207,14 +208,15 @@ type mockUDPIO_UDP_Call struct {
 }
 
 // UDP is a helper method to define mock.On call
+//   - sessionID uint32
 //   - reqAddr string
-func (_e *mockUDPIO_Expecter) UDP(reqAddr interface{}) *mockUDPIO_UDP_Call {
-	return &mockUDPIO_UDP_Call{Call: _e.mock.On("UDP", reqAddr)}
+func (_e *mockUDPIO_Expecter) UDP(sessionID interface{}, reqAddr interface{}) *mockUDPIO_UDP_Call {
+	return &mockUDPIO_UDP_Call{Call: _e.mock.On("UDP", sessionID, reqAddr)}
 }
 
-func (_c *mockUDPIO_UDP_Call) Run(run func(reqAddr string)) *mockUDPIO_UDP_Call {
+func (_c *mockUDPIO_UDP_Call) Run(run func(sessionID uint32, reqAddr string)) *mockUDPIO_UDP_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string))
+		run(args[0].(uint32), args[1].(string))
 	})
 	return _c
 }
@@ -224,7 +226,7 @@ func (_c *mockUDPIO_UDP_Call) Return(_a0 UDPConn, _a1 error) *mockUDPIO_UDP_Call
 	return _c
 }
 
-func (_c *mockUDPIO_UDP_Call) RunAndReturn(run func(string) (UDPConn, error)) *mockUDPIO_UDP_Call {
+func (_c *mockUDPIO_UDP_Call) RunAndReturn(run func(uint32, string) (UDPConn, error)) *mockUDPIO_UDP_Call {
 	_c.Call.Return(run)
 	return _c
 }
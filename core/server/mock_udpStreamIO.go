@@ -0,0 +1,184 @@
+// Code generated by mockery v2.43.0. DO NOT EDIT.
+
+package server
+
+import (
+	protocol "github.com/apernet/hysteria/core/v2/internal/protocol"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// mockUDPStreamIO is an autogenerated mock type for the udpStreamIO type
+type mockUDPStreamIO struct {
+	mock.Mock
+}
+
+type mockUDPStreamIO_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *mockUDPStreamIO) EXPECT() *mockUDPStreamIO_Expecter {
+	return &mockUDPStreamIO_Expecter{mock: &_m.Mock}
+}
+
+// Close provides a mock function with given fields:
+func (_m *mockUDPStreamIO) Close() error {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Close")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// mockUDPStreamIO_Close_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Close'
+type mockUDPStreamIO_Close_Call struct {
+	*mock.Call
+}
+
+// Close is a helper method to define mock.On call
+func (_e *mockUDPStreamIO_Expecter) Close() *mockUDPStreamIO_Close_Call {
+	return &mockUDPStreamIO_Close_Call{Call: _e.mock.On("Close")}
+}
+
+func (_c *mockUDPStreamIO_Close_Call) Run(run func()) *mockUDPStreamIO_Close_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *mockUDPStreamIO_Close_Call) Return(_a0 error) *mockUDPStreamIO_Close_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *mockUDPStreamIO_Close_Call) RunAndReturn(run func() error) *mockUDPStreamIO_Close_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReceiveMessage provides a mock function with given fields:
+func (_m *mockUDPStreamIO) ReceiveMessage() (*protocol.UDPMessage, error) {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReceiveMessage")
+	}
+
+	var r0 *protocol.UDPMessage
+	var r1 error
+	if rf, ok := ret.Get(0).(func() (*protocol.UDPMessage, error)); ok {
+		return rf()
+	}
+	if rf, ok := ret.Get(0).(func() *protocol.UDPMessage); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*protocol.UDPMessage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// mockUDPStreamIO_ReceiveMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReceiveMessage'
+type mockUDPStreamIO_ReceiveMessage_Call struct {
+	*mock.Call
+}
+
+// ReceiveMessage is a helper method to define mock.On call
+func (_e *mockUDPStreamIO_Expecter) ReceiveMessage() *mockUDPStreamIO_ReceiveMessage_Call {
+	return &mockUDPStreamIO_ReceiveMessage_Call{Call: _e.mock.On("ReceiveMessage")}
+}
+
+func (_c *mockUDPStreamIO_ReceiveMessage_Call) Run(run func()) *mockUDPStreamIO_ReceiveMessage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *mockUDPStreamIO_ReceiveMessage_Call) Return(_a0 *protocol.UDPMessage, _a1 error) *mockUDPStreamIO_ReceiveMessage_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *mockUDPStreamIO_ReceiveMessage_Call) RunAndReturn(run func() (*protocol.UDPMessage, error)) *mockUDPStreamIO_ReceiveMessage_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SendMessage provides a mock function with given fields: _a0, _a1
+func (_m *mockUDPStreamIO) SendMessage(_a0 []byte, _a1 *protocol.UDPMessage) error {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SendMessage")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]byte, *protocol.UDPMessage) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// mockUDPStreamIO_SendMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SendMessage'
+type mockUDPStreamIO_SendMessage_Call struct {
+	*mock.Call
+}
+
+// SendMessage is a helper method to define mock.On call
+//   - _a0 []byte
+//   - _a1 *protocol.UDPMessage
+func (_e *mockUDPStreamIO_Expecter) SendMessage(_a0 interface{}, _a1 interface{}) *mockUDPStreamIO_SendMessage_Call {
+	return &mockUDPStreamIO_SendMessage_Call{Call: _e.mock.On("SendMessage", _a0, _a1)}
+}
+
+func (_c *mockUDPStreamIO_SendMessage_Call) Run(run func(_a0 []byte, _a1 *protocol.UDPMessage)) *mockUDPStreamIO_SendMessage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].([]byte), args[1].(*protocol.UDPMessage))
+	})
+	return _c
+}
+
+func (_c *mockUDPStreamIO_SendMessage_Call) Return(_a0 error) *mockUDPStreamIO_SendMessage_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *mockUDPStreamIO_SendMessage_Call) RunAndReturn(run func([]byte, *protocol.UDPMessage) error) *mockUDPStreamIO_SendMessage_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// newMockUDPStreamIO creates a new instance of mockUDPStreamIO. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func newMockUDPStreamIO(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *mockUDPStreamIO {
+	mock := &mockUDPStreamIO{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
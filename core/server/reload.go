@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ReloadConfig contains the parts of Config that can be replaced on a running server.
+// Fields left nil are not changed.
+type ReloadConfig struct {
+	Outbound      Outbound
+	Authenticator Authenticator
+}
+
+// reloadableOutbound is an Outbound whose underlying implementation can be swapped
+// at runtime. Only new requests go through the new outbound. If the old one holds
+// resources (implements io.Closer), it's closed once the requests in flight on it
+// are done dialing and all the connections it has handed out are closed.
+type reloadableOutbound struct {
+	gen atomic.Pointer[outboundGen]
+}
+
+// outboundGen is one generation of the outbound. refs counts the calls in flight
+// and the connections handed out, so that it's only closed when they are all done.
+type outboundGen struct {
+	ob      Outbound
+	mutex   sync.Mutex
+	refs    int
+	retired bool
+}
+
+func newReloadableOutbound(ob Outbound) *reloadableOutbound {
+	r := &reloadableOutbound{}
+	r.gen.Store(&outboundGen{ob: ob})
+	return r
+}
+
+func (r *reloadableOutbound) Swap(ob Outbound) {
+	old := r.gen.Swap(&outboundGen{ob: ob})
+	old.mutex.Lock()
+	old.retired = true
+	idle := old.refs == 0
+	old.mutex.Unlock()
+	if idle {
+		old.close()
+	}
+}
+
+// acquire returns the current generation, which must be released when the call
+// (or the connection it returns) is done.
+func (r *reloadableOutbound) acquire() *outboundGen {
+	for {
+		g := r.gen.Load()
+		g.mutex.Lock()
+		if !g.retired {
+			g.refs++
+			g.mutex.Unlock()
+			return g
+		}
+		// Swapped out in the meantime, the new one is already in place
+		g.mutex.Unlock()
+	}
+}
+
+func (g *outboundGen) release() {
+	g.mutex.Lock()
+	g.refs--
+	idle := g.retired && g.refs == 0
+	g.mutex.Unlock()
+	if idle {
+		g.close()
+	}
+}
+
+func (g *outboundGen) close() {
+	if c, ok := g.ob.(io.Closer); ok {
+		go func() { _ = c.Close() }()
+	}
+}
+
+func (r *reloadableOutbound) TCP(reqAddr string) (net.Conn, error) {
+	g := r.acquire()
+	return g.wrapConn(g.ob.TCP(reqAddr))
+}
+
+func (r *reloadableOutbound) UDP(reqAddr string) (UDPConn, error) {
+	g := r.acquire()
+	return g.wrapUDPConn(g.ob.UDP(reqAddr))
+}
+
+func (r *reloadableOutbound) TCPEx(info *RequestInfo, reqAddr string) (net.Conn, error) {
+	g := r.acquire()
+	return g.wrapConn(toOutboundEx(g.ob).TCPEx(info, reqAddr))
+}
+
+func (r *reloadableOutbound) UDPEx(info *RequestInfo, reqAddr string) (UDPConn, error) {
+	g := r.acquire()
+	return g.wrapUDPConn(toOutboundEx(g.ob).UDPEx(info, reqAddr))
+}
+
+// wrapConn makes the conn hold on to the generation until it's closed.
+func (g *outboundGen) wrapConn(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		g.release()
+		return nil, err
+	}
+	return &genConn{Conn: conn, gen: g}, nil
+}
+
+// wrapUDPConn makes the conn hold on to the generation until it's closed.
+func (g *outboundGen) wrapUDPConn(conn UDPConn, err error) (UDPConn, error) {
+	if err != nil {
+		g.release()
+		return nil, err
+	}
+	return &genUDPConn{UDPConn: conn, gen: g}, nil
+}
+
+type genConn struct {
+	net.Conn
+	gen  *outboundGen
+	once sync.Once
+}
+
+func (c *genConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.gen.release)
+	return err
+}
+
+type genUDPConn struct {
+	UDPConn
+	gen  *outboundGen
+	once sync.Once
+}
+
+func (c *genUDPConn) Close() error {
+	err := c.UDPConn.Close()
+	c.once.Do(c.gen.release)
+	return err
+}
+
+// reloadableAuthenticator is an Authenticator whose underlying implementation
+// can be swapped at runtime. Clients that have already been authenticated
+// stay connected, only new authentication attempts will use the new one.
+type reloadableAuthenticator struct {
+	auth atomic.Pointer[Authenticator]
+}
+
+func newReloadableAuthenticator(auth Authenticator) *reloadableAuthenticator {
+	r := &reloadableAuthenticator{}
+	r.auth.Store(&auth)
+	return r
+}
+
+func (r *reloadableAuthenticator) Swap(auth Authenticator) {
+	r.auth.Store(&auth)
+}
+
+func (r *reloadableAuthenticator) Authenticate(addr net.Addr, auth string, tx uint64) (ok bool, id string) {
+	return (*r.auth.Load()).Authenticate(addr, auth, tx)
+}
+
+func (r *reloadableAuthenticator) AuthenticateEx(addr net.Addr, auth string, tx uint64, caps Capabilities) (bool, string, map[string]string) {
+	return toAuthenticatorEx(*r.auth.Load()).AuthenticateEx(addr, auth, tx, caps)
+}
+
+// CongestionControl forwards to the underlying authenticator if it implements
+// CongestionControlAuthenticator, otherwise it returns "" (no override).
+func (r *reloadableAuthenticator) CongestionControl(id string) string {
+	if cca, ok := (*r.auth.Load()).(CongestionControlAuthenticator); ok {
+		return cca.CongestionControl(id)
+	}
+	return ""
+}
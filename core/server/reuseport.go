@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/rand"
+	"net"
+	"strconv"
+
+	"github.com/apernet/quic-go"
+)
+
+const steeringConnIDLen = 8
+
+var _ quic.ConnectionIDGenerator = (*steeringConnIDGenerator)(nil)
+
+// steeringConnIDGenerator generates random connection IDs whose first byte
+// is Index modulo N. Packets sent to them can then be steered to the socket
+// of the listener that owns the connection, by a SO_REUSEPORT BPF program that
+// looks at the same byte (see extras/transport/reuseport).
+type steeringConnIDGenerator struct {
+	Index int
+	N     int
+}
+
+func (g *steeringConnIDGenerator) GenerateConnectionID() (quic.ConnectionID, error) {
+	b := make([]byte, steeringConnIDLen)
+	if _, err := rand.Read(b); err != nil {
+		return quic.ConnectionID{}, err
+	}
+	v := int(b[0]) - int(b[0])%g.N + g.Index%g.N
+	if v > 255 {
+		v -= g.N
+	}
+	b[0] = byte(v)
+	return quic.ConnectionIDFromBytes(b), nil
+}
+
+func (g *steeringConnIDGenerator) ConnectionIDLen() int {
+	return steeringConnIDLen
+}
+
+// The server creates a quic.Transport of its own for each of its conns, rather than
+// going through quic.Listen, so that each socket of a SO_REUSEPORT group has its own
+// receive loop and connection ID generator, and the server controls their lifetime.
+//
+// quic-go has one constraint that can't be opted out of: every Transport registers
+// its conn in a process-wide multiplexer keyed by the string of LocalAddr(), and
+// panics if the key is already taken. Sockets of a SO_REUSEPORT group share the same
+// address by design, so transportConns gives each conn after the first one on an address
+// a LocalAddr that differs only by its zone. It must stay a *net.UDPAddr, as quic-go
+// only sets DF and reads the packet info (needed to reply from the right address on
+// unspecified IPs) for those. The zone is never used to send or receive anything.
+
+// transportConns returns the conns to create the transports with,
+// which are conns themselves unless their addresses collide.
+func transportConns(conns []net.PacketConn) []net.PacketConn {
+	tConns := make([]net.PacketConn, len(conns))
+	seen := make(map[string]bool, len(conns))
+	for i, conn := range conns {
+		tConns[i] = conn
+		addr := conn.LocalAddr()
+		key := addr.Network() + " " + addr.String()
+		if uAddr, ok := addr.(*net.UDPAddr); ok && seen[key] {
+			newAddr := *uAddr
+			if newAddr.Zone != "" {
+				newAddr.Zone += "-"
+			}
+			newAddr.Zone += "reuseport" + strconv.Itoa(i)
+			if uConn, ok := conn.(*net.UDPConn); ok {
+				tConns[i] = &sharedAddrUDPConn{UDPConn: uConn, addr: &newAddr}
+			} else {
+				tConns[i] = &sharedAddrConn{PacketConn: conn, addr: &newAddr}
+			}
+			continue
+		}
+		seen[key] = true
+	}
+	return tConns
+}
+
+type sharedAddrConn struct {
+	net.PacketConn
+	addr net.Addr
+}
+
+func (c *sharedAddrConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// sharedAddrUDPConn keeps the methods of *net.UDPConn quic-go looks for.
+type sharedAddrUDPConn struct {
+	*net.UDPConn
+	addr net.Addr
+}
+
+func (c *sharedAddrUDPConn) LocalAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSteeringConnIDGenerator(t *testing.T) {
+	for _, n := range []int{1, 3, 4, 7, 256} {
+		for index := 0; index < n+2; index++ {
+			g := &steeringConnIDGenerator{Index: index, N: n}
+			for i := 0; i < 100; i++ {
+				id, err := g.GenerateConnectionID()
+				assert.NoError(t, err)
+				assert.Equal(t, steeringConnIDLen, id.Len())
+				assert.Equal(t, index%n, int(id.Bytes()[0])%n)
+			}
+		}
+	}
+}
+
+func TestTransportConns(t *testing.T) {
+	conn1, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn1.Close()
+	conn2, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn2.Close()
+
+	conns := []net.PacketConn{conn1, conn2, conn1, conn1}
+	tConns := transportConns(conns)
+	assert.Equal(t, []net.PacketConn{conn1, conn2, conn1, conn1}, conns, "must not modify conns")
+	assert.Same(t, conn1, tConns[0])
+	assert.Same(t, conn2, tConns[1])
+	seen := make(map[string]bool)
+	for _, conn := range tConns {
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		assert.True(t, ok)
+		assert.False(t, seen[addr.String()])
+		seen[addr.String()] = true
+		// Same address, only the zone differs
+		assert.True(t, addr.IP.Equal(net.IPv4(127, 0, 0, 1)))
+		// Still usable by quic-go with its UDP optimizations
+		_, ok = conn.(interface {
+			ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+		})
+		assert.True(t, ok)
+	}
+	assert.Equal(t, conn1.LocalAddr().(*net.UDPAddr).Port, tConns[2].LocalAddr().(*net.UDPAddr).Port)
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apernet/quic-go"
+
+	"github.com/apernet/hysteria/core/v2/internal/frag"
+	"github.com/apernet/hysteria/core/v2/internal/protocol"
+	"github.com/apernet/hysteria/core/v2/internal/utils"
+)
+
+var (
+	errReverseDisabled    = errors.New("reverse forwarding is not enabled")
+	errReverseNotAllowed  = errors.New("not allowed to listen on this address")
+	errReverseUDPDisabled = errors.New("UDP is not enabled")
+	errReverseDuplicateID = errors.New("duplicate listener ID")
+)
+
+// reverseManager handles the reverse listeners of a connection.
+// See protocol/reverse.go for how reverse forwarding works.
+type reverseManager struct {
+	h     *h3sHandler
+	udpIO udpIO // nil if UDP is disabled
+
+	mutex sync.Mutex
+	ids   map[uint32]struct{}
+	udp   map[uint32]*reverseUDPListener
+}
+
+// maxReverseUDPPeers is the maximum number of peers a reverse UDP listener keeps track of.
+// Packets from new peers are dropped once it's reached, until some of the peers expire.
+const maxReverseUDPPeers = 4096
+
+// reverseUDPListener is a reverse UDP listener. The client can only send packets
+// to peers that have sent packets to the listener before, so that it can't be
+// used to send packets to arbitrary addresses from the server.
+// Peers expire after IdleTimeout without packets in either direction.
+type reverseUDPListener struct {
+	PC          net.PacketConn
+	D           *frag.Defragger
+	IdleTimeout time.Duration
+
+	peerMutex sync.RWMutex
+	peers     map[string]*reverseUDPPeer // key: addr.String()
+}
+
+type reverseUDPPeer struct {
+	Addr net.Addr
+	Last *utils.AtomicTime
+}
+
+// addPeer records a peer, and returns false if it's new but there are too many peers
+// already, even after removing the expired ones.
+func (r *reverseUDPListener) addPeer(addr net.Addr) bool {
+	key := addr.String()
+	now := time.Now()
+	r.peerMutex.RLock()
+	p, ok := r.peers[key]
+	r.peerMutex.RUnlock()
+	if ok {
+		p.Last.Set(now)
+		return true
+	}
+	r.peerMutex.Lock()
+	defer r.peerMutex.Unlock()
+	if len(r.peers) >= maxReverseUDPPeers {
+		for k, p := range r.peers {
+			if now.Sub(p.Last.Get()) > r.IdleTimeout {
+				delete(r.peers, k)
+			}
+		}
+		if len(r.peers) >= maxReverseUDPPeers {
+			return false
+		}
+	}
+	r.peers[key] = &reverseUDPPeer{Addr: addr, Last: utils.NewAtomicTime(now)}
+	return true
+}
+
+// peer returns the address of a peer that hasn't expired, or nil if there is none.
+func (r *reverseUDPListener) peer(key string) net.Addr {
+	r.peerMutex.RLock()
+	p := r.peers[key]
+	r.peerMutex.RUnlock()
+	if p == nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(p.Last.Get()) > r.IdleTimeout {
+		return nil
+	}
+	p.Last.Set(now)
+	return p.Addr
+}
+
+func (r *reverseUDPListener) Close() error {
+	return r.PC.Close()
+}
+
+func newReverseManager(h *h3sHandler, udpIO udpIO) *reverseManager {
+	return &reverseManager{
+		h:     h,
+		udpIO: udpIO,
+		ids:   make(map[uint32]struct{}),
+		udp:   make(map[uint32]*reverseUDPListener),
+	}
+}
+
+// handleRequest handles a ReverseRequest on the control stream,
+// and blocks until the listener is closed.
+func (m *reverseManager) handleRequest(stream quic.Stream) {
+	defer stream.Close()
+	req, err := protocol.ReadReverseRequest(stream)
+	if err != nil {
+		return
+	}
+	network := req.Network.String()
+	remoteAddr := m.h.conn.RemoteAddr()
+	el, _ := m.h.config.EventLogger.(ReverseEventLogger)
+	if el != nil {
+		el.ReverseRequest(remoteAddr, m.h.authID, network, req.Addr)
+	}
+	l, err := m.listen(req)
+	if err != nil {
+		_ = protocol.WriteTCPResponse(stream, false, err.Error())
+		if el != nil {
+			el.ReverseError(remoteAddr, m.h.authID, network, req.Addr, err)
+		}
+		return
+	}
+	defer m.remove(req.ID)
+	_ = protocol.WriteTCPResponse(stream, true, "Listening")
+
+	// The listener stays open as long as the control stream does
+	var closedByClient atomic.Bool
+	go func() {
+		_, _ = io.Copy(io.Discard, stream)
+		closedByClient.Store(true)
+		_ = l.Close()
+	}()
+	if req.Network == protocol.ReverseNetworkTCP {
+		err = m.serveTCP(req.ID, l.(net.Listener))
+	} else {
+		err = m.serveUDP(req.ID, l.(*reverseUDPListener))
+	}
+	_ = l.Close()
+	if closedByClient.Load() {
+		err = nil
+	}
+	if el != nil {
+		el.ReverseError(remoteAddr, m.h.authID, network, req.Addr, err)
+	}
+}
+
+// listen checks the request and opens the listener, which is either
+// a net.Listener (TCP) or a *reverseUDPListener (UDP).
+// On success, the caller must remove the ID when done.
+// The listener itself doesn't count as an active request of the connection,
+// so that idle listeners don't hold up a graceful shutdown. Only the
+// connections relayed through it do (see handleTCP).
+func (m *reverseManager) listen(req *protocol.ReverseRequest) (io.Closer, error) {
+	if m.h.config.ReverseAuthorizer == nil {
+		return nil, errReverseDisabled
+	}
+	if req.Network == protocol.ReverseNetworkUDP && m.udpIO == nil {
+		return nil, errReverseUDPDisabled
+	}
+	reqInfo := m.h.requestInfo()
+	if !m.h.config.ReverseAuthorizer.AllowReverse(&reqInfo, req.Network.String(), req.Addr) {
+		return nil, errReverseNotAllowed
+	}
+	m.mutex.Lock()
+	if _, ok := m.ids[req.ID]; ok {
+		m.mutex.Unlock()
+		return nil, errReverseDuplicateID
+	}
+	m.ids[req.ID] = struct{}{}
+	m.mutex.Unlock()
+	if m.h.tracker.Draining() {
+		m.remove(req.ID)
+		return nil, errShuttingDown
+	}
+	var l io.Closer
+	var err error
+	if req.Network == protocol.ReverseNetworkTCP {
+		l, err = net.Listen("tcp", req.Addr)
+	} else {
+		var pc net.PacketConn
+		pc, err = net.ListenPacket("udp", req.Addr)
+		if err == nil {
+			r := &reverseUDPListener{
+				PC:          pc,
+				D:           &frag.Defragger{},
+				IdleTimeout: m.h.config.UDPIdleTimeout,
+				peers:       make(map[string]*reverseUDPPeer),
+			}
+			l = r
+			m.mutex.Lock()
+			m.udp[req.ID] = r
+			m.mutex.Unlock()
+		}
+	}
+	if err != nil {
+		m.remove(req.ID)
+		return nil, err
+	}
+	return l, nil
+}
+
+func (m *reverseManager) remove(id uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.ids, id)
+	delete(m.udp, id)
+}
+
+func (m *reverseManager) serveTCP(id uint32, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.handleTCP(id, conn)
+	}
+}
+
+// handleTCP opens a new stream to the client for an incoming connection,
+// and relays between the two.
+func (m *reverseManager) handleTCP(id uint32, conn net.Conn) {
+	defer conn.Close()
+	if !m.h.tracker.Acquire(m.h.conn) {
+		// Shutting down
+		return
+	}
+	defer m.h.tracker.Release(m.h.conn)
+	qStream, err := m.h.conn.OpenStreamSync(m.h.conn.Context())
+	if err != nil {
+		return
+	}
+	// Wraps the stream with QStream, which handles Close() properly
+	stream := &utils.QStream{Stream: qStream}
+	defer stream.Close()
+	peerAddr := conn.RemoteAddr().String()
+	if err := protocol.WriteReverseConnect(stream, id, peerAddr); err != nil {
+		return
+	}
+	trafficLogger := m.h.config.TrafficLogger
+	if trafficLogger != nil || m.h.config.Limiter != nil {
+		streamStats := &StreamStats{
+			AuthID:      m.h.authID,
+			ConnID:      m.h.connID,
+			InitialTime: time.Now(),
+		}
+		streamStats.State.Store(StreamStateEstablished)
+		streamStats.ReqAddr.Store(peerAddr)
+		streamStats.LastActiveTime.Store(time.Now())
+		if trafficLogger != nil {
+			trafficLogger.TraceStream(stream, streamStats)
+			defer trafficLogger.UntraceStream(stream)
+		}
+		err = copyTwoWayEx(m.h.authID, stream, conn, trafficLogger, m.h.config.Limiter, streamStats)
+		streamStats.State.Store(StreamStateClosed)
+	} else {
+		err = copyTwoWay(stream, conn)
+	}
+	// Disconnect the client if TrafficLogger or Limiter requested
+	if err == errDisconnect {
+		_ = m.h.conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
+	}
+}
+
+// serveUDP sends the packets received by the listener to the client as UDP messages.
+// The messages go through m.udpIO like those of regular sessions, which checks them
+// against the Limiter and logs them with the TrafficLogger (and disconnects the client
+// if either of them requests so, in which case this returns errDisconnect).
+func (m *reverseManager) serveUDP(id uint32, r *reverseUDPListener) error {
+	buf := make([]byte, protocol.MaxUDPSize)
+	msgBuf := make([]byte, protocol.MaxUDPSize)
+	for {
+		n, addr, err := r.PC.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if !r.addPeer(addr) {
+			continue
+		}
+		msg := &protocol.UDPMessage{
+			SessionID: protocol.ReverseSessionIDFlag | id,
+			PacketID:  0,
+			FragID:    0,
+			FragCount: 1,
+			Addr:      addr.String(),
+			Data:      buf[:n],
+		}
+		if err := sendMessageAutoFrag(m.udpIO, msgBuf, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// feedUDP sends a UDP message from the client to the peer of a reverse UDP listener.
+// Messages to addresses that are not known peers of the listener are dropped.
+// It's only called by the UDP receiving goroutine of the connection, after
+// the message has been checked against the Limiter and logged with the TrafficLogger.
+func (m *reverseManager) feedUDP(msg *protocol.UDPMessage) {
+	m.mutex.Lock()
+	r := m.udp[msg.SessionID&^protocol.ReverseSessionIDFlag]
+	m.mutex.Unlock()
+	if r == nil {
+		return
+	}
+	dfMsg := r.D.Feed(msg)
+	if dfMsg == nil {
+		return
+	}
+	addr := r.peer(dfMsg.Addr)
+	if addr == nil {
+		return
+	}
+	_, _ = r.PC.WriteTo(dfMsg.Data, addr)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseUDPListenerPeers(t *testing.T) {
+	r := &reverseUDPListener{
+		IdleTimeout: 200 * time.Millisecond,
+		peers:       make(map[string]*reverseUDPPeer),
+	}
+	peerAddr := func(i int) net.Addr {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 5353}
+	}
+	for i := 0; i < maxReverseUDPPeers; i++ {
+		assert.True(t, r.addPeer(peerAddr(i)))
+	}
+	// Full, known peers are still fine, new ones are not
+	assert.True(t, r.addPeer(peerAddr(0)))
+	newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 5353}
+	assert.False(t, r.addPeer(newAddr))
+	assert.Nil(t, r.peer(newAddr.String()))
+	assert.Equal(t, peerAddr(1), r.peer(peerAddr(1).String()))
+
+	// Keep one peer active while the others expire
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, peerAddr(1), r.peer(peerAddr(1).String()))
+	}
+	assert.Nil(t, r.peer(peerAddr(2).String()))
+
+	// The new peer is accepted now that the others have expired
+	assert.True(t, r.addPeer(newAddr))
+	assert.Equal(t, newAddr, r.peer(newAddr.String()))
+	assert.Equal(t, peerAddr(1), r.peer(peerAddr(1).String()))
+	assert.Len(t, r.peers, 2)
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"math/rand"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/apernet/quic-go"
+	quicCongestion "github.com/apernet/quic-go/congestion"
 	"github.com/apernet/quic-go/http3"
 
 	"github.com/apernet/hysteria/core/v2/internal/congestion"
@@ -19,11 +22,28 @@ import (
 const (
 	closeErrCodeOK                  = 0x100 // HTTP3 ErrCodeNoError
 	closeErrCodeTrafficLimitReached = 0x107 // HTTP3 ErrCodeExcessiveLoad
+	closeErrCodeShutdown            = 0x10b // HTTP3 ErrCodeRequestRejected
 )
 
+var errShuttingDown = errors.New("server is shutting down")
+
 type Server interface {
 	Serve() error
 	Close() error
+	// Shutdown gracefully shuts down the server. It stops accepting new connections,
+	// and rejects new TCP requests, UDP sessions & reverse listeners on existing ones.
+	// Existing TCP requests & UDP sessions (including the connections relayed by
+	// reverse listeners, but not idle listeners) continue until they finish,
+	// or until ctx is done, after which all remaining connections are closed.
+	// Connections are closed with an error code that tells clients to reconnect
+	// (preferably to another server) right away.
+	// The server is closed when Shutdown returns, which returns ctx.Err()
+	// if some connections had to be closed forcibly.
+	Shutdown(ctx context.Context) error
+	// Reload replaces the parts of the config that can be changed without
+	// restarting the server. Existing connections are kept, and requests
+	// that are already in progress continue to use the old config.
+	Reload(config *ReloadConfig) error
 }
 
 func NewServer(config *Config) (Server, error) {
@@ -44,40 +64,128 @@ func NewServer(config *Config) (Server, error) {
 		DisablePathMTUDiscovery:        config.QUICConfig.DisablePathMTUDiscovery,
 		EnableDatagrams:                true,
 	}
-	listener, err := quic.Listen(config.Conn, tlsConfig, quicConfig)
-	if err != nil {
-		_ = config.Conn.Close()
-		return nil, err
+	conns := append([]net.PacketConn{config.Conn}, config.ExtraConns...)
+	transports := make([]*quic.Transport, 0, len(conns))
+	listeners := make([]*quic.Listener, 0, len(conns))
+	for i, conn := range transportConns(conns) {
+		tr := &quic.Transport{Conn: conn}
+		if config.ConnIDSteering > 0 {
+			tr.ConnectionIDGenerator = &steeringConnIDGenerator{Index: i, N: config.ConnIDSteering}
+		}
+		transports = append(transports, tr)
+		l, err := tr.Listen(tlsConfig, quicConfig)
+		if err != nil {
+			_ = closeListeners(listeners, transports, conns)
+			return nil, err
+		}
+		listeners = append(listeners, l)
 	}
+	// Wrap the reloadable parts so that they can be swapped later
+	// without touching the handlers that hold a reference to the config.
+	outbound := newReloadableOutbound(config.Outbound)
+	authenticator := newReloadableAuthenticator(config.Authenticator)
+	config.Outbound = outbound
+	config.Authenticator = authenticator
 	return &serverImpl{
-		config:   config,
-		listener: listener,
+		config:        config,
+		transports:    transports,
+		listeners:     listeners,
+		outbound:      outbound,
+		authenticator: authenticator,
+		tracker:       newConnTracker(),
 	}, nil
 }
 
+// closeListeners closes the listeners, the conns, then the transports.
+// Transports don't close conns they didn't create, and they only stop
+// reading from them for sure once the conns are closed.
+// Returns the error from closing the first listener.
+func closeListeners(listeners []*quic.Listener, transports []*quic.Transport, conns []net.PacketConn) error {
+	var err error
+	for i, l := range listeners {
+		if lErr := l.Close(); i == 0 {
+			err = lErr
+		}
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	for _, tr := range transports {
+		_ = tr.Close()
+	}
+	return err
+}
+
 type serverImpl struct {
-	config   *Config
-	listener *quic.Listener
+	config     *Config
+	transports []*quic.Transport
+	listeners  []*quic.Listener // One for each transport, Config.Conn first, then Config.ExtraConns
+
+	outbound      *reloadableOutbound
+	authenticator *reloadableAuthenticator
+	tracker       *connTracker
 }
 
 func (s *serverImpl) Serve() error {
+	for _, l := range s.listeners[1:] {
+		go func(l *quic.Listener) {
+			// Errors from the extra listeners are ignored,
+			// as they only fail when closed, or when their conns fail.
+			_ = s.serveListener(l)
+		}(l)
+	}
+	return s.serveListener(s.listeners[0])
+}
+
+func (s *serverImpl) serveListener(listener *quic.Listener) error {
 	for {
-		conn, err := s.listener.Accept(context.Background())
+		conn, err := listener.Accept(context.Background())
 		if err != nil {
 			return err
 		}
+		if !s.tracker.Add(conn) {
+			// Shutting down
+			_ = conn.CloseWithError(closeErrCodeShutdown, "")
+			continue
+		}
 		go s.handleClient(conn)
 	}
 }
 
 func (s *serverImpl) Close() error {
-	err := s.listener.Close()
-	_ = s.config.Conn.Close()
+	return closeListeners(s.listeners, s.transports, append([]net.PacketConn{s.config.Conn}, s.config.ExtraConns...))
+}
+
+func (s *serverImpl) Shutdown(ctx context.Context) error {
+	// Stop accepting new connections first. Listeners of a quic.Transport
+	// don't close the connections they have already accepted.
+	for _, l := range s.listeners {
+		_ = l.Close()
+	}
+	var err error
+	select {
+	case <-s.tracker.Drain():
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.tracker.CloseAll()
+	}
+	_ = s.Close()
 	return err
 }
 
+func (s *serverImpl) Reload(config *ReloadConfig) error {
+	if config.Outbound != nil {
+		s.outbound.Swap(config.Outbound)
+	}
+	if config.Authenticator != nil {
+		s.authenticator.Swap(config.Authenticator)
+	}
+	return nil
+}
+
 func (s *serverImpl) handleClient(conn quic.Connection) {
-	handler := newH3sHandler(s.config, conn)
+	defer s.tracker.Remove(conn)
+	handler := newH3sHandler(s.config, conn, s.tracker)
 	h3s := http3.Server{
 		Handler:        handler,
 		StreamHijacker: handler.ProxyStreamHijacker,
@@ -87,6 +195,9 @@ func (s *serverImpl) handleClient(conn quic.Connection) {
 	if handler.authenticated {
 		if tl := s.config.TrafficLogger; tl != nil {
 			tl.LogOnlineState(handler.authID, false)
+			if ct, ok := tl.(CongestionTracer); ok {
+				ct.UntraceCongestion(handler.connID)
+			}
 		}
 		if el := s.config.EventLogger; el != nil {
 			el.Disconnect(conn.RemoteAddr(), handler.authID, err)
@@ -96,22 +207,42 @@ func (s *serverImpl) handleClient(conn quic.Connection) {
 }
 
 type h3sHandler struct {
-	config *Config
-	conn   quic.Connection
+	config  *Config
+	conn    quic.Connection
+	tracker *connTracker
 
 	authenticated bool
 	authMutex     sync.Mutex
 	authID        string
+	authHints     map[string]string
 	connID        uint32 // a random id for dump streams
+	tracingID     quic.ConnectionTracingID
 
-	udpSM *udpSessionManager // Only set after authentication
+	udpSM      *udpSessionManager       // Only set after authentication
+	udpIO      *udpIOImpl               // Only set after authentication
+	reverse    *reverseManager          // Only set after authentication
+	congestion *congestionStatsProvider // Only set after authentication
 }
 
-func newH3sHandler(config *Config, conn quic.Connection) *h3sHandler {
+func newH3sHandler(config *Config, conn quic.Connection, tracker *connTracker) *h3sHandler {
+	tracingID, _ := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
 	return &h3sHandler{
-		config: config,
-		conn:   conn,
-		connID: rand.Uint32(),
+		config:    config,
+		conn:      conn,
+		tracker:   tracker,
+		connID:    rand.Uint32(),
+		tracingID: tracingID,
+	}
+}
+
+// requestInfo returns the RequestInfo for requests from this connection.
+// Must only be called after authentication.
+func (h *h3sHandler) requestInfo() RequestInfo {
+	return RequestInfo{
+		AuthID:    h.authID,
+		Addr:      h.conn.RemoteAddr(),
+		ConnID:    h.connID,
+		TracingID: h.tracingID,
 	}
 }
 
@@ -122,63 +253,94 @@ func (h *h3sHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if h.authenticated {
 			// Already authenticated
 			protocol.AuthResponseToHeader(w.Header(), protocol.AuthResponse{
-				UDPEnabled: !h.config.DisableUDP,
-				Rx:         h.config.BandwidthConfig.MaxRx,
-				RxAuto:     h.config.IgnoreClientBandwidth,
+				UDPEnabled:     !h.config.DisableUDP,
+				Rx:             h.config.BandwidthConfig.MaxRx,
+				RxAuto:         h.config.IgnoreClientBandwidth,
+				Capabilities:   h.capabilities(),
+				UDPMaxSessions: h.config.UDPMaxSessions,
+				Hints:          h.authHints,
 			})
 			w.WriteHeader(protocol.StatusAuthOK)
 			return
 		}
 		authReq := protocol.AuthRequestFromHeader(r.Header)
 		actualTx := authReq.Rx
-		ok, id := h.config.Authenticator.Authenticate(h.conn.RemoteAddr(), authReq.Auth, actualTx)
+		ok, id, hints := toAuthenticatorEx(h.config.Authenticator).AuthenticateEx(h.conn.RemoteAddr(), authReq.Auth, actualTx,
+			Capabilities(authReq.Capabilities))
+		if al, isAL := h.config.TrafficLogger.(AuthLogger); isAL {
+			al.LogAuth(h.conn.RemoteAddr(), id, ok)
+		}
 		if ok {
 			// Set authenticated flag
 			h.authenticated = true
 			h.authID = id
+			h.authHints = hints
+			// Per-user limits on top of the server-wide ones.
+			// Note that the user's rx (from remotes) is what we send to the client,
+			// and the user's tx (to remotes) is what we receive from the client.
+			maxTx, maxRx := h.config.BandwidthConfig.MaxTx, h.config.BandwidthConfig.MaxRx
+			if h.config.Limiter != nil {
+				userTx, userRx := h.config.Limiter.Bandwidth(id)
+				maxTx = minBandwidth(maxTx, userRx)
+				maxRx = minBandwidth(maxRx, userTx)
+			}
 			if h.config.IgnoreClientBandwidth {
-				// Ignore client bandwidth, always use BBR
-				congestion.UseBBR(h.conn)
+				// Ignore client bandwidth, Brutal falls back to BBR
 				actualTx = 0
-			} else {
+			} else if maxTx > 0 && actualTx > maxTx {
 				// actualTx = min(serverTx, clientRx)
-				if h.config.BandwidthConfig.MaxTx > 0 && actualTx > h.config.BandwidthConfig.MaxTx {
-					// We have a maxTx limit and the client is asking for more than that,
-					// return and use the limit instead
-					actualTx = h.config.BandwidthConfig.MaxTx
-				}
-				if actualTx > 0 {
-					congestion.UseBrutal(h.conn, actualTx)
-				} else {
-					// Client doesn't know its own bandwidth, use BBR
-					congestion.UseBBR(h.conn)
-				}
+				// We have a maxTx limit and the client is asking for more than that,
+				// return and use the limit instead
+				actualTx = maxTx
+			}
+			// If the client doesn't know its own bandwidth (actualTx = 0), Brutal falls back to BBR
+			ccType, cc := congestion.Use(h.conn, h.congestionControl(id), actualTx)
+			h.congestion = &congestionStatsProvider{Type: ccType, Rate: actualTx, CC: cc}
+			// Initialize UDP session manager (if UDP is enabled) before sending the response,
+			// as the client may open UDP streams right after receiving it
+			var udpIO *udpIOImpl
+			if !h.config.DisableUDP {
+				udpIO = &udpIOImpl{h.conn, id, h.requestInfo(), h.config.TrafficLogger, h.config.Limiter, h.config.RequestHook, h.config.Outbound, h.tracker, nil}
+				h.reverse = newReverseManager(h, udpIO)
+				udpIO.Reverse = h.reverse
+				h.udpIO = udpIO
+				h.udpSM = newUDPSessionManager(
+					udpIO,
+					&udpEventLoggerImpl{h.conn, id, h.config.EventLogger},
+					h.config.UDPIdleTimeout,
+					h.config.UDPMaxSessions,
+					authReq.Capabilities.Has(protocol.ExtensionUDPClose))
+			} else {
+				h.reverse = newReverseManager(h, nil)
 			}
 			// Auth OK, send response
 			protocol.AuthResponseToHeader(w.Header(), protocol.AuthResponse{
-				UDPEnabled: !h.config.DisableUDP,
-				Rx:         h.config.BandwidthConfig.MaxRx,
-				RxAuto:     h.config.IgnoreClientBandwidth,
+				UDPEnabled:     !h.config.DisableUDP,
+				Rx:             maxRx,
+				RxAuto:         h.config.IgnoreClientBandwidth,
+				Capabilities:   h.capabilities(),
+				UDPMaxSessions: h.config.UDPMaxSessions,
+				Hints:          hints,
 			})
 			w.WriteHeader(protocol.StatusAuthOK)
 			// Call event logger
 			if tl := h.config.TrafficLogger; tl != nil {
 				tl.LogOnlineState(id, true)
+				if ct, ok := tl.(CongestionTracer); ok {
+					ct.TraceCongestion(id, h.connID, h.congestion)
+				}
 			}
 			if el := h.config.EventLogger; el != nil {
 				el.Connect(h.conn.RemoteAddr(), id, actualTx)
 			}
-			// Initialize UDP session manager (if UDP is enabled)
-			// We use sync.Once to make sure that only one goroutine is started,
-			// as ServeHTTP may be called by multiple goroutines simultaneously
-			if !h.config.DisableUDP {
+			// Start the UDP session manager (if UDP is enabled)
+			if sm := h.udpSM; sm != nil {
 				go func() {
-					sm := newUDPSessionManager(
-						&udpIOImpl{h.conn, id, h.config.TrafficLogger, h.config.RequestHook, h.config.Outbound},
-						&udpEventLoggerImpl{h.conn, id, h.config.EventLogger},
-						h.config.UDPIdleTimeout)
-					h.udpSM = sm
-					go sm.Run()
+					if t, ok := h.config.TrafficLogger.(UDPSessionTracer); ok {
+						t.TraceUDPSessions(id, h.connID, sm)
+						defer t.UntraceUDPSessions(h.connID)
+					}
+					_ = sm.Run()
 				}()
 			}
 		} else {
@@ -191,6 +353,53 @@ func (h *h3sHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// congestionControl returns the congestion control type for the user,
+// which the authenticator can override.
+func (h *h3sHandler) congestionControl(id string) string {
+	if cca, ok := h.config.Authenticator.(CongestionControlAuthenticator); ok {
+		if t := cca.CongestionControl(id); t != "" && congestion.IsValidType(t) {
+			return t
+		}
+	}
+	return h.config.CongestionControl
+}
+
+// congestionStatsProvider reports the state of the congestion control set for a connection.
+type congestionStatsProvider struct {
+	Type string
+	Rate uint64
+	CC   quicCongestion.CongestionControl
+}
+
+func (p *congestionStatsProvider) CongestionStats() CongestionStats {
+	stats := CongestionStats{Type: p.Type}
+	if p.Type == CongestionControlBrutal || p.Type == CongestionControlAdaptiveBrutal {
+		stats.Rate = p.Rate
+	}
+	if as, ok := congestion.AdaptiveStats(p.CC); ok {
+		stats.Adaptive = &AdaptiveBrutalStats{
+			State:        as.State.String(),
+			RateCap:      as.Rate,
+			DeliveryRate: as.DeliveryRate,
+			LossRate:     as.LossRate,
+			Backoffs:     as.Backoffs,
+		}
+	}
+	return stats
+}
+
+// capabilities returns the capabilities of the server advertised to the client.
+func (h *h3sHandler) capabilities() protocol.Capabilities {
+	caps := protocol.Capabilities{Version: protocol.CapVersion}
+	if !h.config.DisableUDP {
+		caps.Extensions = append(caps.Extensions, protocol.ExtensionUDPClose, protocol.ExtensionUDPStream)
+	}
+	if h.config.ReverseAuthorizer != nil {
+		caps.Extensions = append(caps.Extensions, protocol.ExtensionReverse)
+	}
+	return caps
+}
+
 func (h *h3sHandler) ProxyStreamHijacker(ft http3.FrameType, id quic.ConnectionTracingID, stream quic.Stream, err error) (bool, error) {
 	if err != nil || !h.authenticated {
 		return false, nil
@@ -201,7 +410,24 @@ func (h *h3sHandler) ProxyStreamHijacker(ft http3.FrameType, id quic.ConnectionT
 
 	switch ft {
 	case protocol.FrameTypeTCPRequest:
-		go h.handleTCPRequest(stream)
+		if !h.tracker.Acquire(h.conn) {
+			// Shutting down, reject new requests
+			go func() {
+				_ = protocol.WriteTCPResponse(stream, false, errShuttingDown.Error())
+				_ = stream.Close()
+			}()
+			return true, nil
+		}
+		go func() {
+			defer h.tracker.Release(h.conn)
+			h.handleTCPRequest(stream)
+		}()
+		return true, nil
+	case protocol.FrameTypeReverseRequest:
+		go h.reverse.handleRequest(stream)
+		return true, nil
+	case protocol.FrameTypeUDPStream:
+		go h.handleUDPStream(stream)
 		return true, nil
 	default:
 		return false, nil
@@ -233,17 +459,19 @@ func (h *h3sHandler) handleTCPRequest(stream quic.Stream) {
 	}
 	streamStats.ReqAddr.Store(reqAddr)
 	// Call the hook if set
+	reqInfo := h.requestInfo()
 	var putback []byte
 	var hooked bool
 	if h.config.RequestHook != nil {
-		hooked = h.config.RequestHook.Check(false, reqAddr)
+		hook := toRequestHookEx(h.config.RequestHook)
+		hooked = hook.CheckEx(&reqInfo, false, reqAddr)
 		// When the hook is enabled, the server should always accept a connection
 		// so that the client will send whatever request the hook wants to see.
 		// This is essentially a server-side fast-open.
 		if hooked {
 			streamStats.State.Store(StreamStateHooking)
 			_ = protocol.WriteTCPResponse(stream, true, "RequestHook enabled")
-			putback, err = h.config.RequestHook.TCP(stream, &reqAddr)
+			putback, err = hook.TCPEx(&reqInfo, stream, &reqAddr)
 			if err != nil {
 				_ = stream.Close()
 				return
@@ -257,7 +485,7 @@ func (h *h3sHandler) handleTCPRequest(stream quic.Stream) {
 	}
 	// Dial target
 	streamStats.State.Store(StreamStateConnecting)
-	tConn, err := h.config.Outbound.TCP(reqAddr)
+	tConn, err := toOutboundEx(h.config.Outbound).TCPEx(&reqInfo, reqAddr)
 	if err != nil {
 		if !hooked {
 			_ = protocol.WriteTCPResponse(stream, false, err.Error())
@@ -279,10 +507,10 @@ func (h *h3sHandler) handleTCPRequest(stream quic.Stream) {
 		streamStats.Tx.Add(uint64(n))
 	}
 	// Start proxying
-	if trafficLogger != nil {
-		err = copyTwoWayEx(h.authID, stream, tConn, trafficLogger, streamStats)
+	if trafficLogger != nil || h.config.Limiter != nil {
+		err = copyTwoWayEx(h.authID, stream, tConn, trafficLogger, h.config.Limiter, streamStats)
 	} else {
-		// Use the fast path if no traffic logger is set
+		// Use the fast path if no traffic logger or limiter is set
 		err = copyTwoWay(stream, tConn)
 	}
 	if h.config.EventLogger != nil {
@@ -291,12 +519,34 @@ func (h *h3sHandler) handleTCPRequest(stream quic.Stream) {
 	// Cleanup
 	_ = tConn.Close()
 	_ = stream.Close()
-	// Disconnect the client if TrafficLogger requested
+	// Disconnect the client if TrafficLogger or Limiter requested
 	if err == errDisconnect {
 		_ = h.conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
 	}
 }
 
+func (h *h3sHandler) handleUDPStream(stream quic.Stream) {
+	if h.udpSM == nil {
+		// UDP disabled
+		_ = stream.Close()
+		return
+	}
+	sessionID, err := protocol.ReadUDPStreamRequest(stream)
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	h.udpSM.feedStream(sessionID, &udpStreamIOImpl{stream, h.udpIO})
+}
+
+// minBandwidth returns the smaller of two bandwidth values, where 0 means unlimited.
+func minBandwidth(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
 func (h *h3sHandler) masqHandler(w http.ResponseWriter, r *http.Request) {
 	if h.config.MasqHandler != nil {
 		h.config.MasqHandler.ServeHTTP(w, r)
@@ -306,13 +556,17 @@ func (h *h3sHandler) masqHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// udpIOImpl is the IO implementation for udpSessionManager with TrafficLogger support
+// udpIOImpl is the IO implementation for udpSessionManager with TrafficLogger & Limiter support
 type udpIOImpl struct {
 	Conn          quic.Connection
 	AuthID        string
+	RequestInfo   RequestInfo // Template, SessionID is set for each session
 	TrafficLogger TrafficLogger
+	Limiter       Limiter
 	RequestHook   RequestHook
 	Outbound      Outbound
+	Tracker       *connTracker
+	Reverse       *reverseManager
 }
 
 func (io *udpIOImpl) ReceiveMessage() (*protocol.UDPMessage, error) {
@@ -327,26 +581,31 @@ func (io *udpIOImpl) ReceiveMessage() (*protocol.UDPMessage, error) {
 			// Invalid message, this is fine - just wait for the next
 			continue
 		}
-		if io.TrafficLogger != nil {
-			ok := io.TrafficLogger.LogTraffic(io.AuthID, uint64(len(udpMsg.Data)), 0)
-			if !ok {
-				// TrafficLogger requested to disconnect the client
-				_ = io.Conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
-				return nil, errDisconnect
+		allow, err := io.allowTraffic(uint64(len(udpMsg.Data)), 0)
+		if err != nil {
+			return nil, err
+		}
+		if !allow {
+			// Over the rate limit, drop the message
+			continue
+		}
+		if udpMsg.SessionID&protocol.ReverseSessionIDFlag != 0 {
+			// Belongs to a reverse UDP listener, not a regular session.
+			// Reverse listeners are closed with their control streams instead.
+			if io.Reverse != nil && !udpMsg.IsClose() {
+				io.Reverse.feedUDP(udpMsg)
 			}
+			continue
 		}
 		return udpMsg, nil
 	}
 }
 
 func (io *udpIOImpl) SendMessage(buf []byte, msg *protocol.UDPMessage) error {
-	if io.TrafficLogger != nil {
-		ok := io.TrafficLogger.LogTraffic(io.AuthID, 0, uint64(len(msg.Data)))
-		if !ok {
-			// TrafficLogger requested to disconnect the client
-			_ = io.Conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
-			return errDisconnect
-		}
+	allow, err := io.allowTraffic(0, uint64(len(msg.Data)))
+	if err != nil || !allow {
+		// Over the rate limit, silent drop
+		return err
 	}
 	msgN := msg.Serialize(buf)
 	if msgN < 0 {
@@ -356,16 +615,98 @@ func (io *udpIOImpl) SendMessage(buf []byte, msg *protocol.UDPMessage) error {
 	return io.Conn.SendDatagram(buf[:msgN])
 }
 
-func (io *udpIOImpl) Hook(data []byte, reqAddr *string) error {
-	if io.RequestHook != nil && io.RequestHook.Check(true, *reqAddr) {
-		return io.RequestHook.UDP(data, reqAddr)
+// allowTraffic checks UDP traffic against the Limiter and logs it with the TrafficLogger.
+// Returns false if the traffic is over the rate limit and should be dropped,
+// or errDisconnect (after closing the connection) if either of them requested so.
+func (io *udpIOImpl) allowTraffic(tx, rx uint64) (bool, error) {
+	if io.Limiter != nil {
+		allow, ok := io.Limiter.AllowTraffic(io.AuthID, tx, rx)
+		if !ok {
+			// Limiter requested to disconnect the client
+			_ = io.Conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
+			return false, errDisconnect
+		}
+		if !allow {
+			return false, nil
+		}
+	}
+	if io.TrafficLogger != nil {
+		ok := io.TrafficLogger.LogTraffic(io.AuthID, tx, rx)
+		if !ok {
+			// TrafficLogger requested to disconnect the client
+			_ = io.Conn.CloseWithError(closeErrCodeTrafficLimitReached, "")
+			return false, errDisconnect
+		}
+	}
+	return true, nil
+}
+
+func (io *udpIOImpl) Hook(sessionID uint32, data []byte, reqAddr *string) error {
+	if io.RequestHook == nil {
+		return nil
+	}
+	info := io.RequestInfo
+	info.SessionID = sessionID
+	hook := toRequestHookEx(io.RequestHook)
+	if hook.CheckEx(&info, true, *reqAddr) {
+		return hook.UDPEx(&info, data, reqAddr)
 	} else {
 		return nil
 	}
 }
 
-func (io *udpIOImpl) UDP(reqAddr string) (UDPConn, error) {
-	return io.Outbound.UDP(reqAddr)
+func (io *udpIOImpl) UDP(sessionID uint32, reqAddr string) (UDPConn, error) {
+	if !io.Tracker.Acquire(io.Conn) {
+		// Shutting down, reject new sessions
+		return nil, errShuttingDown
+	}
+	info := io.RequestInfo
+	info.SessionID = sessionID
+	conn, err := toOutboundEx(io.Outbound).UDPEx(&info, reqAddr)
+	if err != nil {
+		io.Tracker.Release(io.Conn)
+		return nil, err
+	}
+	return &trackedUDPConn{
+		UDPConn: conn,
+		Release: func() { io.Tracker.Release(io.Conn) },
+	}, nil
+}
+
+// udpStreamIOImpl is the stream counterpart of udpIOImpl, for sessions carried over streams.
+type udpStreamIOImpl struct {
+	Stream quic.Stream
+	IO     *udpIOImpl
+}
+
+func (io *udpStreamIOImpl) ReceiveMessage() (*protocol.UDPMessage, error) {
+	for {
+		msg, err := protocol.ReadUDPStreamPacket(io.Stream)
+		if err != nil {
+			return nil, err
+		}
+		allow, err := io.IO.allowTraffic(uint64(len(msg.Data)), 0)
+		if err != nil {
+			return nil, err
+		}
+		if allow {
+			return msg, nil
+		}
+		// Over the rate limit, drop the message
+	}
+}
+
+func (io *udpStreamIOImpl) SendMessage(buf []byte, msg *protocol.UDPMessage) error {
+	allow, err := io.IO.allowTraffic(0, uint64(len(msg.Data)))
+	if err != nil || !allow {
+		// Over the rate limit, silent drop
+		return err
+	}
+	return protocol.WriteUDPStreamPacket(io.Stream, buf, msg)
+}
+
+func (io *udpStreamIOImpl) Close() error {
+	return io.Stream.Close()
 }
 
 type udpEventLoggerImpl struct {
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/apernet/quic-go"
+)
+
+// connTracker keeps track of the connections and their active requests
+// (TCP streams & UDP sessions) for graceful shutdown.
+// Once draining, new connections and requests are rejected, and each connection
+// is closed as soon as it has no active requests left.
+type connTracker struct {
+	mutex    sync.Mutex
+	draining bool
+	conns    map[quic.Connection]int // active requests per connection
+	doneCh   chan struct{}           // closed when draining and no connection is left
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:  make(map[quic.Connection]int),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Add registers a new connection. It returns false if the server is draining,
+// in which case the caller should close the connection right away.
+func (t *connTracker) Add(conn quic.Connection) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.draining {
+		return false
+	}
+	t.conns[conn] = 0
+	return true
+}
+
+// Remove unregisters a connection after it has been closed.
+func (t *connTracker) Remove(conn quic.Connection) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.conns, conn)
+	t.checkDone()
+}
+
+// Acquire registers a new request on the connection.
+// It returns false if the server is draining, in which case the request should be rejected.
+// Every successful Acquire must be followed by a Release when the request is done.
+func (t *connTracker) Acquire(conn quic.Connection) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.conns[conn]; !ok || t.draining {
+		return false
+	}
+	t.conns[conn]++
+	return true
+}
+
+// Draining returns whether the server is draining.
+func (t *connTracker) Draining() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.draining
+}
+
+func (t *connTracker) Release(conn quic.Connection) {
+	t.mutex.Lock()
+	n, ok := t.conns[conn]
+	if !ok {
+		t.mutex.Unlock()
+		return
+	}
+	n--
+	t.conns[conn] = n
+	closeNow := t.draining && n == 0
+	t.mutex.Unlock()
+	if closeNow {
+		_ = conn.CloseWithError(closeErrCodeShutdown, "")
+	}
+}
+
+// Drain starts draining, and closes all connections that have no active requests.
+// The returned channel is closed once all connections are gone.
+func (t *connTracker) Drain() <-chan struct{} {
+	t.mutex.Lock()
+	t.draining = true
+	var idle []quic.Connection
+	for conn, n := range t.conns {
+		if n == 0 {
+			idle = append(idle, conn)
+		}
+	}
+	t.checkDone()
+	t.mutex.Unlock()
+	for _, conn := range idle {
+		_ = conn.CloseWithError(closeErrCodeShutdown, "")
+	}
+	return t.doneCh
+}
+
+// CloseAll closes all connections regardless of their active requests.
+func (t *connTracker) CloseAll() {
+	t.mutex.Lock()
+	conns := make([]quic.Connection, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mutex.Unlock()
+	for _, conn := range conns {
+		_ = conn.CloseWithError(closeErrCodeShutdown, "")
+	}
+}
+
+// checkDone must be called with the mutex held.
+func (t *connTracker) checkDone() {
+	if t.draining && len(t.conns) == 0 && !isClosedChan(t.doneCh) {
+		close(t.doneCh)
+	}
+}
+
+func isClosedChan(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackedUDPConn releases its request from the connTracker when closed.
+type trackedUDPConn struct {
+	UDPConn
+	Release  func()
+	released atomic.Bool
+}
+
+func (c *trackedUDPConn) Close() error {
+	if !c.released.Swap(true) {
+		defer c.Release()
+	}
+	return c.UDPConn.Close()
+}
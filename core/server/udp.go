@@ -15,13 +15,25 @@ import (
 
 const (
 	idleCleanupInterval = 1 * time.Second
+
+	maxRejectedUDPSessions = 1024
 )
 
+var errTooManyUDPSessions = errors.New("too many UDP sessions")
+
 type udpIO interface {
 	ReceiveMessage() (*protocol.UDPMessage, error)
 	SendMessage([]byte, *protocol.UDPMessage) error
-	Hook(data []byte, reqAddr *string) error
-	UDP(reqAddr string) (UDPConn, error)
+	Hook(sessionID uint32, data []byte, reqAddr *string) error
+	UDP(sessionID uint32, reqAddr string) (UDPConn, error)
+}
+
+// udpStreamIO is a stream carrying a single UDP session (see protocol.FrameTypeUDPStream).
+// The messages it returns don't have the session ID set.
+type udpStreamIO interface {
+	ReceiveMessage() (*protocol.UDPMessage, error)
+	SendMessage([]byte, *protocol.UDPMessage) error
+	Close() error
 }
 
 type udpEventLogger interface {
@@ -41,6 +53,7 @@ type udpSessionEntry struct {
 	ExitFunc func(err error)
 
 	conn     UDPConn
+	stream   udpStreamIO // Set when the session is carried over a stream
 	connLock sync.Mutex
 	closed   bool
 }
@@ -64,7 +77,8 @@ func newUDPSessionEntry(
 }
 
 // CloseWithErr closes the session and calls ExitFunc with the given error.
-// A nil error indicates the session is cleaned up due to timeout.
+// A nil error indicates the session is cleaned up due to timeout,
+// or closed by the client.
 func (e *udpSessionEntry) CloseWithErr(err error) {
 	// We need this lock to ensure not to create conn after session exit
 	e.connLock.Lock()
@@ -79,11 +93,36 @@ func (e *udpSessionEntry) CloseWithErr(err error) {
 	if e.conn != nil {
 		_ = e.conn.Close()
 	}
+	if e.stream != nil {
+		_ = e.stream.Close()
+	}
 	e.connLock.Unlock()
 
 	e.ExitFunc(err)
 }
 
+// SetStream makes the session send its packets over the stream from now on,
+// replacing (and closing) the previous stream if any.
+// The stream is closed right away if the session is already closed.
+func (e *udpSessionEntry) SetStream(stream udpStreamIO) {
+	e.connLock.Lock()
+	defer e.connLock.Unlock()
+	if e.closed {
+		_ = stream.Close()
+		return
+	}
+	if e.stream != nil {
+		_ = e.stream.Close()
+	}
+	e.stream = stream
+}
+
+func (e *udpSessionEntry) getStream() udpStreamIO {
+	e.connLock.Lock()
+	defer e.connLock.Unlock()
+	return e.stream
+}
+
 // Feed feeds a UDP message to the session.
 // If the message itself is a complete message, or it completes a fragmented message,
 // the message is written to the session's UDP connection, and the number of bytes
@@ -121,6 +160,11 @@ func (e *udpSessionEntry) initConn(firstMsg *protocol.UDPMessage) error {
 		e.connLock.Unlock()
 		return errors.New("session is closed")
 	}
+	if e.conn != nil {
+		// Initialized by a message from the other IO (datagrams or stream)
+		e.connLock.Unlock()
+		return nil
+	}
 
 	conn, actualAddr, err := e.DialFunc(firstMsg.Addr, firstMsg.Data)
 	if err != nil {
@@ -146,13 +190,17 @@ func (e *udpSessionEntry) initConn(firstMsg *protocol.UDPMessage) error {
 }
 
 // receiveLoop receives incoming UDP packets, packs them into UDP messages,
-// and sends using the IO.
+// and sends using the IO, or the stream if the session has one.
 // Exit when either the underlying UDP connection returns error (e.g. closed),
 // or the IO returns error when sending.
 func (e *udpSessionEntry) receiveLoop() {
 	udpBuf := make([]byte, protocol.MaxUDPSize)
 	msgBuf := make([]byte, protocol.MaxUDPSize)
 	for {
+		if len(udpBuf) < protocol.MaxUDPStreamSize && e.getStream() != nil {
+			// Packets of any size can be sent over a stream
+			udpBuf = make([]byte, protocol.MaxUDPStreamSize)
+		}
 		udpN, rAddr, err := e.conn.ReadFrom(udpBuf)
 		if err != nil {
 			e.CloseWithErr(err)
@@ -175,7 +223,11 @@ func (e *udpSessionEntry) receiveLoop() {
 			Addr:      rAddr,
 			Data:      udpBuf[:udpN],
 		}
-		err = sendMessageAutoFrag(e.IO, msgBuf, msg)
+		if stream := e.getStream(); stream != nil {
+			err = stream.SendMessage(msgBuf, msg)
+		} else {
+			err = sendMessageAutoFrag(e.IO, msgBuf, msg)
+		}
 		if err != nil {
 			e.CloseWithErr(err)
 			return
@@ -209,22 +261,37 @@ func sendMessageAutoFrag(io udpIO, buf []byte, msg *protocol.UDPMessage) error {
 // Each UDP session is identified by a SessionID, and corresponds to a UDP connection.
 // A UDP session is created when a UDP message with a new SessionID is received.
 // Similar to standard NAT, a UDP session is destroyed when no UDP message is received
-// for a certain period of time (specified by idleTimeout).
+// for a certain period of time (specified by idleTimeout), or when the client closes it
+// with a UDPClose message, or closes the stream of the session.
+// A session can be carried over a stream (see feedStream) from the start,
+// or switched to one later by the client, in which case it keeps its UDP connection.
+// New sessions are rejected when there are already maxSessions (0 = unlimited) sessions.
+// If sendClose is true (the client supports UDPClose), the client is notified of the rejection.
+// Either is done once per session ID, the rest of the messages of a rejected session are
+// dropped silently (the client keeps sending them if it doesn't support UDPClose).
 type udpSessionManager struct {
 	io          udpIO
 	eventLogger udpEventLogger
 	idleTimeout time.Duration
+	maxSessions int
+	sendClose   bool
 
-	mutex sync.RWMutex
-	m     map[uint32]*udpSessionEntry
+	mutex    sync.RWMutex
+	m        map[uint32]*udpSessionEntry
+	rejected map[uint32]struct{} // IDs of rejected sessions, up to maxRejectedUDPSessions
 }
 
-func newUDPSessionManager(io udpIO, eventLogger udpEventLogger, idleTimeout time.Duration) *udpSessionManager {
+func newUDPSessionManager(io udpIO, eventLogger udpEventLogger, idleTimeout time.Duration,
+	maxSessions int, sendClose bool,
+) *udpSessionManager {
 	return &udpSessionManager{
 		io:          io,
 		eventLogger: eventLogger,
 		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		sendClose:   sendClose,
 		m:           make(map[uint32]*udpSessionEntry),
+		rejected:    make(map[uint32]struct{}),
 	}
 }
 
@@ -241,7 +308,33 @@ func (m *udpSessionManager) Run() error {
 		if err != nil {
 			return err
 		}
-		m.feed(msg)
+		m.feed(msg, nil)
+	}
+}
+
+// feedStream feeds the messages from the stream to the session with the given ID,
+// attaching the stream to the session, until the stream is closed.
+// The session is closed along with the stream, and vice versa.
+func (m *udpSessionManager) feedStream(sessionID uint32, stream udpStreamIO) {
+	var entry *udpSessionEntry
+	for {
+		msg, err := stream.ReceiveMessage()
+		if err != nil {
+			// Closed by the client, or the connection is gone
+			_ = stream.Close()
+			if entry != nil && entry.getStream() == stream {
+				// Not replaced by another stream
+				entry.CloseWithErr(nil)
+			}
+			return
+		}
+		msg.SessionID = sessionID
+		entry = m.feed(msg, stream)
+		if entry == nil {
+			// Rejected
+			_ = stream.Close()
+			return
+		}
 	}
 }
 
@@ -278,16 +371,41 @@ func (m *udpSessionManager) cleanup(idleOnly bool) {
 	}
 }
 
-func (m *udpSessionManager) feed(msg *protocol.UDPMessage) {
+// feed feeds a message to its session, creating the session if needed.
+// If stream is not nil, the message is from that stream, which is attached
+// to the session. Returns the session, or nil if it's closed or rejected.
+func (m *udpSessionManager) feed(msg *protocol.UDPMessage, stream udpStreamIO) *udpSessionEntry {
 	m.mutex.RLock()
 	entry := m.m[msg.SessionID]
 	m.mutex.RUnlock()
 
+	if msg.IsClose() {
+		// Closed by the client
+		if entry != nil {
+			entry.CloseWithErr(nil)
+		} else {
+			m.mutex.Lock()
+			delete(m.rejected, msg.SessionID)
+			m.mutex.Unlock()
+		}
+		return nil
+	}
+
 	// Create a new session if not exists
 	if entry == nil {
+		if m.maxSessions > 0 && m.Count() >= m.maxSessions {
+			if m.reject(msg.SessionID) {
+				m.eventLogger.Close(msg.SessionID, errTooManyUDPSessions)
+				if m.sendClose && stream == nil {
+					closeMsg := &protocol.UDPMessage{SessionID: msg.SessionID}
+					_ = m.io.SendMessage(make([]byte, closeMsg.Size()), closeMsg)
+				}
+			}
+			return nil
+		}
 		dialFunc := func(addr string, firstMsgData []byte) (conn UDPConn, actualAddr string, err error) {
 			// Call the hook
-			err = m.io.Hook(firstMsgData, &addr)
+			err = m.io.Hook(msg.SessionID, firstMsgData, &addr)
 			if err != nil {
 				return
 			}
@@ -295,7 +413,7 @@ func (m *udpSessionManager) feed(msg *protocol.UDPMessage) {
 			// Log the event
 			m.eventLogger.New(msg.SessionID, addr)
 			// Dial target
-			conn, err = m.io.UDP(addr)
+			conn, err = m.io.UDP(msg.SessionID, addr)
 			return
 		}
 		exitFunc := func(err error) {
@@ -313,13 +431,36 @@ func (m *udpSessionManager) feed(msg *protocol.UDPMessage) {
 		// Insert the session into the map
 		m.mutex.Lock()
 		m.m[msg.SessionID] = entry
+		delete(m.rejected, msg.SessionID)
 		m.mutex.Unlock()
 	}
 
+	if stream != nil && entry.getStream() != stream {
+		entry.SetStream(stream)
+	}
+
 	// Feed the message to the session
 	// Feed (send) errors are ignored for now,
 	// as some are temporary (e.g. invalid address)
 	_, _ = entry.Feed(msg)
+	return entry
+}
+
+// reject records the session ID as rejected,
+// and returns false if it already was.
+func (m *udpSessionManager) reject(id uint32) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.rejected[id]; ok {
+		return false
+	}
+	if len(m.rejected) >= maxRejectedUDPSessions {
+		// The client keeps opening sessions we can't take, start over
+		// rather than growing without bound
+		clear(m.rejected)
+	}
+	m.rejected[id] = struct{}{}
+	return true
 }
 
 func (m *udpSessionManager) Count() int {
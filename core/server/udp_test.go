@@ -15,7 +15,7 @@ import (
 func TestUDPSessionManager(t *testing.T) {
 	io := newMockUDPIO(t)
 	eventLogger := newMockUDPEventLogger(t)
-	sm := newUDPSessionManager(io, eventLogger, 2*time.Second)
+	sm := newUDPSessionManager(io, eventLogger, 2*time.Second, 0, false)
 
 	msgCh := make(chan *protocol.UDPMessage, 4)
 	io.EXPECT().ReceiveMessage().RunAndReturn(func() (*protocol.UDPMessage, error) {
@@ -49,8 +49,8 @@ func TestUDPSessionManager(t *testing.T) {
 	eventLogger.EXPECT().New(msg1.SessionID, msg1.Addr).Return().Once()
 	udpConn1 := newMockUDPConn(t)
 	udpConn1Ch := make(chan []byte, 1)
-	io.EXPECT().Hook(msg1.Data, &msg1.Addr).Return(nil).Once()
-	io.EXPECT().UDP(msg1.Addr).Return(udpConn1, nil).Once()
+	io.EXPECT().Hook(msg1.SessionID, msg1.Data, &msg1.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg1.SessionID, msg1.Addr).Return(udpConn1, nil).Once()
 	udpConn1.EXPECT().WriteTo(msg1.Data, msg1.Addr).Return(5, nil).Once()
 	udpConn1.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(b []byte) (int, string, error) {
 		return udpReadFunc(msg1.Addr, udpConn1Ch, b)
@@ -88,8 +88,8 @@ func TestUDPSessionManager(t *testing.T) {
 	udpConn2 := newMockUDPConn(t)
 	udpConn2Ch := make(chan []byte, 1)
 	// On fragmentation, make sure hook gets the whole message
-	io.EXPECT().Hook(msg2data, &msg2_1.Addr).Return(nil).Once()
-	io.EXPECT().UDP(msg2_1.Addr).Return(udpConn2, nil).Once()
+	io.EXPECT().Hook(msg2_1.SessionID, msg2data, &msg2_1.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg2_1.SessionID, msg2_1.Addr).Return(udpConn2, nil).Once()
 	udpConn2.EXPECT().WriteTo(msg2data, msg2_1.Addr).Return(11, nil).Once()
 	udpConn2.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(b []byte) (int, string, error) {
 		return udpReadFunc(msg2_1.Addr, udpConn2Ch, b)
@@ -153,8 +153,8 @@ func TestUDPSessionManager(t *testing.T) {
 	}
 	eventLogger.EXPECT().New(msg4.SessionID, msg4.Addr).Return().Once()
 	udpConn4 := newMockUDPConn(t)
-	io.EXPECT().Hook(msg4.Data, &msg4.Addr).Return(nil).Once()
-	io.EXPECT().UDP(msg4.Addr).Return(udpConn4, nil).Once()
+	io.EXPECT().Hook(msg4.SessionID, msg4.Data, &msg4.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg4.SessionID, msg4.Addr).Return(udpConn4, nil).Once()
 	udpConn4.EXPECT().WriteTo(msg4.Data, msg4.Addr).Return(12, nil).Once()
 	udpConn4.EXPECT().ReadFrom(mock.Anything).Return(0, "", errUDPClosed).Once()
 	udpConn4.EXPECT().Close().Return(nil).Once()
@@ -175,8 +175,8 @@ func TestUDPSessionManager(t *testing.T) {
 		Data:      []byte("babe i miss you"),
 	}
 	eventLogger.EXPECT().New(msg5.SessionID, msg5.Addr).Return().Once()
-	io.EXPECT().Hook(msg5.Data, &msg5.Addr).Return(nil).Once()
-	io.EXPECT().UDP(msg5.Addr).Return(nil, errUDPIO).Once()
+	io.EXPECT().Hook(msg5.SessionID, msg5.Data, &msg5.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg5.SessionID, msg5.Addr).Return(nil, errUDPIO).Once()
 	eventLogger.EXPECT().Close(msg5.SessionID, errUDPIO).Once()
 	msgCh <- msg5
 
@@ -189,3 +189,178 @@ func TestUDPSessionManager(t *testing.T) {
 	assert.Zero(t, sm.Count(), "session count should be 0")
 	goleak.VerifyNone(t)
 }
+
+func TestUDPSessionManagerCloseAndLimit(t *testing.T) {
+	io := newMockUDPIO(t)
+	eventLogger := newMockUDPEventLogger(t)
+	sm := newUDPSessionManager(io, eventLogger, 60*time.Second, 1, true)
+
+	msgCh := make(chan *protocol.UDPMessage, 4)
+	io.EXPECT().ReceiveMessage().RunAndReturn(func() (*protocol.UDPMessage, error) {
+		m := <-msgCh
+		if m == nil {
+			return nil, errors.New("closed")
+		}
+		return m, nil
+	})
+
+	go sm.Run()
+
+	msg1 := &protocol.UDPMessage{
+		SessionID: 1,
+		PacketID:  0,
+		FragID:    0,
+		FragCount: 1,
+		Addr:      "dns.example.com:53",
+		Data:      []byte("query"),
+	}
+	eventLogger.EXPECT().New(msg1.SessionID, msg1.Addr).Return().Once()
+	udpConn1 := newMockUDPConn(t)
+	udpConn1Ch := make(chan []byte)
+	io.EXPECT().Hook(msg1.SessionID, msg1.Data, &msg1.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg1.SessionID, msg1.Addr).Return(udpConn1, nil).Once()
+	udpConn1.EXPECT().WriteTo(msg1.Data, msg1.Addr).Return(5, nil).Once()
+	udpConn1.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(b []byte) (int, string, error) {
+		<-udpConn1Ch
+		return 0, "", errors.New("closed")
+	})
+	msgCh <- msg1
+
+	// Over the limit, rejected & the client is notified, only once for the session
+	msg2 := &protocol.UDPMessage{
+		SessionID: 2,
+		PacketID:  0,
+		FragID:    0,
+		FragCount: 1,
+		Addr:      "dns.example.com:53",
+		Data:      []byte("another query"),
+	}
+	eventLogger.EXPECT().Close(msg2.SessionID, errTooManyUDPSessions).Once()
+	io.EXPECT().SendMessage(mock.Anything, &protocol.UDPMessage{SessionID: msg2.SessionID}).Return(nil).Once()
+	msgCh <- msg2
+	msgCh <- msg2
+	msgCh <- msg2
+
+	time.Sleep(500 * time.Millisecond)
+	mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1)
+	assert.Equal(t, 1, sm.Count())
+
+	// Closed by the client
+	udpConn1.EXPECT().Close().RunAndReturn(func() error {
+		close(udpConn1Ch)
+		return nil
+	}).Once()
+	eventLogger.EXPECT().Close(msg1.SessionID, nil).Once()
+	msgCh <- &protocol.UDPMessage{SessionID: msg1.SessionID}
+
+	time.Sleep(500 * time.Millisecond)
+	mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1)
+	assert.Zero(t, sm.Count(), "session count should be 0")
+
+	// Leak checks
+	close(msgCh)
+	time.Sleep(500 * time.Millisecond)
+	goleak.VerifyNone(t)
+}
+
+func TestUDPSessionManagerStream(t *testing.T) {
+	io := newMockUDPIO(t)
+	eventLogger := newMockUDPEventLogger(t)
+	sm := newUDPSessionManager(io, eventLogger, 60*time.Second, 0, true)
+
+	msgCh := make(chan *protocol.UDPMessage, 4)
+	io.EXPECT().ReceiveMessage().RunAndReturn(func() (*protocol.UDPMessage, error) {
+		m := <-msgCh
+		if m == nil {
+			return nil, errors.New("closed")
+		}
+		return m, nil
+	})
+
+	go sm.Run()
+
+	// Session starts with datagrams
+	msg1 := &protocol.UDPMessage{
+		SessionID: 1,
+		PacketID:  0,
+		FragID:    0,
+		FragCount: 1,
+		Addr:      "game.example.com:7777",
+		Data:      []byte("join"),
+	}
+	eventLogger.EXPECT().New(msg1.SessionID, msg1.Addr).Return().Once()
+	udpConn1 := newMockUDPConn(t)
+	udpConn1Ch := make(chan []byte, 1)
+	io.EXPECT().Hook(msg1.SessionID, msg1.Data, &msg1.Addr).Return(nil).Once()
+	io.EXPECT().UDP(msg1.SessionID, msg1.Addr).Return(udpConn1, nil).Once()
+	udpConn1.EXPECT().WriteTo(msg1.Data, msg1.Addr).Return(4, nil).Once()
+	udpConn1.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(b []byte) (int, string, error) {
+		bs, ok := <-udpConn1Ch
+		if !ok {
+			return 0, "", errors.New("closed")
+		}
+		return copy(b, bs), msg1.Addr, nil
+	})
+	msgCh <- msg1
+
+	time.Sleep(500 * time.Millisecond)
+	mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1)
+
+	// Switched to a stream, the session keeps its UDP connection
+	stream := newMockUDPStreamIO(t)
+	streamCh := make(chan *protocol.UDPMessage, 1)
+	stream.EXPECT().ReceiveMessage().RunAndReturn(func() (*protocol.UDPMessage, error) {
+		m := <-streamCh
+		if m == nil {
+			return nil, errors.New("closed")
+		}
+		return m, nil
+	})
+	largeData := make([]byte, 10000)
+	streamMsg := &protocol.UDPMessage{
+		FragCount: 1,
+		Addr:      msg1.Addr,
+		Data:      largeData,
+	}
+	udpConn1.EXPECT().WriteTo(largeData, msg1.Addr).Return(len(largeData), nil).Once()
+	streamCh <- streamMsg
+	go sm.feedStream(msg1.SessionID, stream)
+
+	time.Sleep(500 * time.Millisecond)
+	mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1, stream)
+	assert.Equal(t, 1, sm.Count())
+
+	// Replies go over the stream, and can be larger than datagrams after the first one
+	for _, data := range [][]byte{[]byte("welcome"), largeData} {
+		stream.EXPECT().SendMessage(mock.Anything, &protocol.UDPMessage{
+			SessionID: msg1.SessionID,
+			PacketID:  0,
+			FragID:    0,
+			FragCount: 1,
+			Addr:      msg1.Addr,
+			Data:      data,
+		}).Return(nil).Once()
+		udpConn1Ch <- data
+
+		time.Sleep(500 * time.Millisecond)
+		mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1, stream)
+	}
+
+	// Stream closed by the client, so is the session
+	stream.EXPECT().Close().Return(nil)
+	udpConn1.EXPECT().Close().RunAndReturn(func() error {
+		close(udpConn1Ch)
+		return nil
+	}).Once()
+	eventLogger.EXPECT().Close(msg1.SessionID, nil).Once()
+	close(streamCh)
+
+	time.Sleep(500 * time.Millisecond)
+	mock.AssertExpectationsForObjects(t, io, eventLogger, udpConn1, stream)
+	assert.Zero(t, sm.Count(), "session count should be 0")
+
+	// Leak checks
+	close(msgCh)
+	time.Sleep(500 * time.Millisecond)
+	goleak.VerifyNone(t)
+}
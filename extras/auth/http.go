@@ -8,22 +8,46 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/apernet/hysteria/core/v2/server"
+	"github.com/apernet/hysteria/extras/v2/limiter"
+	"github.com/apernet/hysteria/extras/v2/outbounds"
 )
 
 const (
 	httpAuthTimeout = 10 * time.Second
 )
 
-var _ server.Authenticator = &HTTPAuthenticator{}
+var (
+	_ server.Authenticator   = &HTTPAuthenticator{}
+	_ server.AuthenticatorEx = &HTTPAuthenticator{}
+
+	_ server.CongestionControlAuthenticator = &HTTPAuthenticator{}
+)
 
 var errInvalidStatusCode = errors.New("invalid status code")
 
 type HTTPAuthenticator struct {
 	Client *http.Client
 	URL    string
+	// Limiter, if set, receives the per-user limits returned by the auth server.
+	// A response without a limit removes the user's limit, if any.
+	Limiter *limiter.Limiter
+	// Groups, if set, receives the per-user groups returned by the auth server,
+	// for routing users to different outbound pipelines.
+	Groups *outbounds.UserGroups
+
+	ccMutex sync.Mutex
+	cc      map[string]*pendingCongestion // User ID -> congestion control type not yet picked up by the server
+}
+
+// pendingCongestion is the congestion control type returned by the auth server for a user,
+// and the number of successful authentications of the user that haven't picked it up yet.
+type pendingCongestion struct {
+	Type    string
+	Pending int
 }
 
 func NewHTTPAuthenticator(url string, insecure bool) *HTTPAuthenticator {
@@ -41,14 +65,29 @@ func NewHTTPAuthenticator(url string, insecure bool) *HTTPAuthenticator {
 }
 
 type httpAuthRequest struct {
-	Addr string `json:"addr"`
-	Auth string `json:"auth"`
-	Tx   uint64 `json:"tx"`
+	Addr       string   `json:"addr"`
+	Auth       string   `json:"auth"`
+	Tx         uint64   `json:"tx"`
+	Extensions []string `json:"extensions,omitempty"` // Protocol extensions supported by the client
 }
 
 type httpAuthResponse struct {
-	OK bool   `json:"ok"`
-	ID string `json:"id"`
+	OK    bool              `json:"ok"`
+	ID    string            `json:"id"`
+	Group string            `json:"group"`
+	Limit *httpAuthLimit    `json:"limit"`
+	Hints map[string]string `json:"hints"` // Sent to the client as is
+	// Congestion, if set, overrides the server's congestion control for this user
+	Congestion string `json:"congestion"`
+}
+
+// httpAuthLimit uses the same perspective as the server's bandwidth config:
+// up is from the server to the client, down is from the client to the server.
+type httpAuthLimit struct {
+	Up         uint64 `json:"up"`         // bytes per second
+	Down       uint64 `json:"down"`       // bytes per second
+	Quota      uint64 `json:"quota"`      // bytes
+	QuotaReset string `json:"quotaReset"` // "daily", "weekly", "monthly" or "never"
 }
 
 func (a *HTTPAuthenticator) post(req *httpAuthRequest) (*httpAuthResponse, error) {
@@ -77,14 +116,82 @@ func (a *HTTPAuthenticator) post(req *httpAuthRequest) (*httpAuthResponse, error
 }
 
 func (a *HTTPAuthenticator) Authenticate(addr net.Addr, auth string, tx uint64) (ok bool, id string) {
+	ok, id, _ = a.AuthenticateEx(addr, auth, tx, server.Capabilities{})
+	return
+}
+
+func (a *HTTPAuthenticator) AuthenticateEx(addr net.Addr, auth string, tx uint64, caps server.Capabilities) (ok bool, id string, hints map[string]string) {
 	req := &httpAuthRequest{
-		Addr: addr.String(),
-		Auth: auth,
-		Tx:   tx,
+		Addr:       addr.String(),
+		Auth:       auth,
+		Tx:         tx,
+		Extensions: caps.Extensions,
 	}
 	resp, err := a.post(req)
 	if err != nil {
-		return false, ""
+		return false, "", nil
+	}
+	if resp.OK && a.Limiter != nil {
+		if resp.Limit == nil {
+			// No longer limited
+			a.Limiter.SetLimit(resp.ID, limiter.Limit{})
+		} else {
+			period, err := limiter.ParseQuotaPeriod(resp.Limit.QuotaReset)
+			if err != nil {
+				// Refuse rather than let the user in without their limits
+				return false, "", nil
+			}
+			a.Limiter.SetLimit(resp.ID, limiter.Limit{
+				Tx:          resp.Limit.Down,
+				Rx:          resp.Limit.Up,
+				Quota:       resp.Limit.Quota,
+				QuotaPeriod: period,
+			})
+		}
+	}
+	if resp.OK && a.Groups != nil {
+		a.Groups.Set(resp.ID, resp.Group)
+	}
+	if resp.OK {
+		a.setCongestionControl(resp.ID, resp.Congestion)
+	}
+	if !resp.OK {
+		return false, resp.ID, nil
+	}
+	return true, resp.ID, resp.Hints
+}
+
+// CongestionControl returns the congestion control type the auth server returned
+// when this user just authenticated, or "" if there was none.
+// The server calls it once after each successful authentication, and the type is
+// forgotten as soon as all of them have picked it up, so that we don't keep any
+// state for users that are not in the middle of authenticating.
+func (a *HTTPAuthenticator) CongestionControl(id string) string {
+	a.ccMutex.Lock()
+	defer a.ccMutex.Unlock()
+	p := a.cc[id]
+	if p == nil {
+		return ""
+	}
+	p.Pending--
+	if p.Pending <= 0 {
+		delete(a.cc, id)
+	}
+	return p.Type
+}
+
+func (a *HTTPAuthenticator) setCongestionControl(id, t string) {
+	a.ccMutex.Lock()
+	defer a.ccMutex.Unlock()
+	if a.cc == nil {
+		a.cc = make(map[string]*pendingCongestion)
+	}
+	p := a.cc[id]
+	if p == nil {
+		p = &pendingCongestion{}
+		a.cc[id] = p
 	}
-	return resp.OK, resp.ID
+	// The latest type wins for all authentications in progress
+	p.Type = t
+	p.Pending++
 }
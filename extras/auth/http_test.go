@@ -7,6 +7,10 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/server"
+	"github.com/apernet/hysteria/extras/v2/limiter"
+	"github.com/apernet/hysteria/extras/v2/outbounds"
 )
 
 func TestHTTPAuthenticator(t *testing.T) {
@@ -19,6 +23,8 @@ func TestHTTPAuthenticator(t *testing.T) {
 	time.Sleep(1 * time.Second) // Wait for the server to start
 
 	auth := NewHTTPAuthenticator("http://127.0.0.1:5000/auth", false)
+	auth.Limiter = limiter.NewLimiter(nil)
+	auth.Groups = outbounds.NewUserGroups()
 
 	ok, id := auth.Authenticate(&net.UDPAddr{
 		IP:   net.ParseIP("1.2.3.4"),
@@ -33,4 +39,44 @@ func TestHTTPAuthenticator(t *testing.T) {
 	}, "wahaha", 12345)
 	assert.True(t, ok)
 	assert.Equal(t, "some_unique_id", id)
+	assert.Equal(t, "", auth.CongestionControl("some_unique_id"))
+
+	ok, id = auth.Authenticate(&net.UDPAddr{
+		IP:   net.ParseIP("1.2.3.4"),
+		Port: 34567,
+	}, "limited", 0)
+	assert.True(t, ok)
+	assert.Equal(t, "limited_user", id)
+	tx, rx := auth.Limiter.Bandwidth("limited_user")
+	assert.Equal(t, uint64(1000000), tx)
+	assert.Equal(t, uint64(2000000), rx)
+	group, ok := auth.Groups.Get("limited_user")
+	assert.True(t, ok)
+	assert.Equal(t, "premium", group)
+	assert.Equal(t, server.CongestionControlBBR3, auth.CongestionControl("limited_user"))
+	// Only kept until the server picks it up
+	assert.Equal(t, "", auth.CongestionControl("limited_user"))
+	assert.Empty(t, auth.cc)
+
+	// The limit is removed when a later response has none
+	ok, id = auth.Authenticate(&net.UDPAddr{
+		IP:   net.ParseIP("1.2.3.4"),
+		Port: 34567,
+	}, "unlimited", 0)
+	assert.True(t, ok)
+	assert.Equal(t, "limited_user", id)
+	tx, rx = auth.Limiter.Bandwidth("limited_user")
+	assert.Zero(t, tx)
+	assert.Zero(t, rx)
+
+	ok, id, hints := auth.AuthenticateEx(&net.UDPAddr{
+		IP:   net.ParseIP("1.2.3.4"),
+		Port: 34567,
+	}, "hinted", 0, server.Capabilities{
+		Version:    1,
+		Extensions: []string{server.ExtensionUDPClose},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "hinted_user", id)
+	assert.Equal(t, map[string]string{"motd": "hello there"}, hints)
 }
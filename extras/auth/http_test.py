@@ -13,9 +13,31 @@ def auth():
     addr = data.get("addr", "")
     auth = data.get("auth", "")
     tx = data.get("tx", 0)
+    extensions = data.get("extensions", [])
 
     if addr == "123.123.123.123:5566" and auth == "wahaha" and tx == 12345:
         return jsonify({"ok": True, "id": "some_unique_id"})
+    elif auth == "hinted" and "udp-close" in extensions:
+        return jsonify(
+            {"ok": True, "id": "hinted_user", "hints": {"motd": "hello there"}}
+        )
+    elif auth == "limited":
+        return jsonify(
+            {
+                "ok": True,
+                "id": "limited_user",
+                "group": "premium",
+                "congestion": "bbr3",
+                "limit": {
+                    "up": 2000000,
+                    "down": 1000000,
+                    "quota": 100000000000,
+                    "quotaReset": "monthly",
+                },
+            }
+        )
+    elif auth == "unlimited":
+        return jsonify({"ok": True, "id": "limited_user"})
     else:
         return jsonify({"ok": False, "id": ""})
 
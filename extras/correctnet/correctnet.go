@@ -1,6 +1,7 @@
 package correctnet
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
@@ -82,6 +83,22 @@ func ListenUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
 	return net.ListenUDP(network, laddr)
 }
 
+// ListenUDPConfig is ListenUDP with a net.ListenConfig, e.g. for setting socket options.
+func ListenUDPConfig(lc *net.ListenConfig, network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+	if network == "udp" {
+		network = udpAddrNetwork(laddr)
+	}
+	var address string
+	if laddr != nil {
+		address = laddr.String()
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
 func HTTPListenAndServe(address string, handler http.Handler) error {
 	listener, err := Listen("tcp", address)
 	if err != nil {
@@ -0,0 +1,65 @@
+// Package deadline implements read/write deadlines for conns that don't
+// have an underlying socket to set them on.
+package deadline
+
+import (
+	"sync"
+	"time"
+)
+
+// Deadline is a deadline that can be waited on. Same as the one used by net.Pipe.
+type Deadline struct {
+	mutex  sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // Closed when the deadline is exceeded
+}
+
+func New() *Deadline {
+	return &Deadline{cancel: make(chan struct{})}
+}
+
+// Set sets the deadline. A zero value for t means no deadline.
+func (d *Deadline) Set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// Wait returns a channel that is closed when the deadline is exceeded.
+func (d *Deadline) Wait() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,69 @@
+// Package udpbatch provides batched reads & writes of UDP packets, using
+// recvmmsg/sendmmsg and UDP GRO/GSO on Linux.
+//
+// quic-go only uses its batched I/O & offloads on a conn that has the methods of
+// a *net.UDPConn (see quic.OOBCapablePacketConn), and reads & writes one packet
+// at a time on anything else, like our obfs & udphop wrappers.
+// The wrappers use this to read packets in batches from the underlying sockets
+// instead. The obfs wrapper of a *net.UDPConn also passes the GSO batches quic-go
+// writes with WriteMsgUDP on to the socket, one segment obfuscated at a time.
+package udpbatch
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// BatchSize is the max number of messages per syscall.
+	BatchSize = 32
+
+	// MaxPacketSize is the max size of a UDP packet,
+	// and also of a GSO/GRO batch of segments.
+	MaxPacketSize = 65535
+
+	// OOBSize is enough for the control messages of a packet,
+	// i.e. GRO/GSO, ECN & packet info. Same as quic-go.
+	OOBSize = 128
+)
+
+// Message is a single UDP packet (or segment), in Buffers[0].
+// It's the same type as quic-go uses for its batched reads, so that
+// a Conn is used by quic-go as is.
+type Message = ipv4.Message
+
+// Conn is a net.PacketConn that can also read multiple packets per call.
+type Conn interface {
+	net.PacketConn
+	// ReadBatch blocks until it can read at least one packet, and returns the
+	// number of packets read into ms. The N of each is set to the size of
+	// the packet in Buffers[0], which is truncated if it doesn't fit, and
+	// the NN to the size of the control messages in OOB, if any.
+	// No flags are supported.
+	ReadBatch(ms []Message, flags int) (int, error)
+}
+
+// BatchWriter is implemented by a Conn that can also write multiple packets per call.
+type BatchWriter interface {
+	// WriteBatch writes the packets in ms, each with the control messages
+	// in its OOB, and returns the number written.
+	// No flags are supported.
+	WriteBatch(ms []Message, flags int) (int, error)
+}
+
+// Wrap returns conn as a Conn if it supports batched I/O, i.e. if it's already
+// a Conn, or a *net.UDPConn on Linux. Otherwise it returns nil.
+//
+// Once wrapped, a *net.UDPConn must only be read from through the returned Conn,
+// as it may have GRO enabled, which makes the kernel coalesce packets.
+func Wrap(conn net.PacketConn) Conn {
+	switch c := conn.(type) {
+	case Conn:
+		return c
+	case *net.UDPConn:
+		return newMmsgConn(c)
+	default:
+		return nil
+	}
+}
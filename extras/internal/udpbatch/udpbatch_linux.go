@@ -0,0 +1,270 @@
+//go:build linux
+
+package udpbatch
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readBufferSize = 2048 // QUIC packets are at most 1500 bytes long, so 2k should be more than enough
+	groBatchSize   = 8    // With GRO, each buffer is MaxPacketSize, so read fewer at a time
+)
+
+var (
+	_ Conn        = (*mmsgConn)(nil)
+	_ BatchWriter = (*mmsgConn)(nil)
+)
+
+// batchConn is implemented by both ipv4.PacketConn & ipv6.PacketConn,
+// as ipv4.Message & ipv6.Message are the same type.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// segment is a packet, or a GRO segment of one, not yet returned by ReadBatch.
+type segment struct {
+	Buf  []byte
+	OOB  []byte // Of the whole packet
+	Addr net.Addr
+}
+
+// mmsgConn reads & writes with recvmmsg & sendmmsg, and GRO when supported.
+// Writes with a GSO control message are passed through to the socket as is.
+type mmsgConn struct {
+	UDPConn *net.UDPConn
+
+	bc  batchConn
+	gro bool
+
+	readMutex sync.Mutex
+	readMsgs  []ipv4.Message
+	segBuf    []segment
+	segs      []segment // From the last read, not yet returned
+	readOne   [1]Message
+	readIov   [1][]byte
+}
+
+func newMmsgConn(conn *net.UDPConn) Conn {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var gro bool
+	err = rawConn.Control(func(fd uintptr) {
+		gro = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1) == nil
+	})
+	if err != nil {
+		return nil
+	}
+	c := &mmsgConn{
+		UDPConn: conn,
+		gro:     gro,
+	}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		c.bc = ipv4.NewPacketConn(conn)
+	} else {
+		c.bc = ipv6.NewPacketConn(conn)
+	}
+	batchSize, bufSize := BatchSize, readBufferSize
+	if gro {
+		batchSize, bufSize = groBatchSize, MaxPacketSize
+	}
+	c.readMsgs = make([]ipv4.Message, batchSize)
+	for i := range c.readMsgs {
+		c.readMsgs[i].Buffers = [][]byte{make([]byte, bufSize)}
+		c.readMsgs[i].OOB = make([]byte, OOBSize)
+	}
+	c.segBuf = make([]segment, 0, batchSize)
+	return c
+}
+
+func (c *mmsgConn) ReadBatch(ms []Message, flags int) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	return c.readBatch(ms)
+}
+
+func (c *mmsgConn) readBatch(ms []Message) (int, error) {
+	if len(c.segs) == 0 {
+		if err := c.read(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for n < len(ms) && len(c.segs) > 0 {
+		seg := c.segs[0]
+		c.segs = c.segs[1:]
+		m := &ms[n]
+		m.N = copy(m.Buffers[0], seg.Buf)
+		m.NN = copy(m.OOB, seg.OOB)
+		m.Flags = 0
+		m.Addr = seg.Addr
+		n++
+	}
+	return n, nil
+}
+
+// read reads a batch from the socket into segs, splitting GRO coalesced packets.
+func (c *mmsgConn) read() error {
+	n, err := c.bc.ReadBatch(c.readMsgs, 0)
+	if err != nil {
+		return err
+	}
+	segs := c.segBuf[:0]
+	for i := 0; i < n; i++ {
+		m := &c.readMsgs[i]
+		buf, oob := m.Buffers[0][:m.N], m.OOB[:m.NN]
+		size := len(buf)
+		if c.gro && len(oob) > 0 {
+			if s := groSegmentSize(oob); s > 0 {
+				size = s
+			}
+		}
+		if size == 0 {
+			segs = append(segs, segment{Buf: buf, OOB: oob, Addr: m.Addr})
+			continue
+		}
+		for len(buf) > 0 {
+			l := min(size, len(buf))
+			segs = append(segs, segment{Buf: buf[:l], OOB: oob, Addr: m.Addr})
+			buf = buf[l:]
+		}
+	}
+	c.segBuf = segs[:0]
+	c.segs = segs
+	return nil
+}
+
+func groSegmentSize(oob []byte) int {
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, cmsg := range cmsgs {
+		if cmsg.Header.Level == unix.IPPROTO_UDP && cmsg.Header.Type == unix.UDP_GRO && len(cmsg.Data) >= 4 {
+			return int(binary.NativeEndian.Uint32(cmsg.Data))
+		}
+	}
+	return 0
+}
+
+func (c *mmsgConn) WriteBatch(ms []Message, flags int) (int, error) {
+	written := 0
+	for written < len(ms) {
+		// sendmmsg may return before all messages are sent
+		n, err := c.bc.WriteBatch(ms[written:], 0)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// SplitGSO returns the segment size in the UDP_SEGMENT control message in oob,
+// or 0 if there is none, and appends the other control messages to dst.
+func SplitGSO(oob, dst []byte) (size int, rest []byte) {
+	rest = dst
+	for len(oob) > 0 {
+		h, data, remainder, err := unix.ParseOneSocketControlMessage(oob)
+		if err != nil {
+			// Leave what we can't parse to the kernel
+			return size, append(rest, oob...)
+		}
+		if h.Level == unix.IPPROTO_UDP && h.Type == unix.UDP_SEGMENT && len(data) >= 2 {
+			size = int(binary.NativeEndian.Uint16(data))
+		} else {
+			rest = append(rest, oob[:len(oob)-len(remainder)]...)
+		}
+		oob = remainder
+	}
+	return size, rest
+}
+
+// AppendGSO appends a UDP_SEGMENT control message with the segment size to oob.
+func AppendGSO(oob []byte, size int) []byte {
+	start := len(oob)
+	oob = append(oob, make([]byte, unix.CmsgSpace(2))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[start]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[start+unix.CmsgSpace(0):], uint16(size))
+	return oob
+}
+
+func (c *mmsgConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, _, _, uAddr, err := c.ReadMsgUDP(p, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, uAddr, nil
+}
+
+func (c *mmsgConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	return c.UDPConn.WriteTo(p, addr)
+}
+
+func (c *mmsgConn) Close() error {
+	return c.UDPConn.Close()
+}
+
+func (c *mmsgConn) LocalAddr() net.Addr {
+	return c.UDPConn.LocalAddr()
+}
+
+func (c *mmsgConn) SetDeadline(t time.Time) error {
+	return c.UDPConn.SetDeadline(t)
+}
+
+func (c *mmsgConn) SetReadDeadline(t time.Time) error {
+	return c.UDPConn.SetReadDeadline(t)
+}
+
+func (c *mmsgConn) SetWriteDeadline(t time.Time) error {
+	return c.UDPConn.SetWriteDeadline(t)
+}
+
+// UDP-specific methods below
+
+func (c *mmsgConn) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	c.readIov[0] = b
+	c.readOne[0] = Message{Buffers: c.readIov[:], OOB: oob}
+	_, err = c.readBatch(c.readOne[:])
+	m := c.readOne[0]
+	c.readIov[0], c.readOne[0] = nil, Message{} // Don't keep b & oob
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	addr, _ = m.Addr.(*net.UDPAddr)
+	return m.N, m.NN, m.Flags, addr, nil
+}
+
+func (c *mmsgConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error) {
+	return c.UDPConn.WriteMsgUDP(b, oob, addr)
+}
+
+func (c *mmsgConn) SetReadBuffer(bytes int) error {
+	return c.UDPConn.SetReadBuffer(bytes)
+}
+
+func (c *mmsgConn) SetWriteBuffer(bytes int) error {
+	return c.UDPConn.SetWriteBuffer(bytes)
+}
+
+func (c *mmsgConn) SyscallConn() (syscall.RawConn, error) {
+	return c.UDPConn.SyscallConn()
+}
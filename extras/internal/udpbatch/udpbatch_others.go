@@ -0,0 +1,22 @@
+//go:build !linux
+
+package udpbatch
+
+import (
+	"net"
+)
+
+func newMmsgConn(conn *net.UDPConn) Conn {
+	// Not supported, x/net would only read & write one packet per syscall anyway
+	return nil
+}
+
+// SplitGSO returns 0 and oob appended to dst, as GSO is only supported on Linux.
+func SplitGSO(oob, dst []byte) (size int, rest []byte) {
+	return 0, append(dst, oob...)
+}
+
+// AppendGSO returns oob as is, as GSO is only supported on Linux.
+func AppendGSO(oob []byte, size int) []byte {
+	return oob
+}
@@ -0,0 +1,197 @@
+package udpbatch
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listenBatch(t testing.TB, addr string) (*net.UDPConn, Conn) {
+	uAddr, err := net.ResolveUDPAddr("udp", addr)
+	assert.NoError(t, err)
+	conn, err := net.ListenUDP("udp", uAddr)
+	assert.NoError(t, err)
+	return listenBatchConn(t, conn)
+}
+
+func listenBatchConn(t testing.TB, conn *net.UDPConn) (*net.UDPConn, Conn) {
+	bc := Wrap(conn)
+	if bc == nil {
+		_ = conn.Close()
+		t.Skip("batched I/O not supported on this platform")
+	}
+	return conn, bc
+}
+
+func newMessages(n, size int) []Message {
+	ms := make([]Message, n)
+	for i := range ms {
+		ms[i].Buffers = [][]byte{make([]byte, size)}
+		ms[i].OOB = make([]byte, OOBSize)
+	}
+	return ms
+}
+
+func TestConn(t *testing.T) {
+	sConn, sBatch := listenBatch(t, "127.0.0.1:0")
+	defer sBatch.Close()
+	// Dual-stack, sending to an IPv4 address
+	_, cBatch := listenBatch(t, ":0")
+	defer cBatch.Close()
+
+	// Same sizes, to be coalesced with GRO where possible, and odd ones out
+	var sizes []int
+	for i := 0; i < 100; i++ {
+		sizes = append(sizes, 1200)
+	}
+	sizes = append(sizes, 500, 1, 1400, 1400, 1300)
+	msgs := make([]Message, len(sizes))
+	for i, size := range sizes {
+		msgs[i] = Message{
+			Buffers: [][]byte{bytes.Repeat([]byte{byte(i)}, size)},
+			Addr:    sConn.LocalAddr(),
+		}
+	}
+	_ = sBatch.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	rMsgs := newMessages(7, 2048)
+	received := 0
+	// In chunks, so that the socket buffer doesn't overflow,
+	// alternating between WriteBatch & WriteTo
+	for sent := 0; sent < len(msgs); {
+		chunk := msgs[sent:min(len(msgs), sent+20)]
+		if (sent/20)%2 == 0 {
+			n, err := cBatch.(BatchWriter).WriteBatch(chunk, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, len(chunk), n)
+		} else {
+			for _, m := range chunk {
+				_, err := cBatch.WriteTo(m.Buffers[0], m.Addr)
+				assert.NoError(t, err)
+			}
+		}
+		sent += len(chunk)
+		for received < sent {
+			n, err := sBatch.ReadBatch(rMsgs, 0)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Greater(t, n, 0)
+			for _, m := range rMsgs[:n] {
+				assert.Equal(t, msgs[received].Buffers[0], m.Buffers[0][:m.N])
+				assert.Equal(t, cBatch.LocalAddr().(*net.UDPAddr).Port, m.Addr.(*net.UDPAddr).Port)
+				received++
+			}
+		}
+	}
+
+	// ReadFrom
+	_, err := cBatch.WriteTo([]byte("single"), sConn.LocalAddr())
+	assert.NoError(t, err)
+	buf := make([]byte, 2048)
+	n, _, err := sBatch.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "single", string(buf[:n]))
+}
+
+func TestGSO(t *testing.T) {
+	oob := AppendGSO(nil, 1200)
+	if len(oob) == 0 {
+		t.Skip("GSO not supported on this platform")
+	}
+	// The other control messages are kept
+	other := []byte{1, 2, 3}
+	size, rest := SplitGSO(append(oob, other...), nil)
+	assert.Equal(t, 1200, size)
+	assert.Equal(t, other, rest)
+	size, rest = SplitGSO(other, nil)
+	assert.Equal(t, 0, size)
+	assert.Equal(t, other, rest)
+
+	sConn, sBatch := listenBatch(t, "127.0.0.1:0")
+	defer sBatch.Close()
+	cConn, cBatch := listenBatch(t, "127.0.0.1:0")
+	defer cBatch.Close()
+	_ = sBatch.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// 3 full segments & a short one
+	var data []byte
+	for i := 0; i < 4; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, 1200)...)
+	}
+	data = data[:len(data)-200]
+	_, _, err := cBatch.(interface {
+		WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+	}).WriteMsgUDP(data, oob, sConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Skipf("GSO not supported: %v", err)
+	}
+	rMsgs := newMessages(4, 2048)
+	for received := 0; received < 4; {
+		n, err := sBatch.ReadBatch(rMsgs, 0)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, m := range rMsgs[:n] {
+			seg := data[received*1200 : min(len(data), (received+1)*1200)]
+			assert.Equal(t, seg, m.Buffers[0][:m.N])
+			assert.Equal(t, cConn.LocalAddr().String(), m.Addr.String())
+			received++
+		}
+	}
+}
+
+const benchPacketSize = 1200
+
+func BenchmarkRead(b *testing.B) {
+	bench := func(b *testing.B, wrap bool, read func(sConn net.PacketConn) int) {
+		sConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(b, err)
+		_ = sConn.SetReadBuffer(4 << 20)
+		var sPacketConn net.PacketConn = sConn
+		if wrap {
+			_, sPacketConn = listenBatchConn(b, sConn)
+		}
+		defer sPacketConn.Close()
+		cConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(b, err)
+		defer cConn.Close()
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go func() {
+			// Flood
+			data := make([]byte, benchPacketSize)
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+					_, _ = cConn.WriteTo(data, sConn.LocalAddr())
+				}
+			}
+		}()
+		b.SetBytes(benchPacketSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; {
+			i += read(sPacketConn)
+		}
+	}
+
+	b.Run("ReadFrom", func(b *testing.B) {
+		buf := make([]byte, 2048)
+		bench(b, false, func(sConn net.PacketConn) int {
+			_, _, _ = sConn.ReadFrom(buf)
+			return 1
+		})
+	})
+	b.Run("ReadBatch", func(b *testing.B) {
+		msgs := newMessages(BatchSize, 2048)
+		bench(b, true, func(sConn net.PacketConn) int {
+			n, _ := sConn.(Conn).ReadBatch(msgs, 0)
+			return n
+		})
+	})
+}
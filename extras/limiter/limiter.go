@@ -0,0 +1,266 @@
+package limiter
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+const (
+	// burstDuration is how much traffic (in terms of time at the limited rate)
+	// a user can send in a burst after being idle.
+	burstDuration = 250 * time.Millisecond
+	// minBurst makes sure we can always send at least one full read buffer
+	// in a burst, even when the rate limit is very low.
+	minBurst = 64 * 1024
+)
+
+var _ server.Limiter = &Limiter{}
+
+var errInvalidQuotaPeriod = errors.New("invalid quota period")
+
+// QuotaPeriod determines how often a user's used quota is reset.
+type QuotaPeriod int
+
+const (
+	QuotaPeriodNever QuotaPeriod = iota
+	QuotaPeriodDaily
+	QuotaPeriodWeekly
+	QuotaPeriodMonthly
+)
+
+// ParseQuotaPeriod parses "daily", "weekly", "monthly", or "never"/"" into a QuotaPeriod.
+func ParseQuotaPeriod(s string) (QuotaPeriod, error) {
+	switch strings.ToLower(s) {
+	case "", "never":
+		return QuotaPeriodNever, nil
+	case "daily", "day":
+		return QuotaPeriodDaily, nil
+	case "weekly", "week":
+		return QuotaPeriodWeekly, nil
+	case "monthly", "month":
+		return QuotaPeriodMonthly, nil
+	default:
+		return QuotaPeriodNever, errInvalidQuotaPeriod
+	}
+}
+
+// start returns the start of the period that t is in.
+// Weeks start on Monday. All periods are in local time.
+func (p QuotaPeriod) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch p {
+	case QuotaPeriodDaily:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case QuotaPeriodWeekly:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case QuotaPeriodMonthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// Limit describes the limits of a single user.
+// Tx/Rx follow the same definition as in server.TrafficLogger (server-remote perspective).
+type Limit struct {
+	Tx          uint64 // bytes per second, 0 = unlimited
+	Rx          uint64 // bytes per second, 0 = unlimited
+	Quota       uint64 // total bytes (tx + rx) per period, 0 = unlimited
+	QuotaPeriod QuotaPeriod
+}
+
+// Limiter is a server.Limiter that enforces per-user rate limits with token buckets,
+// and per-user traffic quotas. Users without a limit are not limited.
+// Used quota is only kept in memory and does not survive restarts.
+type Limiter struct {
+	Mutex sync.RWMutex
+	Users map[string]*userLimiter
+
+	now func() time.Time
+}
+
+func NewLimiter(limits map[string]Limit) *Limiter {
+	l := &Limiter{
+		Users: make(map[string]*userLimiter, len(limits)),
+		now:   time.Now,
+	}
+	for id, limit := range limits {
+		l.SetLimit(id, limit)
+	}
+	return l
+}
+
+// SetLimit sets or replaces the limit of a user.
+// The quota the user has already used in the current period is kept.
+// A zero Limit removes all limits from the user.
+func (l *Limiter) SetLimit(id string, limit Limit) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if limit == (Limit{}) {
+		delete(l.Users, id)
+		return
+	}
+	if u, ok := l.Users[id]; ok {
+		u.SetLimit(limit, l.now())
+	} else {
+		u = &userLimiter{}
+		u.SetLimit(limit, l.now())
+		l.Users[id] = u
+	}
+}
+
+func (l *Limiter) user(id string) *userLimiter {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	return l.Users[id]
+}
+
+func (l *Limiter) WaitTraffic(id string, tx, rx uint64) (ok bool) {
+	u := l.user(id)
+	if u == nil {
+		return true
+	}
+	d, ok := u.Reserve(tx, rx, l.now())
+	if !ok {
+		return false
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return true
+}
+
+func (l *Limiter) AllowTraffic(id string, tx, rx uint64) (allow, ok bool) {
+	u := l.user(id)
+	if u == nil {
+		return true, true
+	}
+	return u.Allow(tx, rx, l.now())
+}
+
+func (l *Limiter) Bandwidth(id string) (tx, rx uint64) {
+	u := l.user(id)
+	if u == nil {
+		return 0, 0
+	}
+	u.Mutex.Lock()
+	defer u.Mutex.Unlock()
+	return u.Limit.Tx, u.Limit.Rx
+}
+
+type userLimiter struct {
+	Mutex       sync.Mutex
+	Limit       Limit
+	TxBucket    tokenBucket
+	RxBucket    tokenBucket
+	Used        uint64
+	PeriodStart time.Time
+}
+
+func (u *userLimiter) SetLimit(limit Limit, now time.Time) {
+	u.Mutex.Lock()
+	defer u.Mutex.Unlock()
+	u.Limit = limit
+	u.TxBucket.SetRate(limit.Tx, now)
+	u.RxBucket.SetRate(limit.Rx, now)
+	if start := limit.QuotaPeriod.start(now); !start.Equal(u.PeriodStart) {
+		u.PeriodStart = start
+		u.Used = 0
+	}
+}
+
+// useQuota adds n bytes to the used quota, and returns false if
+// the user has gone over their quota. Must be called with the lock held.
+func (u *userLimiter) useQuota(n uint64, now time.Time) bool {
+	if u.Limit.Quota == 0 {
+		return true
+	}
+	if start := u.Limit.QuotaPeriod.start(now); !start.Equal(u.PeriodStart) {
+		// New period, reset
+		u.PeriodStart = start
+		u.Used = 0
+	}
+	u.Used += n
+	return u.Used <= u.Limit.Quota
+}
+
+// Reserve takes the tokens for the traffic and returns how long the caller
+// needs to wait before the traffic is within the rate limit.
+func (u *userLimiter) Reserve(tx, rx uint64, now time.Time) (time.Duration, bool) {
+	u.Mutex.Lock()
+	defer u.Mutex.Unlock()
+	if !u.useQuota(tx+rx, now) {
+		return 0, false
+	}
+	return max(u.TxBucket.Reserve(tx, now), u.RxBucket.Reserve(rx, now)), true
+}
+
+// Allow takes the tokens for the traffic only if there are enough of them.
+// Traffic over the rate limit does not count towards the quota.
+func (u *userLimiter) Allow(tx, rx uint64, now time.Time) (allow, ok bool) {
+	u.Mutex.Lock()
+	defer u.Mutex.Unlock()
+	if !u.TxBucket.Allow(tx, now) || !u.RxBucket.Allow(rx, now) {
+		return false, true
+	}
+	if !u.useQuota(tx+rx, now) {
+		return false, false
+	}
+	_ = u.TxBucket.Reserve(tx, now)
+	_ = u.RxBucket.Reserve(rx, now)
+	return true, true
+}
+
+// tokenBucket is a simple token bucket that is allowed to go into debt,
+// so that a large write doesn't have to be split to fit into the burst size.
+// A rate of 0 means unlimited. Not thread-safe.
+type tokenBucket struct {
+	Rate   uint64
+	Burst  float64
+	Tokens float64
+	Last   time.Time
+}
+
+func (b *tokenBucket) SetRate(rate uint64, now time.Time) {
+	b.Rate = rate
+	b.Burst = max(float64(rate)*burstDuration.Seconds(), minBurst)
+	b.Tokens = min(b.Tokens, b.Burst)
+	if b.Last.IsZero() {
+		b.Tokens = b.Burst
+	}
+	b.Last = now
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.Last); elapsed > 0 {
+		b.Tokens = min(b.Tokens+elapsed.Seconds()*float64(b.Rate), b.Burst)
+		b.Last = now
+	}
+}
+
+// Allow reports whether n bytes can be sent right now, without taking any tokens.
+func (b *tokenBucket) Allow(n uint64, now time.Time) bool {
+	if b.Rate == 0 || n == 0 {
+		return true
+	}
+	b.refill(now)
+	return b.Tokens >= float64(n)
+}
+
+// Reserve takes n tokens and returns how long to wait until the bucket is out of debt.
+func (b *tokenBucket) Reserve(n uint64, now time.Time) time.Duration {
+	if b.Rate == 0 || n == 0 {
+		return 0
+	}
+	b.refill(now)
+	b.Tokens -= float64(n)
+	if b.Tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.Tokens / float64(b.Rate) * float64(time.Second))
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(limits map[string]Limit) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)}
+	l := &Limiter{
+		Users: make(map[string]*userLimiter),
+		now:   clock.Now,
+	}
+	for id, limit := range limits {
+		l.SetLimit(id, limit)
+	}
+	return l, clock
+}
+
+func TestLimiterRate(t *testing.T) {
+	l, clock := newTestLimiter(map[string]Limit{
+		"alice": {Tx: 1_000_000, Rx: 100_000},
+	})
+
+	// Unknown users are not limited
+	assert.Equal(t, true, l.WaitTraffic("bob", 100_000_000, 100_000_000))
+	allow, ok := l.AllowTraffic("bob", 100_000_000, 100_000_000)
+	assert.Equal(t, true, allow)
+	assert.Equal(t, true, ok)
+	tx, rx := l.Bandwidth("bob")
+	assert.Equal(t, uint64(0), tx)
+	assert.Equal(t, uint64(0), rx)
+
+	tx, rx = l.Bandwidth("alice")
+	assert.Equal(t, uint64(1_000_000), tx)
+	assert.Equal(t, uint64(100_000), rx)
+
+	u := l.Users["alice"]
+	// Burst is 250ms worth of tx, but at least 64 KiB for rx
+	assert.Equal(t, time.Duration(0), mustReserve(t, u, 250_000, 0, clock.Now()))
+	assert.Equal(t, time.Duration(0), mustReserve(t, u, 0, minBurst, clock.Now()))
+	// Bucket is empty, we can go into debt but have to wait it out
+	assert.Equal(t, 500*time.Millisecond, mustReserve(t, u, 500_000, 0, clock.Now()))
+	assert.Equal(t, time.Second, mustReserve(t, u, 0, 100_000, clock.Now()))
+
+	// UDP packets are dropped while in debt
+	allow, ok = l.AllowTraffic("alice", 1000, 0)
+	assert.Equal(t, false, allow)
+	assert.Equal(t, true, ok)
+	clock.Advance(501 * time.Millisecond)
+	allow, ok = l.AllowTraffic("alice", 1000, 0)
+	assert.Equal(t, true, allow)
+	assert.Equal(t, true, ok)
+	// But rx is still in debt
+	allow, ok = l.AllowTraffic("alice", 0, 1000)
+	assert.Equal(t, false, allow)
+	assert.Equal(t, true, ok)
+}
+
+func TestLimiterQuota(t *testing.T) {
+	l, clock := newTestLimiter(map[string]Limit{
+		"alice": {Quota: 1000, QuotaPeriod: QuotaPeriodMonthly},
+		"bob":   {Quota: 1000},
+	})
+
+	assert.Equal(t, true, l.WaitTraffic("alice", 600, 0))
+	assert.Equal(t, true, l.WaitTraffic("alice", 0, 400))
+	assert.Equal(t, false, l.WaitTraffic("alice", 1, 0))
+	allow, ok := l.AllowTraffic("alice", 1, 0)
+	assert.Equal(t, false, allow)
+	assert.Equal(t, false, ok)
+
+	// Changing the limit keeps the used quota
+	l.SetLimit("alice", Limit{Quota: 2000, QuotaPeriod: QuotaPeriodMonthly})
+	assert.Equal(t, uint64(1002), l.Users["alice"].Used)
+	assert.Equal(t, true, l.WaitTraffic("alice", 998, 0))
+
+	// Reset on the first day of the next month
+	assert.Equal(t, true, l.WaitTraffic("bob", 1000, 0))
+	clock.Advance(12 * time.Hour)
+	assert.Equal(t, true, l.WaitTraffic("alice", 2000, 0))
+	// Bob's quota never resets
+	assert.Equal(t, false, l.WaitTraffic("bob", 1, 0))
+
+	// Removing the limit
+	l.SetLimit("bob", Limit{})
+	assert.Equal(t, true, l.WaitTraffic("bob", 1, 0))
+}
+
+func TestQuotaPeriodStart(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 3, 6, 15, 4, 5, 0, time.UTC)
+	assert.Equal(t, time.Time{}, QuotaPeriodNever.start(now))
+	assert.Equal(t, time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), QuotaPeriodDaily.start(now))
+	assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), QuotaPeriodWeekly.start(now))
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), QuotaPeriodMonthly.start(now))
+	// Sunday belongs to the week that started on Monday
+	sunday := time.Date(2024, 3, 3, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), QuotaPeriodWeekly.start(sunday))
+}
+
+func mustReserve(t *testing.T, u *userLimiter, tx, rx uint64, now time.Time) time.Duration {
+	d, ok := u.Reserve(tx, rx, now)
+	assert.True(t, ok)
+	return d
+}
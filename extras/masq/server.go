@@ -18,7 +18,16 @@ type MasqTCPServer struct {
 	HTTPSPort  int
 	Handler    http.Handler
 	TLSConfig  *tls.Config
-	ForceHTTPS bool // Always 301 redirect from HTTP to HTTPS
+	ForceHTTPS bool            // Always 301 redirect from HTTP to HTTPS
+	Fallback   FallbackHandler // Optional, gets the first chance to handle HTTPS requests
+}
+
+// FallbackHandler handles the requests of a fallback transport over HTTPS
+// (e.g. transport/fallback), for clients that can't use QUIC.
+// ServeFallback must return false without touching w if the request is not for it,
+// in which case the request is handled by the masquerade Handler as usual.
+type FallbackHandler interface {
+	ServeFallback(w http.ResponseWriter, r *http.Request) bool
 }
 
 func (s *MasqTCPServer) ListenAndServeHTTP(addr string) error {
@@ -40,6 +49,9 @@ func (s *MasqTCPServer) ListenAndServeHTTPS(addr string) error {
 	server := &http.Server{
 		Addr: addr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.Fallback != nil && s.Fallback.ServeFallback(w, r) {
+				return
+			}
 			s.Handler.ServeHTTP(newAltSvcHijackResponseWriter(w, s.QUICPort), r)
 		}),
 		TLSConfig: s.TLSConfig,
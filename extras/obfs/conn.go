@@ -1,14 +1,19 @@
 package obfs
 
 import (
+	"errors"
 	"net"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/apernet/hysteria/extras/v2/internal/udpbatch"
 )
 
 const udpBufferSize = 2048 // QUIC packets are at most 1500 bytes long, so 2k should be more than enough
 
+var errGSOBatchTooLarge = errors.New("GSO batch too large after obfuscation")
+
 // Obfuscator is the interface that wraps the Obfuscate and Deobfuscate methods.
 // Both methods return the number of bytes written to out.
 // If a packet is not valid, the methods should return 0.
@@ -17,13 +22,21 @@ type Obfuscator interface {
 	Deobfuscate(in, out []byte) int
 }
 
-var _ net.PacketConn = (*obfsPacketConn)(nil)
+var (
+	_ udpbatch.Conn = (*obfsPacketConn)(nil)
+	_ udpbatch.Conn = (*obfsPacketConnUDP)(nil)
+)
 
 type obfsPacketConn struct {
-	Conn net.PacketConn
-	Obfs Obfuscator
+	Conn  net.PacketConn
+	Obfs  Obfuscator
+	Batch udpbatch.Conn // nil if Conn doesn't support batched reads
 
 	readBuf    []byte
+	readMsgs   []udpbatch.Message // Batch only, read but not yet deobfuscated
+	readIndex  int
+	readOne    [1]udpbatch.Message
+	readIov    [1][]byte
 	readMutex  sync.Mutex
 	writeBuf   []byte
 	writeMutex sync.Mutex
@@ -31,16 +44,25 @@ type obfsPacketConn struct {
 
 // obfsPacketConnUDP is a special case of obfsPacketConn that uses a UDPConn
 // as the underlying connection. We pass additional methods to quic-go to
-// enable UDP-specific optimizations.
+// enable UDP-specific optimizations, including GSO: a batch of segments
+// written with WriteMsgUDP is obfuscated one segment at a time, and sent
+// in one syscall if the obfuscated segments are still all the same size.
 type obfsPacketConnUDP struct {
 	*obfsPacketConn
 	UDPConn *net.UDPConn
+
+	writeOOB  []byte
+	writeSegs [][]byte           // Non-uniform GSO batches only
+	writeMsgs []udpbatch.Message // Same
 }
 
 // WrapPacketConn enables obfuscation on a net.PacketConn.
 // The obfuscation is transparent to the caller - the n bytes returned by
 // ReadFrom and WriteTo are the number of original bytes, not after
 // obfuscation/deobfuscation.
+// If conn supports batched reads (see udpbatch.Wrap), packets are read from it
+// in batches, and the returned conn also supports it, with each packet
+// (or GRO segment) deobfuscated separately.
 func WrapPacketConn(conn net.PacketConn, obfs Obfuscator) net.PacketConn {
 	opc := &obfsPacketConn{
 		Conn:     conn,
@@ -48,17 +70,37 @@ func WrapPacketConn(conn net.PacketConn, obfs Obfuscator) net.PacketConn {
 		readBuf:  make([]byte, udpBufferSize),
 		writeBuf: make([]byte, udpBufferSize),
 	}
+	if bc := udpbatch.Wrap(conn); bc != nil {
+		opc.Batch = bc
+		opc.readMsgs = newMessages(udpbatch.BatchSize)[:0]
+	}
 	if udpConn, ok := conn.(*net.UDPConn); ok {
+		// Large enough for a GSO batch
+		opc.writeBuf = make([]byte, udpbatch.MaxPacketSize)
 		return &obfsPacketConnUDP{
 			obfsPacketConn: opc,
 			UDPConn:        udpConn,
+			writeOOB:       make([]byte, 0, udpbatch.OOBSize),
 		}
 	} else {
 		return opc
 	}
 }
 
+func newMessages(n int) []udpbatch.Message {
+	msgs := make([]udpbatch.Message, n)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, udpBufferSize)}
+		msgs[i].OOB = make([]byte, udpbatch.OOBSize)
+	}
+	return msgs
+}
+
 func (c *obfsPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	if c.Batch != nil {
+		n, _, addr, err = c.readMsg(p, nil)
+		return
+	}
 	for {
 		c.readMutex.Lock()
 		n, addr, err = c.Conn.ReadFrom(c.readBuf)
@@ -75,6 +117,21 @@ func (c *obfsPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 	}
 }
 
+// readMsg reads a single packet, and its control messages into oob, through Batch.
+func (c *obfsPacketConn) readMsg(p, oob []byte) (n, oobn int, addr net.Addr, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	c.readIov[0] = p
+	c.readOne[0] = udpbatch.Message{Buffers: c.readIov[:], OOB: oob}
+	_, err = c.readBatch(c.readOne[:])
+	m := c.readOne[0]
+	c.readIov[0], c.readOne[0] = nil, udpbatch.Message{} // Don't keep p & oob
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return m.N, m.NN, m.Addr, nil
+}
+
 func (c *obfsPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	c.writeMutex.Lock()
 	nn := c.Obfs.Obfuscate(p, c.writeBuf)
@@ -86,6 +143,49 @@ func (c *obfsPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	return
 }
 
+func (c *obfsPacketConn) ReadBatch(ms []udpbatch.Message, flags int) (int, error) {
+	if c.Batch == nil {
+		n, addr, err := c.ReadFrom(ms[0].Buffers[0])
+		if err != nil {
+			return 0, err
+		}
+		ms[0].N, ms[0].NN, ms[0].Flags, ms[0].Addr = n, 0, 0, addr
+		return 1, nil
+	}
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	return c.readBatch(ms)
+}
+
+// readBatch deobfuscates the packets left from the last read from Batch into ms,
+// and only reads again when there are none. The caller must hold readMutex.
+func (c *obfsPacketConn) readBatch(ms []udpbatch.Message) (int, error) {
+	for {
+		if c.readIndex >= len(c.readMsgs) {
+			c.readMsgs, c.readIndex = c.readMsgs[:cap(c.readMsgs)], 0
+			n, err := c.Batch.ReadBatch(c.readMsgs, 0)
+			c.readMsgs = c.readMsgs[:n]
+			if err != nil {
+				return 0, err
+			}
+		}
+		n := 0
+		for n < len(ms) && c.readIndex < len(c.readMsgs) {
+			in := &c.readMsgs[c.readIndex]
+			c.readIndex++
+			out := &ms[n]
+			if nn := c.Obfs.Deobfuscate(in.Buffers[0][:in.N], out.Buffers[0]); nn > 0 {
+				out.N, out.NN, out.Flags, out.Addr = nn, copy(out.OOB, in.OOB[:in.NN]), 0, in.Addr
+				n++
+			}
+			// Invalid packets are skipped
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
 func (c *obfsPacketConn) Close() error {
 	return c.Conn.Close()
 }
@@ -119,3 +219,101 @@ func (c *obfsPacketConnUDP) SetWriteBuffer(bytes int) error {
 func (c *obfsPacketConnUDP) SyscallConn() (syscall.RawConn, error) {
 	return c.UDPConn.SyscallConn()
 }
+
+func (c *obfsPacketConnUDP) ReadBatch(ms []udpbatch.Message, flags int) (int, error) {
+	if c.Batch != nil {
+		return c.obfsPacketConn.ReadBatch(ms, flags)
+	}
+	// Still read with ReadMsgUDP, for the control messages
+	n, oobn, _, addr, err := c.ReadMsgUDP(ms[0].Buffers[0], ms[0].OOB)
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N, ms[0].NN, ms[0].Flags, ms[0].Addr = n, oobn, 0, addr
+	return 1, nil
+}
+
+func (c *obfsPacketConnUDP) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	if c.Batch != nil {
+		var a net.Addr
+		n, oobn, a, err = c.readMsg(b, oob)
+		addr, _ = a.(*net.UDPAddr)
+		return
+	}
+	for {
+		c.readMutex.Lock()
+		n, oobn, flags, addr, err = c.UDPConn.ReadMsgUDP(c.readBuf, oob)
+		if n <= 0 {
+			c.readMutex.Unlock()
+			return
+		}
+		n = c.Obfs.Deobfuscate(c.readBuf[:n], b)
+		c.readMutex.Unlock()
+		if n > 0 || err != nil {
+			return
+		}
+		// Invalid packet, try again
+	}
+}
+
+// WriteMsgUDP obfuscates and writes b, or each segment of b if oob has a GSO
+// control message. Errors from the socket are returned as is, so that quic-go
+// can tell when GSO isn't supported and stop using it.
+func (c *obfsPacketConnUDP) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	size, rest := udpbatch.SplitGSO(oob, c.writeOOB[:0])
+	if size <= 0 {
+		nn := c.Obfs.Obfuscate(b, c.writeBuf)
+		_, _, err = c.UDPConn.WriteMsgUDP(c.writeBuf[:nn], oob, addr)
+	} else {
+		err = c.writeGSO(b, size, rest, addr)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(b), len(oob), nil
+}
+
+// writeGSO obfuscates each segment of b, and sends them as a GSO batch if they are
+// all still the same size (except the last, which can be smaller), or one by one
+// otherwise. oob holds the other control messages. The caller must hold writeMutex.
+func (c *obfsPacketConnUDP) writeGSO(b []byte, size int, oob []byte, addr *net.UDPAddr) error {
+	segs := c.writeSegs[:0]
+	total, segSize, uniform := 0, 0, true
+	for len(b) > 0 {
+		seg := b[:min(size, len(b))]
+		b = b[len(seg):]
+		nn := c.Obfs.Obfuscate(seg, c.writeBuf[total:])
+		if nn <= 0 {
+			return errGSOBatchTooLarge
+		}
+		if len(segs) == 0 {
+			segSize = nn
+		} else if nn > segSize || (nn < segSize && len(b) > 0) {
+			uniform = false
+		}
+		segs = append(segs, c.writeBuf[total:total+nn])
+		total += nn
+	}
+	c.writeSegs = segs[:0]
+	if uniform {
+		_, _, err := c.UDPConn.WriteMsgUDP(c.writeBuf[:total], udpbatch.AppendGSO(oob, segSize), addr)
+		return err
+	}
+	if bw, ok := c.Batch.(udpbatch.BatchWriter); ok {
+		ms := c.writeMsgs[:0]
+		for i := range segs {
+			ms = append(ms, udpbatch.Message{Buffers: segs[i : i+1], OOB: oob, Addr: addr})
+		}
+		c.writeMsgs = ms[:0]
+		_, err := bw.WriteBatch(ms, 0)
+		return err
+	}
+	for _, seg := range segs {
+		if _, _, err := c.UDPConn.WriteMsgUDP(seg, oob, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package obfs
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/apernet/quic-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/extras/v2/internal/udpbatch"
+)
+
+// quic-go only writes GSO batches to an OOBCapablePacketConn
+var _ quic.OOBCapablePacketConn = (*obfsPacketConnUDP)(nil)
+
+// plainPacketConn hides the *net.UDPConn, so that it's wrapped without batched I/O.
+type plainPacketConn struct {
+	net.PacketConn
+}
+
+func listenObfs(t testing.TB, batch bool) (*net.UDPConn, net.PacketConn) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	ob, _ := NewSalamanderObfuscator([]byte("average_password"))
+	if batch {
+		return udpConn, WrapPacketConn(udpConn, ob)
+	}
+	return udpConn, WrapPacketConn(&plainPacketConn{udpConn}, ob)
+}
+
+func TestObfsPacketConn(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		name := "single"
+		if batch {
+			name = "batch"
+		}
+		t.Run(name, func(t *testing.T) {
+			_, sConn := listenObfs(t, batch)
+			defer sConn.Close()
+			_, cConn := listenObfs(t, batch)
+			defer cConn.Close()
+
+			var msgs [][]byte
+			for i := 0; i < 30; i++ {
+				msgs = append(msgs, bytes.Repeat([]byte{byte(i)}, 1200))
+			}
+			msgs = append(msgs, []byte("last"))
+			for _, m := range msgs {
+				_, err := cConn.WriteTo(m, sConn.LocalAddr())
+				assert.NoError(t, err)
+			}
+			// Not obfuscated, must be skipped
+			rawConn, err := net.ListenUDP("udp", nil)
+			assert.NoError(t, err)
+			_, err = rawConn.WriteTo([]byte("invalid"), sConn.LocalAddr())
+			assert.NoError(t, err)
+			_ = rawConn.Close()
+			_, err = cConn.WriteTo([]byte("hello"), sConn.LocalAddr())
+			assert.NoError(t, err)
+
+			// Mix of ReadBatch & ReadFrom
+			rMsgs := newMessages(8)
+			received := 0
+			for received < len(msgs) {
+				n, err := sConn.(udpbatch.Conn).ReadBatch(rMsgs[:min(len(rMsgs), len(msgs)-received)], 0)
+				assert.NoError(t, err)
+				for _, m := range rMsgs[:n] {
+					assert.Equal(t, msgs[received], m.Buffers[0][:m.N])
+					assert.Equal(t, cConn.LocalAddr().String(), m.Addr.String())
+					received++
+				}
+			}
+			buf := make([]byte, 2048)
+			n, addr, err := sConn.ReadFrom(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(buf[:n]))
+			assert.Equal(t, cConn.LocalAddr().String(), addr.String())
+		})
+	}
+}
+
+// paddingObfuscator pads packets of odd sizes by one more byte than others,
+// so that the segments of a GSO batch aren't all the same size after obfuscation.
+type paddingObfuscator struct{}
+
+func (paddingObfuscator) Obfuscate(in, out []byte) int {
+	pad := 1 + len(in)%2
+	if len(out) < len(in)+pad {
+		return 0
+	}
+	copy(out[pad:], in)
+	out[0] = byte(pad)
+	return len(in) + pad
+}
+
+func (paddingObfuscator) Deobfuscate(in, out []byte) int {
+	if len(in) == 0 || int(in[0]) > len(in) {
+		return 0
+	}
+	return copy(out, in[in[0]:])
+}
+
+func TestObfsPacketConnGSO(t *testing.T) {
+	if len(udpbatch.AppendGSO(nil, 1)) == 0 {
+		t.Skip("GSO not supported on this platform")
+	}
+	salamander, _ := NewSalamanderObfuscator([]byte("average_password"))
+	for _, tc := range []struct {
+		name string
+		obfs Obfuscator
+		segs []int // Sizes
+	}{
+		{"uniform", salamander, []int{1200, 1200, 1200, 700}},
+		{"non-uniform", paddingObfuscator{}, []int{1201, 1201, 1201, 700}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			assert.NoError(t, err)
+			sConn := WrapPacketConn(sUDPConn, tc.obfs)
+			defer sConn.Close()
+			cUDPConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			assert.NoError(t, err)
+			cConn := WrapPacketConn(cUDPConn, tc.obfs).(quic.OOBCapablePacketConn)
+			defer cConn.Close()
+
+			var data []byte
+			for i, size := range tc.segs {
+				data = append(data, bytes.Repeat([]byte{byte(i)}, size)...)
+			}
+			n, _, err := cConn.WriteMsgUDP(data, udpbatch.AppendGSO(nil, tc.segs[0]), sUDPConn.LocalAddr().(*net.UDPAddr))
+			if err != nil {
+				t.Skipf("GSO not supported: %v", err)
+			}
+			assert.Equal(t, len(data), n)
+
+			// Each segment is obfuscated separately
+			buf := make([]byte, 2048)
+			for i, size := range tc.segs {
+				n, addr, err := sConn.ReadFrom(buf)
+				assert.NoError(t, err)
+				assert.Equal(t, bytes.Repeat([]byte{byte(i)}, size), buf[:n])
+				assert.Equal(t, cConn.LocalAddr().String(), addr.String())
+			}
+		})
+	}
+}
+
+func BenchmarkObfsPacketConnRead(b *testing.B) {
+	const packetSize = 1200
+	bench := func(b *testing.B, batch bool) {
+		sUDPConn, sConn := listenObfs(b, batch)
+		defer sConn.Close()
+		_ = sUDPConn.SetReadBuffer(4 << 20)
+		_, cConn := listenObfs(b, true)
+		defer cConn.Close()
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go func() {
+			// Flood
+			data := make([]byte, packetSize)
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+					_, _ = cConn.WriteTo(data, sConn.LocalAddr())
+				}
+			}
+		}()
+		// quic-go reads with ReadFrom
+		buf := make([]byte, 2048)
+		b.SetBytes(packetSize)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = sConn.ReadFrom(buf)
+		}
+	}
+	b.Run("Single", func(b *testing.B) {
+		bench(b, false)
+	})
+	b.Run("Batch", func(b *testing.B) {
+		bench(b, true)
+	})
+}
+
+// BenchmarkObfsPacketConnWrite benchmarks the send path as quic-go uses it,
+// one WriteTo per packet, or one WriteMsgUDP per GSO batch when supported,
+// against a plain UDP socket.
+func BenchmarkObfsPacketConnWrite(b *testing.B) {
+	const (
+		packetSize = 1200
+		gsoCount   = 16 // quic-go's GSO batches are up to 20 KB
+	)
+	bench := func(b *testing.B, obfs, gso bool) {
+		sUDPConn, sConn := listenObfs(b, true)
+		defer sConn.Close()
+		go func() {
+			// Drain
+			buf := make([]byte, udpbatch.MaxPacketSize)
+			for {
+				if _, _, err := sUDPConn.ReadFrom(buf); err != nil {
+					return
+				}
+			}
+		}()
+		cUDPConn, cConn := listenObfs(b, true)
+		defer cConn.Close()
+		if !obfs {
+			cConn = cUDPConn
+		}
+		addr := sUDPConn.LocalAddr().(*net.UDPAddr)
+		if !gso {
+			data := make([]byte, packetSize)
+			b.SetBytes(packetSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = cConn.WriteTo(data, addr)
+			}
+			return
+		}
+		oob := udpbatch.AppendGSO(nil, packetSize)
+		if len(oob) == 0 {
+			b.Skip("GSO not supported on this platform")
+		}
+		data := make([]byte, packetSize*gsoCount)
+		oc := cConn.(quic.OOBCapablePacketConn)
+		if _, _, err := oc.WriteMsgUDP(data, oob, addr); err != nil {
+			b.Skipf("GSO not supported: %v", err)
+		}
+		b.SetBytes(int64(len(data)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _, _ = oc.WriteMsgUDP(data, oob, addr)
+		}
+	}
+	b.Run("Plain", func(b *testing.B) {
+		bench(b, false, false)
+	})
+	b.Run("Obfs", func(b *testing.B) {
+		bench(b, true, false)
+	})
+	b.Run("PlainGSO", func(b *testing.B) {
+		bench(b, false, true)
+	})
+	b.Run("ObfsGSO", func(b *testing.B) {
+		bench(b, true, true)
+	})
+}
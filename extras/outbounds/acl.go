@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/apernet/hysteria/core/v2/server"
 	"github.com/apernet/hysteria/extras/v2/outbounds/acl"
 )
 
@@ -81,8 +82,19 @@ func (a *aclEngine) handle(reqAddr *AddrEx, proto acl.Protocol) PluggableOutboun
 	if reqAddr.ResolveInfo != nil {
 		hostInfo.IPv4 = reqAddr.ResolveInfo.IPv4
 		hostInfo.IPv6 = reqAddr.ResolveInfo.IPv6
+	} else if resolve := reqAddr.resolve; resolve != nil {
+		hostInfo.Resolve = func() (net.IP, net.IP) {
+			resolve(reqAddr)
+			return reqAddr.ResolveInfo.IPv4, reqAddr.ResolveInfo.IPv6
+		}
+	}
+	reqAddr.resolve = nil
+	var clientInfo acl.ClientInfo
+	if reqAddr.RequestInfo != nil {
+		clientInfo.AuthID = reqAddr.RequestInfo.AuthID
+		clientInfo.IP = server.AddrIP(reqAddr.RequestInfo.Addr)
 	}
-	ob, hijackIP := a.RuleSet.Match(hostInfo, proto, reqAddr.Port)
+	ob, hijackIP := a.RuleSet.Match(clientInfo, hostInfo, proto, reqAddr.Port)
 	if ob == nil {
 		// No match, use default outbound
 		return a.Default
@@ -110,6 +122,14 @@ func (a *aclEngine) UDP(reqAddr *AddrEx) (UDPConn, error) {
 	return ob.UDP(reqAddr)
 }
 
+func (a *aclEngine) RouteUDP(reqAddr *AddrEx) PluggableOutbound {
+	ob := a.handle(reqAddr, acl.ProtocolUDP)
+	if router, ok := ob.(udpRouter); ok {
+		return router.RouteUDP(reqAddr)
+	}
+	return ob
+}
+
 type aclRejectOutbound struct{}
 
 func (a *aclRejectOutbound) TCP(reqAddr *AddrEx) (net.Conn, error) {
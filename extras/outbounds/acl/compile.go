@@ -5,6 +5,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/apernet/hysteria/extras/v2/outbounds/acl/v2geo"
 
@@ -27,6 +28,10 @@ type HostInfo struct {
 	Name string
 	IPv4 net.IP
 	IPv6 net.IP
+	// Resolve, if set, is called to fill in IPv4 & IPv6 the first time
+	// a rule that matches IP addresses is checked, so that the name is only
+	// resolved when needed. Results are then cached by name only.
+	Resolve func() (ipv4, ipv6 net.IP)
 }
 
 func (h HostInfo) String() string {
@@ -34,7 +39,7 @@ func (h HostInfo) String() string {
 }
 
 type CompiledRuleSet[O Outbound] interface {
-	Match(host HostInfo, proto Protocol, port uint16) (O, net.IP)
+	Match(client ClientInfo, host HostInfo, proto Protocol, port uint16) (O, net.IP)
 }
 
 type compiledRule[O Outbound] struct {
@@ -44,16 +49,30 @@ type compiledRule[O Outbound] struct {
 	StartPort     uint16
 	EndPort       uint16
 	HijackAddress net.IP
+	Conditions    []conditionMatcher
+	TimeDependent bool // whether any of the conditions depends on the current time
 }
 
-func (r *compiledRule[O]) Match(host HostInfo, proto Protocol, port uint16) bool {
+func (r *compiledRule[O]) Match(client ClientInfo, host *HostInfo, proto Protocol, port uint16, now time.Time) bool {
 	if r.Protocol != ProtocolBoth && r.Protocol != proto {
 		return false
 	}
 	if r.StartPort != 0 && (port < r.StartPort || port > r.EndPort) {
 		return false
 	}
-	return r.HostMatcher.Match(host)
+	if host.Resolve != nil && matchesIP(r.HostMatcher) {
+		host.IPv4, host.IPv6 = host.Resolve()
+		host.Resolve = nil
+	}
+	if !r.HostMatcher.Match(*host) {
+		return false
+	}
+	for _, c := range r.Conditions {
+		if !c.Match(client, now) {
+			return false
+		}
+	}
+	return true
 }
 
 type matchResult[O Outbound] struct {
@@ -63,25 +82,51 @@ type matchResult[O Outbound] struct {
 
 type compiledRuleSetImpl[O Outbound] struct {
 	Rules []compiledRule[O]
-	Cache *lru.Cache[string, matchResult[O]] // key: HostInfo.String()
+	Cache *lru.Cache[string, matchResult[O]] // key: [ClientInfo.String()|]HostInfo.String()
+	// HasClientConditions indicates whether any rule depends on ClientInfo,
+	// in which case it must be part of the cache key.
+	HasClientConditions bool
+	// RuleSets are the rule sets used by the rules. Their content may change
+	// at any time, in which case the cache must be purged.
+	RuleSets []*ruleSetMatcher
+	Now      func() time.Time
 }
 
-func (s *compiledRuleSetImpl[O]) Match(host HostInfo, proto Protocol, port uint16) (O, net.IP) {
+func (s *compiledRuleSetImpl[O]) Match(client ClientInfo, host HostInfo, proto Protocol, port uint16) (O, net.IP) {
 	host.Name = strings.ToLower(host.Name) // Normalize host name to lower case
+	for _, m := range s.RuleSets {
+		if m.update() {
+			s.Cache.Purge()
+		}
+	}
 	key := host.String()
+	if s.HasClientConditions {
+		key = client.String() + "|" + key
+	}
 	if result, ok := s.Cache.Get(key); ok {
 		return result.Outbound, result.HijackAddress
 	}
+	now := s.Now()
+	// Results that went through a time dependent rule
+	// may change at any time, so they must not be cached.
+	cacheable := true
 	for _, rule := range s.Rules {
-		if rule.Match(host, proto, port) {
+		if rule.TimeDependent {
+			cacheable = false
+		}
+		if rule.Match(client, &host, proto, port, now) {
 			result := matchResult[O]{rule.Outbound, rule.HijackAddress}
-			s.Cache.Add(key, result)
+			if cacheable {
+				s.Cache.Add(key, result)
+			}
 			return result.Outbound, result.HijackAddress
 		}
 	}
 	// No match should also be cached
 	var zero O
-	s.Cache.Add(key, matchResult[O]{zero, nil})
+	if cacheable {
+		s.Cache.Add(key, matchResult[O]{zero, nil})
+	}
 	return zero, nil
 }
 
@@ -103,11 +148,14 @@ type GeoLoader interface {
 // Names in the outbounds map MUST be in all lower case.
 // We want on-demand loading of GeoIP/GeoSite databases, so instead of passing the
 // databases directly, we use a GeoLoader interface to load them only when needed
-// by at least one rule.
+// by at least one rule. The same goes for rule sets, if geoLoader also implements
+// RuleSetLoader.
 func Compile[O Outbound](rules []TextRule, outbounds map[string]O,
 	cacheSize int, geoLoader GeoLoader,
 ) (CompiledRuleSet[O], error) {
 	compiledRules := make([]compiledRule[O], len(rules))
+	hasClientConditions := false
+	var ruleSets []*ruleSetMatcher
 	for i, rule := range rules {
 		outbound, ok := outbounds[strings.ToLower(rule.Outbound)]
 		if !ok {
@@ -117,6 +165,9 @@ func Compile[O Outbound](rules []TextRule, outbounds map[string]O,
 		if errStr != "" {
 			return nil, &CompilationError{rule.LineNum, errStr}
 		}
+		if rsm, ok := hm.(*ruleSetMatcher); ok {
+			ruleSets = append(ruleSets, rsm)
+		}
 		proto, startPort, endPort, ok := parseProtoPort(rule.ProtoPort)
 		if !ok {
 			return nil, &CompilationError{rule.LineNum, fmt.Sprintf("invalid protocol/port: %s", rule.ProtoPort)}
@@ -128,13 +179,27 @@ func Compile[O Outbound](rules []TextRule, outbounds map[string]O,
 				return nil, &CompilationError{rule.LineNum, fmt.Sprintf("invalid hijack address (must be an IP address): %s", rule.HijackAddress)}
 			}
 		}
-		compiledRules[i] = compiledRule[O]{outbound, hm, proto, startPort, endPort, hijackAddress}
+		var conds []conditionMatcher
+		timeDependent := false
+		for _, c := range rule.Conditions {
+			cm, td, errStr := compileCondition(c)
+			if errStr != "" {
+				return nil, &CompilationError{rule.LineNum, errStr}
+			}
+			conds = append(conds, cm)
+			if td {
+				timeDependent = true
+			} else {
+				hasClientConditions = true
+			}
+		}
+		compiledRules[i] = compiledRule[O]{outbound, hm, proto, startPort, endPort, hijackAddress, conds, timeDependent}
 	}
 	cache, err := lru.New[string, matchResult[O]](cacheSize)
 	if err != nil {
 		return nil, err
 	}
-	return &compiledRuleSetImpl[O]{compiledRules, cache}, nil
+	return &compiledRuleSetImpl[O]{compiledRules, cache, hasClientConditions, ruleSets, time.Now}, nil
 }
 
 // parseProtoPort parses the protocol and port from a protoPort string.
@@ -255,6 +320,22 @@ func compileHostMatcher(addr string, geoLoader GeoLoader) (hostMatcher, string)
 		}
 		return m, ""
 	}
+	if strings.HasPrefix(addr, "ruleset:") {
+		// Rule set matcher
+		name := addr[8:]
+		if len(name) == 0 {
+			return nil, "empty rule set name"
+		}
+		rsLoader, ok := geoLoader.(RuleSetLoader)
+		if !ok {
+			return nil, "rule sets are not supported"
+		}
+		provider, err := rsLoader.LoadRuleSet(name)
+		if err != nil {
+			return nil, err.Error()
+		}
+		return newRuleSetMatcher(provider), ""
+	}
 	if strings.HasPrefix(addr, "suffix:") {
 		// Domain suffix matcher
 		suffix := addr[7:]
@@ -1,9 +1,15 @@
 package acl
 
 import (
+	"errors"
 	"net"
+	"net/netip"
+	"regexp"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl/ruleset"
 	"github.com/apernet/hysteria/extras/v2/outbounds/acl/v2geo"
 
 	"github.com/stretchr/testify/assert"
@@ -261,7 +267,7 @@ func TestCompile(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		gotOutbound, gotIP := comp.Match(test.host, test.proto, test.port)
+		gotOutbound, gotIP := comp.Match(ClientInfo{}, test.host, test.proto, test.port)
 		assert.Equal(t, test.wantOutbound, gotOutbound)
 		assert.Equal(t, test.wantIP, gotIP)
 	}
@@ -283,6 +289,228 @@ func TestCompile(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCompileConditions(t *testing.T) {
+	rules, err := ParseTextRules(`
+reject(*.netflix.com) user:kids time:22:00-07:00
+ob1(all, tcp/22) src:10.0.0.0/8,192.168.1.1
+ob2(all) user:alice,bob
+`)
+	assert.NoError(t, err)
+	comp, err := Compile[string](rules, map[string]string{
+		"reject": "reject",
+		"ob1":    "ob1",
+		"ob2":    "ob2",
+	}, 100, nil)
+	assert.NoError(t, err)
+	impl := comp.(*compiledRuleSetImpl[string])
+
+	now := time.Date(2024, 1, 1, 23, 30, 0, 0, time.Local)
+	impl.Now = func() time.Time { return now }
+
+	tests := []struct {
+		client       ClientInfo
+		host         HostInfo
+		proto        Protocol
+		port         uint16
+		wantOutbound string
+	}{
+		{
+			client:       ClientInfo{AuthID: "kids"},
+			host:         HostInfo{Name: "www.netflix.com"},
+			proto:        ProtocolTCP,
+			port:         443,
+			wantOutbound: "reject",
+		},
+		{
+			client:       ClientInfo{AuthID: "alice"},
+			host:         HostInfo{Name: "www.netflix.com"},
+			proto:        ProtocolTCP,
+			port:         443,
+			wantOutbound: "ob2",
+		},
+		{
+			client:       ClientInfo{AuthID: "kids", IP: net.ParseIP("10.1.2.3")},
+			host:         HostInfo{Name: "ssh.example.com"},
+			proto:        ProtocolTCP,
+			port:         22,
+			wantOutbound: "ob1",
+		},
+		{
+			client:       ClientInfo{AuthID: "kids", IP: net.ParseIP("192.168.1.1")},
+			host:         HostInfo{Name: "ssh.example.com"},
+			proto:        ProtocolTCP,
+			port:         22,
+			wantOutbound: "ob1",
+		},
+		{
+			client:       ClientInfo{AuthID: "kids", IP: net.ParseIP("192.168.1.2")},
+			host:         HostInfo{Name: "ssh.example.com"},
+			proto:        ProtocolTCP,
+			port:         22,
+			wantOutbound: "", // no match default
+		},
+		{
+			client:       ClientInfo{AuthID: "bob"},
+			host:         HostInfo{Name: "ssh.example.com"},
+			proto:        ProtocolTCP,
+			port:         22,
+			wantOutbound: "ob2",
+		},
+	}
+	for _, test := range tests {
+		gotOutbound, _ := comp.Match(test.client, test.host, test.proto, test.port)
+		assert.Equal(t, test.wantOutbound, gotOutbound)
+	}
+
+	// Outside the time window, results must not come from the cache
+	now = time.Date(2024, 1, 2, 12, 0, 0, 0, time.Local)
+	gotOutbound, _ := comp.Match(ClientInfo{AuthID: "kids"}, HostInfo{Name: "www.netflix.com"}, ProtocolTCP, 443)
+	assert.Equal(t, "", gotOutbound)
+	now = time.Date(2024, 1, 2, 6, 59, 0, 0, time.Local)
+	gotOutbound, _ = comp.Match(ClientInfo{AuthID: "kids"}, HostInfo{Name: "www.netflix.com"}, ProtocolTCP, 443)
+	assert.Equal(t, "reject", gotOutbound)
+
+	// Invalid conditions
+	for _, cond := range []string{"user:", "src:10.0.0.0/33", "time:07:00-07:00", "time:25:00-01:00", "time:9-10", "lol:1"} {
+		_, err = Compile[string]([]TextRule{
+			{Outbound: "ob1", Address: "all", Conditions: []string{cond}},
+		}, map[string]string{"ob1": "ob1"}, 100, nil)
+		assert.Error(t, err, cond)
+	}
+}
+
+func TestCompileResolve(t *testing.T) {
+	rules, err := ParseTextRules(`
+ob1(*.google.com)
+ob2(10.0.0.0/8, udp)
+ob3(1.1.1.1)
+`)
+	assert.NoError(t, err)
+	comp, err := Compile[string](rules, map[string]string{
+		"ob1": "ob1",
+		"ob2": "ob2",
+		"ob3": "ob3",
+	}, 100, nil)
+	assert.NoError(t, err)
+
+	var resolved []string
+	host := func(name string, ip net.IP) HostInfo {
+		return HostInfo{Name: name, Resolve: func() (net.IP, net.IP) {
+			resolved = append(resolved, name)
+			return ip, nil
+		}}
+	}
+
+	// Not resolved when a domain rule matches first
+	gotOutbound, _ := comp.Match(ClientInfo{}, host("www.google.com", net.ParseIP("10.0.0.1")), ProtocolTCP, 443)
+	assert.Equal(t, "ob1", gotOutbound)
+	assert.Empty(t, resolved)
+
+	// Resolved once, on the first IP rule for the protocol
+	gotOutbound, _ = comp.Match(ClientInfo{}, host("one.one", net.ParseIP("1.1.1.1")), ProtocolTCP, 443)
+	assert.Equal(t, "ob3", gotOutbound)
+	assert.Equal(t, []string{"one.one"}, resolved)
+
+	// Then cached by name
+	gotOutbound, _ = comp.Match(ClientInfo{}, host("one.one", net.ParseIP("1.1.1.1")), ProtocolTCP, 443)
+	assert.Equal(t, "ob3", gotOutbound)
+	assert.Equal(t, []string{"one.one"}, resolved)
+}
+
+type testRuleSetLoader struct {
+	testGeoLoader
+	RuleSets map[string]*testRuleSetProvider
+}
+
+func (l *testRuleSetLoader) LoadRuleSet(name string) (RuleSetProvider, error) {
+	p, ok := l.RuleSets[name]
+	if !ok {
+		return nil, errors.New("rule set " + name + " not found")
+	}
+	return p, nil
+}
+
+type testRuleSetProvider struct {
+	rs atomic.Pointer[ruleset.RuleSet]
+}
+
+func (p *testRuleSetProvider) RuleSet() *ruleset.RuleSet {
+	return p.rs.Load()
+}
+
+func TestCompileRuleSets(t *testing.T) {
+	ads := &testRuleSetProvider{}
+	ads.rs.Store(&ruleset.RuleSet{
+		Domains:        []string{"ads.example.com"},
+		DomainSuffixes: []string{"doubleclick.net", ".tracking.org"},
+		DomainKeywords: []string{"adserver"},
+		DomainRegexes:  []*regexp.Regexp{regexp.MustCompile(`^ad[0-9]+\.`)},
+		IPs: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("10.1.0.0/16"), // overlapping
+			netip.MustParsePrefix("10.1.0.0/16"), // duplicate
+			netip.MustParsePrefix("11.0.0.0/8"),  // adjacent
+			netip.MustParsePrefix("2001:db8::/32"),
+		},
+	})
+	loader := &testRuleSetLoader{RuleSets: map[string]*testRuleSetProvider{"ads": ads}}
+
+	rules, err := ParseTextRules(`
+reject(ruleset:ADS)
+direct(all)
+`)
+	assert.NoError(t, err)
+	comp, err := Compile[string](rules, map[string]string{
+		"reject": "reject",
+		"direct": "direct",
+	}, 100, loader)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		host         HostInfo
+		wantOutbound string
+	}{
+		{HostInfo{Name: "ads.example.com"}, "reject"},
+		{HostInfo{Name: "www.ads.example.com"}, "direct"},
+		{HostInfo{Name: "doubleclick.net"}, "reject"},
+		{HostInfo{Name: "a.b.doubleclick.net"}, "reject"},
+		{HostInfo{Name: "notdoubleclick.net"}, "direct"},
+		{HostInfo{Name: "tracking.org"}, "direct"},
+		{HostInfo{Name: "x.tracking.org"}, "reject"},
+		{HostInfo{Name: "my-adserver.com"}, "reject"},
+		{HostInfo{Name: "ad123.example.org"}, "reject"},
+		{HostInfo{Name: "example.com", IPv4: net.ParseIP("10.1.2.3")}, "reject"},
+		{HostInfo{Name: "example.com", IPv4: net.ParseIP("11.255.255.255")}, "reject"},
+		{HostInfo{Name: "example.com", IPv4: net.ParseIP("12.0.0.0")}, "direct"},
+		{HostInfo{Name: "example.com", IPv4: net.ParseIP("9.255.255.255")}, "direct"},
+		{HostInfo{Name: "example.com", IPv6: net.ParseIP("2001:db8::1")}, "reject"},
+		{HostInfo{Name: "example.com", IPv6: net.ParseIP("2001:db9::1")}, "direct"},
+	}
+	for _, test := range tests {
+		gotOutbound, _ := comp.Match(ClientInfo{}, test.host, ProtocolTCP, 443)
+		assert.Equal(t, test.wantOutbound, gotOutbound, test.host.String())
+	}
+
+	// Updated rule sets must take effect immediately, even for cached results
+	ads.rs.Store(&ruleset.RuleSet{Domains: []string{"example.com"}})
+	gotOutbound, _ := comp.Match(ClientInfo{}, HostInfo{Name: "ads.example.com"}, ProtocolTCP, 443)
+	assert.Equal(t, "direct", gotOutbound)
+	gotOutbound, _ = comp.Match(ClientInfo{}, HostInfo{Name: "example.com", IPv4: net.ParseIP("12.0.0.0")}, ProtocolTCP, 443)
+	assert.Equal(t, "reject", gotOutbound)
+
+	// Unknown rule set
+	rules, err = ParseTextRules(`reject(ruleset:nope)`)
+	assert.NoError(t, err)
+	_, err = Compile[string](rules, map[string]string{"reject": "reject"}, 100, loader)
+	assert.Error(t, err)
+
+	// Loader without rule set support
+	rules, err = ParseTextRules(`reject(ruleset:ads)`)
+	assert.NoError(t, err)
+	_, err = Compile[string](rules, map[string]string{"reject": "reject"}, 100, &testGeoLoader{})
+	assert.Error(t, err)
+}
+
 func Test_parseGeoSiteName(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -0,0 +1,148 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientInfo describes the client making the request.
+// It is only used by rules with conditions.
+type ClientInfo struct {
+	AuthID string
+	IP     net.IP
+}
+
+func (c ClientInfo) String() string {
+	return fmt.Sprintf("%s|%s", c.AuthID, c.IP)
+}
+
+type conditionMatcher interface {
+	Match(client ClientInfo, now time.Time) bool
+}
+
+// userCondition matches if the client's auth ID is one of the IDs.
+type userCondition struct {
+	IDs []string
+}
+
+func (c *userCondition) Match(client ClientInfo, now time.Time) bool {
+	for _, id := range c.IDs {
+		if client.AuthID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// srcCondition matches if the client's IP is in one of the networks.
+type srcCondition struct {
+	IPNets []*net.IPNet
+}
+
+func (c *srcCondition) Match(client ClientInfo, now time.Time) bool {
+	if client.IP == nil {
+		return false
+	}
+	for _, ipNet := range c.IPNets {
+		if ipNet.Contains(client.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeCondition matches if the local time of day is within [Start, End).
+// Both are in minutes since midnight. If Start > End, the window wraps
+// around midnight (e.g. 22:00-07:00).
+type timeCondition struct {
+	Start int
+	End   int
+}
+
+func (c *timeCondition) Match(client ClientInfo, now time.Time) bool {
+	m := now.Hour()*60 + now.Minute()
+	if c.Start < c.End {
+		return m >= c.Start && m < c.End
+	} else {
+		return m >= c.Start || m < c.End
+	}
+}
+
+// compileCondition compiles a condition in the form of "key:value".
+// Supported conditions:
+//
+//	user:id1,id2,...          - client auth ID is one of the IDs (case-sensitive)
+//	src:cidr1,ip2,...         - client IP is in one of the networks
+//	time:HH:MM-HH:MM          - local time of day is within the window
+//
+// Returns the matcher, whether it depends on the current time,
+// and an error string if the condition is invalid.
+func compileCondition(cond string) (conditionMatcher, bool, string) {
+	key, value, ok := strings.Cut(cond, ":")
+	if !ok || value == "" {
+		return nil, false, fmt.Sprintf("invalid condition: %s", cond)
+	}
+	switch strings.ToLower(key) {
+	case "user":
+		return &userCondition{IDs: strings.Split(value, ",")}, false, ""
+	case "src":
+		var ipNets []*net.IPNet
+		for _, s := range strings.Split(value, ",") {
+			ipNet := parseIPOrCIDR(s)
+			if ipNet == nil {
+				return nil, false, fmt.Sprintf("invalid source address: %s", s)
+			}
+			ipNets = append(ipNets, ipNet)
+		}
+		return &srcCondition{IPNets: ipNets}, false, ""
+	case "time":
+		startStr, endStr, ok := strings.Cut(value, "-")
+		if !ok {
+			return nil, false, fmt.Sprintf("invalid time window: %s", value)
+		}
+		start, ok1 := parseTimeOfDay(startStr)
+		end, ok2 := parseTimeOfDay(endStr)
+		if !ok1 || !ok2 || start == end {
+			return nil, false, fmt.Sprintf("invalid time window: %s", value)
+		}
+		return &timeCondition{Start: start, End: end}, true, ""
+	default:
+		return nil, false, fmt.Sprintf("unknown condition: %s", key)
+	}
+}
+
+// parseIPOrCIDR parses either a CIDR or a single IP (as a /32 or /128 network).
+func parseIPOrCIDR(s string) *net.IPNet {
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		return ipNet
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes since midnight.
+// "24:00" is accepted as the end of the day.
+func parseTimeOfDay(s string) (int, bool) {
+	hStr, mStr, ok := strings.Cut(s, ":")
+	if !ok || len(mStr) != 2 {
+		return 0, false
+	}
+	h, err := strconv.Atoi(hStr)
+	if err != nil || h < 0 || h > 24 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(mStr)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, false
+	}
+	return h*60 + m, true
+}
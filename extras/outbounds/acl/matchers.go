@@ -17,6 +17,18 @@ type hostMatcher interface {
 	Match(HostInfo) bool
 }
 
+// matchesIP returns whether m may match a host by its IP addresses.
+func matchesIP(m hostMatcher) bool {
+	switch m := m.(type) {
+	case *ipMatcher, *cidrMatcher, *geoipMatcher:
+		return true
+	case *ruleSetMatcher:
+		return len(m.data.Load().IPRanges) > 0
+	default:
+		return false
+	}
+}
+
 type ipMatcher struct {
 	IP net.IP
 }
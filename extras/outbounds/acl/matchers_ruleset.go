@@ -0,0 +1,182 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apernet/hysteria/extras/v2/outbounds/acl/ruleset"
+)
+
+// RuleSetProvider provides the current content of a rule set.
+// The content may change over time (e.g. when a remote rule set is refreshed),
+// in which case RuleSet must return a new pointer.
+type RuleSetProvider interface {
+	RuleSet() *ruleset.RuleSet
+}
+
+// RuleSetLoader is an optional interface that a GeoLoader can implement
+// to support "ruleset:name" rules.
+type RuleSetLoader interface {
+	LoadRuleSet(name string) (RuleSetProvider, error)
+}
+
+var _ hostMatcher = (*ruleSetMatcher)(nil)
+
+type ipRange struct {
+	From, To netip.Addr
+}
+
+// ruleSetData is a rule set compiled for fast matching.
+type ruleSetData struct {
+	Source         *ruleset.RuleSet
+	Domains        map[string]bool
+	Suffixes       map[string]bool // matches the domain and its subdomains
+	SubdomainsOnly map[string]bool // matches only the subdomains
+	Keywords       []string
+	Regexes        []*regexp.Regexp
+	IPRanges       []ipRange // sorted & merged
+}
+
+func newRuleSetData(rs *ruleset.RuleSet) *ruleSetData {
+	d := &ruleSetData{
+		Source:         rs,
+		Domains:        make(map[string]bool, len(rs.Domains)),
+		Suffixes:       make(map[string]bool),
+		SubdomainsOnly: make(map[string]bool),
+		Keywords:       rs.DomainKeywords,
+		Regexes:        rs.DomainRegexes,
+	}
+	for _, domain := range rs.Domains {
+		d.Domains[domain] = true
+	}
+	for _, suffix := range rs.DomainSuffixes {
+		if strings.HasPrefix(suffix, ".") {
+			d.SubdomainsOnly[suffix[1:]] = true
+		} else {
+			d.Suffixes[suffix] = true
+		}
+	}
+	// Prefixes in a rule set may overlap, so we convert them to
+	// ranges and merge them, which makes binary search possible.
+	ranges := make([]ipRange, 0, len(rs.IPs))
+	for _, p := range rs.IPs {
+		p = p.Masked()
+		ranges = append(ranges, ipRange{p.Addr(), lastPrefixAddr(p)})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].From.Less(ranges[j].From)
+	})
+	for _, r := range ranges {
+		if n := len(d.IPRanges); n > 0 {
+			last := &d.IPRanges[n-1]
+			if last.To.Is4() == r.From.Is4() &&
+				(r.From.Compare(last.To) <= 0 || r.From == last.To.Next()) {
+				if last.To.Less(r.To) {
+					last.To = r.To
+				}
+				continue
+			}
+		}
+		d.IPRanges = append(d.IPRanges, r)
+	}
+	return d
+}
+
+func lastPrefixAddr(p netip.Prefix) netip.Addr {
+	bs := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(bs)*8; i++ {
+		bs[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(bs)
+	return addr
+}
+
+func (d *ruleSetData) matchDomain(name string) bool {
+	if name == "" {
+		return false
+	}
+	if d.Domains[name] || d.Suffixes[name] {
+		return true
+	}
+	for i := strings.IndexByte(name, '.'); i >= 0; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if d.Suffixes[name] || d.SubdomainsOnly[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ruleSetData) matchIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	// Find the last range that starts at or before addr
+	i := sort.Search(len(d.IPRanges), func(i int) bool {
+		return addr.Less(d.IPRanges[i].From)
+	}) - 1
+	return i >= 0 && addr.Compare(d.IPRanges[i].To) <= 0
+}
+
+func (d *ruleSetData) Match(host HostInfo) bool {
+	if d.matchDomain(host.Name) || d.matchIP(host.IPv4) || d.matchIP(host.IPv6) {
+		return true
+	}
+	if host.Name != "" {
+		for _, keyword := range d.Keywords {
+			if strings.Contains(host.Name, keyword) {
+				return true
+			}
+		}
+		for _, regex := range d.Regexes {
+			if regex.MatchString(host.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleSetMatcher matches hosts against a rule set whose content may change.
+// The compiled data is swapped atomically by update, so that Match
+// can be called concurrently.
+type ruleSetMatcher struct {
+	Provider RuleSetProvider
+	data     atomic.Pointer[ruleSetData]
+}
+
+func newRuleSetMatcher(provider RuleSetProvider) *ruleSetMatcher {
+	m := &ruleSetMatcher{Provider: provider}
+	m.update()
+	return m
+}
+
+// update recompiles the rule set if the provider has new content.
+// Returns whether it was updated.
+func (m *ruleSetMatcher) update() bool {
+	rs := m.Provider.RuleSet()
+	if d := m.data.Load(); d != nil && d.Source == rs {
+		return false
+	}
+	if rs == nil {
+		d := newRuleSetData(&ruleset.RuleSet{})
+		d.Source = nil
+		m.data.Store(d)
+	} else {
+		m.data.Store(newRuleSetData(rs))
+	}
+	return true
+}
+
+func (m *ruleSetMatcher) Match(host HostInfo) bool {
+	return m.data.Load().Match(host)
+}
@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-var linePattern = regexp.MustCompile(`^(\w+)\s*\(([^,]+)(?:,([^,]+))?(?:,([^,]+))?\)$`)
+var linePattern = regexp.MustCompile(`^(\w+)\s*\(([^,()]+)(?:,([^,()]+))?(?:,([^,()]+))?\)((?:\s+\S+)*)$`)
 
 type InvalidSyntaxError struct {
 	Line    string
@@ -24,12 +24,18 @@ func (e *InvalidSyntaxError) Error() string {
 //	outbound(address,protoPort)
 //	outbound(address,protoPort,hijackAddress)
 //
+// Any of the above can be followed by one or more whitespace-separated conditions
+// in the form of "key:value", which restrict the rule to certain clients or times:
+//
+//	reject(geosite:netflix) user:kids time:22:00-07:00
+//
 // It does not check whether any of the fields is valid - it's up to the compiler to do so.
 type TextRule struct {
 	Outbound      string
 	Address       string
 	ProtoPort     string
 	HijackAddress string
+	Conditions    []string
 	LineNum       int
 }
 
@@ -38,13 +44,17 @@ func parseLine(line string, num int) *TextRule {
 	if matches == nil {
 		return nil
 	}
-	return &TextRule{
+	rule := &TextRule{
 		Outbound:      matches[1],
 		Address:       strings.TrimSpace(matches[2]),
 		ProtoPort:     strings.TrimSpace(matches[3]),
 		HijackAddress: strings.TrimSpace(matches[4]),
 		LineNum:       num,
 	}
+	if conds := strings.Fields(matches[5]); len(conds) > 0 {
+		rule.Conditions = conds
+	}
+	return rule
 }
 
 func ParseTextRules(text string) ([]TextRule, error) {
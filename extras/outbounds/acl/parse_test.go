@@ -42,6 +42,20 @@ my_custom_outbound2(all)
 			},
 			wantErr: false,
 		},
+		{
+			name: "conditions",
+			text: `
+reject(geosite:netflix) user:kids time:22:00-07:00
+direct(all, tcp/22)   src:10.0.0.0/8 # inline comment
+ob(1.1.1.1,*,8.8.8.8) user:a,b
+`,
+			want: []TextRule{
+				{Outbound: "reject", Address: "geosite:netflix", Conditions: []string{"user:kids", "time:22:00-07:00"}, LineNum: 2},
+				{Outbound: "direct", Address: "all", ProtoPort: "tcp/22", Conditions: []string{"src:10.0.0.0/8"}, LineNum: 3},
+				{Outbound: "ob", Address: "1.1.1.1", ProtoPort: "*", HijackAddress: "8.8.8.8", Conditions: []string{"user:a,b"}, LineNum: 4},
+			},
+			wantErr: false,
+		},
 		{
 			name:    "fail 1",
 			text:    `boom()`,
@@ -54,6 +68,12 @@ my_custom_outbound2(all)
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name:    "fail 3",
+			text:    `direct(all)user:kids`,
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
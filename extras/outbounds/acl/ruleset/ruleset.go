@@ -0,0 +1,158 @@
+package ruleset
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// Format is the file format of a rule set.
+type Format string
+
+const (
+	// FormatDomain is a list of domains, one per line.
+	// "example.com" matches only example.com itself,
+	// "+.example.com" matches example.com and all its subdomains,
+	// ".example.com" and "*.example.com" match only the subdomains.
+	FormatDomain Format = "domain"
+	// FormatCIDR is a list of CIDRs or IP addresses, one per line.
+	FormatCIDR Format = "cidr"
+	// FormatClassical is the Clash "classical" format, e.g. "DOMAIN-SUFFIX,example.com".
+	// Only DOMAIN, DOMAIN-SUFFIX, DOMAIN-KEYWORD, DOMAIN-REGEX, IP-CIDR & IP-CIDR6
+	// are supported, other types are ignored.
+	FormatClassical Format = "classical"
+	// FormatSRS is the binary sing-box rule set format.
+	FormatSRS Format = "srs"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatDomain, FormatCIDR, FormatClassical, FormatSRS:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported rule set format: %s", s)
+	}
+}
+
+// RuleSet is the parsed content of a rule set file. A host matches
+// the rule set if it matches any of the entries.
+type RuleSet struct {
+	// Domains are matched exactly.
+	Domains []string
+	// DomainSuffixes like "example.com" match example.com and all its subdomains.
+	// Those starting with a dot, like ".example.com", match only the subdomains.
+	DomainSuffixes []string
+	// DomainKeywords match any domain containing them.
+	DomainKeywords []string
+	DomainRegexes  []*regexp.Regexp
+	IPs            []netip.Prefix
+}
+
+// Parse parses a rule set file in the given format.
+// Domains are normalized to lower case.
+func Parse(format Format, data []byte) (*RuleSet, error) {
+	switch format {
+	case FormatDomain:
+		return parseText(data, parseDomainLine)
+	case FormatCIDR:
+		return parseText(data, parseCIDRLine)
+	case FormatClassical:
+		return parseText(data, parseClassicalLine)
+	case FormatSRS:
+		return parseSRS(data)
+	default:
+		return nil, fmt.Errorf("unsupported rule set format: %s", format)
+	}
+}
+
+// parseText parses the line-based formats. Besides plain text, it also accepts
+// the YAML form used by Clash rule providers ("payload:" followed by a list).
+func parseText(data []byte, parseLine func(rs *RuleSet, line string) error) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for i, line := range strings.Split(string(data), "\n") {
+		// Remove comments
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "payload:" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") {
+			line = strings.Trim(strings.TrimSpace(line[2:]), `'"`)
+		}
+		if err := parseLine(rs, line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return rs, nil
+}
+
+func parseDomainLine(rs *RuleSet, line string) error {
+	line = strings.ToLower(line)
+	switch {
+	case strings.HasPrefix(line, "+."):
+		rs.DomainSuffixes = append(rs.DomainSuffixes, line[2:])
+	case strings.HasPrefix(line, "*."):
+		rs.DomainSuffixes = append(rs.DomainSuffixes, line[1:])
+	case strings.HasPrefix(line, "."):
+		rs.DomainSuffixes = append(rs.DomainSuffixes, line)
+	default:
+		rs.Domains = append(rs.Domains, line)
+	}
+	return nil
+}
+
+func parseCIDRLine(rs *RuleSet, line string) error {
+	prefix, err := parsePrefix(line)
+	if err != nil {
+		return err
+	}
+	rs.IPs = append(rs.IPs, prefix)
+	return nil
+}
+
+func parseClassicalLine(rs *RuleSet, line string) error {
+	parts := strings.Split(line, ",")
+	if len(parts) < 2 {
+		return errors.New("invalid rule: " + line)
+	}
+	value := strings.TrimSpace(parts[1])
+	switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+	case "DOMAIN":
+		rs.Domains = append(rs.Domains, strings.ToLower(value))
+	case "DOMAIN-SUFFIX":
+		rs.DomainSuffixes = append(rs.DomainSuffixes, strings.ToLower(value))
+	case "DOMAIN-KEYWORD":
+		rs.DomainKeywords = append(rs.DomainKeywords, strings.ToLower(value))
+	case "DOMAIN-REGEX":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		rs.DomainRegexes = append(rs.DomainRegexes, re)
+	case "IP-CIDR", "IP-CIDR6":
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return err
+		}
+		rs.IPs = append(rs.IPs, prefix)
+	default:
+		// Unsupported rule types (PROCESS-NAME, GEOIP, etc.) are ignored
+	}
+	return nil
+}
+
+// parsePrefix parses either a CIDR or a single IP address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR or IP address: %s", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,74 @@
+package ruleset
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func regexStrings(rs *RuleSet) []string {
+	var ss []string
+	for _, re := range rs.DomainRegexes {
+		ss = append(ss, re.String())
+	}
+	return ss
+}
+
+func TestParseDomain(t *testing.T) {
+	rs, err := Parse(FormatDomain, []byte(`
+# comment
+Example.com
++.google.com
+.sub.example.net # trailing comment
+*.wild.example.org
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, rs.Domains)
+	assert.Equal(t, []string{"google.com", ".sub.example.net", ".wild.example.org"}, rs.DomainSuffixes)
+}
+
+func TestParseCIDR(t *testing.T) {
+	rs, err := Parse(FormatCIDR, []byte("payload:\n  - '10.0.0.1/8'\n  - \"2001:db8::1\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}, rs.IPs)
+
+	_, err = Parse(FormatCIDR, []byte("10.0.0.0/8\nnope\n"))
+	assert.EqualError(t, err, "line 2: invalid CIDR or IP address: nope")
+}
+
+func TestParseClassical(t *testing.T) {
+	rs, err := Parse(FormatClassical, []byte(`
+payload:
+  - DOMAIN,Exact.example.com
+  - DOMAIN-SUFFIX,example.org
+  - DOMAIN-KEYWORD,tracker
+  - DOMAIN-REGEX,^ad[0-9]+\.
+  - IP-CIDR,192.168.0.0/16,no-resolve
+  - IP-CIDR6,2001:db8::/32
+  - PROCESS-NAME,curl
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"exact.example.com"}, rs.Domains)
+	assert.Equal(t, []string{"example.org"}, rs.DomainSuffixes)
+	assert.Equal(t, []string{"tracker"}, rs.DomainKeywords)
+	assert.Equal(t, []string{`^ad[0-9]+\.`}, regexStrings(rs))
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, rs.IPs)
+
+	_, err = Parse(FormatClassical, []byte("DOMAIN"))
+	assert.Error(t, err)
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("SRS")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatSRS, f)
+	_, err = ParseFormat("mrs")
+	assert.Error(t, err)
+}
@@ -0,0 +1,381 @@
+package ruleset
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// The binary sing-box rule set format (.srs) is a zlib compressed list of
+// "headless" rules, each consisting of a list of items. We only support
+// rules that can be expressed with a RuleSet (domains and destination IPs),
+// any rule with other items (ports, processes, etc.), as well as inverted
+// and logical rules, are skipped so that they don't match more than intended.
+
+var srsMagic = [3]byte{'S', 'R', 'S'}
+
+const srsMaxVersion = 3
+
+const (
+	srsItemQueryType uint8 = iota
+	srsItemNetwork
+	srsItemDomain
+	srsItemDomainKeyword
+	srsItemDomainRegex
+	srsItemSourceIPCIDR
+	srsItemIPCIDR
+	srsItemSourcePort
+	srsItemSourcePortRange
+	srsItemPort
+	srsItemPortRange
+	srsItemProcessName
+	srsItemProcessPath
+	srsItemPackageName
+	srsItemWIFISSID
+	srsItemWIFIBSSID
+	srsItemAdGuardDomain
+	srsItemProcessPathRegex
+	srsItemNetworkType
+	srsItemNetworkIsExpensive
+	srsItemNetworkIsConstrained
+	srsItemFinal uint8 = 0xFF
+)
+
+// Special labels used by the sing-box domain matcher.
+const (
+	srsPrefixLabel = '\r' // followed by ".example.com": subdomains only
+	srsRootLabel   = '\n' // followed by "example.com": the domain and its subdomains
+)
+
+var errSRSUnsupported = errors.New("unsupported rule item")
+
+type srsRule struct {
+	Domains        []string
+	DomainSuffixes []string
+	DomainKeywords []string
+	DomainRegexes  []string
+	IPs            []netip.Prefix
+}
+
+func parseSRS(data []byte) (*RuleSet, error) {
+	if len(data) < 4 || !bytes.Equal(data[:3], srsMagic[:]) {
+		return nil, errors.New("invalid sing-box rule set file")
+	}
+	if version := data[3]; version == 0 || version > srsMaxVersion {
+		return nil, fmt.Errorf("unsupported sing-box rule set version: %d", version)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	rs := &RuleSet{}
+	for i := uint64(0); i < n; i++ {
+		rule, ok, err := readSRSRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if !ok {
+			continue
+		}
+		rs.Domains = append(rs.Domains, rule.Domains...)
+		rs.DomainSuffixes = append(rs.DomainSuffixes, rule.DomainSuffixes...)
+		rs.DomainKeywords = append(rs.DomainKeywords, rule.DomainKeywords...)
+		for _, s := range rule.DomainRegexes {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			rs.DomainRegexes = append(rs.DomainRegexes, re)
+		}
+		rs.IPs = append(rs.IPs, rule.IPs...)
+	}
+	return rs, nil
+}
+
+// readSRSRule reads a rule. ok is false if the rule is valid but not supported.
+func readSRSRule(r *bufio.Reader) (rule srsRule, ok bool, err error) {
+	ruleType, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	switch ruleType {
+	case 0:
+		return readSRSDefaultRule(r)
+	case 1:
+		// Logical rule: mode, sub-rules, invert
+		if _, err = r.ReadByte(); err != nil {
+			return
+		}
+		var n uint64
+		n, err = binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, _, err = readSRSRule(r); err != nil {
+				return
+			}
+		}
+		_, err = r.ReadByte()
+		return rule, false, err
+	default:
+		return rule, false, fmt.Errorf("unknown rule type %d", ruleType)
+	}
+}
+
+func readSRSDefaultRule(r *bufio.Reader) (rule srsRule, ok bool, err error) {
+	ok = true
+	for {
+		var itemType uint8
+		itemType, err = r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch itemType {
+		case srsItemDomain:
+			var domains []string
+			domains, err = readSRSDomainSet(r)
+			for _, d := range domains {
+				d = strings.ToLower(d)
+				switch d[0] {
+				case srsPrefixLabel:
+					rule.DomainSuffixes = append(rule.DomainSuffixes, d[1:])
+				case srsRootLabel:
+					rule.DomainSuffixes = append(rule.DomainSuffixes, d[1:])
+				default:
+					rule.Domains = append(rule.Domains, d)
+				}
+			}
+		case srsItemDomainKeyword:
+			rule.DomainKeywords, err = readSRSStrings(r)
+		case srsItemDomainRegex:
+			rule.DomainRegexes, err = readSRSStrings(r)
+		case srsItemIPCIDR:
+			rule.IPs, err = readSRSIPSet(r)
+		case srsItemQueryType, srsItemSourcePort, srsItemPort:
+			ok = false
+			_, err = readSRSUint16s(r)
+		case srsItemNetwork, srsItemSourcePortRange, srsItemPortRange, srsItemProcessName,
+			srsItemProcessPath, srsItemProcessPathRegex, srsItemPackageName, srsItemWIFISSID, srsItemWIFIBSSID:
+			ok = false
+			_, err = readSRSStrings(r)
+		case srsItemSourceIPCIDR:
+			ok = false
+			_, err = readSRSIPSet(r)
+		case srsItemNetworkType:
+			ok = false
+			_, err = readSRSBytes(r)
+		case srsItemNetworkIsExpensive, srsItemNetworkIsConstrained:
+			ok = false
+		case srsItemFinal:
+			var invert byte
+			invert, err = r.ReadByte()
+			if invert != 0 {
+				ok = false
+			}
+			return
+		default:
+			// AdGuard rules have no length prefix, so we can't skip them
+			return rule, false, fmt.Errorf("%w %d", errSRSUnsupported, itemType)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readSRSBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	bs := make([]byte, n)
+	_, err = io.ReadFull(r, bs)
+	return bs, err
+}
+
+func readSRSStrings(r *bufio.Reader) ([]string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]string, n)
+	for i := range ss {
+		bs, err := readSRSBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = string(bs)
+	}
+	return ss, nil
+}
+
+func readSRSUint16s(r *bufio.Reader) ([]uint16, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]uint16, n)
+	err = binary.Read(r, binary.BigEndian, vs)
+	return vs, err
+}
+
+func readSRSUint64s(r *bufio.Reader) ([]uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]uint64, n)
+	err = binary.Read(r, binary.BigEndian, vs)
+	return vs, err
+}
+
+// readSRSIPSet reads a list of IP ranges and converts them to prefixes.
+func readSRSIPSet(r *bufio.Reader) ([]netip.Prefix, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported IP set version %d", version)
+	}
+	var n uint64
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for i := uint64(0); i < n; i++ {
+		fromBytes, err := readSRSBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		toBytes, err := readSRSBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		from, ok1 := netip.AddrFromSlice(fromBytes)
+		to, ok2 := netip.AddrFromSlice(toBytes)
+		if !ok1 || !ok2 || from.Is4() != to.Is4() || to.Less(from) {
+			return nil, errors.New("invalid IP range")
+		}
+		prefixes = appendRangePrefixes(prefixes, from, to)
+	}
+	return prefixes, nil
+}
+
+// appendRangePrefixes appends the smallest list of prefixes covering [from, to].
+func appendRangePrefixes(prefixes []netip.Prefix, from, to netip.Addr) []netip.Prefix {
+	for {
+		// Find the largest prefix starting at from that doesn't go past to
+		bits := from.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(from, bits-1).Masked()
+			if p.Addr() != from || lastAddr(p).Compare(to) > 0 {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, p)
+		last := lastAddr(p)
+		if last.Compare(to) >= 0 {
+			return prefixes
+		}
+		from = last.Next()
+	}
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	bs := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(bs)*8; i++ {
+		bs[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(bs)
+	return addr
+}
+
+// readSRSDomainSet reads the succinct trie used by the sing-box domain matcher
+// and returns all its keys, in their original (non-reversed) form.
+//
+// The trie is stored in level order: for each node, labelBitmap has a 0 for
+// each of its children followed by a 1, and labels has the label of each child.
+// Each key is stored reversed, and nodes that end a key are marked in leaves.
+func readSRSDomainSet(r *bufio.Reader) ([]string, error) {
+	if _, err := r.ReadByte(); err != nil { // reserved
+		return nil, err
+	}
+	leaves, err := readSRSUint64s(r)
+	if err != nil {
+		return nil, err
+	}
+	labelBitmap, err := readSRSUint64s(r)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := readSRSBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	getBit := func(bm []uint64, i int) bool {
+		return i>>6 < len(bm) && bm[i>>6]&(1<<uint(i&63)) != 0
+	}
+	nodeCount := len(labels) + 1
+	parents := make([]int, nodeCount)
+	parents[0] = -1
+	node, child := 0, 1
+	for bmIdx := 0; node < nodeCount && child <= nodeCount; bmIdx++ {
+		if bmIdx>>6 >= len(labelBitmap) {
+			return nil, errors.New("invalid domain set")
+		}
+		if getBit(labelBitmap, bmIdx) {
+			node++
+		} else {
+			if child >= nodeCount {
+				return nil, errors.New("invalid domain set")
+			}
+			parents[child] = node
+			child++
+		}
+	}
+	var keys []string
+	for i := 1; i < nodeCount; i++ {
+		if !getBit(leaves, i) {
+			continue
+		}
+		var key []byte
+		for n := i; n > 0; n = parents[n] {
+			key = append(key, labels[n-1])
+		}
+		// The key is now byte-reversed, but it was stored rune-reversed
+		keys = append(keys, reverseRunes(reverseBytes(key)))
+	}
+	return keys, nil
+}
+
+func reverseBytes(bs []byte) []byte {
+	for i, j := 0, len(bs)-1; i < j; i, j = i+1, j-1 {
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	return bs
+}
+
+func reverseRunes(bs []byte) string {
+	rs := []rune(string(bs))
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return string(rs)
+}
@@ -0,0 +1,82 @@
+package ruleset
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSRS(t *testing.T) {
+	// Generated by sing-box, see the test cases below for the content.
+	// The third rule (portonly.example.com with port 443) is skipped.
+	tests := []struct {
+		file           string
+		domains        []string
+		domainSuffixes []string
+	}{
+		{
+			// Version 1 stores the suffix "suffix.example.org" as the domain
+			// itself plus the subdomain-only suffix ".suffix.example.org"
+			file:           "test_v1.srs",
+			domains:        []string{"exact.example.com", "suffix.example.org"},
+			domainSuffixes: []string{".sub.example.net", ".suffix.example.org"},
+		},
+		{
+			file:           "test_v2.srs",
+			domains:        []string{"exact.example.com"},
+			domainSuffixes: []string{".sub.example.net", "suffix.example.org"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			assert.NoError(t, err)
+			rs, err := Parse(FormatSRS, data)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.domains, rs.Domains)
+			assert.ElementsMatch(t, tt.domainSuffixes, rs.DomainSuffixes)
+			assert.Equal(t, []string{"tracker"}, rs.DomainKeywords)
+			assert.Equal(t, []string{`^ad[0-9]+\.`}, regexStrings(rs))
+			assert.Equal(t, []netip.Prefix{
+				netip.MustParsePrefix("10.10.0.0/16"),
+				netip.MustParsePrefix("192.0.2.1/32"),
+				netip.MustParsePrefix("2001:db8::/32"),
+			}, rs.IPs)
+		})
+	}
+}
+
+func TestParseSRSInvalid(t *testing.T) {
+	_, err := Parse(FormatSRS, []byte("not a rule set"))
+	assert.Error(t, err)
+	_, err = Parse(FormatSRS, []byte("SRS\x09"))
+	assert.Error(t, err)
+
+	data, err := os.ReadFile("test_v1.srs")
+	assert.NoError(t, err)
+	_, err = Parse(FormatSRS, data[:len(data)/2])
+	assert.Error(t, err)
+}
+
+func TestAppendRangePrefixes(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}},
+		{"10.0.0.1", "10.0.0.1", []string{"10.0.0.1/32"}},
+		{"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"::", "::1", []string{"::/127"}},
+	}
+	for _, tt := range tests {
+		got := appendRangePrefixes(nil, netip.MustParseAddr(tt.from), netip.MustParseAddr(tt.to))
+		var gotStrs []string
+		for _, p := range got {
+			gotStrs = append(gotStrs, p.String())
+		}
+		assert.Equal(t, tt.want, gotStrs, "%s-%s", tt.from, tt.to)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/server"
+	"github.com/apernet/hysteria/extras/v2/transport/multiport"
 )
 
 func TestACLEngine(t *testing.T) {
@@ -59,3 +62,69 @@ reJect(nsa.gov)
 	assert.Error(t, err)
 	assert.Nil(t, conn)
 }
+
+func TestACLEngineConditions(t *testing.T) {
+	ob1, ob2 := &mockPluggableOutbound{}, &mockPluggableOutbound{}
+	obs := []OutboundEntry{
+		{"ob1", ob1},
+		{"ob2", ob2},
+	}
+	acl, err := NewACLEngineFromString(`
+ob2(all) user:kids
+ob2(all) src:10.0.0.0/8
+`, obs, nil)
+	assert.NoError(t, err)
+
+	// No RequestInfo, default (ob1)
+	ob1.EXPECT().TCP(&AddrEx{Host: "example.com"}).Return(nil, nil).Once()
+	_, err = acl.TCP(&AddrEx{Host: "example.com"})
+	assert.NoError(t, err)
+
+	// Match by user
+	reqAddr := &AddrEx{Host: "example.com", RequestInfo: &server.RequestInfo{AuthID: "kids"}}
+	ob2.EXPECT().TCP(reqAddr).Return(nil, nil).Once()
+	_, err = acl.TCP(reqAddr)
+	assert.NoError(t, err)
+
+	// Match by source IP
+	reqAddr = &AddrEx{Host: "example.com", RequestInfo: &server.RequestInfo{
+		AuthID: "adult",
+		Addr:   &net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 12345},
+	}}
+	ob2.EXPECT().UDP(reqAddr).Return(nil, nil).Once()
+	_, err = acl.UDP(reqAddr)
+	assert.NoError(t, err)
+}
+
+// TestACLEngineMultiportSource tests source IP rules on clients of a multi-port listener,
+// whose addresses wrap the actual ones.
+func TestACLEngineMultiportSource(t *testing.T) {
+	ob1, ob2 := &mockPluggableOutbound{}, &mockPluggableOutbound{}
+	acl, err := NewACLEngineFromString(`
+ob2(all) src:127.0.0.0/8
+`, []OutboundEntry{{"ob1", ob1}, {"ob2", ob2}}, nil)
+	assert.NoError(t, err)
+
+	var conns []net.PacketConn
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	mConn, err := multiport.NewPacketConn(conns)
+	assert.NoError(t, err)
+	defer mConn.Close()
+	cConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cConn.Close()
+	_, err = cConn.WriteTo([]byte("hi"), conns[1].LocalAddr())
+	assert.NoError(t, err)
+	_, addr, err := mConn.ReadFrom(make([]byte, 2048))
+	assert.NoError(t, err)
+	assert.IsType(t, &multiport.Addr{}, addr)
+
+	reqAddr := &AddrEx{Host: "example.com", RequestInfo: &server.RequestInfo{Addr: addr}}
+	ob2.EXPECT().TCP(reqAddr).Return(nil, nil).Once()
+	_, err = acl.TCP(reqAddr)
+	assert.NoError(t, err)
+}
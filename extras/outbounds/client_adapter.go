@@ -0,0 +1,181 @@
+package outbounds
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	coreErrs "github.com/apernet/hysteria/core/v2/errors"
+)
+
+const udpBufferSize = 4096
+
+var _ client.Client = (*PluggableOutboundClientAdapter)(nil)
+
+// PluggableOutboundClientAdapter is the client-side counterpart of PluggableOutboundAdapter.
+// It turns a PluggableOutbound into a Hysteria client.Client, so that client-side
+// modes (SOCKS5, HTTP, TUN, etc.) can send requests through a pipeline,
+// e.g. an ACL engine that chooses between a Hysteria outbound and a direct one.
+//
+// Since client.Client.UDP() doesn't take an address, the underlying UDP connections
+// are only created when packets are sent, with the address of the first packet as
+// the request address (which is what the ACL engine makes decisions on).
+// If the pipeline chooses between outbounds (udpRouter), each packet is routed
+// by its own address, with one underlying UDP connection per outbound.
+//
+// Close does nothing, the caller is responsible for closing the outbounds.
+type PluggableOutboundClientAdapter struct {
+	PluggableOutbound
+}
+
+func (a *PluggableOutboundClientAdapter) TCP(addr string) (net.Conn, error) {
+	reqAddr, err := parseAddrEx(addr)
+	if err != nil {
+		return nil, err
+	}
+	return a.PluggableOutbound.TCP(reqAddr)
+}
+
+func (a *PluggableOutboundClientAdapter) UDP() (client.HyUDPConn, error) {
+	return &clientUDPConnAdapter{
+		Outbound:  a.PluggableOutbound,
+		ReceiveCh: make(chan udpReadResult),
+		CloseCh:   make(chan struct{}),
+		conns:     make(map[PluggableOutbound]UDPConn),
+	}, nil
+}
+
+func (a *PluggableOutboundClientAdapter) Close() error {
+	return nil
+}
+
+// udpRouter is implemented by the stages of the pipeline that choose between
+// outbounds, such as the ACL engine. RouteUDP returns the outbound that a UDP
+// request for reqAddr goes to (which may modify reqAddr like UDP would).
+// Each outbound returned must be comparable, as it's used as a map key.
+type udpRouter interface {
+	RouteUDP(reqAddr *AddrEx) PluggableOutbound
+}
+
+type udpReadResult struct {
+	Data []byte
+	Addr string
+	Err  error
+}
+
+type clientUDPConnAdapter struct {
+	Outbound  PluggableOutbound
+	ReceiveCh chan udpReadResult // reads from all the underlying conns
+	CloseCh   chan struct{}
+
+	mutex  sync.Mutex
+	conns  map[PluggableOutbound]UDPConn
+	closed bool
+}
+
+// open returns the underlying UDP connection for ob, creating it if needed.
+func (c *clientUDPConnAdapter) open(ob PluggableOutbound, reqAddr *AddrEx) (UDPConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, coreErrs.ClosedError{}
+	}
+	if conn, ok := c.conns[ob]; ok {
+		return conn, nil
+	}
+	conn, err := ob.UDP(reqAddr)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, errors.New("outbound returned no UDP connection")
+	}
+	c.conns[ob] = conn
+	go c.readLoop(conn)
+	return conn, nil
+}
+
+func (c *clientUDPConnAdapter) readLoop(conn UDPConn) {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		var r udpReadResult
+		if err != nil {
+			r.Err = err
+		} else {
+			r.Data = make([]byte, n)
+			copy(r.Data, buf[:n])
+			if addr != nil {
+				r.Addr = addr.String()
+			}
+		}
+		select {
+		case c.ReceiveCh <- r:
+		case <-c.CloseCh:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *clientUDPConnAdapter) Receive() ([]byte, string, error) {
+	select {
+	case r := <-c.ReceiveCh:
+		return r.Data, r.Addr, r.Err
+	case <-c.CloseCh:
+		return nil, "", coreErrs.ClosedError{}
+	}
+}
+
+func (c *clientUDPConnAdapter) Send(data []byte, addr string) error {
+	reqAddr, err := parseAddrEx(addr)
+	if err != nil {
+		return err
+	}
+	ob := c.Outbound
+	if router, ok := ob.(udpRouter); ok {
+		ob = router.RouteUDP(reqAddr)
+	}
+	conn, err := c.open(ob, reqAddr)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(data, reqAddr)
+	return err
+}
+
+func (c *clientUDPConnAdapter) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.CloseCh)
+	var firstErr error
+	for _, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func parseAddrEx(addr string) (*AddrEx, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portInt, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return &AddrEx{
+		Host: host,
+		Port: uint16(portInt),
+	}, nil
+}
@@ -0,0 +1,158 @@
+package outbounds
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPluggableOutboundClientAdapter(t *testing.T) {
+	ob := newMockPluggableOutbound(t)
+	adapter := &PluggableOutboundClientAdapter{ob}
+
+	ob.EXPECT().TCP(&AddrEx{
+		Host: "only.fans",
+		Port: 443,
+	}).Return(nil, nil).Once()
+	conn, err := adapter.TCP("only.fans:443")
+	assert.Nil(t, conn)
+	assert.Nil(t, err)
+
+	_, err = adapter.TCP("only.fans")
+	assert.Error(t, err)
+
+	// The underlying UDP conn is created on the first send
+	mc := newMockUDPConn(t)
+	ob.EXPECT().UDP(&AddrEx{
+		Host: "hololive.tv",
+		Port: 8999,
+	}).Return(mc, nil).Once()
+	mc.EXPECT().WriteTo([]byte("gawr"), &AddrEx{
+		Host: "hololive.tv",
+		Port: 8999,
+	}).Return(4, nil).Once()
+	mc.EXPECT().WriteTo([]byte("gura"), &AddrEx{
+		Host: "another.hololive.tv",
+		Port: 1551,
+	}).Return(4, nil).Once()
+	mc.EXPECT().ReadFrom(mock.Anything).RunAndReturn(func(bs []byte) (int, *AddrEx, error) {
+		return copy(bs, "shark"), &AddrEx{
+			Host: "hololive.tv",
+			Port: 8999,
+		}, nil
+	}).Once()
+	mc.EXPECT().ReadFrom(mock.Anything).Return(0, nil, errors.New("closed")).Maybe()
+	mc.EXPECT().Close().Return(nil).Once()
+
+	uConn, err := adapter.UDP()
+	assert.Nil(t, err)
+	assert.Nil(t, uConn.Send([]byte("gawr"), "hololive.tv:8999"))
+	assert.Nil(t, uConn.Send([]byte("gura"), "another.hololive.tv:1551"))
+	bs, addr, err := uConn.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, "shark", string(bs))
+	assert.Equal(t, "hololive.tv:8999", addr)
+	assert.Nil(t, uConn.Close())
+	assert.Error(t, uConn.Send([]byte("gawr"), "hololive.tv:8999"))
+
+	// Failing to create the UDP conn fails the send, and is tried again on the next one
+	rejectErr := errors.New("rejected")
+	ob.EXPECT().UDP(&AddrEx{
+		Host: "reject.me",
+		Port: 53,
+	}).Return(nil, rejectErr).Twice()
+	uConn, err = adapter.UDP()
+	assert.Nil(t, err)
+	assert.ErrorIs(t, uConn.Send([]byte("hi"), "reject.me:53"), rejectErr)
+	assert.ErrorIs(t, uConn.Send([]byte("hi"), "reject.me:53"), rejectErr)
+	assert.Nil(t, uConn.Close())
+
+	// Receive returns when closed before any send
+	uConn, err = adapter.UDP()
+	assert.Nil(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := uConn.Receive()
+		errCh <- err
+	}()
+	assert.Nil(t, uConn.Close())
+	assert.Error(t, <-errCh)
+}
+
+func TestPluggableOutboundClientAdapterRouting(t *testing.T) {
+	proxy, direct := newMockPluggableOutbound(t), newMockPluggableOutbound(t)
+	acl, err := NewACLEngineFromString(`
+direct(*.lan)
+direct(10.0.0.0/8)
+reject(*.ads.com)
+`, []OutboundEntry{
+		{"proxy", proxy},
+		{"direct", direct},
+	}, nil)
+	assert.NoError(t, err)
+	adapter := &PluggableOutboundClientAdapter{NewOnDemandSystemResolver(acl)}
+
+	// Domains that are decided without IP rules are not resolved
+	proxy.EXPECT().TCP(&AddrEx{Host: "direct.lan", Port: 80}).Return(nil, nil).Maybe()
+	direct.EXPECT().TCP(&AddrEx{Host: "direct.lan", Port: 80}).Return(nil, nil).Once()
+	_, err = adapter.TCP("direct.lan:80")
+	assert.NoError(t, err)
+	// IP rules are matched against the resolved addresses
+	direct.EXPECT().TCP(&AddrEx{
+		Host:        "10.1.2.3",
+		Port:        80,
+		ResolveInfo: &ResolveInfo{IPv4: net.ParseIP("10.1.2.3")},
+	}).Return(nil, nil).Once()
+	_, err = adapter.TCP("10.1.2.3:80")
+	assert.NoError(t, err)
+
+	// Each destination of a UDP session goes to its own outbound,
+	// with one conn per outbound, and reads from all of them
+	proxyConn, directConn := newMockUDPConn(t), newMockUDPConn(t)
+	proxy.EXPECT().UDP(&AddrEx{Host: "1.1.1.1", Port: 53, ResolveInfo: &ResolveInfo{IPv4: net.ParseIP("1.1.1.1")}}).Return(proxyConn, nil).Once()
+	direct.EXPECT().UDP(&AddrEx{Host: "printer.lan", Port: 631}).Return(directConn, nil).Once()
+	proxyConn.EXPECT().WriteTo([]byte("q1"), mock.Anything).Return(2, nil).Once()
+	proxyConn.EXPECT().WriteTo([]byte("q2"), mock.Anything).Return(2, nil).Once()
+	directConn.EXPECT().WriteTo([]byte("print"), mock.Anything).Return(5, nil).Once()
+	proxyRead, directRead := make(chan struct{}), make(chan struct{})
+	readFrom := func(ch chan struct{}, data string, addr *AddrEx) func([]byte) (int, *AddrEx, error) {
+		return func(bs []byte) (int, *AddrEx, error) {
+			if _, ok := <-ch; !ok {
+				return 0, nil, errors.New("closed")
+			}
+			return copy(bs, data), addr, nil
+		}
+	}
+	proxyConn.EXPECT().ReadFrom(mock.Anything).RunAndReturn(readFrom(proxyRead, "a1", &AddrEx{Host: "1.1.1.1", Port: 53}))
+	directConn.EXPECT().ReadFrom(mock.Anything).RunAndReturn(readFrom(directRead, "ok", &AddrEx{Host: "192.168.1.5", Port: 631}))
+	proxyConn.EXPECT().Close().RunAndReturn(func() error {
+		close(proxyRead)
+		return nil
+	}).Once()
+	directConn.EXPECT().Close().RunAndReturn(func() error {
+		close(directRead)
+		return nil
+	}).Once()
+
+	uConn, err := adapter.UDP()
+	assert.NoError(t, err)
+	assert.NoError(t, uConn.Send([]byte("q1"), "1.1.1.1:53"))
+	assert.NoError(t, uConn.Send([]byte("print"), "printer.lan:631"))
+	assert.NoError(t, uConn.Send([]byte("q2"), "1.1.1.1:53"))
+	assert.ErrorIs(t, uConn.Send([]byte("ad"), "tracker.ads.com:443"), errRejected)
+
+	directRead <- struct{}{}
+	bs, addr, err := uConn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(bs))
+	assert.Equal(t, "192.168.1.5:631", addr)
+	proxyRead <- struct{}{}
+	bs, addr, err = uConn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(bs))
+	assert.Equal(t, "1.1.1.1:53", addr)
+	assert.NoError(t, uConn.Close())
+}
@@ -19,7 +19,7 @@ func NewSystemResolver(next PluggableOutbound) PluggableOutbound {
 	}
 }
 
-func (r *systemResolver) resolve(reqAddr *AddrEx) {
+func systemResolve(reqAddr *AddrEx) {
 	ips, err := net.LookupIP(reqAddr.Host)
 	if err != nil {
 		reqAddr.ResolveInfo = &ResolveInfo{Err: err}
@@ -31,11 +31,44 @@ func (r *systemResolver) resolve(reqAddr *AddrEx) {
 }
 
 func (r *systemResolver) TCP(reqAddr *AddrEx) (net.Conn, error) {
-	r.resolve(reqAddr)
+	systemResolve(reqAddr)
 	return r.Next.TCP(reqAddr)
 }
 
 func (r *systemResolver) UDP(reqAddr *AddrEx) (UDPConn, error) {
-	r.resolve(reqAddr)
+	systemResolve(reqAddr)
 	return r.Next.UDP(reqAddr)
 }
+
+// onDemandSystemResolver is like systemResolver, but it leaves the resolution to
+// the ACL engine after it, which only resolves a hostname when it gets to a rule
+// that matches IP addresses. Requests that are decided before that, or on which
+// no rule matches by IP, are passed on with the hostname alone, so that
+// they don't leak to the local DNS server if they go through a proxy.
+type onDemandSystemResolver struct {
+	Next PluggableOutbound
+}
+
+func NewOnDemandSystemResolver(next PluggableOutbound) PluggableOutbound {
+	return &onDemandSystemResolver{
+		Next: next,
+	}
+}
+
+func (r *onDemandSystemResolver) TCP(reqAddr *AddrEx) (net.Conn, error) {
+	reqAddr.resolve = systemResolve
+	return r.Next.TCP(reqAddr)
+}
+
+func (r *onDemandSystemResolver) UDP(reqAddr *AddrEx) (UDPConn, error) {
+	reqAddr.resolve = systemResolve
+	return r.Next.UDP(reqAddr)
+}
+
+func (r *onDemandSystemResolver) RouteUDP(reqAddr *AddrEx) PluggableOutbound {
+	if router, ok := r.Next.(udpRouter); ok {
+		reqAddr.resolve = systemResolve
+		return router.RouteUDP(reqAddr)
+	}
+	return r
+}
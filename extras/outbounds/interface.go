@@ -1,6 +1,8 @@
 package outbounds
 
 import (
+	"errors"
+	"io"
 	"net"
 	"strconv"
 
@@ -38,10 +40,17 @@ type UDPConn interface {
 // A SOCKS5 outbound, for example, should prefer the string representation
 // because SOCKS5 protocol supports sending the hostname to the proxy server
 // and let the proxy server do the DNS resolution.
+// RequestInfo describes the client that made the request, and is only set
+// for the initial address of a TCP or UDP request (not for every UDP packet).
 type AddrEx struct {
 	Host        string // String representation of the host, can be an IP or a domain name
 	Port        uint16
-	ResolveInfo *ResolveInfo // Only set if there's a resolver in the pipeline
+	ResolveInfo *ResolveInfo        // Only set if there's a resolver in the pipeline
+	RequestInfo *server.RequestInfo // Can be nil
+
+	// resolve is set by an on-demand resolver to let the ACL engine
+	// fill in ResolveInfo only when a rule needs it.
+	resolve func(reqAddr *AddrEx)
 }
 
 func (a *AddrEx) String() string {
@@ -60,13 +69,36 @@ type ResolveInfo struct {
 	Err  error
 }
 
-var _ server.Outbound = (*PluggableOutboundAdapter)(nil)
+var (
+	_ server.Outbound   = (*PluggableOutboundAdapter)(nil)
+	_ server.OutboundEx = (*PluggableOutboundAdapter)(nil)
+	_ io.Closer         = (*PluggableOutboundAdapter)(nil)
+)
 
+// PluggableOutboundAdapter also closes the outbounds in Closers when closed.
+// These are the outbounds in the pipeline that hold resources, such as a connection
+// to the next hop or a tunnel, which are not reachable through PluggableOutbound
+// as ACL, resolvers and such don't forward Close.
 type PluggableOutboundAdapter struct {
 	PluggableOutbound
+	Closers []io.Closer
+}
+
+func (a *PluggableOutboundAdapter) Close() error {
+	var errs []error
+	for _, c := range a.Closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (a *PluggableOutboundAdapter) TCP(reqAddr string) (net.Conn, error) {
+	return a.TCPEx(nil, reqAddr)
+}
+
+func (a *PluggableOutboundAdapter) TCPEx(info *server.RequestInfo, reqAddr string) (net.Conn, error) {
 	host, port, err := net.SplitHostPort(reqAddr)
 	if err != nil {
 		return nil, err
@@ -76,12 +108,17 @@ func (a *PluggableOutboundAdapter) TCP(reqAddr string) (net.Conn, error) {
 		return nil, err
 	}
 	return a.PluggableOutbound.TCP(&AddrEx{
-		Host: host,
-		Port: uint16(portInt),
+		Host:        host,
+		Port:        uint16(portInt),
+		RequestInfo: info,
 	})
 }
 
 func (a *PluggableOutboundAdapter) UDP(reqAddr string) (server.UDPConn, error) {
+	return a.UDPEx(nil, reqAddr)
+}
+
+func (a *PluggableOutboundAdapter) UDPEx(info *server.RequestInfo, reqAddr string) (server.UDPConn, error) {
 	host, port, err := net.SplitHostPort(reqAddr)
 	if err != nil {
 		return nil, err
@@ -91,8 +128,9 @@ func (a *PluggableOutboundAdapter) UDP(reqAddr string) (server.UDPConn, error) {
 		return nil, err
 	}
 	conn, err := a.PluggableOutbound.UDP(&AddrEx{
-		Host: host,
-		Port: uint16(portInt),
+		Host:        host,
+		Port:        uint16(portInt),
+		RequestInfo: info,
 	})
 	if err != nil {
 		return nil, err
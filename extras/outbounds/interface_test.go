@@ -5,11 +5,13 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/apernet/hysteria/core/v2/server"
 )
 
 func TestPluggableOutboundAdapter(t *testing.T) {
 	ob := newMockPluggableOutbound(t)
-	adapter := &PluggableOutboundAdapter{ob}
+	adapter := &PluggableOutboundAdapter{PluggableOutbound: ob}
 
 	ob.EXPECT().TCP(&AddrEx{
 		Host: "only.fans",
@@ -47,4 +49,14 @@ func TestPluggableOutboundAdapter(t *testing.T) {
 	assert.Equal(t, 4, n)
 	assert.Equal(t, "gura", string(bs[:n]))
 	assert.Equal(t, "gura.com:2333", addr)
+
+	info := &server.RequestInfo{AuthID: "ame"}
+	ob.EXPECT().TCP(&AddrEx{
+		Host:        "only.fans",
+		Port:        443,
+		RequestInfo: info,
+	}).Return(nil, nil).Once()
+	conn, err = adapter.TCPEx(info, "only.fans:443")
+	assert.Nil(t, conn)
+	assert.Nil(t, err)
 }
@@ -0,0 +1,79 @@
+package outbounds
+
+import (
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/apernet/hysteria/core/v2/client"
+)
+
+// hysteriaOutbound is a PluggableOutbound that relays requests through
+// another Hysteria server, which makes multi-hop setups possible.
+// Since the next hop does its own DNS resolution (and possibly has a
+// different view of the network), it will ignore ResolveInfo in AddrEx
+// and always only use Host.
+type hysteriaOutbound struct {
+	Client client.Client
+}
+
+// NewHysteriaOutbound creates a PluggableOutbound from a Hysteria client.
+// The client is usually a reconnectable one, as the outbound is expected
+// to keep working after the connection to the next hop is lost.
+func NewHysteriaOutbound(c client.Client) PluggableOutbound {
+	return &hysteriaOutbound{Client: c}
+}
+
+var _ io.Closer = (*hysteriaOutbound)(nil)
+
+// Close closes the client, and with it the connection to the next hop.
+func (o *hysteriaOutbound) Close() error {
+	return o.Client.Close()
+}
+
+func (o *hysteriaOutbound) TCP(reqAddr *AddrEx) (net.Conn, error) {
+	return o.Client.TCP(reqAddr.String())
+}
+
+func (o *hysteriaOutbound) UDP(reqAddr *AddrEx) (UDPConn, error) {
+	conn, err := o.Client.UDP()
+	if err != nil {
+		return nil, err
+	}
+	return &hysteriaUDPConn{Conn: conn}, nil
+}
+
+type hysteriaUDPConn struct {
+	Conn client.HyUDPConn
+}
+
+func (c *hysteriaUDPConn) ReadFrom(b []byte) (int, *AddrEx, error) {
+	bs, addr, err := c.Conn.Receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := copy(b, bs)
+	return n, &AddrEx{
+		Host: host,
+		Port: uint16(portInt),
+	}, nil
+}
+
+func (c *hysteriaUDPConn) WriteTo(b []byte, addr *AddrEx) (int, error) {
+	if err := c.Conn.Send(b, addr.String()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *hysteriaUDPConn) Close() error {
+	return c.Conn.Close()
+}
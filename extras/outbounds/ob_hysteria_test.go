@@ -0,0 +1,81 @@
+package outbounds
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/client"
+)
+
+type fakeHyClient struct {
+	TCPAddr string
+	UDPConn *fakeHyUDPConn
+}
+
+func (c *fakeHyClient) TCP(addr string) (net.Conn, error) {
+	c.TCPAddr = addr
+	return nil, errors.New("fake")
+}
+
+func (c *fakeHyClient) UDP() (client.HyUDPConn, error) {
+	return c.UDPConn, nil
+}
+
+func (c *fakeHyClient) Close() error {
+	return nil
+}
+
+type fakeHyUDPConn struct {
+	Sent     []byte
+	SentAddr string
+	Closed   bool
+}
+
+func (c *fakeHyUDPConn) Receive() ([]byte, string, error) {
+	return []byte("pong"), "[2001:db8::1]:53", nil
+}
+
+func (c *fakeHyUDPConn) Send(bs []byte, addr string) error {
+	c.Sent = bs
+	c.SentAddr = addr
+	return nil
+}
+
+func (c *fakeHyUDPConn) Close() error {
+	c.Closed = true
+	return nil
+}
+
+func TestHysteriaOutbound(t *testing.T) {
+	fc := &fakeHyClient{UDPConn: &fakeHyUDPConn{}}
+	ob := NewHysteriaOutbound(fc)
+
+	// ResolveInfo should be ignored
+	_, err := ob.TCP(&AddrEx{
+		Host:        "example.com",
+		Port:        443,
+		ResolveInfo: &ResolveInfo{IPv4: net.ParseIP("1.2.3.4")},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "example.com:443", fc.TCPAddr)
+
+	uc, err := ob.UDP(&AddrEx{Host: "dns.example.com", Port: 53})
+	assert.NoError(t, err)
+	n, err := uc.WriteTo([]byte("ping"), &AddrEx{Host: "dns.example.com", Port: 53})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte("ping"), fc.UDPConn.Sent)
+	assert.Equal(t, "dns.example.com:53", fc.UDPConn.SentAddr)
+
+	buf := make([]byte, 100)
+	n, addr, err := uc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:n]))
+	assert.Equal(t, &AddrEx{Host: "2001:db8::1", Port: 53}, addr)
+
+	assert.NoError(t, uc.Close())
+	assert.True(t, fc.UDPConn.Closed)
+}
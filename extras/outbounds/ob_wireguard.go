@@ -0,0 +1,303 @@
+package outbounds
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+const (
+	defaultWireGuardMTU = 1420
+)
+
+var errWireGuardNoAddress = errors.New("at least one local address is required")
+
+// WireGuardPeer is a peer of the WireGuard outbound.
+// Keys are in the standard base64 format used by wg(8).
+type WireGuardPeer struct {
+	PublicKey    string
+	PresharedKey string // optional
+	Endpoint     string // host:port, resolved once when the outbound is created
+	AllowedIPs   []netip.Prefix
+	KeepAlive    time.Duration // 0 = disabled
+}
+
+type WireGuardOutboundOptions struct {
+	PrivateKey string
+	// Addresses are the local addresses of the tunnel interface.
+	// The IP families available here determine which targets can be reached.
+	Addresses []netip.Addr
+	// DNS servers inside the tunnel, used to resolve targets when there is
+	// no resolver in the pipeline. If empty, the system resolver is used.
+	DNS   []netip.Addr
+	MTU   int // 0 = default
+	Peers []WireGuardPeer
+}
+
+// wireGuardOutbound is a PluggableOutbound that connects to the target through
+// a WireGuard tunnel, using a userspace network stack so that it doesn't need
+// root privileges or a TUN interface.
+// It prefers to use ResolveInfo in AddrEx if available. But if it's nil,
+// it will resolve Host either through the tunnel (if DNS servers are configured)
+// or using Go's built-in DNS resolver.
+type wireGuardOutbound struct {
+	Device *device.Device
+	Net    *netstack.Net
+	Addr4  netip.Addr // invalid if the tunnel doesn't have an IPv4 address
+	Addr6  netip.Addr // invalid if the tunnel doesn't have an IPv6 address
+	HasDNS bool
+}
+
+func NewWireGuardOutbound(opts WireGuardOutboundOptions) (PluggableOutbound, error) {
+	if len(opts.Addresses) == 0 {
+		return nil, errWireGuardNoAddress
+	}
+	ipc, err := opts.ipcConfig()
+	if err != nil {
+		return nil, err
+	}
+	mtu := opts.MTU
+	if mtu == 0 {
+		mtu = defaultWireGuardMTU
+	}
+	tunDev, tnet, err := netstack.CreateNetTUN(opts.Addresses, opts.DNS, mtu)
+	if err != nil {
+		return nil, err
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	if err := dev.IpcSet(ipc); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	o := &wireGuardOutbound{
+		Device: dev,
+		Net:    tnet,
+		HasDNS: len(opts.DNS) > 0,
+	}
+	for _, addr := range opts.Addresses {
+		if addr.Is4() && !o.Addr4.IsValid() {
+			o.Addr4 = addr
+		} else if addr.Is6() && !o.Addr6.IsValid() {
+			o.Addr6 = addr
+		}
+	}
+	return o, nil
+}
+
+var _ io.Closer = (*wireGuardOutbound)(nil)
+
+// Close shuts down the tunnel, including the network stack and the UDP socket to the peers.
+func (o *wireGuardOutbound) Close() error {
+	o.Device.Close()
+	return nil
+}
+
+// ipcConfig converts the options to the UAPI configuration format of wireguard-go.
+func (opts *WireGuardOutboundOptions) ipcConfig() (string, error) {
+	var sb strings.Builder
+	key, err := wireGuardKeyToHex(opts.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	sb.WriteString("private_key=" + key + "\n")
+	for _, peer := range opts.Peers {
+		key, err := wireGuardKeyToHex(peer.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid peer public key: %w", err)
+		}
+		sb.WriteString("public_key=" + key + "\n")
+		if peer.PresharedKey != "" {
+			key, err := wireGuardKeyToHex(peer.PresharedKey)
+			if err != nil {
+				return "", fmt.Errorf("invalid peer preshared key: %w", err)
+			}
+			sb.WriteString("preshared_key=" + key + "\n")
+		}
+		if peer.Endpoint != "" {
+			// wireguard-go only accepts IP addresses for endpoints
+			addr, err := net.ResolveUDPAddr("udp", peer.Endpoint)
+			if err != nil {
+				return "", fmt.Errorf("invalid peer endpoint: %w", err)
+			}
+			sb.WriteString("endpoint=" + addr.String() + "\n")
+		}
+		if peer.KeepAlive > 0 {
+			sb.WriteString(fmt.Sprintf("persistent_keepalive_interval=%d\n", int(peer.KeepAlive.Seconds())))
+		}
+		for _, prefix := range peer.AllowedIPs {
+			sb.WriteString("allowed_ip=" + prefix.String() + "\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+func wireGuardKeyToHex(key string) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	if len(bs) != 32 {
+		return "", errors.New("key must be 32 bytes")
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+func (o *wireGuardOutbound) resolve(reqAddr *AddrEx) {
+	var ips []net.IP
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialerTimeout)
+	defer cancel()
+	if o.HasDNS {
+		addrs, err := o.Net.LookupContextHost(ctx, reqAddr.Host)
+		if err != nil {
+			reqAddr.ResolveInfo = &ResolveInfo{Err: err}
+			return
+		}
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, reqAddr.Host)
+		if err != nil {
+			reqAddr.ResolveInfo = &ResolveInfo{Err: err}
+			return
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	r := &ResolveInfo{}
+	r.IPv4, r.IPv6 = splitIPv4IPv6(ips)
+	if r.IPv4 == nil && r.IPv6 == nil {
+		r.Err = noAddressError{IPv4: true, IPv6: true}
+	}
+	reqAddr.ResolveInfo = r
+}
+
+// target picks the address to connect to, based on the
+// IP families the tunnel has addresses for. IPv4 is preferred.
+func (o *wireGuardOutbound) target(reqAddr *AddrEx) (netip.AddrPort, error) {
+	if reqAddr.ResolveInfo == nil {
+		// AddrEx.ResolveInfo is nil (no resolver in the pipeline),
+		// we need to resolve the address ourselves.
+		o.resolve(reqAddr)
+	}
+	r := reqAddr.ResolveInfo
+	if r.IPv4 == nil && r.IPv6 == nil {
+		// ResolveInfo not nil but no address available,
+		// this can only mean that the resolver failed.
+		// Return the error from the resolver.
+		return netip.AddrPort{}, resolveError{Err: r.Err}
+	}
+	if r.IPv4 != nil && o.Addr4.IsValid() {
+		ip, _ := netip.AddrFromSlice(r.IPv4.To4())
+		return netip.AddrPortFrom(ip, reqAddr.Port), nil
+	}
+	if r.IPv6 != nil && o.Addr6.IsValid() {
+		ip, _ := netip.AddrFromSlice(r.IPv6.To16())
+		return netip.AddrPortFrom(ip, reqAddr.Port), nil
+	}
+	return netip.AddrPort{}, noAddressError{IPv4: o.Addr4.IsValid(), IPv6: o.Addr6.IsValid()}
+}
+
+func (o *wireGuardOutbound) TCP(reqAddr *AddrEx) (net.Conn, error) {
+	addr, err := o.target(reqAddr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialerTimeout)
+	defer cancel()
+	return o.Net.DialContextTCPAddrPort(ctx, addr)
+}
+
+func (o *wireGuardOutbound) UDP(reqAddr *AddrEx) (UDPConn, error) {
+	// The netstack doesn't support dual-stack sockets,
+	// so we bind to the family of the first target.
+	addr, err := o.target(reqAddr)
+	if err != nil {
+		return nil, err
+	}
+	var laddr netip.Addr
+	var state udpConnState
+	if addr.Addr().Is4() {
+		laddr, state = o.Addr4, udpConnStateIPv4
+	} else {
+		laddr, state = o.Addr6, udpConnStateIPv6
+	}
+	c, err := o.Net.ListenUDPAddrPort(netip.AddrPortFrom(laddr, 0))
+	if err != nil {
+		return nil, err
+	}
+	return &wireGuardUDPConn{
+		wireGuardOutbound: o,
+		PacketConn:        c,
+		State:             state,
+	}, nil
+}
+
+type wireGuardUDPConn struct {
+	*wireGuardOutbound
+	PacketConn net.PacketConn
+	State      udpConnState
+}
+
+func (u *wireGuardUDPConn) ReadFrom(b []byte) (int, *AddrEx, error) {
+	n, addr, err := u.PacketConn.ReadFrom(b)
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr != nil {
+		return n, &AddrEx{
+			Host: udpAddr.IP.String(),
+			Port: uint16(udpAddr.Port),
+		}, err
+	} else {
+		return n, nil, err
+	}
+}
+
+func (u *wireGuardUDPConn) WriteTo(b []byte, addr *AddrEx) (int, error) {
+	if addr.ResolveInfo == nil {
+		u.wireGuardOutbound.resolve(addr)
+	}
+	r := addr.ResolveInfo
+	if r.IPv4 == nil && r.IPv6 == nil {
+		return 0, resolveError{Err: r.Err}
+	}
+	if u.State == udpConnStateIPv4 {
+		if r.IPv4 != nil {
+			return u.PacketConn.WriteTo(b, &net.UDPAddr{
+				IP:   r.IPv4,
+				Port: int(addr.Port),
+			})
+		} else {
+			return 0, noAddressError{IPv4: true}
+		}
+	} else {
+		if r.IPv6 != nil {
+			return u.PacketConn.WriteTo(b, &net.UDPAddr{
+				IP:   r.IPv6,
+				Port: int(addr.Port),
+			})
+		} else {
+			return 0, noAddressError{IPv6: true}
+		}
+	}
+}
+
+func (u *wireGuardUDPConn) Close() error {
+	return u.PacketConn.Close()
+}
@@ -0,0 +1,162 @@
+package outbounds
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+func wireGuardTestKeyPair(t *testing.T) (priv, pub []byte) {
+	priv = make([]byte, 32)
+	_, err := rand.Read(priv)
+	assert.NoError(t, err)
+	priv[0] &= 248
+	priv[31] = (priv[31] & 127) | 64
+	pub, err = curve25519.X25519(priv, curve25519.Basepoint)
+	assert.NoError(t, err)
+	return priv, pub
+}
+
+// wireGuardTestPeer starts a WireGuard peer with its own netstack at 10.99.0.1,
+// running a TCP and a UDP echo server on port 7.
+func wireGuardTestPeer(t *testing.T, priv, clientPub []byte) (port int, closeFunc func()) {
+	// Find a free UDP port
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	port = pc.LocalAddr().(*net.UDPAddr).Port
+	_ = pc.Close()
+
+	tunDev, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.99.0.1")}, nil, 1420)
+	assert.NoError(t, err)
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	err = dev.IpcSet(fmt.Sprintf("private_key=%s\nlisten_port=%d\npublic_key=%s\nallowed_ip=10.99.0.2/32\n",
+		hex.EncodeToString(priv), port, hex.EncodeToString(clientPub)))
+	assert.NoError(t, err)
+	assert.NoError(t, dev.Up())
+
+	tl, err := tnet.ListenTCPAddrPort(netip.MustParseAddrPort("10.99.0.1:7"))
+	assert.NoError(t, err)
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+	ul, err := tnet.ListenUDPAddrPort(netip.MustParseAddrPort("10.99.0.1:7"))
+	assert.NoError(t, err)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := ul.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = ul.WriteTo(buf[:n], addr)
+		}
+	}()
+	return port, func() {
+		_ = tl.Close()
+		_ = ul.Close()
+		dev.Close()
+	}
+}
+
+func TestWireGuardOutbound(t *testing.T) {
+	serverPriv, serverPub := wireGuardTestKeyPair(t)
+	clientPriv, clientPub := wireGuardTestKeyPair(t)
+	port, closeFunc := wireGuardTestPeer(t, serverPriv, clientPub)
+	defer closeFunc()
+
+	ob, err := NewWireGuardOutbound(WireGuardOutboundOptions{
+		PrivateKey: base64.StdEncoding.EncodeToString(clientPriv),
+		Addresses:  []netip.Addr{netip.MustParseAddr("10.99.0.2")},
+		Peers: []WireGuardPeer{
+			{
+				PublicKey:  base64.StdEncoding.EncodeToString(serverPub),
+				Endpoint:   fmt.Sprintf("127.0.0.1:%d", port),
+				AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.99.0.0/24")},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	defer ob.(*wireGuardOutbound).Device.Close()
+
+	// TCP, the host should be ignored in favor of ResolveInfo
+	tc, err := ob.TCP(&AddrEx{
+		Host:        "echo.invalid",
+		Port:        7,
+		ResolveInfo: &ResolveInfo{IPv4: net.ParseIP("10.99.0.1")},
+	})
+	assert.NoError(t, err)
+	_, err = tc.Write([]byte("hello wg"))
+	assert.NoError(t, err)
+	buf := make([]byte, 100)
+	_ = tc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := io.ReadAtLeast(tc, buf, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello wg", string(buf[:n]))
+	_ = tc.Close()
+
+	// UDP
+	addr := &AddrEx{Host: "10.99.0.1", Port: 7}
+	uc, err := ob.UDP(addr)
+	assert.NoError(t, err)
+	_, err = uc.WriteTo([]byte("hello udp"), addr)
+	assert.NoError(t, err)
+	n, rAddr, err := uc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello udp", string(buf[:n]))
+	assert.Equal(t, &AddrEx{Host: "10.99.0.1", Port: 7}, rAddr)
+	// No IPv6 address on the tunnel
+	_, err = uc.WriteTo([]byte("nope"), &AddrEx{
+		Host:        "v6.invalid",
+		Port:        7,
+		ResolveInfo: &ResolveInfo{IPv6: net.ParseIP("fd00::1")},
+	})
+	assert.Equal(t, noAddressError{IPv4: true}, err)
+	_ = uc.Close()
+}
+
+func TestWireGuardOutboundOptionsIPC(t *testing.T) {
+	opts := WireGuardOutboundOptions{
+		PrivateKey: "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+		Peers: []WireGuardPeer{
+			{
+				PublicKey:  "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+				Endpoint:   "192.0.2.1:51820",
+				AllowedIPs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+				KeepAlive:  25 * time.Second,
+			},
+		},
+	}
+	ipc, err := opts.ipcConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "private_key=c809f3e5317e9575c9b5ed78b638b7ce530dabe85ddab614220241801ddf0669\n"+
+		"public_key=c53201039adba14be71f886da1d8dbe9eebded08cb111b75340078999aa9f038\n"+
+		"endpoint=192.0.2.1:51820\n"+
+		"persistent_keepalive_interval=25\n"+
+		"allowed_ip=0.0.0.0/0\n"+
+		"allowed_ip=::/0\n", ipc)
+
+	opts.PrivateKey = "too short"
+	_, err = opts.ipcConfig()
+	assert.Error(t, err)
+}
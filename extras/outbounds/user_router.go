@@ -0,0 +1,90 @@
+package outbounds
+
+import (
+	"net"
+	"sync"
+)
+
+// UserGroups keeps track of the groups assigned to users at runtime, e.g. by
+// the HTTP authenticator. It is safe for concurrent use, and is meant to be
+// kept across config reloads so that users who are already connected don't
+// lose their group.
+type UserGroups struct {
+	mutex  sync.RWMutex
+	groups map[string]string
+}
+
+func NewUserGroups() *UserGroups {
+	return &UserGroups{groups: make(map[string]string)}
+}
+
+// Set assigns a group to a user. An empty group removes the assignment.
+func (g *UserGroups) Set(id, group string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if group == "" {
+		delete(g.groups, id)
+	} else {
+		g.groups[id] = group
+	}
+}
+
+func (g *UserGroups) Get(id string) (string, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	group, ok := g.groups[id]
+	return group, ok
+}
+
+// userRouter is a PluggableOutbound that sends requests to different
+// outbound pipelines (routes) based on who the client is.
+// The route of a user is determined by its group, which is looked up
+// in Groups (assigned at runtime) first, then in Users (static).
+// Requests without RequestInfo, from users without a group, or
+// whose group has no route, go to Default.
+type userRouter struct {
+	Users   map[string]string // auth ID -> group
+	Groups  *UserGroups       // can be nil
+	Routes  map[string]PluggableOutbound
+	Default PluggableOutbound
+}
+
+func NewUserRouter(users map[string]string, groups *UserGroups,
+	routes map[string]PluggableOutbound, def PluggableOutbound,
+) PluggableOutbound {
+	return &userRouter{
+		Users:   users,
+		Groups:  groups,
+		Routes:  routes,
+		Default: def,
+	}
+}
+
+func (r *userRouter) route(reqAddr *AddrEx) PluggableOutbound {
+	if reqAddr.RequestInfo == nil {
+		return r.Default
+	}
+	id := reqAddr.RequestInfo.AuthID
+	group, ok := "", false
+	if r.Groups != nil {
+		group, ok = r.Groups.Get(id)
+	}
+	if !ok {
+		group, ok = r.Users[id]
+	}
+	if !ok {
+		return r.Default
+	}
+	if ob, ok := r.Routes[group]; ok {
+		return ob
+	}
+	return r.Default
+}
+
+func (r *userRouter) TCP(reqAddr *AddrEx) (net.Conn, error) {
+	return r.route(reqAddr).TCP(reqAddr)
+}
+
+func (r *userRouter) UDP(reqAddr *AddrEx) (UDPConn, error) {
+	return r.route(reqAddr).UDP(reqAddr)
+}
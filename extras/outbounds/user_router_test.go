@@ -0,0 +1,51 @@
+package outbounds
+
+import (
+	"testing"
+
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+func TestUserRouter(t *testing.T) {
+	def, premium, free := newMockPluggableOutbound(t), newMockPluggableOutbound(t), newMockPluggableOutbound(t)
+	groups := NewUserGroups()
+	r := NewUserRouter(map[string]string{
+		"alice": "premium",
+		"bob":   "free",
+		"carol": "nonexistent",
+	}, groups, map[string]PluggableOutbound{
+		"premium": premium,
+		"free":    free,
+	}, def)
+
+	addr := func(id string) *AddrEx {
+		return &AddrEx{Host: "example.com", Port: 443, RequestInfo: &server.RequestInfo{AuthID: id}}
+	}
+
+	// Static groups
+	premium.EXPECT().TCP(addr("alice")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("alice"))
+	free.EXPECT().UDP(addr("bob")).Return(nil, nil).Once()
+	_, _ = r.UDP(addr("bob"))
+
+	// No RequestInfo, unknown user, group without a route
+	def.EXPECT().TCP(&AddrEx{Host: "example.com", Port: 443}).Return(nil, nil).Once()
+	_, _ = r.TCP(&AddrEx{Host: "example.com", Port: 443})
+	def.EXPECT().TCP(addr("dave")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("dave"))
+	def.EXPECT().TCP(addr("carol")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("carol"))
+
+	// Runtime groups take precedence over static ones
+	groups.Set("bob", "premium")
+	groups.Set("dave", "free")
+	premium.EXPECT().TCP(addr("bob")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("bob"))
+	free.EXPECT().TCP(addr("dave")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("dave"))
+
+	// Removing a runtime group falls back to the static one
+	groups.Set("bob", "")
+	free.EXPECT().TCP(addr("bob")).Return(nil, nil).Once()
+	_, _ = r.TCP(addr("bob"))
+}
@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"slices"
 	"strconv"
@@ -23,26 +24,47 @@ const (
 // to provide a simple HTTP API to get the traffic stats per user.
 type TrafficStatsServer interface {
 	server.TrafficLogger
+	server.AuthLogger
+	server.UDPSessionTracer
+	server.CongestionTracer
 	http.Handler
 }
 
 func NewTrafficStatsServer(secret string) TrafficStatsServer {
 	return &trafficStatsServerImpl{
-		StatsMap:  make(map[string]*trafficStatsEntry),
-		KickMap:   make(map[string]struct{}),
-		OnlineMap: make(map[string]int),
-		StreamMap: make(map[quic.Stream]*server.StreamStats),
-		Secret:    secret,
+		StatsMap:   make(map[string]*trafficStatsEntry),
+		TotalMap:   make(map[string]*trafficStatsEntry),
+		KickMap:    make(map[string]struct{}),
+		OnlineMap:  make(map[string]int),
+		StreamMap:  make(map[quic.Stream]*server.StreamStats),
+		UDPConnMap: make(map[uint32]udpSessionsEntry),
+		CCConnMap:  make(map[uint32]congestionEntry),
+		Secret:     secret,
 	}
 }
 
 type trafficStatsServerImpl struct {
-	Mutex     sync.RWMutex
-	StatsMap  map[string]*trafficStatsEntry
-	OnlineMap map[string]int
-	StreamMap map[quic.Stream]*server.StreamStats
-	KickMap   map[string]struct{}
-	Secret    string
+	Mutex      sync.RWMutex
+	StatsMap   map[string]*trafficStatsEntry
+	TotalMap   map[string]*trafficStatsEntry // Same as StatsMap, but never cleared, for metrics
+	OnlineMap  map[string]int
+	StreamMap  map[quic.Stream]*server.StreamStats
+	UDPConnMap map[uint32]udpSessionsEntry
+	CCConnMap  map[uint32]congestionEntry
+	KickMap    map[string]struct{}
+	AuthOK     uint64
+	AuthFailed uint64
+	Secret     string
+}
+
+type udpSessionsEntry struct {
+	ID      string
+	Counter server.UDPSessionCounter
+}
+
+type congestionEntry struct {
+	ID       string
+	Provider server.CongestionStatsProvider
 }
 
 type trafficStatsEntry struct {
@@ -68,6 +90,14 @@ func (s *trafficStatsServerImpl) LogTraffic(id string, tx, rx uint64) (ok bool)
 	entry.Tx += tx
 	entry.Rx += rx
 
+	total, ok := s.TotalMap[id]
+	if !ok {
+		total = &trafficStatsEntry{}
+		s.TotalMap[id] = total
+	}
+	total.Tx += tx
+	total.Rx += rx
+
 	return true
 }
 
@@ -100,8 +130,50 @@ func (s *trafficStatsServerImpl) UntraceStream(stream quic.Stream) {
 	delete(s.StreamMap, stream)
 }
 
+func (s *trafficStatsServerImpl) LogAuth(addr net.Addr, id string, ok bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if ok {
+		s.AuthOK++
+	} else {
+		s.AuthFailed++
+	}
+}
+
+func (s *trafficStatsServerImpl) TraceUDPSessions(id string, connID uint32, counter server.UDPSessionCounter) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.UDPConnMap[connID] = udpSessionsEntry{ID: id, Counter: counter}
+}
+
+func (s *trafficStatsServerImpl) UntraceUDPSessions(connID uint32) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	delete(s.UDPConnMap, connID)
+}
+
+func (s *trafficStatsServerImpl) TraceCongestion(id string, connID uint32, provider server.CongestionStatsProvider) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.CCConnMap[connID] = congestionEntry{ID: id, Provider: provider}
+}
+
+func (s *trafficStatsServerImpl) UntraceCongestion(connID uint32) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	delete(s.CCConnMap, connID)
+}
+
 func (s *trafficStatsServerImpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if s.Secret != "" && r.Header.Get("Authorization") != s.Secret {
+	// Also accept the secret as a bearer token, as that's what most
+	// monitoring tools (e.g. Prometheus) send when scraping metrics.
+	if s.Secret != "" && r.Header.Get("Authorization") != s.Secret &&
+		r.Header.Get("Authorization") != "Bearer "+s.Secret {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -125,6 +197,14 @@ func (s *trafficStatsServerImpl) ServeHTTP(w http.ResponseWriter, r *http.Reques
 		s.getDumpStreams(w, r)
 		return
 	}
+	if r.Method == http.MethodGet && r.URL.Path == "/dump/conns" {
+		s.getDumpConns(w, r)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/metrics" {
+		s.getMetrics(w, r)
+		return
+	}
 	http.NotFound(w, r)
 }
 
@@ -283,6 +363,106 @@ func (s *trafficStatsServerImpl) getDumpStreams(w http.ResponseWriter, r *http.R
 	}
 }
 
+type dumpConnAdaptiveEntry struct {
+	State        string  `json:"state"`
+	RateCap      uint64  `json:"rate_cap"`
+	DeliveryRate uint64  `json:"delivery_rate"`
+	LossRate     float64 `json:"loss_rate"`
+	Backoffs     uint64  `json:"backoffs"`
+}
+
+type dumpConnEntry struct {
+	Auth       string `json:"auth"`
+	Connection uint32 `json:"connection"`
+
+	Congestion string                 `json:"congestion"`
+	Rate       uint64                 `json:"rate"`
+	Adaptive   *dumpConnAdaptiveEntry `json:"adaptive,omitempty"`
+}
+
+func (e *dumpConnEntry) fromCongestionStats(id string, connID uint32, s server.CongestionStats) {
+	e.Auth = id
+	e.Connection = connID
+	e.Congestion = s.Type
+	e.Rate = s.Rate
+	if a := s.Adaptive; a != nil {
+		e.Adaptive = &dumpConnAdaptiveEntry{
+			State:        a.State,
+			RateCap:      a.RateCap,
+			DeliveryRate: a.DeliveryRate,
+			LossRate:     a.LossRate,
+			Backoffs:     a.Backoffs,
+		}
+	}
+}
+
+func formatDumpConnLine(auth, connection, congestion, rate, state, rateCap, deliveryRate, lossRate, backoffs string) string {
+	return fmt.Sprintf("%-12s %12s %-16s %12s %-8s %12s %12s %8s %8s", auth, connection, congestion, rate, state, rateCap, deliveryRate, lossRate, backoffs)
+}
+
+func (e *dumpConnEntry) String() string {
+	connectionText := fmt.Sprintf("%08X", e.Connection)
+	rateText := "-"
+	if e.Rate > 0 {
+		rateText = strconv.FormatUint(e.Rate, 10)
+	}
+	stateText, rateCapText, deliveryRateText, lossRateText, backoffsText := "-", "-", "-", "-", "-"
+	if a := e.Adaptive; a != nil {
+		stateText = strings.ToUpper(a.State)
+		if a.RateCap > 0 {
+			rateCapText = strconv.FormatUint(a.RateCap, 10)
+		}
+		deliveryRateText = strconv.FormatUint(a.DeliveryRate, 10)
+		lossRateText = strconv.FormatFloat(a.LossRate*100, 'f', 2, 64) + "%"
+		backoffsText = strconv.FormatUint(a.Backoffs, 10)
+	}
+	return formatDumpConnLine(e.Auth, connectionText, e.Congestion, rateText, stateText, rateCapText, deliveryRateText, lossRateText, backoffsText)
+}
+
+func (s *trafficStatsServerImpl) getDumpConns(w http.ResponseWriter, r *http.Request) {
+	var entries []dumpConnEntry
+
+	s.Mutex.RLock()
+	entries = make([]dumpConnEntry, len(s.CCConnMap))
+	index := 0
+	for connID, entry := range s.CCConnMap {
+		entries[index].fromCongestionStats(entry.ID, connID, entry.Provider.CongestionStats())
+		index++
+	}
+	s.Mutex.RUnlock()
+
+	slices.SortFunc(entries, func(lhs, rhs dumpConnEntry) int {
+		if ret := cmp.Compare(lhs.Auth, rhs.Auth); ret != 0 {
+			return ret
+		}
+		return cmp.Compare(lhs.Connection, rhs.Connection)
+	})
+
+	accept := r.Header.Get("Accept")
+
+	if strings.Contains(accept, "text/plain") {
+		// Generate netstat-like output for humans
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		// Print table header
+		_, _ = fmt.Fprintln(w, formatDumpConnLine("Auth", "Connection", "Congestion", "Rate", "State", "Rate-Cap", "Delivery-Rate", "Loss", "Backoffs"))
+		for _, entry := range entries {
+			_, _ = fmt.Fprintln(w, entry.String())
+		}
+		return
+	}
+
+	// Response with json by default
+	wrapper := struct {
+		Conns []dumpConnEntry `json:"conns"`
+	}{entries}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(&wrapper)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (s *trafficStatsServerImpl) kick(w http.ResponseWriter, r *http.Request) {
 	var ids []string
 	err := json.NewDecoder(r.Body).Decode(&ids)
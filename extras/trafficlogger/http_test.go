@@ -0,0 +1,55 @@
+package trafficlogger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+type fakeCongestionStatsProvider server.CongestionStats
+
+func (p fakeCongestionStatsProvider) CongestionStats() server.CongestionStats {
+	return server.CongestionStats(p)
+}
+
+func TestTrafficStatsServerDumpConns(t *testing.T) {
+	s := NewTrafficStatsServer("")
+	s.TraceCongestion("bob", 2, fakeCongestionStatsProvider{Type: server.CongestionControlBBR})
+	s.TraceCongestion("alice", 1, fakeCongestionStatsProvider{
+		Type: server.CongestionControlAdaptiveBrutal,
+		Rate: 12500000,
+		Adaptive: &server.AdaptiveBrutalStats{
+			State:        "backoff",
+			RateCap:      5625000,
+			DeliveryRate: 6250000,
+			LossRate:     0.25,
+			Backoffs:     1,
+		},
+	})
+	s.TraceCongestion("carol", 3, fakeCongestionStatsProvider{Type: server.CongestionControlCubic})
+	s.UntraceCongestion(3)
+
+	req := httptest.NewRequest(http.MethodGet, "/dump/conns", nil)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"conns":[
+{"auth":"alice","connection":1,"congestion":"adaptive-brutal","rate":12500000,
+ "adaptive":{"state":"backoff","rate_cap":5625000,"delivery_rate":6250000,"loss_rate":0.25,"backoffs":1}},
+{"auth":"bob","connection":2,"congestion":"bbr","rate":0}
+]}`, rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/dump/conns", nil)
+	req.Header.Set("Accept", "text/plain")
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, formatDumpConnLine("Auth", "Connection", "Congestion", "Rate", "State", "Rate-Cap", "Delivery-Rate", "Loss", "Backoffs")+"\n"+
+		formatDumpConnLine("alice", "00000001", "adaptive-brutal", "12500000", "BACKOFF", "5625000", "6250000", "25.00%", "1")+"\n"+
+		formatDumpConnLine("bob", "00000002", "bbr", "-", "-", "-", "-", "-", "-")+"\n",
+		rr.Body.String())
+}
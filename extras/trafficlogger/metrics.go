@@ -0,0 +1,168 @@
+package trafficlogger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+const (
+	metricsContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	metricsContentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var metricsStreamStates = []server.StreamState{
+	server.StreamStateInitial,
+	server.StreamStateHooking,
+	server.StreamStateConnecting,
+	server.StreamStateEstablished,
+}
+
+// metricsSnapshot is a copy of everything we export as metrics,
+// so that we don't hold the lock while writing the response.
+type metricsSnapshot struct {
+	Traffic     map[string]trafficStatsEntry
+	Online      map[string]int
+	Streams     map[server.StreamState]int
+	UDPSessions map[string]int
+	AuthOK      uint64
+	AuthFailed  uint64
+}
+
+func (s *trafficStatsServerImpl) metricsSnapshot() *metricsSnapshot {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	snap := &metricsSnapshot{
+		Traffic:     make(map[string]trafficStatsEntry, len(s.TotalMap)),
+		Online:      make(map[string]int, len(s.OnlineMap)),
+		Streams:     make(map[server.StreamState]int),
+		UDPSessions: make(map[string]int),
+		AuthOK:      s.AuthOK,
+		AuthFailed:  s.AuthFailed,
+	}
+	for id, entry := range s.TotalMap {
+		snap.Traffic[id] = *entry
+	}
+	for id, count := range s.OnlineMap {
+		snap.Online[id] = count
+	}
+	for _, stats := range s.StreamMap {
+		snap.Streams[stats.State.Load()]++
+	}
+	for _, entry := range s.UDPConnMap {
+		snap.UDPSessions[entry.ID] += entry.Counter.Count()
+	}
+	return snap
+}
+
+func (s *trafficStatsServerImpl) getMetrics(w http.ResponseWriter, r *http.Request) {
+	snap := s.metricsSnapshot()
+
+	// Prefer OpenMetrics if the scraper says it understands it,
+	// otherwise fall back to the classic Prometheus text format.
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", metricsContentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", metricsContentTypeText)
+	}
+	mw := &metricsWriter{W: w, OpenMetrics: openMetrics}
+
+	ids := sortedKeys(snap.Traffic)
+	mw.Family("hysteria_traffic_tx_bytes", "counter", "Bytes sent from the server to the proxy targets (uploaded by the user).")
+	for _, id := range ids {
+		mw.Sample("hysteria_traffic_tx_bytes_total", []string{"auth", id}, snap.Traffic[id].Tx)
+	}
+	mw.Family("hysteria_traffic_rx_bytes", "counter", "Bytes received by the server from the proxy targets (downloaded by the user).")
+	for _, id := range ids {
+		mw.Sample("hysteria_traffic_rx_bytes_total", []string{"auth", id}, snap.Traffic[id].Rx)
+	}
+
+	mw.Family("hysteria_online_connections", "gauge", "Number of connected clients.")
+	for _, id := range sortedKeys(snap.Online) {
+		mw.Sample("hysteria_online_connections", []string{"auth", id}, uint64(snap.Online[id]))
+	}
+
+	mw.Family("hysteria_streams", "gauge", "Number of TCP proxy streams by state.")
+	for _, state := range metricsStreamStates {
+		mw.Sample("hysteria_streams", []string{"state", state.String()}, uint64(snap.Streams[state]))
+	}
+
+	mw.Family("hysteria_udp_sessions", "gauge", "Number of active UDP sessions.")
+	for _, id := range sortedKeys(snap.UDPSessions) {
+		mw.Sample("hysteria_udp_sessions", []string{"auth", id}, uint64(snap.UDPSessions[id]))
+	}
+
+	mw.Family("hysteria_auth", "counter", "Number of authentication attempts by result.")
+	mw.Sample("hysteria_auth_total", []string{"result", "success"}, snap.AuthOK)
+	mw.Sample("hysteria_auth_total", []string{"result", "failure"}, snap.AuthFailed)
+
+	mw.EOF()
+}
+
+// metricsWriter writes metrics in either the OpenMetrics text format
+// or the Prometheus text exposition format (version 0.0.4).
+// The two are nearly identical, the differences being how counter families
+// are named in the metadata lines and the mandatory "# EOF" in OpenMetrics.
+type metricsWriter struct {
+	W           io.Writer
+	OpenMetrics bool
+}
+
+func (m *metricsWriter) Family(name, typ, help string) {
+	if !m.OpenMetrics && typ == "counter" {
+		name += "_total"
+	}
+	_, _ = fmt.Fprintf(m.W, "# TYPE %s %s\n", name, typ)
+	_, _ = fmt.Fprintf(m.W, "# HELP %s %s\n", name, help)
+}
+
+// Sample writes a single sample. labels must be a list of name-value pairs.
+func (m *metricsWriter) Sample(name string, labels []string, value uint64) {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(labels[i])
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(labels[i+1]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatUint(value, 10))
+	sb.WriteByte('\n')
+	_, _ = io.WriteString(m.W, sb.String())
+}
+
+func (m *metricsWriter) EOF() {
+	if m.OpenMetrics {
+		_, _ = io.WriteString(m.W, "# EOF\n")
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package trafficlogger
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeUDPSessionCounter int
+
+func (c fakeUDPSessionCounter) Count() int {
+	return int(c)
+}
+
+func TestTrafficStatsServerMetrics(t *testing.T) {
+	s := NewTrafficStatsServer("secret")
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	s.LogAuth(addr, "alice", true)
+	s.LogAuth(addr, "", false)
+	s.LogOnlineState("alice", true)
+	s.LogTraffic("alice", 100, 200)
+	s.LogTraffic("bob\"", 1, 2)
+	s.TraceUDPSessions("alice", 1, fakeUDPSessionCounter(3))
+	s.TraceUDPSessions("alice", 2, fakeUDPSessionCounter(4))
+
+	// Clearing the traffic stats must not reset the counters
+	req := httptest.NewRequest(http.MethodGet, "/traffic?clear=1", nil)
+	req.Header.Set("Authorization", "secret")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, metricsContentTypeOpenMetrics, rr.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE hysteria_traffic_tx_bytes counter
+# HELP hysteria_traffic_tx_bytes Bytes sent from the server to the proxy targets (uploaded by the user).
+hysteria_traffic_tx_bytes_total{auth="alice"} 100
+hysteria_traffic_tx_bytes_total{auth="bob\""} 1
+# TYPE hysteria_traffic_rx_bytes counter
+# HELP hysteria_traffic_rx_bytes Bytes received by the server from the proxy targets (downloaded by the user).
+hysteria_traffic_rx_bytes_total{auth="alice"} 200
+hysteria_traffic_rx_bytes_total{auth="bob\""} 2
+# TYPE hysteria_online_connections gauge
+# HELP hysteria_online_connections Number of connected clients.
+hysteria_online_connections{auth="alice"} 1
+# TYPE hysteria_streams gauge
+# HELP hysteria_streams Number of TCP proxy streams by state.
+hysteria_streams{state="init"} 0
+hysteria_streams{state="hook"} 0
+hysteria_streams{state="connect"} 0
+hysteria_streams{state="estab"} 0
+# TYPE hysteria_udp_sessions gauge
+# HELP hysteria_udp_sessions Number of active UDP sessions.
+hysteria_udp_sessions{auth="alice"} 7
+# TYPE hysteria_auth counter
+# HELP hysteria_auth Number of authentication attempts by result.
+hysteria_auth_total{result="success"} 1
+hysteria_auth_total{result="failure"} 1
+# EOF
+`, rr.Body.String())
+
+	// Prometheus text format
+	s.UntraceUDPSessions(2)
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "secret")
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, metricsContentTypeText, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "# TYPE hysteria_auth_total counter\n")
+	assert.Contains(t, rr.Body.String(), "hysteria_udp_sessions{auth=\"alice\"} 3\n")
+	assert.NotContains(t, rr.Body.String(), "# EOF")
+
+	// Unauthorized
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -0,0 +1,168 @@
+package fallback
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+// ClientConfig is the config for the client side of the fallback transport.
+type ClientConfig struct {
+	Addr      string      // TCP address (host:port) of the server's HTTPS listener
+	Path      string      // Must match the Path of the Server
+	TLSConfig *tls.Config // If ServerName is set, it's also used as the host of the request
+
+	// DialFunc is optional, for dialing Addr with custom socket options.
+	DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Addr is the address of the ends of a client conn.
+type Addr string
+
+func (a Addr) Network() string {
+	return "fallback"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+var _ net.PacketConn = (*clientConn)(nil)
+
+type clientConn struct {
+	transport  *http2.Transport
+	body       io.ReadCloser
+	bodyReader *bufio.Reader
+	pipeWriter *io.PipeWriter
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	readBuf    []byte
+	readMutex  sync.Mutex
+	writeBuf   []byte
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+}
+
+// NewClientConn connects to the server and returns a net.PacketConn carrying
+// packets over the connection. Regardless of the address passed to WriteTo,
+// all packets are sent to the server, and all packets read are from it.
+func NewClientConn(config ClientConfig) (net.PacketConn, error) {
+	dialFunc := config.DialFunc
+	if dialFunc == nil {
+		dialFunc = (&net.Dialer{Timeout: defaultDialTimeout}).DialContext
+	}
+	var localAddr net.Addr
+	transport := &http2.Transport{
+		TLSClientConfig: config.TLSConfig,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dialFunc(ctx, "tcp", config.Addr)
+			if err != nil {
+				return nil, err
+			}
+			_ = conn.SetDeadline(time.Now().Add(defaultDialTimeout))
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			_ = conn.SetDeadline(time.Time{})
+			localAddr = conn.LocalAddr()
+			return tlsConn, nil
+		},
+	}
+	host := config.Addr
+	if config.TLSConfig != nil && config.TLSConfig.ServerName != "" {
+		host = config.TLSConfig.ServerName
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+config.Path, pr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		_ = pw.Close()
+		transport.CloseIdleConnections()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		_ = pw.Close()
+		transport.CloseIdleConnections()
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return &clientConn{
+		transport:  transport,
+		body:       resp.Body,
+		bodyReader: bufio.NewReader(resp.Body),
+		pipeWriter: pw,
+		localAddr:  Addr(localAddr.String()),
+		remoteAddr: Addr(config.Addr),
+		readBuf:    make([]byte, udpBufferSize),
+		writeBuf:   make([]byte, 0, udpBufferSize),
+	}, nil
+}
+
+func (c *clientConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	p, err := readPacket(c.bodyReader, c.readBuf)
+	if err != nil {
+		return 0, nil, err
+	}
+	n = copy(b, p)
+	return n, c.remoteAddr, nil
+}
+
+func (c *clientConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.writeBuf, err = appendPacket(c.writeBuf[:0], b)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.pipeWriter.Write(c.writeBuf)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *clientConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.pipeWriter.Close()
+		_ = c.body.Close()
+		c.transport.CloseIdleConnections()
+	})
+	return nil
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	// Not supported
+	return nil
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	// Not supported
+	return nil
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	// Not supported
+	return nil
+}
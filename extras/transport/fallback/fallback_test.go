@@ -0,0 +1,203 @@
+package fallback
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+const testPath = "/secret-tunnel"
+
+func newTestServer() (*Server, *httptest.Server) {
+	fs := NewServer(testPath, nil)
+	hs := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fs.ServeFallback(w, r) {
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	hs.EnableHTTP2 = true
+	hs.StartTLS()
+	return fs, hs
+}
+
+func TestFallback(t *testing.T) {
+	fs, hs := newTestServer()
+	defer hs.Close()
+	defer fs.Close()
+
+	// Wrong path
+	_, err := NewClientConn(ClientConfig{
+		Addr:      hs.Listener.Addr().String(),
+		Path:      "/wrong",
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+	})
+	assert.Error(t, err)
+
+	// Not HTTP/2
+	h1Client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSNextProto:    map[string]func(string, *tls.Conn) http.RoundTripper{},
+	}}
+	resp, err := h1Client.Post(hs.URL+testPath, "", nil)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 1, resp.ProtoMajor)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	conns := make([]net.PacketConn, 2)
+	for i := range conns {
+		conns[i], err = NewClientConn(ClientConfig{
+			Addr:      hs.Listener.Addr().String(),
+			Path:      testPath,
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		})
+		assert.NoError(t, err)
+		defer conns[i].Close()
+	}
+
+	// Echo from the server, with each tunnel getting its own address
+	// that still has the IP of the client
+	clientIPs := make(chan net.IP, 1)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := fs.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case clientIPs <- server.AddrIP(addr):
+			default:
+			}
+			_, _ = fs.WriteTo(buf[:n], addr)
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for i, conn := range conns {
+		for _, size := range []int{0, 1, 1200, 60000} {
+			data := bytes.Repeat([]byte{byte(i)}, size)
+			n, err := conn.WriteTo(data, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, size, n)
+			n, addr, err := conn.ReadFrom(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, data, buf[:n])
+			assert.Equal(t, hs.Listener.Addr().String(), addr.String())
+		}
+	}
+
+	assert.Equal(t, "127.0.0.1", (<-clientIPs).String())
+
+	// Too large
+	_, err = conns[0].WriteTo(make([]byte, maxPacketSize+1), nil)
+	assert.Error(t, err)
+
+	// Closing the server ends the tunnels
+	_ = fs.Close()
+	_, _, err = conns[0].ReadFrom(buf)
+	assert.Error(t, err)
+}
+
+type testAuthenticator struct{}
+
+func (a *testAuthenticator) Authenticate(addr net.Addr, auth string, tx uint64) (bool, string) {
+	return true, "nobody"
+}
+
+type blockedConnFactory struct{}
+
+func (f *blockedConnFactory) New(addr net.Addr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &blockedConn{conn}, nil
+}
+
+// blockedConn fails all writes, as if UDP were blocked.
+type blockedConn struct {
+	net.PacketConn
+}
+
+func (c *blockedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return 0, errors.New("udp blocked")
+}
+
+type clientConnFactory struct {
+	Config ClientConfig
+}
+
+func (f *clientConnFactory) New(addr net.Addr) (net.PacketConn, error) {
+	return NewClientConn(f.Config)
+}
+
+// TestFallbackHysteria tests a Hysteria client & server connected over the fallback transport.
+func TestFallbackHysteria(t *testing.T) {
+	fs, hs := newTestServer()
+	defer hs.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:     server.TLSConfig{Certificates: hs.TLS.Certificates},
+		Conn:          udpConn,
+		ExtraConns:    []net.PacketConn{fs},
+		Authenticator: &testAuthenticator{},
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// TCP echo server
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	c, info, err := client.NewClient(&client.Config{
+		ConnFactory: &blockedConnFactory{},
+		FallbackConnFactory: &clientConnFactory{Config: ClientConfig{
+			Addr:      hs.Listener.Addr().String(),
+			Path:      testPath,
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		}},
+		ServerAddr: udpConn.LocalAddr(),
+		TLSConfig:  client.TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.True(t, info.Fallback)
+
+	conn, err := c.TCP(echoListener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	sData := bytes.Repeat([]byte("fallback"), 1<<17)
+	go func() {
+		_, _ = conn.Write(sData)
+	}()
+	rData := make([]byte, len(sData))
+	_, err = io.ReadFull(conn, rData)
+	assert.NoError(t, err)
+	assert.Equal(t, sData, rData)
+}
@@ -0,0 +1,51 @@
+package fallback
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The fallback transport carries the QUIC packets of a Hysteria connection over
+// an HTTP/2 stream (TLS over TCP), for networks where UDP is blocked.
+// The client sends a POST request to a secret path on the server's HTTPS
+// (masquerade) listener. The request body carries the packets from the client,
+// and the response body (status 200) those from the server, each framed as:
+//
+// Length (uint16 BE)
+// Packet (bytes)
+//
+// Everything else, including the Hysteria protocol itself, works as usual on
+// top of the packets.
+
+const (
+	maxPacketSize   = 65535
+	packetQueueSize = 1024
+	udpBufferSize   = 2048 // QUIC packets are at most 1500 bytes long, so 2k should be more than enough
+)
+
+var errPacketTooLarge = errors.New("packet too large")
+
+func readPacket(r io.Reader, buf []byte) ([]byte, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if n > len(buf) {
+		buf = make([]byte, n)
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// appendPacket appends the framed packet to buf.
+func appendPacket(buf, p []byte) ([]byte, error) {
+	if len(p) > maxPacketSize {
+		return nil, errPacketTooLarge
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(p)))
+	return append(buf, p...), nil
+}
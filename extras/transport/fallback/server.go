@@ -0,0 +1,213 @@
+package fallback
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apernet/hysteria/extras/v2/internal/deadline"
+)
+
+var _ net.PacketConn = (*Server)(nil)
+
+// Server is the server side of the fallback transport. It's a net.PacketConn
+// for the Hysteria server (server.Config.ExtraConns) carrying the packets of
+// the requests it takes over in ServeFallback, which should be called by
+// the HTTPS server the clients connect to (masq.MasqTCPServer.Fallback).
+type Server struct {
+	Path string
+
+	localAddr    net.Addr
+	recvQueue    chan *packet
+	readDeadline *deadline.Deadline // quic-go relies on it to stop reading when a listener is closed
+	closeChan    chan struct{}
+	closeOnce    sync.Once
+}
+
+type packet struct {
+	Data []byte
+	Addr net.Addr
+}
+
+// TunnelAddr is the address of a client for the Hysteria server.
+// Each request gets its own, even if they are from the same remote address.
+type TunnelAddr struct {
+	RemoteAddr string
+	tunnel     *tunnel
+}
+
+func (a *TunnelAddr) Network() string {
+	return "fallback"
+}
+
+func (a *TunnelAddr) String() string {
+	return a.RemoteAddr
+}
+
+// Unwrap returns the TCP address of the client, e.g. for its IP,
+// or nil if RemoteAddr isn't an IP address and port.
+func (a *TunnelAddr) Unwrap() net.Addr {
+	ap, err := netip.ParseAddrPort(a.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+type tunnel struct {
+	sendQueue chan []byte
+	done      chan struct{}
+}
+
+// NewServer creates a server that takes over POST requests to path.
+// localAddr is only used as the LocalAddr of the PacketConn (quic-go requires
+// one that is unique among its conns). If nil, an Addr of the path is used.
+func NewServer(path string, localAddr net.Addr) *Server {
+	if localAddr == nil {
+		localAddr = Addr(path)
+	}
+	return &Server{
+		Path:         path,
+		localAddr:    localAddr,
+		recvQueue:    make(chan *packet, packetQueueSize),
+		readDeadline: deadline.New(),
+		closeChan:    make(chan struct{}),
+	}
+}
+
+// ServeFallback handles the request if it's for the fallback transport, and returns
+// false without touching w otherwise. It blocks until the client goes away.
+func (s *Server) ServeFallback(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost || r.URL.Path != s.Path || r.ProtoMajor != 2 {
+		return false
+	}
+	select {
+	case <-s.closeChan:
+		return false
+	default:
+	}
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return true
+	}
+	t := &tunnel{
+		sendQueue: make(chan []byte, packetQueueSize),
+		done:      make(chan struct{}),
+	}
+	defer close(t.done)
+	addr := &TunnelAddr{RemoteAddr: r.RemoteAddr, tunnel: t}
+	readDone := make(chan struct{})
+	go func() {
+		s.recvLoop(bufio.NewReader(r.Body), addr)
+		close(readDone)
+	}()
+	// The response must only be written to by the handler itself,
+	// so WriteTo only queues the packets for us.
+	var buf []byte
+	for {
+		select {
+		case p := <-t.sendQueue:
+			buf, _ = appendPacket(buf[:0], p) // Size already checked by WriteTo
+			// Send whatever else is queued in the same write
+			for n := len(t.sendQueue); n > 0; n-- {
+				buf, _ = appendPacket(buf, <-t.sendQueue)
+			}
+			if _, err := w.Write(buf); err != nil {
+				return true
+			}
+			if err := rc.Flush(); err != nil {
+				return true
+			}
+		case <-readDone:
+			return true
+		case <-s.closeChan:
+			return true
+		}
+	}
+}
+
+func (s *Server) recvLoop(r io.Reader, addr *TunnelAddr) {
+	for {
+		p, err := readPacket(r, nil)
+		if err != nil {
+			return
+		}
+		select {
+		case s.recvQueue <- &packet{Data: p, Addr: addr}:
+		case <-addr.tunnel.done:
+			return
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Server) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	select {
+	case p := <-s.recvQueue:
+		n := copy(b, p.Data)
+		return n, p.Addr, nil
+	case <-s.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-s.closeChan:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (s *Server) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	tAddr, ok := addr.(*TunnelAddr)
+	if !ok || tAddr.tunnel == nil {
+		return 0, errors.New("not a fallback tunnel address")
+	}
+	if len(b) > maxPacketSize {
+		return 0, errPacketTooLarge
+	}
+	select {
+	case <-s.closeChan:
+		return 0, net.ErrClosed
+	default:
+	}
+	p := make([]byte, len(b))
+	copy(p, b)
+	select {
+	case tAddr.tunnel.sendQueue <- p:
+	case <-tAddr.tunnel.done:
+		// The client is gone, drop it like UDP would
+	default:
+		// Queue full, drop it like UDP would
+	}
+	return len(b), nil
+}
+
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+	return nil
+}
+
+func (s *Server) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+func (s *Server) SetDeadline(t time.Time) error {
+	s.readDeadline.Set(t)
+	return nil
+}
+
+func (s *Server) SetReadDeadline(t time.Time) error {
+	s.readDeadline.Set(t)
+	return nil
+}
+
+func (s *Server) SetWriteDeadline(t time.Time) error {
+	// Not supported, WriteTo never blocks
+	return nil
+}
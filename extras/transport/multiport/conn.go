@@ -0,0 +1,172 @@
+// Package multiport lets a server receive on many ports at once, e.g. a range
+// of ports for clients that hop between them (see udphop), without any
+// firewall rules to redirect them to a single port.
+package multiport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apernet/hysteria/extras/v2/internal/deadline"
+)
+
+const (
+	packetQueueSize = 1024
+	udpBufferSize   = 2048 // QUIC packets are at most 1500 bytes long, so 2k should be more than enough
+)
+
+var _ net.PacketConn = (*multiPacketConn)(nil)
+
+// Addr is the address of a client along with the conn its packet was received on.
+// Packets written to it are sent from the same conn, so that clients get replies
+// from the port they sent to.
+type Addr struct {
+	Addr  net.Addr
+	index int
+}
+
+func (a *Addr) Network() string {
+	return a.Addr.Network()
+}
+
+func (a *Addr) String() string {
+	return a.Addr.String()
+}
+
+// Unwrap returns the address of the client, e.g. for its IP.
+func (a *Addr) Unwrap() net.Addr {
+	return a.Addr
+}
+
+type multiPacketConn struct {
+	Conns []net.PacketConn
+
+	recvQueue    chan *packet
+	readDeadline *deadline.Deadline // quic-go relies on it to stop reading when a listener is closed
+	closeChan    chan struct{}
+	closeOnce    sync.Once
+
+	bufPool sync.Pool
+}
+
+type packet struct {
+	Buf  []byte
+	N    int
+	Addr net.Addr
+	Err  error
+}
+
+// NewPacketConn returns a net.PacketConn that reads from all conns, and replies
+// to each client from the conn it was received on. The addresses it returns
+// are *Addr. Its LocalAddr is the one of the first conn.
+func NewPacketConn(conns []net.PacketConn) (net.PacketConn, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("no conns")
+	}
+	c := &multiPacketConn{
+		Conns:        conns,
+		recvQueue:    make(chan *packet, packetQueueSize),
+		readDeadline: deadline.New(),
+		closeChan:    make(chan struct{}),
+		bufPool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, udpBufferSize)
+			},
+		},
+	}
+	for i, conn := range conns {
+		go c.recvLoop(conn, i)
+	}
+	return c, nil
+}
+
+func (c *multiPacketConn) recvLoop(conn net.PacketConn, index int) {
+	for {
+		buf := c.bufPool.Get().([]byte)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			c.bufPool.Put(buf)
+			select {
+			case <-c.closeChan:
+			case c.recvQueue <- &packet{Err: err}:
+				// Pass it on, a conn failing on its own is fatal
+			}
+			return
+		}
+		select {
+		case c.recvQueue <- &packet{buf, n, &Addr{Addr: addr, index: index}, nil}:
+			// Packet successfully queued
+		default:
+			// Queue is full, drop the packet
+			c.bufPool.Put(buf)
+		}
+	}
+}
+
+func (c *multiPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	select {
+	case p := <-c.recvQueue:
+		if p.Err != nil {
+			return 0, nil, p.Err
+		}
+		n := copy(b, p.Buf[:p.N])
+		c.bufPool.Put(p.Buf)
+		return n, p.Addr, nil
+	case <-c.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-c.closeChan:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *multiPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	if mAddr, ok := addr.(*Addr); ok {
+		return c.Conns[mAddr.index].WriteTo(b, mAddr.Addr)
+	}
+	// Not from us, send it from the first conn
+	return c.Conns[0].WriteTo(b, addr)
+}
+
+func (c *multiPacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		for _, conn := range c.Conns {
+			if cErr := conn.Close(); cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+	})
+	return err
+}
+
+func (c *multiPacketConn) LocalAddr() net.Addr {
+	return c.Conns[0].LocalAddr()
+}
+
+func (c *multiPacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	for _, conn := range c.Conns {
+		if err := conn.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *multiPacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+func (c *multiPacketConn) SetWriteDeadline(t time.Time) error {
+	for _, conn := range c.Conns {
+		if err := conn.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
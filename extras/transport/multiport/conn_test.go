@@ -0,0 +1,64 @@
+package multiport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketConn(t *testing.T) {
+	var conns []net.PacketConn
+	for i := 0; i < 3; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	mConn, err := NewPacketConn(conns)
+	assert.NoError(t, err)
+	defer mConn.Close()
+	assert.Equal(t, conns[0].LocalAddr(), mConn.LocalAddr())
+
+	cConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cConn.Close()
+
+	buf := make([]byte, 2048)
+	for _, conn := range []net.PacketConn{conns[2], conns[0], conns[1]} {
+		_, err := cConn.WriteTo([]byte("ping"), conn.LocalAddr())
+		assert.NoError(t, err)
+		n, addr, err := mConn.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:n]))
+		assert.Equal(t, cConn.LocalAddr().String(), addr.String())
+
+		// The reply must come from the port the client sent to
+		_, err = mConn.WriteTo([]byte("pong"), addr)
+		assert.NoError(t, err)
+		n, addr, err = cConn.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "pong", string(buf[:n]))
+		assert.Equal(t, conn.LocalAddr().String(), addr.String())
+	}
+
+	// Read deadline
+	assert.NoError(t, mConn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = mConn.ReadFrom(buf)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	assert.NoError(t, mConn.SetReadDeadline(time.Time{}))
+
+	// Close
+	assert.NoError(t, mConn.Close())
+	_, _, err = mConn.ReadFrom(buf)
+	assert.ErrorIs(t, err, net.ErrClosed)
+	for _, conn := range conns {
+		_, err := conn.WriteTo([]byte("x"), cConn.LocalAddr())
+		assert.Error(t, err)
+	}
+
+	_, err = NewPacketConn(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+// Package reuseport opens multiple UDP sockets on the same address with SO_REUSEPORT,
+// so that a server can read from them in parallel, one QUIC listener on each.
+//
+// By default, the kernel picks the socket of a packet by a hash of its source and
+// destination addresses, so packets of a client stay on the same socket only as long
+// as its address doesn't change (e.g. not with port hopping). With steering, a BPF
+// program picks it by the first byte of the destination connection ID of the QUIC
+// packet modulo the number of sockets instead, and the server issues connection IDs
+// accordingly (server.Config.ConnIDSteering), so packets of a connection always go
+// to the listener that owns it. Steering can't work with obfuscation, as the
+// connection IDs are not visible to the kernel.
+package reuseport
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrNotSupported = errors.New("SO_REUSEPORT is not supported on this platform")
+
+// ListenUDP opens n sockets on laddr with SO_REUSEPORT. If laddr has port 0,
+// they all share the port picked for the first one.
+func ListenUDP(laddr *net.UDPAddr, n int, steering bool) ([]*net.UDPConn, error) {
+	if n <= 0 {
+		return nil, errors.New("number of sockets must be positive")
+	}
+	if n > 256 {
+		// Steering only looks at one byte of the connection ID
+		return nil, errors.New("number of sockets must not exceed 256")
+	}
+	return listenUDP(laddr, n, steering)
+}
+
+func closeAll(conns []*net.UDPConn) {
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package reuseport
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/apernet/hysteria/extras/v2/correctnet"
+)
+
+func listenUDP(laddr *net.UDPAddr, n int, steering bool) ([]*net.UDPConn, error) {
+	lc := &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conns := make([]*net.UDPConn, 0, n)
+	addr := laddr
+	for i := 0; i < n; i++ {
+		conn, err := correctnet.ListenUDPConfig(lc, "udp", addr)
+		if err != nil {
+			closeAll(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+		if i == 0 {
+			// In case laddr has port 0
+			addr = &net.UDPAddr{Port: conn.LocalAddr().(*net.UDPAddr).Port}
+			if laddr != nil {
+				addr.IP, addr.Zone = laddr.IP, laddr.Zone
+			}
+		}
+	}
+	if steering {
+		// The program applies to the whole group, no matter which socket it's attached to
+		if err := attachSteering(conns[0], n); err != nil {
+			closeAll(conns)
+			return nil, err
+		}
+	}
+	return conns, nil
+}
+
+// steeringProgram returns a classic BPF program that returns the index of the socket
+// (in the order they were bound) for a QUIC packet, which is the first byte of its
+// destination connection ID modulo n. Packets too short to have one go to socket 0.
+func steeringProgram(n int) ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x80, SkipTrue: 2}, // Long header?
+		// Short header: flags (1), DCID
+		bpf.LoadAbsolute{Off: 1, Size: 1},
+		bpf.Jump{Skip: 1},
+		// Long header: flags (1), version (4), DCID length (1), DCID
+		bpf.LoadAbsolute{Off: 6, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: uint32(n)},
+		bpf.RetA{},
+	})
+}
+
+func attachSteering(conn *net.UDPConn, n int) error {
+	raw, err := steeringProgram(n)
+	if err != nil {
+		return err
+	}
+	filters := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filters[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := &unix.SockFprog{Len: uint16(len(filters)), Filter: &filters[0]}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	cerr := rawConn.Control(func(fd uintptr) {
+		err = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, prog)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to attach steering program: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package reuseport
+
+import "net"
+
+func listenUDP(laddr *net.UDPAddr, n int, steering bool) ([]*net.UDPConn, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,224 @@
+package reuseport
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apernet/hysteria/core/v2/client"
+	"github.com/apernet/hysteria/core/v2/server"
+)
+
+const testSockets = 4
+
+func listenTest(t *testing.T, steering bool) []*net.UDPConn {
+	conns, err := ListenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, testSockets, steering)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	}
+	assert.NoError(t, err)
+	assert.Len(t, conns, testSockets)
+	return conns
+}
+
+func TestListenUDP(t *testing.T) {
+	conns := listenTest(t, false)
+	defer closeAll(conns)
+	for _, conn := range conns {
+		assert.Equal(t, conns[0].LocalAddr().String(), conn.LocalAddr().String())
+	}
+
+	_, err := ListenUDP(nil, 0, false)
+	assert.Error(t, err)
+	_, err = ListenUDP(nil, 257, false)
+	assert.Error(t, err)
+}
+
+func TestSteering(t *testing.T) {
+	conns := listenTest(t, true)
+	defer closeAll(conns)
+	cConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cConn.Close()
+
+	for b := 0; b < 256; b += 7 {
+		short := []byte{0x40, byte(b), 0xaa, 0xbb}
+		long := []byte{0xc0, 0, 0, 0, 1, 8, byte(b), 0xaa, 0xbb}
+		for _, p := range [][]byte{short, long} {
+			_, err := cConn.WriteTo(p, conns[0].LocalAddr())
+			assert.NoError(t, err)
+			conn := conns[b%testSockets]
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 64)
+			n, _, err := conn.ReadFrom(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, p, buf[:n])
+		}
+	}
+}
+
+// rebindConn switches to a new socket (source port) on Rebind,
+// like a client behind a NAT that changes its mapping.
+type rebindConn struct {
+	mutex sync.Mutex
+	conn  *net.UDPConn
+}
+
+func (c *rebindConn) current() *net.UDPConn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn
+}
+
+func (c *rebindConn) Rebind() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	old := c.conn
+	c.conn = conn
+	c.mutex.Unlock()
+	return old.Close()
+}
+
+func (c *rebindConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		conn := c.current()
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil && conn != c.current() {
+			// Rebound, read from the new one
+			continue
+		}
+		return n, addr, err
+	}
+}
+
+func (c *rebindConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(b, addr)
+}
+
+func (c *rebindConn) Close() error {
+	return c.current().Close()
+}
+
+func (c *rebindConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *rebindConn) SetDeadline(t time.Time) error {
+	return c.current().SetDeadline(t)
+}
+
+func (c *rebindConn) SetReadDeadline(t time.Time) error {
+	return c.current().SetReadDeadline(t)
+}
+
+func (c *rebindConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
+
+type rebindConnFactory struct {
+	Conn *rebindConn
+}
+
+func (f *rebindConnFactory) New(addr net.Addr) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	f.Conn = &rebindConn{conn: conn}
+	return f.Conn, nil
+}
+
+type testAuthenticator struct{}
+
+func (a *testAuthenticator) Authenticate(addr net.Addr, auth string, tx uint64) (bool, string) {
+	return true, "test"
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestSteeringHysteria checks that connections keep working when
+// clients change their source port, which lands them on other sockets.
+func TestSteeringHysteria(t *testing.T) {
+	conns := listenTest(t, true)
+	extraConns := make([]net.PacketConn, 0, len(conns)-1)
+	for _, conn := range conns[1:] {
+		extraConns = append(extraConns, conn)
+	}
+	s, err := server.NewServer(&server.Config{
+		TLSConfig:      server.TLSConfig{Certificates: []tls.Certificate{testCertificate(t)}},
+		Conn:           conns[0],
+		ExtraConns:     extraConns,
+		ConnIDSteering: len(conns),
+		Authenticator:  &testAuthenticator{},
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	go s.Serve()
+
+	// TCP echo server
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		cf := &rebindConnFactory{}
+		c, _, err := client.NewClient(&client.Config{
+			ConnFactory: cf,
+			ServerAddr:  conns[0].LocalAddr(),
+			TLSConfig:   client.TLSConfig{InsecureSkipVerify: true},
+		})
+		assert.NoError(t, err)
+		for j := 0; j < 4; j++ {
+			assert.NoError(t, cf.Conn.Rebind())
+			conn, err := c.TCP(echoListener.Addr().String())
+			assert.NoError(t, err)
+			sData := bytes.Repeat([]byte("steering"), 1024)
+			_, err = conn.Write(sData)
+			assert.NoError(t, err)
+			rData := make([]byte, len(sData))
+			_, err = io.ReadFull(conn, rData)
+			assert.NoError(t, err)
+			assert.Equal(t, sData, rData)
+			_ = conn.Close()
+		}
+		_ = c.Close()
+	}
+}
@@ -1,8 +1,11 @@
 package udphop
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 
 	"github.com/apernet/hysteria/extras/v2/utils"
 )
@@ -15,9 +18,11 @@ func (e InvalidPortError) Error() string {
 	return fmt.Sprintf("%s is not a valid port number or range", e.PortStr)
 }
 
-// UDPHopAddr contains an IP address and a list of ports.
+// UDPHopAddr contains the hosts of the server, their IP addresses, and a list of ports.
+// Hosts that are not IP addresses are re-resolved periodically by the conn.
 type UDPHopAddr struct {
-	IP      net.IP
+	Hosts   []string
+	IPs     []net.IP // All IPs of all Hosts at the time of resolution
 	Ports   []uint16
 	PortStr string
 }
@@ -27,33 +32,43 @@ func (a *UDPHopAddr) Network() string {
 }
 
 func (a *UDPHopAddr) String() string {
-	return net.JoinHostPort(a.IP.String(), a.PortStr)
+	return net.JoinHostPort(strings.Join(a.Hosts, ","), a.PortStr)
 }
 
-// addrs returns a list of net.Addr's, one for each port.
-func (a *UDPHopAddr) addrs() ([]net.Addr, error) {
+// addrs returns a list of net.Addr's, one for each IP & port.
+func addrs(ips []net.IP, ports []uint16) []net.Addr {
 	var addrs []net.Addr
-	for _, port := range a.Ports {
-		addr := &net.UDPAddr{
-			IP:   a.IP,
-			Port: int(port),
+	for _, ip := range ips {
+		for _, port := range ports {
+			addr := &net.UDPAddr{
+				IP:   ip,
+				Port: int(port),
+			}
+			addrs = append(addrs, addr)
 		}
-		addrs = append(addrs, addr)
 	}
-	return addrs, nil
+	return addrs
 }
 
+// ResolveUDPHopAddr resolves an address of one or more comma-separated hosts
+// and a port, or a list of ports and ranges, e.g. "a.example.com,10.0.0.1:20000-30000".
 func ResolveUDPHopAddr(addr string) (*UDPHopAddr, error) {
-	host, portStr, err := net.SplitHostPort(addr)
+	hostStr, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
 	}
-	ip, err := net.ResolveIPAddr("ip", host)
-	if err != nil {
-		return nil, err
+	hosts := strings.Split(hostStr, ",")
+	var ips []net.IP
+	for _, host := range hosts {
+		hostIPs, err := resolveHost(host)
+		if err != nil {
+			return nil, err
+		}
+		ips = appendIPs(ips, hostIPs)
 	}
 	result := &UDPHopAddr{
-		IP:      ip.IP,
+		Hosts:   hosts,
+		IPs:     ips,
 		PortStr: portStr,
 	}
 
@@ -65,3 +80,56 @@ func ResolveUDPHopAddr(addr string) (*UDPHopAddr, error) {
 
 	return result, nil
 }
+
+// appendIPs appends the IPs in newIPs that are not already in ips.
+func appendIPs(ips, newIPs []net.IP) []net.IP {
+	for _, ip := range newIPs {
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// lookupIPAddr is replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// resolveHost returns all IPv4 addresses of host, or all IPv6 addresses if
+// it has none. Same preference as net.ResolveIPAddr, which returns only one.
+func resolveHost(host string) ([]net.IP, error) {
+	if host == "" {
+		return nil, &net.AddrError{Err: "missing host", Addr: host}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ipAddrs, err := lookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	var ip4s, ip6s []net.IP
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.To4() != nil {
+			ip4s = append(ip4s, ipAddr.IP)
+		} else {
+			ip6s = append(ip6s, ipAddr.IP)
+		}
+	}
+	if len(ip4s) > 0 {
+		return ip4s, nil
+	}
+	if len(ip6s) > 0 {
+		return ip6s, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// allIPs returns whether all hosts are IP addresses, i.e. there is nothing to re-resolve.
+func allIPs(hosts []string) bool {
+	for _, host := range hosts {
+		if net.ParseIP(host) == nil {
+			return false
+		}
+	}
+	return true
+}
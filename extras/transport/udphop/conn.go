@@ -5,8 +5,11 @@ import (
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/apernet/hysteria/extras/v2/internal/udpbatch"
 )
 
 const (
@@ -14,18 +17,31 @@ const (
 	udpBufferSize   = 2048 // QUIC packets are at most 1500 bytes long, so 2k should be more than enough
 
 	defaultHopInterval = 30 * time.Second
+
+	resolveInterval = 5 * time.Minute
+
+	// An address is considered dead (e.g. the port has been blocked) when nothing
+	// has been received from it for deadAddrTimeout since we started sending to it.
+	// We then hop right away, and don't hop to it again for deadAddrCooldown.
+	deadAddrTimeout       = 3 * time.Second
+	deadAddrCooldown      = 10 * time.Minute
+	deadAddrCheckInterval = 500 * time.Millisecond
 )
 
+var _ udpbatch.Conn = (*udpHopPacketConn)(nil)
+
 type udpHopPacketConn struct {
-	Addr          net.Addr
-	Addrs         []net.Addr
-	HopInterval   time.Duration
-	ListenUDPFunc ListenUDPFunc
+	Addr           *UDPHopAddr
+	MinHopInterval time.Duration
+	MaxHopInterval time.Duration
+	ListenUDPFunc  ListenUDPFunc
 
 	connMutex   sync.RWMutex
 	prevConn    net.PacketConn
 	currentConn net.PacketConn
-	addrIndex   int
+	state       *hopState // Of currentConn
+	addrs       []net.Addr
+	deadAddrs   map[string]time.Time // Address string -> until when it's skipped
 
 	readBufferSize  int
 	writeBufferSize int
@@ -44,49 +60,108 @@ type udpPacket struct {
 	Err  error
 }
 
+// hopState tracks whether the server replies at the address we hopped to.
+type hopState struct {
+	Addr       net.Addr
+	lastWrite  atomic.Int64 // UnixNano
+	unanswered atomic.Int64 // UnixNano of the first write not followed by a received packet, 0 if none
+}
+
+func (s *hopState) onWrite() {
+	now := time.Now().UnixNano()
+	last := s.lastWrite.Swap(now)
+	if s.unanswered.Load() == 0 || now-last > int64(deadAddrTimeout) {
+		// Start over after a long silence, as the last packet before it
+		// may have been one that doesn't get a reply (e.g. an ACK)
+		s.unanswered.Store(now)
+	}
+}
+
+func (s *hopState) onRead() {
+	if s.unanswered.Load() != 0 {
+		s.unanswered.Store(0)
+	}
+}
+
+// dead returns whether we have kept sending without receiving anything
+// for deadAddrTimeout. The last packets before going idle don't count,
+// as they may not get a reply, so there must be writes spanning at least
+// half of it (e.g. retransmissions).
+func (s *hopState) dead(now time.Time) bool {
+	t := s.unanswered.Load()
+	return t != 0 && now.UnixNano()-t > int64(deadAddrTimeout) &&
+		s.lastWrite.Load()-t >= int64(deadAddrTimeout/2)
+}
+
 type ListenUDPFunc = func() (net.PacketConn, error)
 
-func NewUDPHopPacketConn(addr *UDPHopAddr, hopInterval time.Duration, listenUDPFunc ListenUDPFunc) (net.PacketConn, error) {
-	if hopInterval == 0 {
-		hopInterval = defaultHopInterval
-	} else if hopInterval < 5*time.Second {
+// NewUDPHopPacketConn creates a conn that hops to a random IP & port of addr
+// after a random interval between minHopInterval and maxHopInterval (0 for
+// a fixed interval), and right away when the current one seems to be blocked.
+func NewUDPHopPacketConn(addr *UDPHopAddr, minHopInterval, maxHopInterval time.Duration, listenUDPFunc ListenUDPFunc) (net.PacketConn, error) {
+	if minHopInterval == 0 {
+		minHopInterval = defaultHopInterval
+	} else if minHopInterval < 5*time.Second {
 		return nil, errors.New("hop interval must be at least 5 seconds")
 	}
+	if maxHopInterval == 0 {
+		maxHopInterval = minHopInterval
+	} else if maxHopInterval < minHopInterval {
+		return nil, errors.New("max hop interval must not be less than hop interval")
+	}
 	if listenUDPFunc == nil {
 		listenUDPFunc = func() (net.PacketConn, error) {
 			return net.ListenUDP("udp", nil)
 		}
 	}
-	addrs, err := addr.addrs()
-	if err != nil {
-		return nil, err
+	addrs := addrs(addr.IPs, addr.Ports)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to hop to")
 	}
 	curConn, err := listenUDPFunc()
 	if err != nil {
 		return nil, err
 	}
+	curConn = wrapBatch(curConn)
 	hConn := &udpHopPacketConn{
-		Addr:          addr,
-		Addrs:         addrs,
-		HopInterval:   hopInterval,
-		ListenUDPFunc: listenUDPFunc,
-		prevConn:      nil,
-		currentConn:   curConn,
-		addrIndex:     rand.Intn(len(addrs)),
-		recvQueue:     make(chan *udpPacket, packetQueueSize),
-		closeChan:     make(chan struct{}),
+		Addr:           addr,
+		MinHopInterval: minHopInterval,
+		MaxHopInterval: maxHopInterval,
+		ListenUDPFunc:  listenUDPFunc,
+		prevConn:       nil,
+		currentConn:    curConn,
+		addrs:          addrs,
+		deadAddrs:      make(map[string]time.Time),
+		recvQueue:      make(chan *udpPacket, packetQueueSize),
+		closeChan:      make(chan struct{}),
 		bufPool: sync.Pool{
 			New: func() interface{} {
 				return make([]byte, udpBufferSize)
 			},
 		},
 	}
-	go hConn.recvLoop(curConn)
+	hConn.state = &hopState{Addr: hConn.nextAddr(nil)}
+	go hConn.recvLoop(curConn, hConn.state)
 	go hConn.hopLoop()
+	if !allIPs(addr.Hosts) {
+		go hConn.resolveLoop()
+	}
 	return hConn, nil
 }
 
-func (u *udpHopPacketConn) recvLoop(conn net.PacketConn) {
+// wrapBatch returns conn with batched reads if supported, or conn itself otherwise.
+func wrapBatch(conn net.PacketConn) net.PacketConn {
+	if bc := udpbatch.Wrap(conn); bc != nil {
+		return bc
+	}
+	return conn
+}
+
+func (u *udpHopPacketConn) recvLoop(conn net.PacketConn, state *hopState) {
+	if bc, ok := conn.(udpbatch.Conn); ok {
+		u.recvLoopBatch(bc, state)
+		return
+	}
 	for {
 		buf := u.bufPool.Get().([]byte)
 		n, addr, err := conn.ReadFrom(buf)
@@ -101,6 +176,7 @@ func (u *udpHopPacketConn) recvLoop(conn net.PacketConn) {
 			}
 			return
 		}
+		state.onRead()
 		select {
 		case u.recvQueue <- &udpPacket{buf, n, addr, nil}:
 			// Packet successfully queued
@@ -110,31 +186,145 @@ func (u *udpHopPacketConn) recvLoop(conn net.PacketConn) {
 		}
 	}
 }
+func (u *udpHopPacketConn) recvLoopBatch(conn udpbatch.Conn, state *hopState) {
+	msgs := make([]udpbatch.Message, udpbatch.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	defer func() {
+		for _, m := range msgs {
+			if m.Buffers[0] != nil {
+				u.bufPool.Put(m.Buffers[0])
+			}
+		}
+	}()
+	for {
+		for i := range msgs {
+			if msgs[i].Buffers[0] == nil {
+				msgs[i].Buffers[0] = u.bufPool.Get().([]byte)
+			}
+		}
+		n, err := conn.ReadBatch(msgs, 0)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Same as recvLoop
+				u.recvQueue <- &udpPacket{nil, 0, nil, netErr}
+			}
+			return
+		}
+		state.onRead()
+		for i := range msgs[:n] {
+			m := &msgs[i]
+			select {
+			case u.recvQueue <- &udpPacket{m.Buffers[0], m.N, m.Addr, nil}:
+				// Packet successfully queued, get a new buffer next time
+				m.Buffers[0] = nil
+			default:
+				// Queue is full, drop the packet and reuse the buffer
+			}
+		}
+	}
+}
 
 func (u *udpHopPacketConn) hopLoop() {
-	ticker := time.NewTicker(u.HopInterval)
-	defer ticker.Stop()
+	hopTimer := time.NewTimer(u.nextHopInterval())
+	defer hopTimer.Stop()
+	checkTicker := time.NewTicker(deadAddrCheckInterval)
+	defer checkTicker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			u.hop()
+		case <-hopTimer.C:
+			u.hop(false)
+		case now := <-checkTicker.C:
+			u.connMutex.RLock()
+			dead := u.state.dead(now)
+			u.connMutex.RUnlock()
+			if !dead {
+				continue
+			}
+			u.hop(true)
+			if !hopTimer.Stop() {
+				<-hopTimer.C
+			}
 		case <-u.closeChan:
 			return
 		}
+		hopTimer.Reset(u.nextHopInterval())
 	}
 }
 
-func (u *udpHopPacketConn) hop() {
+func (u *udpHopPacketConn) nextHopInterval() time.Duration {
+	if u.MaxHopInterval <= u.MinHopInterval {
+		return u.MinHopInterval
+	}
+	return u.MinHopInterval + time.Duration(rand.Int63n(int64(u.MaxHopInterval-u.MinHopInterval)+1))
+}
+
+// nextAddr picks a random address other than current that is not dead.
+// If there is none, the dead ones are given another chance.
+// Must be called with connMutex held (or before the conn is used).
+func (u *udpHopPacketConn) nextAddr(current net.Addr) net.Addr {
+	now := time.Now()
+	usable := func(addr net.Addr) bool {
+		if current != nil && addr.String() == current.String() {
+			return false
+		}
+		until, ok := u.deadAddrs[addr.String()]
+		return !ok || now.After(until)
+	}
+	// Most of them should be usable, so try a few random picks first
+	for i := 0; i < 8; i++ {
+		addr := u.addrs[rand.Intn(len(u.addrs))]
+		if usable(addr) {
+			return addr
+		}
+	}
+	var candidates []net.Addr
+	for _, addr := range u.addrs {
+		if usable(addr) {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	// All dead, or there's only one
+	for k, until := range u.deadAddrs {
+		if now.After(until) {
+			delete(u.deadAddrs, k)
+		}
+	}
+	if len(u.addrs) > 1 && len(u.deadAddrs) >= len(u.addrs)-1 {
+		// Maybe it's the server (or our network) that's down instead
+		clear(u.deadAddrs)
+	}
+	for i := 0; i < 8; i++ {
+		addr := u.addrs[rand.Intn(len(u.addrs))]
+		if current == nil || addr.String() != current.String() {
+			return addr
+		}
+	}
+	return u.addrs[rand.Intn(len(u.addrs))]
+}
+
+// hop switches to a new socket and a new address. If dead is true,
+// the current address is marked as dead.
+func (u *udpHopPacketConn) hop(dead bool) {
 	u.connMutex.Lock()
 	defer u.connMutex.Unlock()
 	if u.closed {
 		return
 	}
+	if dead {
+		u.deadAddrs[u.state.Addr.String()] = time.Now().Add(deadAddrCooldown)
+	}
 	newConn, err := u.ListenUDPFunc()
 	if err != nil {
 		// Could be temporary, just skip this hop
 		return
 	}
+	newConn = wrapBatch(newConn)
 	// We need to keep receiving packets from the previous connection,
 	// because otherwise there will be packet loss due to the time gap
 	// between we hop to a new port and the server acknowledges this change.
@@ -155,9 +345,43 @@ func (u *udpHopPacketConn) hop() {
 	if u.writeBufferSize > 0 {
 		_ = trySetWriteBuffer(u.currentConn, u.writeBufferSize)
 	}
-	go u.recvLoop(newConn)
-	// Update addrIndex to a new random value
-	u.addrIndex = rand.Intn(len(u.Addrs))
+	// Pick a new random address
+	u.state = &hopState{Addr: u.nextAddr(u.state.Addr)}
+	go u.recvLoop(newConn, u.state)
+}
+
+func (u *udpHopPacketConn) resolveLoop() {
+	ticker := time.NewTicker(resolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			u.resolve()
+		case <-u.closeChan:
+			return
+		}
+	}
+}
+
+// resolve re-resolves the hosts of the server. If any of them fails,
+// the current addresses are kept until the next time.
+func (u *udpHopPacketConn) resolve() {
+	var ips []net.IP
+	for _, host := range u.Addr.Hosts {
+		hostIPs, err := resolveHost(host)
+		if err != nil {
+			return
+		}
+		ips = appendIPs(ips, hostIPs)
+	}
+	newAddrs := addrs(ips, u.Addr.Ports)
+	if len(newAddrs) == 0 {
+		return
+	}
+	u.connMutex.Lock()
+	defer u.connMutex.Unlock()
+	u.addrs = newAddrs
+	// The current address is kept until the next hop even if it's gone
 }
 
 func (u *udpHopPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
@@ -186,7 +410,46 @@ func (u *udpHopPacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
 	}
 	// Skip the check for now, always write to the server,
 	// for the same reason as in ReadFrom.
-	return u.currentConn.WriteTo(b, u.Addrs[u.addrIndex])
+	u.state.onWrite()
+	return u.currentConn.WriteTo(b, u.state.Addr)
+}
+
+// ReadBatch returns at least one of the queued packets, and as many more as are available.
+func (u *udpHopPacketConn) ReadBatch(msgs []udpbatch.Message, flags int) (int, error) {
+	n := 0
+	for n < len(msgs) {
+		var p *udpPacket
+		if n == 0 {
+			select {
+			case p = <-u.recvQueue:
+			case <-u.closeChan:
+				return 0, net.ErrClosed
+			}
+		} else {
+			select {
+			case p = <-u.recvQueue:
+			default:
+				return n, nil
+			}
+		}
+		if p.Err != nil {
+			if n == 0 {
+				return 0, p.Err
+			}
+			// Put it back for the next call. If the queue is full, it's lost,
+			// but the next read will time out again anyway.
+			select {
+			case u.recvQueue <- p:
+			default:
+			}
+			return n, nil
+		}
+		// Same as ReadFrom, we do not check whether the packet is from the server.
+		msgs[n].N, msgs[n].NN, msgs[n].Flags, msgs[n].Addr = copy(msgs[n].Buffers[0], p.Buf[:p.N]), 0, 0, u.Addr
+		u.bufPool.Put(p.Buf)
+		n++
+	}
+	return n, nil
 }
 
 func (u *udpHopPacketConn) Close() error {
@@ -204,7 +467,7 @@ func (u *udpHopPacketConn) Close() error {
 	err := u.currentConn.Close()
 	close(u.closeChan)
 	u.closed = true
-	u.Addrs = nil // For GC
+	u.addrs = nil // For GC
 	return err
 }
 
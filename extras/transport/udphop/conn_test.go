@@ -0,0 +1,149 @@
+package udphop
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveUDPHopAddr(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "dual.test":
+			return []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("10.0.0.3")}}, nil
+		case "v6.test":
+			return []net.IPAddr{{IP: net.ParseIP("2001:db8::2")}}, nil
+		default:
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+	}
+
+	addr, err := ResolveUDPHopAddr("dual.test,10.0.0.1,v6.test,10.0.0.2:1000-1002,2000")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dual.test", "10.0.0.1", "v6.test", "10.0.0.2"}, addr.Hosts)
+	var ips []string
+	for _, ip := range addr.IPs {
+		ips = append(ips, ip.String())
+	}
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.1", "2001:db8::2"}, ips)
+	assert.Equal(t, []uint16{1000, 1001, 1002, 2000}, addr.Ports)
+	assert.Equal(t, "dual.test,10.0.0.1,v6.test,10.0.0.2:1000-1002,2000", addr.String())
+	assert.Len(t, addrs(addr.IPs, addr.Ports), 16)
+
+	_, err = ResolveUDPHopAddr("dual.test,nx.test:1000-1002")
+	assert.Error(t, err)
+	_, err = ResolveUDPHopAddr("dual.test,:1000-1002")
+	assert.Error(t, err)
+	_, err = ResolveUDPHopAddr("dual.test:1000-abc")
+	assert.Error(t, err)
+}
+
+func TestNextHopInterval(t *testing.T) {
+	u := &udpHopPacketConn{MinHopInterval: 10 * time.Second, MaxHopInterval: 20 * time.Second}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := u.nextHopInterval()
+		assert.GreaterOrEqual(t, d, 10*time.Second)
+		assert.LessOrEqual(t, d, 20*time.Second)
+		seen[d] = true
+	}
+	assert.Greater(t, len(seen), 1)
+
+	u.MaxHopInterval = u.MinHopInterval
+	assert.Equal(t, 10*time.Second, u.nextHopInterval())
+}
+
+func TestHopState(t *testing.T) {
+	s := &hopState{}
+	start := time.Now()
+	assert.False(t, s.dead(start.Add(time.Hour)))
+	// The last packet before going idle
+	s.onWrite()
+	assert.False(t, s.dead(start.Add(deadAddrTimeout*2)))
+	// Retransmissions without any reply
+	s.unanswered.Store(start.Add(-deadAddrTimeout).UnixNano())
+	s.lastWrite.Store(start.Add(-deadAddrTimeout).UnixNano())
+	s.onWrite() // After a long silence, starts over
+	assert.False(t, s.dead(start.Add(deadAddrTimeout*2)))
+	s.lastWrite.Store(start.Add(deadAddrTimeout).UnixNano())
+	assert.True(t, s.dead(start.Add(deadAddrTimeout*2)))
+	s.onRead()
+	assert.False(t, s.dead(start.Add(deadAddrTimeout*2)))
+}
+
+func TestNextAddr(t *testing.T) {
+	u := &udpHopPacketConn{
+		addrs:     addrs([]net.IP{net.IPv4(10, 0, 0, 1)}, []uint16{1, 2, 3}),
+		deadAddrs: make(map[string]time.Time),
+	}
+	u.deadAddrs["10.0.0.1:1"] = time.Now().Add(time.Hour)
+	u.deadAddrs["10.0.0.1:2"] = time.Now().Add(-time.Second) // Expired
+	for i := 0; i < 50; i++ {
+		addr := u.nextAddr(u.addrs[2])
+		assert.Equal(t, "10.0.0.1:2", addr.String())
+	}
+	// All dead (except the current one), start over
+	u.deadAddrs["10.0.0.1:2"] = time.Now().Add(time.Hour)
+	addr := u.nextAddr(u.addrs[2])
+	assert.NotEqual(t, "10.0.0.1:3", addr.String())
+	assert.Empty(t, u.deadAddrs)
+}
+
+// TestDeadAddr checks that the conn hops away from a port that doesn't reply.
+func TestDeadAddr(t *testing.T) {
+	blocked, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer blocked.Close()
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	addr := &UDPHopAddr{
+		Hosts: []string{"127.0.0.1"},
+		IPs:   []net.IP{net.IPv4(127, 0, 0, 1)},
+		Ports: []uint16{
+			uint16(blocked.LocalAddr().(*net.UDPAddr).Port),
+			uint16(echo.LocalAddr().(*net.UDPAddr).Port),
+		},
+	}
+	conn, err := NewUDPHopPacketConn(addr, time.Hour, 0, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	u := conn.(*udpHopPacketConn)
+	u.connMutex.Lock()
+	u.state = &hopState{Addr: blocked.LocalAddr()}
+	u.connMutex.Unlock()
+
+	go func() {
+		for {
+			if _, err := conn.WriteTo([]byte("ping"), addr); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(deadAddrTimeout*3)))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+
+	u.connMutex.RLock()
+	defer u.connMutex.RUnlock()
+	assert.Equal(t, echo.LocalAddr().String(), u.state.Addr.String())
+	assert.Contains(t, u.deadAddrs, blocked.LocalAddr().String())
+}